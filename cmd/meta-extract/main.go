@@ -1,26 +1,59 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"time"
 
+	"greg-hacke/go-metadata/formats"
 	"greg-hacke/go-metadata/meta"
 )
 
 func main() {
+	// Define command line flags
+	var verbosity int
+	var numeric bool
+	flag.IntVar(&verbosity, "v", 0, "Verbosity of parse trace written to stderr (0-3)")
+	flag.BoolVar(&numeric, "n", false, "Print numeric values instead of print-converted ones (like exiftool -n)")
+	magicReport := flag.Bool("magic", false, "Report magic number patterns that cannot be matched, then exit")
+	flag.Parse()
+
+	if *magicReport {
+		os.Exit(reportMagicNumbers(verbosity > 0))
+	}
+
 	// Check command line arguments
-	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "Usage: %s <path/to/file>\n", os.Args[0])
+	if flag.NArg() < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-v level] [-n] <path/to/file> [tag ...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s -magic [-v level]\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "\nExamples:\n")
 		fmt.Fprintf(os.Stderr, "  %s image.jpg\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "  %s /path/to/document.pdf\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -v 2 /path/to/document.pdf\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s image.jpg EXIF:Make 'XMP-dc:*' -IPTC:All\n", os.Args[0])
 		os.Exit(1)
 	}
 
-	filePath := os.Args[1]
+	filePath := flag.Arg(0)
+
+	// Remaining arguments select tags, ExifTool style
+	var requested meta.MetadataRequest
+	if flag.NArg() > 1 {
+		requested = make(meta.MetadataRequest)
+		for _, tag := range flag.Args()[1:] {
+			requested[tag] = true
+		}
+	}
+
+	// Send library debug output to stderr when verbose
+	opts := []meta.Option{meta.WithNumeric(numeric)}
+	if verbosity > 0 {
+		logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		opts = append(opts, meta.WithLogger(logger), meta.WithVerbosity(verbosity))
+	}
 
 	// Get file info
 	fileInfo, err := os.Stat(filePath)
@@ -58,11 +91,38 @@ func main() {
 	// Process the file for metadata
 	fmt.Println("\n=== Processing Metadata ===")
 
-	// For now, pass nil for requested metadata (get all)
-	err = meta.ProcessFileByPath(filePath, nil)
+	// A nil request extracts everything
+	err = meta.ProcessFileByPath(filePath, requested, opts...)
 	if err != nil {
 		log.Fatalf("Error processing file: %v", err)
 	}
 
 	fmt.Println("\n=== Processing Complete ===")
 }
+
+// reportMagicNumbers prints the magic number patterns that cannot be used to
+// identify files, and with verbose set those needing the slower matcher. It
+// returns the exit status: 1 if any pattern is unusable.
+func reportMagicNumbers(verbose bool) int {
+	status := 0
+	for _, m := range formats.MagicNumberReport() {
+		switch {
+		case m.Err != nil:
+			fmt.Printf("%-8s unusable: %v\n", m.FileType, m.Err)
+			status = 1
+		case verbose && m.Reason != "":
+			fmt.Printf("%-8s %-6s %s: %s\n", m.FileType, strength(m.Weak), m.Engine, m.Reason)
+		case verbose:
+			fmt.Printf("%-8s %-6s %s\n", m.FileType, strength(m.Weak), m.Engine)
+		}
+	}
+	return status
+}
+
+// strength describes how much a magic number match says about the file type
+func strength(weak bool) string {
+	if weak {
+		return "weak"
+	}
+	return "strong"
+}
@@ -14,6 +14,8 @@ type FileTypeData struct {
 	TestOrder    []string            // order to test files
 	ModuleNames  map[string]string   // type -> module name
 	MimeTypes    map[string]string   // type -> mime type
+	FileTypeExts map[string]string   // type -> normal extension, if not the type
+	Descriptions map[string]string   // type -> description, if no extension has it
 }
 
 func parseExifTool(exifToolPath string) (*FileTypeData, error) {
@@ -27,6 +29,8 @@ func parseExifTool(exifToolPath string) (*FileTypeData, error) {
 		MagicNumbers: make(map[string]string),
 		ModuleNames:  make(map[string]string),
 		MimeTypes:    make(map[string]string),
+		FileTypeExts: make(map[string]string),
+		Descriptions: make(map[string]string),
 	}
 
 	text := string(content)
@@ -46,6 +50,10 @@ func parseExifTool(exifToolPath string) (*FileTypeData, error) {
 	// Parse %mimeType
 	data.MimeTypes = parseMimeTypes(text)
 
+	// Parse %fileTypeExt and %fileDescription
+	data.FileTypeExts = parsePerlHash(text, "%fileTypeExt")
+	data.Descriptions = parsePerlHash(text, "%fileDescription")
+
 	return data, nil
 }
 
@@ -59,24 +67,7 @@ func parseFileTypeLookup(content string) map[string][]string {
 	}
 
 	// Find the closing );
-	end := start + len("%fileTypeLookup = (")
-	openParen := 1
-
-	for i := end; i < len(content); i++ {
-		switch content[i] {
-		case '(':
-			openParen++
-		case ')':
-			openParen--
-			if openParen == 0 {
-				end = i
-				break
-			}
-		}
-		if openParen == 0 {
-			break
-		}
-	}
+	end := perlListEnd(content, start+len("%fileTypeLookup = (")-1)
 
 	section := content[start:end]
 
@@ -235,46 +226,153 @@ func parseMagicNumbers(content string) map[string]string {
 		return result
 	}
 
-	// Find the closing );
-	depth := 0
-	end := start
-	for i := start; i < len(content); i++ {
-		if content[i] == '(' {
-			depth++
-		} else if content[i] == ')' {
-			depth--
-			if depth == 0 && i > start && i+1 < len(content) && content[i+1] == ';' {
-				end = i + 2
+	// Entries are "Type => 'pattern'," where the pattern may be double-quoted
+	// or several strings joined with "." across lines
+	entryRe := regexp.MustCompile(`^\s*(\w+)\s*=>\s*`)
+	rest := content[start+len("%magicNumber = ("):]
+	for {
+		rest = skipPerlSpace(rest)
+		if rest == "" || rest[0] == ')' {
+			break
+		}
+		match := entryRe.FindStringSubmatch(rest)
+		if match == nil {
+			fmt.Printf("Warning: Unexpected text in %%magicNumber: %.40q\n", rest)
+			break
+		}
+		rest = rest[len(match[0]):]
+
+		pattern := ""
+		for {
+			value, remaining, ok := parsePerlString(rest)
+			if !ok {
 				break
 			}
+			pattern += value
+			rest = skipPerlSpace(remaining)
+			if !strings.HasPrefix(rest, ".") {
+				break
+			}
+			rest = skipPerlSpace(rest[1:])
+		}
+		if pattern != "" {
+			result[match[1]] = pattern
+		}
+
+		// Skip to the end of the entry
+		end := strings.IndexAny(rest, ",)")
+		if end < 0 {
+			break
 		}
+		if rest[end] == ',' {
+			end++
+		}
+		rest = rest[end:]
 	}
 
-	section := content[start:end]
+	fmt.Printf("Found %d magic numbers\n", len(result))
+	return result
+}
 
-	// Parse line by line
-	lines := strings.Split(section, "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" || !strings.Contains(line, "=>") {
-			continue
+// skipPerlSpace skips whitespace and # comments
+func skipPerlSpace(s string) string {
+	for {
+		s = strings.TrimLeft(s, " \t\r\n")
+		if !strings.HasPrefix(s, "#") {
+			return s
 		}
+		if nl := strings.IndexByte(s, '\n'); nl >= 0 {
+			s = s[nl:]
+		} else {
+			return ""
+		}
+	}
+}
 
-		// Match patterns with magic bytes
-		re := regexp.MustCompile(`^\s*(\w+)\s*=>\s*'([^']+)'`)
-		match := re.FindStringSubmatch(line)
-		if len(match) >= 3 {
-			// Only include if it looks like a magic number pattern
-			pattern := match[2]
-			if strings.Contains(pattern, "\\x") || strings.Contains(pattern, "\\0") ||
-				len(pattern) <= 10 || pattern == "RawConv" {
-				result[match[1]] = pattern
+// perlListEnd returns the index of the parenthesis closing the one at open,
+// ignoring any in comments and quoted strings, or len(content) if it is
+// never closed
+func perlListEnd(content string, open int) int {
+	depth := 0
+	for i := open; i < len(content); i++ {
+		switch content[i] {
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				return i
+			}
+		case '#':
+			if nl := strings.IndexByte(content[i:], '\n'); nl >= 0 {
+				i += nl
+			} else {
+				return len(content)
 			}
+		case '\'', '"':
+			_, rest, ok := parsePerlString(content[i:])
+			if !ok {
+				return len(content)
+			}
+			i = len(content) - len(rest) - 1
 		}
 	}
+	return len(content)
+}
 
-	fmt.Printf("Found %d magic numbers\n", len(result))
-	return result
+// parsePerlString decodes a single- or double-quoted Perl string literal at
+// the start of s, returning its value and the text after it
+func parsePerlString(s string) (string, string, bool) {
+	if s == "" || (s[0] != '\'' && s[0] != '"') {
+		return "", s, false
+	}
+	quote := s[0]
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		if c == quote {
+			return b.String(), s[i+1:], true
+		}
+		if c != '\\' || i+1 >= len(s) {
+			b.WriteByte(c)
+			continue
+		}
+		next := s[i+1]
+		if quote == '\'' {
+			// Only \\ and \' are escapes in single quotes
+			if next == '\\' || next == '\'' {
+				b.WriteByte(next)
+				i++
+			} else {
+				b.WriteByte(c)
+			}
+			continue
+		}
+		i++
+		switch next {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case '0':
+			b.WriteByte(0)
+		case 'x':
+			// \xHH
+			if i+2 < len(s) {
+				var v byte
+				if _, err := fmt.Sscanf(s[i+1:i+3], "%02x", &v); err == nil {
+					b.WriteByte(v)
+					i += 2
+					continue
+				}
+			}
+			b.WriteByte(0)
+		default:
+			b.WriteByte(next)
+		}
+	}
+	return "", s, false
 }
 
 func parseFileTypes(content string) []string {
@@ -324,19 +422,7 @@ func parseModuleNames(content string) map[string]string {
 	}
 
 	// Find the closing );
-	depth := 0
-	end := start
-	for i := start; i < len(content); i++ {
-		if content[i] == '(' {
-			depth++
-		} else if content[i] == ')' {
-			depth--
-			if depth == 0 && i > start && i+1 < len(content) && content[i+1] == ';' {
-				end = i + 2
-				break
-			}
-		}
-	}
+	end := perlListEnd(content, start+strings.IndexByte(content[start:], '('))
 
 	section := content[start:end]
 
@@ -355,9 +441,9 @@ func parseModuleNames(content string) map[string]string {
 					break
 				}
 			}
-			if value != "0" && value != "" { // Skip '0' values
-				result[key] = value
-			}
+			// Keep '0' and '' values: they mark types ExifTool recognizes
+			// by extension only, or processes without loading a module
+			result[key] = value
 		}
 	}
 
@@ -366,48 +452,59 @@ func parseModuleNames(content string) map[string]string {
 }
 
 func parseMimeTypes(content string) map[string]string {
-	result := make(map[string]string)
+	return parsePerlHash(content, "%mimeType")
+}
 
-	// Look for %mimeType hash
-	start := strings.Index(content, "%mimeType = (")
+// parsePerlHash returns the string values of the Perl hash assigned as
+// "name = (", where name includes the sigil
+func parsePerlHash(content string, name string) map[string]string {
+	start := strings.Index(content, name+" = (")
 	if start == -1 {
-		fmt.Println("Warning: Could not find %mimeType")
-		return result
+		fmt.Printf("Warning: Could not find %s\n", name)
+		return map[string]string{}
 	}
+	open := start + len(name+" = (") - 1
+	result := perlHashEntries(content[open+1 : perlListEnd(content, open)])
+	fmt.Printf("Found %d entries in %s\n", len(result), name)
+	return result
+}
 
-	// Find the closing );
-	depth := 0
-	end := start
-	for i := start; i < len(content); i++ {
-		if content[i] == '(' {
-			depth++
-		} else if content[i] == ')' {
-			depth--
-			if depth == 0 && i > start && i+1 < len(content) && content[i+1] == ';' {
-				end = i + 2
+// perlHashEntries decodes the key => 'value' pairs of a Perl hash body.
+// Keys may be bare words or quoted; entries whose value is not a string
+// literal are skipped.
+func perlHashEntries(body string) map[string]string {
+	result := make(map[string]string)
+	keyRe := regexp.MustCompile(`^\w+`)
+	for s := skipPerlSpace(body); s != ""; s = skipPerlSpace(s) {
+		key, rest, ok := parsePerlString(s)
+		if !ok {
+			if key = keyRe.FindString(s); key == "" {
+				// Not an entry: skip to the next line
+				if nl := strings.IndexByte(s, '\n'); nl >= 0 {
+					s = s[nl:]
+					continue
+				}
 				break
 			}
+			rest = s[len(key):]
 		}
-	}
-
-	section := content[start:end]
-
-	// Parse entries like: DOCX => 'application/vnd...',
-	lines := strings.Split(section, "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" || !strings.Contains(line, "=>") {
+		rest = skipPerlSpace(rest)
+		if !strings.HasPrefix(rest, "=>") {
+			s = rest
 			continue
 		}
-
-		re := regexp.MustCompile(`^\s*(\w+)\s*=>\s*'([^']+)'`)
-		match := re.FindStringSubmatch(line)
-		if len(match) >= 3 {
-			result[match[1]] = match[2]
+		rest = skipPerlSpace(rest[2:])
+		if value, after, ok := parsePerlString(rest); ok {
+			result[key] = value
+			rest = after
+		}
+		// Skip the rest of the value up to the separating comma
+		if comma := strings.IndexAny(rest, ",\n"); comma >= 0 {
+			s = rest[comma+1:]
+		} else {
+			break
 		}
 	}
-
-	fmt.Printf("Found %d MIME types\n", len(result))
 	return result
 }
 
@@ -432,6 +529,8 @@ func generateGoFile(data *FileTypeData, outputPath string) error {
 	fmt.Fprintln(w, "    TestOrder    []string")
 	fmt.Fprintln(w, "    ModuleNames  map[string]string")
 	fmt.Fprintln(w, "    MimeTypes    map[string]string")
+	fmt.Fprintln(w, "    FileTypeExts map[string]string")
+	fmt.Fprintln(w, "    Descriptions map[string]string")
 	fmt.Fprintln(w, "}{")
 
 	// Write Extensions
@@ -474,6 +573,20 @@ func generateGoFile(data *FileTypeData, outputPath string) error {
 	}
 	fmt.Fprintln(w, "    },")
 
+	// Write FileTypeExts
+	fmt.Fprintln(w, "    FileTypeExts: map[string]string{")
+	for fileType, ext := range data.FileTypeExts {
+		fmt.Fprintf(w, "        %q: %q,\n", fileType, ext)
+	}
+	fmt.Fprintln(w, "    },")
+
+	// Write Descriptions
+	fmt.Fprintln(w, "    Descriptions: map[string]string{")
+	for fileType, desc := range data.Descriptions {
+		fmt.Fprintf(w, "        %q: %q,\n", fileType, desc)
+	}
+	fmt.Fprintln(w, "    },")
+
 	fmt.Fprintln(w, "}")
 	fmt.Fprintln(w, "")
 	fmt.Fprintln(w, "type FileTypeInfo struct {")
@@ -0,0 +1,94 @@
+package formats
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"greg-hacke/go-metadata/tags"
+)
+
+// unknownMIMEType is returned for content that cannot be identified, as by
+// http.DetectContentType
+const unknownMIMEType = "application/octet-stream"
+
+// ContentType describes the type of a file's content
+type ContentType struct {
+	FileType    string // ExifTool FileType, e.g. "JPEG" or "NEF"; "" if unknown
+	MIMEType    string // application/octet-stream if unknown
+	Extension   string // Normal lower-case extension for the type, without the dot
+	Description string
+	Confidence  Confidence
+	Mismatch    bool // The file name's extension names a different type
+}
+
+// DetectContentType identifies data, the start of a file, like
+// http.DetectContentType but with ExifTool's file types. filename is
+// optional; its extension picks among types that share a magic number,
+// such as TIFF-based camera raw formats. At most HeaderSize bytes of data
+// are examined, and empty data is unknown.
+func DetectContentType(data []byte, filename string) ContentType {
+	if len(data) == 0 {
+		return ContentType{MIMEType: unknownMIMEType}
+	}
+	id := Identify(data[:min(len(data), headerSize)], filename)
+	best, ok := id.Best()
+	if !ok {
+		return ContentType{MIMEType: unknownMIMEType, Mismatch: id.Mismatch}
+	}
+
+	// Like ExifTool's SetFileType, use the extension's more specific type
+	// when it shares the identified one
+	fileType := best.FileType
+	if ext := id.Extension; ext != "" && ext != fileType && best.Magic {
+		f, fok := tags.ExifToolFileTypes.Extensions[fileType]
+		e, eok := tags.ExifToolFileTypes.Extensions[ext]
+		// Entries without a description are plain aliases
+		if fok && eok && f.Description != "" && e.Description != "" && f.Type == e.Type {
+			// Only when fileType is a root type, not another sub-type
+			if _, ok := tags.ExifToolFileTypes.Extensions[f.Type]; f.Type == fileType || !ok {
+				fileType = ext
+			}
+		}
+	}
+
+	ct := ContentType{
+		FileType:   fileType,
+		MIMEType:   tags.ExifToolFileTypes.MimeTypes[fileType],
+		Extension:  tags.ExifToolFileTypes.FileTypeExts[fileType],
+		Confidence: best.Confidence,
+		Mismatch:   id.Mismatch,
+	}
+	if ct.MIMEType == "" && best.FileType != "TIFF" {
+		// TIFF-based types without their own MIME type are not image/tiff
+		ct.MIMEType = tags.ExifToolFileTypes.MimeTypes[best.FileType]
+	}
+	if ct.MIMEType == "" {
+		ct.MIMEType = unknownMIMEType
+	}
+	if ct.Extension == "" {
+		ct.Extension = strings.ToLower(fileType)
+	}
+	if info, ok := tags.ExifToolFileTypes.Extensions[fileType]; ok && info.Description != "" {
+		ct.Description = info.Description
+	} else {
+		ct.Description = tags.ExifToolFileTypes.Descriptions[fileType]
+	}
+	return ct
+}
+
+// DetectReader reads the start of r and identifies it with
+// DetectContentType. The returned reader yields everything r would have,
+// including the bytes read to identify it, so an upload can be checked and
+// then stored in one pass.
+func DetectReader(r io.Reader, filename string) (ContentType, io.Reader, error) {
+	header := make([]byte, headerSize)
+	n, err := io.ReadFull(r, header)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return ContentType{}, nil, fmt.Errorf("cannot read file header: %w", err)
+	}
+	header = header[:n]
+	return DetectContentType(header, filename), io.MultiReader(bytes.NewReader(header), r), nil
+}
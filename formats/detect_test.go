@@ -0,0 +1,65 @@
+package formats
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDetectContentType(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		filename string
+		want     ContentType
+	}{
+		{"empty", nil, "photo.jpg", ContentType{MIMEType: "application/octet-stream"}},
+		{"unknown", mp3Header, "", ContentType{MIMEType: "application/octet-stream"}},
+		{"JPEG", jpegHeader, "photo.jpg", ContentType{"JPEG", "image/jpeg", "jpg", "Joint Photographic Experts Group", ConfidenceCertain, false}},
+		{"JPEG named .png", jpegHeader, "photo.png", ContentType{"JPEG", "image/jpeg", "jpg", "Joint Photographic Experts Group", ConfidenceHigh, true}},
+		{"TIFF", nefHeader, "", ContentType{"TIFF", "image/tiff", "tif", "Tagged Image File Format", ConfidenceMedium, false}},
+		// The extension names a type sharing the magic number
+		{"NEF", nefHeader, "DSC_0001.NEF", ContentType{"NEF", "image/x-nikon-nef", "nef", "Nikon (RAW) Electronic Format", ConfidenceHigh, false}},
+		// THM has no MIME type of its own, so it takes JPEG's
+		{"MIME type of the root type", jpegHeader, "IMG_0001.THM", ContentType{"THM", "image/jpeg", "thm", "Thumbnail", ConfidenceCertain, false}},
+		// but a raw format is not image/tiff
+		{"no MIME type for a TIFF-based type", nefHeader, "DSC_0001.ARQ", ContentType{"ARQ", "application/octet-stream", "arq", "Sony Alpha Pixel-Shift RAW format", ConfidenceHigh, false}},
+		{"extension only", mp3Header, "song.mp3", ContentType{"MP3", "audio/mpeg", "mp3", "MPEG-1 Layer 3 audio", ConfidenceLow, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectContentType(tt.data, tt.filename); got != tt.want {
+				t.Errorf("DetectContentType = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectReader(t *testing.T) {
+	for _, size := range []int{0, 20, HeaderSize(), HeaderSize() + 5000} {
+		data := append(append([]byte{}, jpegHeader...), bytes.Repeat([]byte{0xAA}, size)...)[:size]
+		ct, r, err := DetectReader(iotest.OneByteReader(bytes.NewReader(data)), "photo.jpg")
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if want := DetectContentType(data, "photo.jpg"); ct != want {
+			t.Errorf("%d bytes: DetectReader = %+v, want %+v", size, ct, want)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%d bytes: reading: %v", size, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%d bytes: reader yields %d bytes, want the %d read", size, len(got), len(data))
+		}
+	}
+
+	errRead := errors.New("connection reset")
+	if _, _, err := DetectReader(iotest.ErrReader(errRead), ""); !errors.Is(err, errRead) ||
+		!strings.Contains(err.Error(), "cannot read file header") {
+		t.Errorf("DetectReader error = %v", err)
+	}
+}
@@ -0,0 +1,234 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+func init() {
+	Register("GIF", gifHandler{})
+}
+
+// GIF block introducers and extension labels
+const (
+	gifExtension       = 0x21
+	gifImageDescriptor = 0x2C
+	gifTrailer         = 0x3B
+
+	gifGraphicControl = 0xF9
+	gifComment        = 0xFE
+	gifApplication    = 0xFF
+)
+
+// gifXMPApplication identifies the application extension holding XMP. The
+// packet is stored as is, its bytes read as sub-block lengths, and is
+// followed by a 258-byte magic trailer that brings a reader skipping
+// sub-blocks back to the terminator.
+const gifXMPApplication = "XMP DataXMP"
+
+// gifXMPTrailerSize is the length of the magic trailer after a GIF XMP
+// packet: 0x01, the bytes 0xFF down to 0x00, and the block terminator
+const gifXMPTrailerSize = 258
+
+// gifHandler walks the blocks of a GIF file
+type gifHandler struct{}
+
+// Sniff reports whether header starts with a GIF87a or GIF89a signature
+func (gifHandler) Sniff(header []byte) bool {
+	return len(header) >= 6 && (string(header[0:6]) == "GIF87a" || string(header[0:6]) == "GIF89a")
+}
+
+// Parse records the version and logical screen descriptor, comments, the
+// NETSCAPE2.0 loop count and the frame count and duration of animations,
+// and passes XMP and ICC application extensions to sink
+func (gifHandler) Parse(r io.ReaderAt, size int64, sink Sink) error {
+	sink.Segment(0, "GIF", "GIF structure")
+
+	header := make([]byte, 13)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return fmt.Errorf("reading GIF header: %w", err)
+	}
+	sink.Tag(Tag{Group: "GIF", Table: "GIF::Main", ID: "GIFVersion", Name: "GIFVersion", Value: string(header[3:6])})
+
+	// GIF::Screen is a binary table indexed by byte offset after the signature
+	screen := header[6:13]
+	flags := screen[4]
+	fields := []tableField{
+		{"0", "ImageWidth", int(binary.LittleEndian.Uint16(screen[0:2]))},
+		{"2", "ImageHeight", int(binary.LittleEndian.Uint16(screen[2:4]))},
+		{"4.1", "HasColorMap", int(flags >> 7)},
+		{"4.2", "ColorResolutionDepth", int(flags>>4&0x07) + 1},
+		{"4.3", "BitsPerPixel", int(flags&0x07) + 1},
+		{"5", "BackgroundColor", int(screen[5])},
+	}
+	if screen[6] != 0 {
+		fields = append(fields, tableField{"6", "PixelAspectRatio", float64(int(screen[6])+15) / 64})
+	}
+	for _, f := range fields {
+		sink.Tag(Tag{Group: "GIF", Table: "GIF::Screen", ID: f.id, Name: f.name, Value: f.value})
+	}
+
+	offset := int64(13)
+	if flags&0x80 != 0 {
+		offset += 3 << (flags&0x07 + 1) // Global color table
+	}
+
+	frames, delay := 0, 0 // Delays are in hundredths of a second
+	buf := make([]byte, 10)
+	for offset < size {
+		if _, err := r.ReadAt(buf[:1], offset); err != nil {
+			return fmt.Errorf("reading GIF block at offset %d: %w", offset, err)
+		}
+		switch buf[0] {
+		case gifTrailer:
+			offset = size
+			continue
+
+		case gifImageDescriptor:
+			// Position and size, flags with any local color table, then the
+			// LZW code size and the image data sub-blocks
+			if _, err := r.ReadAt(buf, offset); err != nil {
+				return fmt.Errorf("reading GIF image descriptor at offset %d: %w", offset, err)
+			}
+			frames++
+			offset += 10
+			if buf[9]&0x80 != 0 {
+				offset += 3 << (buf[9]&0x07 + 1)
+			}
+			end, err := gifSubBlocks(r, offset+1, size, nil)
+			if err != nil {
+				sink.Warn("GIF image data at offset %d is truncated", offset)
+				return nil
+			}
+			offset = end
+
+		case gifExtension:
+			if _, err := r.ReadAt(buf[:2], offset); err != nil {
+				return fmt.Errorf("reading GIF extension at offset %d: %w", offset, err)
+			}
+			label := buf[1]
+			end, err := gifExtensionBlock(r, label, offset, size, &delay, sink)
+			if err != nil {
+				sink.Warn("GIF extension 0x%02X at offset %d is truncated", label, offset)
+				return nil
+			}
+			offset = end
+
+		default:
+			sink.Warn("Unknown GIF block 0x%02X at offset %d", buf[0], offset)
+			return nil
+		}
+	}
+
+	if frames > 1 {
+		sink.Tag(Tag{Group: "GIF", Table: "GIF::Main", ID: "FrameCount", Name: "FrameCount", Value: frames})
+	}
+	if delay > 0 {
+		sink.Tag(Tag{Group: "GIF", Table: "GIF::Main", ID: "Duration", Name: "Duration", Value: float64(delay) / 100})
+	}
+	return nil
+}
+
+// gifExtensionBlock decodes the extension at offset, adding any frame delay
+// to delay, and returns the offset after it
+func gifExtensionBlock(r io.ReaderAt, label byte, offset, size int64, delay *int, sink Sink) (int64, error) {
+	start := offset + 2
+	switch label {
+	case gifGraphicControl:
+		var data bytes.Buffer
+		end, err := gifSubBlocks(r, start, size, &data)
+		if err == nil && data.Len() >= 3 {
+			*delay += int(binary.LittleEndian.Uint16(data.Bytes()[1:3]))
+		}
+		return end, err
+
+	case gifComment:
+		var data bytes.Buffer
+		end, err := gifSubBlocks(r, start, size, &data)
+		if err == nil {
+			sink.Tag(Tag{Group: "GIF", Table: "GIF::Main", ID: "Comment", Name: "Comment", Value: data.String()})
+		}
+		return end, err
+
+	case gifApplication:
+		// An 11-byte identifier and authentication code, then the data
+		id := make([]byte, 12)
+		if _, err := r.ReadAt(id, start); err != nil {
+			return 0, err
+		}
+		if id[0] != 11 {
+			break
+		}
+		app := string(id[1:12])
+		sink.Segment(offset, "Application", app)
+		dataStart := start + 12
+
+		if app == gifXMPApplication {
+			end, err := gifSubBlocks(r, dataStart, size, nil)
+			if err != nil {
+				return end, err
+			}
+			// The packet is everything up to the trailer
+			if xmpLen := end - dataStart - gifXMPTrailerSize; xmpLen > 0 {
+				sink.Block(BlockXMP, r, dataStart, xmpLen)
+			} else {
+				sink.Warn("GIF XMP at offset %d has no magic trailer", offset)
+			}
+			return end, nil
+		}
+
+		var data bytes.Buffer
+		end, err := gifSubBlocks(r, dataStart, size, &data)
+		if err != nil {
+			return end, err
+		}
+		switch app {
+		case "NETSCAPE2.0", "ANIMEXTS1.0":
+			// A sub-block ID of 1 and the number of times to loop, 0 for ever
+			if b := data.Bytes(); len(b) >= 3 && b[0] == 1 {
+				sink.Tag(Tag{Group: "GIF", Table: "GIF::Animation", ID: "1", Name: "AnimationIterations", Value: int(binary.LittleEndian.Uint16(b[1:3]))})
+			}
+		case "ICCRGBG1012":
+			if sink.Wants("ICC_Profile", "") {
+				sink.Block(BlockICC, bytes.NewReader(data.Bytes()), 0, int64(data.Len()))
+			}
+		}
+		return end, nil
+	}
+	return gifSubBlocks(r, start, size, nil)
+}
+
+// gifSubBlocks walks the data sub-blocks at offset, each a length byte and
+// that many bytes, up to the zero-length terminator. The data is appended
+// to data unless it is nil. It returns the offset after the terminator.
+func gifSubBlocks(r io.ReaderAt, offset, size int64, data *bytes.Buffer) (int64, error) {
+	block := make([]byte, 256)
+	for {
+		if offset >= size {
+			return offset, io.ErrUnexpectedEOF
+		}
+		if _, err := r.ReadAt(block[:1], offset); err != nil {
+			return offset, err
+		}
+		n := int64(block[0])
+		offset++
+		if n == 0 {
+			return offset, nil
+		}
+		if offset+n > size {
+			return offset, io.ErrUnexpectedEOF
+		}
+		if data != nil {
+			if data.Len()+int(n) > maxBlockSize {
+				return offset, fmt.Errorf("GIF extension exceeds %d bytes", maxBlockSize)
+			}
+			if _, err := r.ReadAt(block[:n], offset); err != nil {
+				return offset, err
+			}
+			data.Write(block[:n])
+		}
+		offset += n
+	}
+}
@@ -0,0 +1,118 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// gifTestFile builds an animated GIF with three frames, a loop count, a
+// comment split over two sub-blocks and XMP with its magic trailer
+func gifTestFile() []byte {
+	le := binary.LittleEndian
+	frame := func(delay uint16) []byte {
+		control := le.AppendUint16([]byte{0x21, 0xF9, 0x04, 0x00}, delay)
+		control = append(control, 0x00, 0x00)
+		descriptor := []byte{0x2C, 0, 0, 0, 0, 2, 0, 2, 0, 0}
+		return bytes.Join([][]byte{control, descriptor, {0x02, 0x02, 0x44, 0x01, 0x00}}, nil)
+	}
+	trailer := []byte{0x01}
+	for i := 255; i >= 0; i-- {
+		trailer = append(trailer, byte(i))
+	}
+	trailer = append(trailer, 0x00)
+
+	return bytes.Join([][]byte{
+		[]byte("GIF89a"),
+		{2, 0, 2, 0, 0x91, 0, 49},
+		{0, 0, 0, 0xFF, 0xFF, 0xFF, 0xFF, 0, 0, 0, 0, 0xFF}, // Global color table
+		[]byte("\x21\xFF\x0BNETSCAPE2.0\x03\x01\x00\x00\x00"),
+		[]byte("\x21\xFE\x05hello\x06 world\x00"),
+		[]byte("\x21\xFF\x0B" + gifXMPApplication), testXMP, trailer,
+		frame(10), frame(25), frame(5),
+		{gifTrailer},
+	}, nil)
+}
+
+func TestGIF(t *testing.T) {
+	sink := parseTest(t, gifHandler{}, gifTestFile())
+	sink.checkTags(t, []tagCheck{
+		{"GIF::Main", "GIFVersion", "89a"},
+		{"GIF::Main", "Comment", "hello world"},
+		{"GIF::Main", "FrameCount", 3},
+		{"GIF::Main", "Duration", 0.4},
+		{"GIF::Screen", "ImageWidth", 2},
+		{"GIF::Screen", "ImageHeight", 2},
+		{"GIF::Screen", "HasColorMap", 1},
+		{"GIF::Screen", "ColorResolutionDepth", 2},
+		{"GIF::Screen", "BitsPerPixel", 2},
+		{"GIF::Screen", "BackgroundColor", 0},
+		{"GIF::Screen", "PixelAspectRatio", 1.0},
+		{"GIF::Animation", "AnimationIterations", 0},
+	})
+	sink.checkBlocks(t, testBlock{BlockXMP, testXMP})
+	if len(sink.warnings) > 0 {
+		t.Errorf("warnings: %q", sink.warnings)
+	}
+}
+
+// gifWithExtensions builds a GIF with a one-pixel screen, the given
+// extension blocks and no image
+func gifWithExtensions(extensions ...[]byte) []byte {
+	return bytes.Join(append(append([][]byte{[]byte("GIF89a"), {1, 0, 1, 0, 0, 0, 0}}, extensions...), []byte{gifTrailer}), nil)
+}
+
+func TestGIFExtensions(t *testing.T) {
+	icc := []byte("icc profile")
+	tests := []struct {
+		name    string
+		file    []byte
+		tags    []tagCheck
+		blocks  []testBlock
+		warning string
+	}{
+		{
+			// Written by tools that treat the packet as ordinary sub-blocks
+			"XMP without trailer",
+			gifWithExtensions([]byte("\x21\xFF\x0B" + gifXMPApplication + "\x05<x:a/\x00")),
+			nil, nil, "has no magic trailer",
+		},
+		{
+			"ICC profile",
+			gifWithExtensions([]byte("\x21\xFF\x0BICCRGBG1012"), []byte{byte(len(icc))}, icc, []byte{0}),
+			nil, []testBlock{{BlockICC, icc}}, "",
+		},
+		{
+			"ANIMEXTS loop count",
+			gifWithExtensions([]byte("\x21\xFF\x0BANIMEXTS1.0\x03\x01\x05\x00\x00")),
+			[]tagCheck{{"GIF::Animation", "AnimationIterations", 5}}, nil, "",
+		},
+		{
+			"comment over sub-blocks",
+			gifWithExtensions([]byte("\x21\xFE\x01a\x02bc\x00")),
+			[]tagCheck{{"GIF::Main", "Comment", "abc"}}, nil, "",
+		},
+		{
+			"unterminated extension",
+			gifWithExtensions([]byte("\x21\xFE\x40abc")),
+			nil, nil, "GIF extension 0xFE at offset 13 is truncated",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := parseTest(t, gifHandler{}, tt.file)
+			sink.checkTags(t, tt.tags)
+			sink.checkBlocks(t, tt.blocks...)
+			if tt.warning != "" {
+				sink.checkWarning(t, tt.warning)
+			}
+		})
+	}
+}
+
+func FuzzGIF(f *testing.F) {
+	f.Add(gifTestFile())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzParse(gifHandler{}, data)
+	})
+}
@@ -0,0 +1,115 @@
+package formats
+
+import (
+	"io"
+	"strings"
+)
+
+// BlockKind identifies a kind of embedded metadata block that a Handler
+// passes to its Sink rather than decoding itself
+type BlockKind int
+
+const (
+	BlockTIFF        BlockKind = iota // TIFF/EXIF structure starting with its byte-order header
+	BlockXMP                          // XMP packet
+	BlockIPTC                         // IPTC-IIM records
+	BlockPhotoshop                    // Photoshop image resources (8BIM)
+	BlockExtendedXMP                  // Extended XMP packet, reassembled from its chunks
+	BlockICC                          // ICC color profile
+	BlockMPF                          // Multi-Picture Format index, a TIFF structure locating the images after the first
+)
+
+// String returns the name of the block kind
+func (k BlockKind) String() string {
+	switch k {
+	case BlockTIFF:
+		return "TIFF"
+	case BlockXMP:
+		return "XMP"
+	case BlockIPTC:
+		return "IPTC"
+	case BlockPhotoshop:
+		return "Photoshop"
+	case BlockExtendedXMP:
+		return "ExtendedXMP"
+	case BlockICC:
+		return "ICC_Profile"
+	case BlockMPF:
+		return "MPF"
+	}
+	return "unknown"
+}
+
+// Tag is a value decoded directly by a Handler
+type Tag struct {
+	Group     string      // Family 0 group, e.g. "File" or "PNG"
+	Directory string      // Family 1 group, empty when the same as Group
+	Table     string      // tags.AllTags key the ID is resolved against, empty for none
+	ID        string      // Tag ID within Table, e.g. "0x0100" or a PNG text keyword
+	Name      string      // Tag name used when Table has no definition for ID
+	Value     interface{} // Decoded value
+}
+
+// tableField is a value a Handler decodes from a binary structure, by its
+// ID in the table the structure is read with
+type tableField struct {
+	id    string
+	name  string
+	value interface{}
+}
+
+// tagNameFor makes a tag name from a text keyword or atom name, as ExifTool
+// does for ones not in its tables: characters other than letters, digits,
+// hyphens and underscores are dropped, capitalizing the letter after them,
+// and the first letter is capitalized
+func tagNameFor(keyword string) string {
+	var name strings.Builder
+	upper := true
+	for _, r := range keyword {
+		if r != '-' && r != '_' && (r < '0' || r > '9') && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			upper = true
+			continue
+		}
+		if upper && r >= 'a' && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		upper = false
+		name.WriteRune(r)
+	}
+	if name.Len() == 0 {
+		return "Unknown"
+	}
+	return name.String()
+}
+
+// Sink receives what a Handler finds while parsing a file
+type Sink interface {
+	// Wants reports whether a requested tag could be in the family 0 group.
+	// An empty name asks about any tag in the group. Handlers use it to skip
+	// work, but may pass on tags and blocks that are not wanted.
+	Wants(group, name string) bool
+
+	// Tag records a value decoded by the handler
+	Tag(tag Tag)
+
+	// Block decodes an embedded metadata block of size bytes at offset in r,
+	// reporting whether anything was extracted from it
+	Block(kind BlockKind, r io.ReaderAt, offset, size int64) bool
+
+	// Segment notes a structural element of the file for tracing
+	Segment(offset int64, name, detail string)
+
+	// Warn records a non-fatal problem with the file
+	Warn(format string, args ...interface{})
+}
+
+// Handler parses one file format
+type Handler interface {
+	// Sniff reports whether header, the first bytes of a file, is in this format
+	Sniff(header []byte) bool
+
+	// Parse walks the size bytes of r, passing what it finds to sink. An
+	// error means the structure could not be read any further; what was
+	// already passed to sink is kept.
+	Parse(r io.ReaderAt, size int64, sink Sink) error
+}
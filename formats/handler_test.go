@@ -0,0 +1,144 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSink records what a Handler passes to its Sink
+type testSink struct {
+	tags     []Tag
+	blocks   []testBlock
+	warnings []string
+}
+
+// testBlock is a metadata block passed to a testSink
+type testBlock struct {
+	kind BlockKind
+	data []byte
+}
+
+func (s *testSink) Wants(group, name string) bool { return true }
+
+func (s *testSink) Tag(tag Tag) { s.tags = append(s.tags, tag) }
+
+func (s *testSink) Block(kind BlockKind, r io.ReaderAt, offset, size int64) bool {
+	data, err := readBlock(r, offset, size)
+	if err != nil {
+		s.Warn("reading %s block: %v", kind, err)
+		return false
+	}
+	s.blocks = append(s.blocks, testBlock{kind, data})
+	return true
+}
+
+func (s *testSink) Segment(offset int64, name, detail string) {}
+
+func (s *testSink) Warn(format string, args ...interface{}) {
+	s.warnings = append(s.warnings, fmt.Sprintf(format, args...))
+}
+
+// parseTest runs h over data, failing t if Parse returns an error
+func parseTest(t *testing.T, h Handler, data []byte) *testSink {
+	t.Helper()
+	if !h.Sniff(data) {
+		t.Fatalf("%T does not recognize the test file", h)
+	}
+	sink := &testSink{}
+	if err := h.Parse(bytes.NewReader(data), int64(len(data)), sink); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	return sink
+}
+
+// fuzzParse runs h over data, which must not panic whatever it holds
+func fuzzParse(h Handler, data []byte) {
+	h.Parse(bytes.NewReader(data), int64(len(data)), &testSink{})
+}
+
+// tagCheck is a value a test expects a handler to emit
+type tagCheck struct {
+	table, name string
+	want        interface{}
+}
+
+// checkTags fails t for each check without a matching tag. Tags without a
+// table are checked with an empty table.
+func (s *testSink) checkTags(t *testing.T, checks []tagCheck) {
+	t.Helper()
+	for _, c := range checks {
+		var found []interface{}
+		for _, tag := range s.tags {
+			if tag.Table == c.table && tag.Name == c.name {
+				found = append(found, tag.Value)
+			}
+		}
+		switch {
+		case len(found) == 0:
+			t.Errorf("%s %s: not found", c.table, c.name)
+		case !containsValue(found, c.want):
+			t.Errorf("%s %s = %#v, want %#v", c.table, c.name, found, c.want)
+		}
+	}
+}
+
+// containsValue reports whether values holds want. Times are compared as
+// instants, whatever their location.
+func containsValue(values []interface{}, want interface{}) bool {
+	for _, v := range values {
+		if t, ok := want.(time.Time); ok {
+			if vt, ok := v.(time.Time); ok && vt.Equal(t) {
+				return true
+			}
+			continue
+		}
+		if reflect.DeepEqual(v, want) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkWarning fails t unless a warning passed to s contains substr
+func (s *testSink) checkWarning(t *testing.T, substr string) {
+	t.Helper()
+	for _, w := range s.warnings {
+		if strings.Contains(w, substr) {
+			return
+		}
+	}
+	t.Errorf("no warning containing %q in %q", substr, s.warnings)
+}
+
+// checkBlocks fails t unless the blocks passed to s are those given
+func (s *testSink) checkBlocks(t *testing.T, want ...testBlock) {
+	t.Helper()
+	if !reflect.DeepEqual(s.blocks, want) {
+		var got []string
+		for _, b := range s.blocks {
+			got = append(got, fmt.Sprintf("%s %q", b.kind, b.data))
+		}
+		t.Errorf("blocks = %q, want %d blocks", got, len(want))
+	}
+}
+
+// testTIFF is a minimal big-endian TIFF structure with an empty IFD0
+var testTIFF = []byte("MM\x00\x2A\x00\x00\x00\x08\x00\x00\x00\x00\x00\x00")
+
+// testXMP is a minimal XMP packet
+var testXMP = []byte(`<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?><x:xmpmeta xmlns:x="adobe:ns:meta/"/><?xpacket end="w"?>`)
+
+// be builds big-endian bytes from values of fixed-size integer types
+func be(values ...interface{}) []byte {
+	var buf bytes.Buffer
+	for _, v := range values {
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+	return buf.Bytes()
+}
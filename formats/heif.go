@@ -0,0 +1,391 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// heifXMPContentType is the content type of the mime item holding XMP
+const heifXMPContentType = "application/rdf+xml"
+
+// heifItem is an item of a HEIF meta box: an image, or metadata such as
+// EXIF or XMP, located by its extents
+type heifItem struct {
+	id          uint32
+	itemType    string // "hvc1", "av01", "grid", "Exif", "mime", ...
+	contentType string // MIME type of a mime item
+	method      int    // Construction method: 0 from the file, 1 from the idat box
+	extents     []heifExtent
+	props       []int // 1-based indexes of the item's properties in ipco
+}
+
+// heifExtent is a run of an item's data
+type heifExtent struct {
+	offset, length int64
+}
+
+// heifMeta collects the boxes of a meta box, which can come in any order
+type heifMeta struct {
+	size    int64 // Size of the file
+	primary uint32
+	items   map[uint32]*heifItem
+	props   []isoBox // Children of ipco
+	idat    isoBox
+	refs    map[uint32][]string // References from each item, like "cdsc 1"
+}
+
+// item returns the item with the given ID, creating it if needed
+func (m *heifMeta) item(id uint32) *heifItem {
+	if m.items[id] == nil {
+		m.items[id] = &heifItem{id: id}
+	}
+	return m.items[id]
+}
+
+// parseHEIFMeta decodes the meta box of a HEIF or AVIF file: the handler,
+// the primary item, the item information and locations, the references
+// between items and the item properties. The properties of the primary
+// image are recorded, and the Exif and XMP items are passed to sink. size
+// is the size of the file.
+func parseHEIFMeta(r io.ReaderAt, size int64, meta isoBox, sink Sink) error {
+	m := &heifMeta{size: size, items: make(map[uint32]*heifItem), refs: make(map[uint32][]string)}
+
+	// meta is a full box; its children follow the version and flags
+	err := walkBoxes(r, meta.Data+4, meta.End, sink, func(box isoBox) error {
+		sink.Segment(box.Offset, box.Type, fmt.Sprintf("%d bytes", box.Size()))
+		switch box.Type {
+		case "idat":
+			m.idat = box
+			return nil
+		case "iprp":
+			return walkBoxes(r, box.Data, box.End, sink, func(child isoBox) error {
+				switch child.Type {
+				case "ipco":
+					return walkBoxes(r, child.Data, child.End, sink, func(prop isoBox) error {
+						m.props = append(m.props, prop)
+						return nil
+					})
+				case "ipma":
+					data, err := readBlock(r, child.Data, child.Size())
+					if err != nil {
+						return fmt.Errorf("reading ipma box: %w", err)
+					}
+					m.parseAssociations(data, sink)
+				}
+				return nil
+			})
+		case "hdlr", "pitm", "iinf", "iloc", "iref":
+		default:
+			return nil
+		}
+
+		data, err := readBlock(r, box.Data, box.Size())
+		if err != nil {
+			return fmt.Errorf("reading %s box: %w", box.Type, err)
+		}
+		b := &boxReader{data: data}
+		version, _ := b.fullBox()
+		switch box.Type {
+		case "hdlr":
+			// Predefined, then the handler type, "pict" for images
+			b.uint(4)
+			sink.Tag(Tag{Group: "QuickTime", Directory: "Meta", Table: "QuickTime::Handler", ID: "8", Name: "HandlerType", Value: string(b.bytes(4))})
+		case "pitm":
+			m.primary = uint32(b.uint(heifIDSize(version)))
+			sink.Tag(Tag{Group: "QuickTime", Directory: "Meta", Table: "QuickTime::Meta", ID: "pitm", Name: "PrimaryItemReference", Value: int(m.primary)})
+		case "iinf":
+			m.parseItemInfo(r, box, version, sink)
+		case "iloc":
+			m.parseLocations(b, version)
+		case "iref":
+			m.parseReferences(b, version)
+		}
+		if b.short {
+			sink.Warn("HEIF %s box at offset %d is truncated", box.Type, box.Offset)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if item := m.items[m.primary]; item != nil {
+		m.parseProperties(r, item, sink)
+	}
+
+	// Metadata items in ID order; each normally describes the primary image
+	ids := make([]uint32, 0, len(m.items))
+	for id := range m.items {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		item := m.items[id]
+		isXMP := item.itemType == "mime" && item.contentType == heifXMPContentType
+		if item.itemType != "Exif" && !isXMP {
+			continue
+		}
+		offset := meta.Offset
+		if len(item.extents) > 0 {
+			offset = item.extents[0].offset
+			if item.method == 1 {
+				offset += m.idat.Data
+			}
+		}
+		sink.Segment(offset, fmt.Sprintf("%s item %d", item.itemType, id), strings.Join(m.refs[id], ", "))
+		data, ok := m.itemData(r, item, sink)
+		if !ok {
+			continue
+		}
+		if isXMP {
+			sink.Block(BlockXMP, bytes.NewReader(data), 0, int64(len(data)))
+			continue
+		}
+		// The TIFF header follows a 32-bit offset to it, which skips the
+		// "Exif\0\0" of a JPEG APP1 segment
+		if len(data) < 4 || int64(binary.BigEndian.Uint32(data[0:4])) > int64(len(data)-4) {
+			sink.Warn("Invalid HEIF Exif item %d", id)
+			continue
+		}
+		tiff := data[4+binary.BigEndian.Uint32(data[0:4]):]
+		sink.Block(BlockTIFF, bytes.NewReader(tiff), 0, int64(len(tiff)))
+	}
+	return nil
+}
+
+// heifIDSize returns the size of item IDs in a box of the given version,
+// 32 bits from version 1 on for most boxes
+func heifIDSize(version int) int {
+	if version == 0 {
+		return 2
+	}
+	return 4
+}
+
+// parseItemInfo reads the infe boxes of an iinf box, each giving the ID and
+// type of an item and, for mime items, its content type
+func (m *heifMeta) parseItemInfo(r io.ReaderAt, iinf isoBox, version int, sink Sink) {
+	start := iinf.Data + 4 + int64(heifIDSize(version)) // Version, flags and entry count
+	walkBoxes(r, start, iinf.End, sink, func(box isoBox) error {
+		if box.Type != "infe" {
+			return nil
+		}
+		data, err := readBlock(r, box.Data, min(box.Size(), 1024))
+		if err != nil {
+			return nil
+		}
+		b := &boxReader{data: data}
+		v, _ := b.fullBox()
+		if v < 2 {
+			// Versions 0 and 1 have no item type
+			return nil
+		}
+		size := 2
+		if v > 2 {
+			size = 4
+		}
+		item := m.item(uint32(b.uint(size)))
+		b.uint(2) // Protection index
+		item.itemType = string(b.bytes(4))
+		b.string() // Item name
+		if item.itemType == "mime" {
+			item.contentType = b.string()
+		}
+		return nil
+	})
+}
+
+// parseLocations reads an iloc box, giving the extents of each item's data.
+// An extent length of 0 runs to the end of the file or the idat box, which
+// itemData works out as the idat box can come after the iloc box.
+func (m *heifMeta) parseLocations(b *boxReader, version int) {
+	sizes := b.uint(2)
+	offsetSize, lengthSize := int(sizes>>12), int(sizes>>8&0x0F)
+	baseSize, indexSize := int(sizes>>4&0x0F), 0
+	if version == 1 || version == 2 {
+		indexSize = int(sizes & 0x0F)
+	}
+	// Item counts and IDs are 32-bit only from version 2
+	idSize := 2
+	if version == 2 {
+		idSize = 4
+	}
+	count := int(b.uint(idSize))
+	for i := 0; i < count && !b.short; i++ {
+		item := m.item(uint32(b.uint(idSize)))
+		if version == 1 || version == 2 {
+			item.method = int(b.uint(2) & 0x0F)
+		}
+		b.uint(2) // Data reference index
+		base := int64(b.uint(baseSize))
+		extents := int(b.uint(2))
+		item.extents = item.extents[:0]
+		for j := 0; j < extents && !b.short; j++ {
+			b.uint(indexSize)
+			offset := int64(b.uint(offsetSize))
+			length := int64(b.uint(lengthSize))
+			item.extents = append(item.extents, heifExtent{base + offset, length})
+		}
+	}
+}
+
+// parseReferences reads an iref box: boxes named by the reference type,
+// such as "cdsc" for metadata describing an image or "thmb" for a
+// thumbnail, each from one item to others
+func (m *heifMeta) parseReferences(b *boxReader, version int) {
+	size := heifIDSize(version)
+	for !b.short && b.pos+8 <= len(b.data) {
+		boxSize := int(b.uint(4))
+		refType := string(b.bytes(4))
+		end := b.pos - 8 + boxSize
+		if boxSize < 8 || end > len(b.data) {
+			return
+		}
+		from := uint32(b.uint(size))
+		count := int(b.uint(2))
+		for i := 0; i < count && b.pos+size <= end; i++ {
+			m.refs[from] = append(m.refs[from], fmt.Sprintf("%s %d", refType, b.uint(size)))
+		}
+		b.pos = end
+	}
+}
+
+// parseAssociations reads an ipma box, listing the properties of each item
+// as 1-based indexes into ipco with a flag for essential properties
+func (m *heifMeta) parseAssociations(data []byte, sink Sink) {
+	b := &boxReader{data: data}
+	version, flags := b.fullBox()
+	count := int(b.uint(4))
+	for i := 0; i < count && !b.short; i++ {
+		item := m.item(uint32(b.uint(heifIDSize(version))))
+		n := int(b.uint(1))
+		for j := 0; j < n && !b.short; j++ {
+			if flags&1 != 0 {
+				item.props = append(item.props, int(b.uint(2)&0x7FFF))
+			} else {
+				item.props = append(item.props, int(b.uint(1)&0x7F))
+			}
+		}
+	}
+	if b.short {
+		sink.Warn("HEIF ipma box is truncated")
+	}
+}
+
+// parseProperties records the size, pixel depth, rotation and color of an
+// image from the properties associated with it
+func (m *heifMeta) parseProperties(r io.ReaderAt, item *heifItem, sink Sink) {
+	for _, index := range item.props {
+		if index < 1 || index > len(m.props) {
+			continue
+		}
+		prop := m.props[index-1]
+		switch prop.Type {
+		case "ispe", "pixi", "irot", "colr":
+		default:
+			continue
+		}
+		data, err := readBlock(r, prop.Data, prop.Size())
+		if err != nil {
+			sink.Warn("HEIF %s property at offset %d could not be read: %v", prop.Type, prop.Offset, err)
+			continue
+		}
+		b := &boxReader{data: data}
+		switch prop.Type {
+		case "ispe":
+			// Full box, then the width and height
+			b.fullBox()
+			w, h := int(b.uint(4)), int(b.uint(4))
+			if b.short {
+				break
+			}
+			sink.Tag(Tag{Group: "QuickTime", Directory: "Meta", Table: "QuickTime::ItemProp", ID: "ispe", Name: "ImageSpatialExtent", Value: []int{w, h}})
+			sink.Tag(Tag{Group: "QuickTime", Directory: "Meta", Name: "ImageWidth", Value: w})
+			sink.Tag(Tag{Group: "QuickTime", Directory: "Meta", Name: "ImageHeight", Value: h})
+		case "pixi":
+			// Full box, then the bits per sample of each channel
+			b.fullBox()
+			var depth []int
+			for _, bits := range b.bytes(int(b.uint(1))) {
+				depth = append(depth, int(bits))
+			}
+			sink.Tag(Tag{Group: "QuickTime", Directory: "Meta", Table: "QuickTime::ItemProp", ID: "pixi", Name: "ImagePixelDepth", Value: depth})
+		case "irot":
+			// Anticlockwise rotation in units of 90 degrees
+			sink.Tag(Tag{Group: "QuickTime", Directory: "Meta", Table: "QuickTime::ItemProp", ID: "irot", Name: "Rotation", Value: int(b.uint(1) & 0x03)})
+		case "colr":
+			parseColorProperty(data, prop.Offset, sink)
+		}
+		if b.short {
+			sink.Warn("HEIF %s property at offset %d is truncated", prop.Type, prop.Offset)
+		}
+	}
+}
+
+// parseColorProperty records a colr property: nclx color parameters, or
+// an ICC profile for the prof and rICC types
+func parseColorProperty(data []byte, offset int64, sink Sink) {
+	if len(data) < 4 {
+		return
+	}
+	switch colorType := string(data[0:4]); colorType {
+	case "nclx":
+		if len(data) < 11 {
+			sink.Warn("HEIF colr property at offset %d is truncated", offset)
+			return
+		}
+		fields := []tableField{
+			{"0", "ColorProfiles", colorType},
+			{"4", "ColorPrimaries", int(binary.BigEndian.Uint16(data[4:6]))},
+			{"6", "TransferCharacteristics", int(binary.BigEndian.Uint16(data[6:8]))},
+			{"8", "MatrixCoefficients", int(binary.BigEndian.Uint16(data[8:10]))},
+			{"10", "VideoFullRangeFlag", int(data[10] >> 7)},
+		}
+		for _, f := range fields {
+			sink.Tag(Tag{Group: "QuickTime", Directory: "Meta", Table: "QuickTime::ColorRep", ID: f.id, Name: f.name, Value: f.value})
+		}
+	case "prof", "rICC":
+		sink.Tag(Tag{Group: "QuickTime", Directory: "Meta", Table: "QuickTime::ColorRep", ID: "0", Name: "ColorProfiles", Value: colorType})
+		sink.Block(BlockICC, bytes.NewReader(data[4:]), 0, int64(len(data)-4))
+	}
+}
+
+// itemData reads the extents of an item from the file or the idat box
+func (m *heifMeta) itemData(r io.ReaderAt, item *heifItem, sink Sink) ([]byte, bool) {
+	base, end, within := int64(0), m.size, "file"
+	switch item.method {
+	case 0:
+	case 1:
+		base, end, within = m.idat.Data, m.idat.End, "idat box"
+	default:
+		sink.Warn("HEIF item %d uses unsupported construction method %d", item.id, item.method)
+		return nil, false
+	}
+	var data []byte
+	for _, ext := range item.extents {
+		length := ext.length
+		if length == 0 {
+			// The extent runs to the end of the file or the idat box
+			length = end - base - ext.offset
+		}
+		if length < 0 || base+ext.offset+length > end {
+			sink.Warn("HEIF item %d runs past the end of the %s", item.id, within)
+			return nil, false
+		}
+		if int64(len(data))+length > maxBlockSize {
+			sink.Warn("HEIF item %d exceeds %d bytes", item.id, maxBlockSize)
+			return nil, false
+		}
+		block, err := readBlock(r, base+ext.offset, length)
+		if err != nil {
+			sink.Warn("HEIF item %d could not be read: %v", item.id, err)
+			return nil, false
+		}
+		data = append(data, block...)
+	}
+	return data, len(data) > 0
+}
@@ -0,0 +1,134 @@
+package formats
+
+import (
+	"bytes"
+	"testing"
+)
+
+// heifTestFile builds a HEIF file whose primary image has a size, pixel
+// depth, rotation, nclx colors and an ICC profile, with an Exif item in
+// the media data and an XMP item in the idat box
+func heifTestFile() []byte {
+	image := make([]byte, 20)
+	exif := bytes.Join([][]byte{be(uint32(6)), []byte(exifNamespace), testTIFF}, nil)
+
+	infe := func(id uint16, itemType string, extra string) []byte {
+		return testFullBox("infe", 2, 0, be(id, uint16(0)), []byte(itemType+"\x00"+extra))
+	}
+	colr := func(parts ...[]byte) []byte { return testBox("colr", parts...) }
+	properties := testBox("iprp",
+		testBox("ipco",
+			testFullBox("ispe", 0, 0, be(uint32(4032), uint32(3024))),
+			testFullBox("pixi", 0, 0, []byte{3, 8, 8, 8}),
+			testBox("irot", []byte{1}),
+			colr([]byte("nclx"), be(uint16(1), uint16(13), uint16(6)), []byte{0x80}),
+			colr([]byte("prof"), []byte("icc profile")),
+		),
+		// Item 1 has all five properties, the third marked essential
+		testFullBox("ipma", 0, 0, be(uint32(1), uint16(1)), []byte{5, 1, 2, 0x83, 4, 5}),
+	)
+	meta := func(mediaData uint32) []byte {
+		extent := func(id, method uint16, offset, length int) []byte {
+			return be(id, method, uint16(0), uint16(1), uint32(offset), uint32(length))
+		}
+		return testFullBox("meta", 0, 0,
+			testFullBox("hdlr", 0, 0, make([]byte, 4), []byte("pict"), make([]byte, 13)),
+			testFullBox("pitm", 0, 0, be(uint16(1))),
+			testFullBox("iinf", 0, 0, be(uint16(3)), infe(1, "hvc1", ""), infe(2, "Exif", ""), infe(3, "mime", heifXMPContentType+"\x00")),
+			testFullBox("iref", 0, 0, testBox("cdsc", be(uint16(2), uint16(1), uint16(1))), testBox("cdsc", be(uint16(3), uint16(1), uint16(1)))),
+			properties,
+			testFullBox("iloc", 1, 0, []byte{0x44, 0x00}, be(uint16(3)),
+				extent(1, 0, int(mediaData), len(image)),
+				extent(2, 0, int(mediaData)+len(image), len(exif)),
+				extent(3, 1, 0, len(testXMP))),
+			testBox("idat", testXMP),
+		)
+	}
+
+	ftyp := testBox("ftyp", []byte("heic\x00\x00\x00\x00mif1heic"))
+	mediaData := len(ftyp) + len(meta(0)) + 8
+	return bytes.Join([][]byte{ftyp, meta(uint32(mediaData)), testBox("mdat", image, exif)}, nil)
+}
+
+func TestHEIF(t *testing.T) {
+	sink := parseTest(t, quickTimeHandler{}, heifTestFile())
+	sink.checkTags(t, []tagCheck{
+		{"QuickTime::FileType", "MajorBrand", "heic"},
+		{"QuickTime::FileType", "MinorVersion", "0.0.0"},
+		{"QuickTime::FileType", "CompatibleBrands", []string{"mif1", "heic"}},
+		{"QuickTime::Handler", "HandlerType", "pict"},
+		{"QuickTime::Meta", "PrimaryItemReference", 1},
+		{"QuickTime::ItemProp", "ImageSpatialExtent", []int{4032, 3024}},
+		{"QuickTime::ItemProp", "ImagePixelDepth", []int{8, 8, 8}},
+		{"QuickTime::ItemProp", "Rotation", 1},
+		{"", "ImageWidth", 4032},
+		{"", "ImageHeight", 3024},
+		{"QuickTime::ColorRep", "ColorProfiles", "nclx"},
+		{"QuickTime::ColorRep", "ColorProfiles", "prof"},
+		{"QuickTime::ColorRep", "ColorPrimaries", 1},
+		{"QuickTime::ColorRep", "TransferCharacteristics", 13},
+		{"QuickTime::ColorRep", "MatrixCoefficients", 6},
+		{"QuickTime::ColorRep", "VideoFullRangeFlag", 1},
+	})
+	sink.checkBlocks(t,
+		testBlock{BlockICC, []byte("icc profile")},
+		testBlock{BlockTIFF, testTIFF},
+		testBlock{BlockXMP, testXMP},
+	)
+	if len(sink.warnings) > 0 {
+		t.Errorf("warnings: %q", sink.warnings)
+	}
+}
+
+func TestHEIFBadItems(t *testing.T) {
+	file := heifTestFile()
+	// Point the Exif item's TIFF offset past its end and the XMP item past idat
+	i := bytes.LastIndex(file, []byte(exifNamespace)) - 4
+	copy(file[i:], be(uint32(1000)))
+	i = bytes.Index(file, []byte("iloc")) + len("iloc") + 8 + 2*16 + 8
+	copy(file[i:], be(uint32(1)))
+
+	sink := parseTest(t, quickTimeHandler{}, file)
+	sink.checkBlocks(t, testBlock{BlockICC, []byte("icc profile")})
+	sink.checkWarning(t, "Invalid HEIF Exif item 2")
+	sink.checkWarning(t, "HEIF item 3 runs past the end of the idat box")
+}
+
+// heifExifFile builds a HEIF file whose only item is Exif data located by
+// one iloc extent using the given construction method
+func heifExifFile(method uint16, offset, length int, idat []byte) []byte {
+	return append(testBox("ftyp", []byte("mif1\x00\x00\x00\x00mif1")), testFullBox("meta", 0, 0,
+		testFullBox("iinf", 0, 0, be(uint16(1)), testFullBox("infe", 2, 0, be(uint16(1), uint16(0)), []byte("Exif\x00"))),
+		testFullBox("iloc", 1, 0, []byte{0x44, 0x00}, be(uint16(1), uint16(1), method, uint16(0), uint16(1), uint32(offset), uint32(length))),
+		testBox("idat", idat),
+	)...)
+}
+
+func TestHEIFConstructionMethods(t *testing.T) {
+	exif := append(be(uint32(0)), testTIFF...)
+	idat := append([]byte("padding"), exif...)
+	fileEnd := len(heifExifFile(0, 0, 0, idat)) // The idat box ends the file
+	tests := []struct {
+		name    string
+		file    []byte
+		blocks  []testBlock
+		warning string
+	}{
+		{"idat", heifExifFile(1, 7, len(exif), idat), []testBlock{{BlockTIFF, testTIFF}}, ""},
+		{"past the idat box", heifExifFile(1, 8, len(exif), idat), nil, "HEIF item 1 runs past the end of the idat box"},
+		{"item offset", heifExifFile(2, 0, len(exif), idat), nil, "HEIF item 1 uses unsupported construction method 2"},
+		// A length of 0 runs to the end of the idat box or the file
+		{"idat to the end", heifExifFile(1, 7, 0, idat), []testBlock{{BlockTIFF, testTIFF}}, ""},
+		{"file to the end", heifExifFile(0, fileEnd-len(exif), 0, idat), []testBlock{{BlockTIFF, testTIFF}}, ""},
+		{"past the end of the file", heifExifFile(0, fileEnd+1, 0, idat), nil, "HEIF item 1 runs past the end of the file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := parseTest(t, quickTimeHandler{}, tt.file)
+			sink.checkBlocks(t, tt.blocks...)
+			if tt.warning != "" {
+				sink.checkWarning(t, tt.warning)
+			}
+		})
+	}
+}
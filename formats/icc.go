@@ -0,0 +1,22 @@
+package formats
+
+import "io"
+
+func init() {
+	Register("ICC", iccHandler{})
+}
+
+// iccHandler reads standalone ICC color profiles (.icc and .icm files)
+type iccHandler struct{}
+
+// Sniff reports whether header has the "acsp" signature of an ICC profile header
+func (iccHandler) Sniff(header []byte) bool {
+	return len(header) >= 40 && string(header[36:40]) == "acsp"
+}
+
+// Parse hands the whole file to the ICC decoder
+func (iccHandler) Parse(r io.ReaderAt, size int64, sink Sink) error {
+	sink.Segment(0, "ICC", "ICC profile")
+	sink.Block(BlockICC, r, 0, size)
+	return nil
+}
@@ -0,0 +1,175 @@
+package formats
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"greg-hacke/go-metadata/internal/latin1"
+	"greg-hacke/go-metadata/tags"
+)
+
+// Confidence is how strongly a file's content and name support a file type
+type Confidence int
+
+const (
+	ConfidenceNone    Confidence = iota
+	ConfidenceLow                // Only the extension names the type, the content is not checked
+	ConfidenceMedium             // A weak magic number matches
+	ConfidenceHigh               // A distinctive magic number matches, or a weak one the extension agrees with
+	ConfidenceCertain            // A distinctive magic number matches and the extension agrees
+)
+
+// String returns the confidence level in lower case
+func (c Confidence) String() string {
+	switch c {
+	case ConfidenceLow:
+		return "low"
+	case ConfidenceMedium:
+		return "medium"
+	case ConfidenceHigh:
+		return "high"
+	case ConfidenceCertain:
+		return "certain"
+	}
+	return "none"
+}
+
+// Candidate is a file type a file could be
+type Candidate struct {
+	FileType   string // Key of tags.ExifToolFileTypes.MagicNumbers
+	Confidence Confidence
+	Magic      bool // The content matches the type's magic number
+	Extension  bool // The file name's extension names the type
+}
+
+// Identification lists the file types a file could be
+type Identification struct {
+	Candidates    []Candidate // Most likely first
+	Extension     string      // Upper-case extension of the file name, without the dot
+	ExtensionType string      // File type the extension names, "" if unknown
+	Mismatch      bool        // The content does not match ExtensionType's magic number
+}
+
+// Best returns the most likely candidate, or false if nothing identifies the file
+func (id Identification) Best() (Candidate, bool) {
+	if len(id.Candidates) == 0 {
+		return Candidate{}, false
+	}
+	return id.Candidates[0], true
+}
+
+// noMagic lists types ExifTool accepts by extension without testing the
+// magic number, which is unreliable for them
+var noMagic = map[string]bool{"MXF": true, "DV": true}
+
+// Identify returns the file types header, the start of a file, could be.
+// Like ExifTool, the type named by the extension of filename (which may be
+// empty) is tested first and then every type in ExifTool's test order.
+// Candidates are ranked by confidence, and among equals the one ExifTool
+// tests first comes first. header should hold HeaderSize bytes, or the
+// whole file if it is shorter.
+func Identify(header []byte, filename string) Identification {
+	var id Identification
+	if filename != "" {
+		id.Extension = strings.ToUpper(strings.TrimPrefix(filepath.Ext(filename), "."))
+	}
+	id.ExtensionType = extensionType(id.Extension)
+
+	// Set when ExifTool knows the extension but can only recognize the
+	// type by it, in which case weak magic numbers are not trusted
+	module, known := tags.ExifToolFileTypes.ModuleNames[id.Extension]
+	recognizedExt := id.Extension != "" && magicNumbers[id.Extension] == nil &&
+		known && (module == "" || module == "0")
+
+	// Patterns that could look past the test length only see that much
+	text, window := latin1.Decode(header), ""
+	if len(header) > testLen {
+		window = latin1.Decode(header[:testLen])
+	} else {
+		window = text
+	}
+
+	order := tags.ExifToolFileTypes.TestOrder
+	if id.ExtensionType != "" {
+		order = append([]string{id.ExtensionType}, order...)
+	}
+	seen := make(map[string]bool, len(order))
+	tested, matched := false, false // Whether the extension's type was tested and matched
+	for _, fileType := range order {
+		if seen[fileType] {
+			continue
+		}
+		seen[fileType] = true
+		fromExt := fileType == id.ExtensionType
+
+		m := magicNumbers[fileType]
+		if m == nil || fromExt && noMagic[fileType] {
+			if fromExt {
+				id.Candidates = append(id.Candidates, Candidate{FileType: fileType, Confidence: ConfidenceLow, Extension: true})
+			}
+			continue
+		}
+		if m.weak && recognizedExt {
+			continue
+		}
+		tested = tested || fromExt
+
+		in := text
+		if !m.bounded {
+			in = window
+		}
+		if !m.re.MatchLatin1(in) {
+			continue
+		}
+		matched = matched || fromExt
+		c := Candidate{FileType: fileType, Magic: true, Extension: fromExt}
+		switch {
+		case !m.weak && fromExt:
+			c.Confidence = ConfidenceCertain
+		case !m.weak || fromExt:
+			c.Confidence = ConfidenceHigh
+		default:
+			c.Confidence = ConfidenceMedium
+		}
+		id.Candidates = append(id.Candidates, c)
+	}
+	id.Mismatch = tested && !matched
+
+	sort.SliceStable(id.Candidates, func(i, j int) bool {
+		return id.Candidates[i].Confidence > id.Candidates[j].Confidence
+	})
+	return id
+}
+
+// IdentifyReader reads the header of r, a file of size bytes, and
+// identifies it with Identify
+func IdentifyReader(r io.ReaderAt, size int64, filename string) (Identification, error) {
+	header := make([]byte, min(int64(headerSize), size))
+	n, err := r.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return Identification{}, fmt.Errorf("cannot read file header: %w", err)
+	}
+	return Identify(header[:n], filename), nil
+}
+
+// extensionType returns the file type an upper-case extension names,
+// following aliases such as JPG for JPEG
+func extensionType(ext string) string {
+	info, ok := tags.ExifToolFileTypes.Extensions[ext]
+	if !ok {
+		return ""
+	}
+	seen := map[string]bool{ext: true}
+	for info.Description == "" && !seen[info.Type] {
+		seen[info.Type] = true
+		alias, ok := tags.ExifToolFileTypes.Extensions[info.Type]
+		if !ok {
+			break
+		}
+		info = alias
+	}
+	return info.Type
+}
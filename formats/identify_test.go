@@ -0,0 +1,114 @@
+package formats
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// Headers of real files, padded as Identify would see them
+var (
+	jpegHeader = pad("\xff\xd8\xff\xe0\x00\x10JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00")
+	nefHeader  = pad("MM\x00\x2a\x00\x00\x00\x08\x00\x1c\x00\xfe\x00\x04\x00\x00\x00\x01")
+	mp3Header  = pad("\xff\xfb\x90\x64\x00\x0f\xf0\x00\x00\x69\x00\x00\x00\x08\x00\x00")
+	id3Header  = pad("ID3\x04\x00\x00\x00\x00\x01\x76TIT2\x00\x00\x00\x06")
+	bmpHeader  = pad("BM\x36\x00\x0c\x00\x00\x00\x00\x00\x36\x00\x00\x00\x28\x00")
+)
+
+// pad extends the start of a file with zeros to the size of a small file
+func pad(header string) []byte {
+	return append([]byte(header), make([]byte, 256)...)
+}
+
+func TestIdentify(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   []byte
+		filename string
+		want     Candidate // Best candidate, the zero Candidate for none
+		mismatch bool
+	}{
+		{"distinctive magic and extension", jpegHeader, "photo.jpg", Candidate{"JPEG", ConfidenceCertain, true, true}, false},
+		{"distinctive magic alone", jpegHeader, "", Candidate{"JPEG", ConfidenceHigh, true, false}, false},
+		{"JPEG named .png", jpegHeader, "photo.png", Candidate{"JPEG", ConfidenceHigh, true, false}, true},
+		{"weak magic and extension", nefHeader, "DSC_0001.NEF", Candidate{"TIFF", ConfidenceHigh, true, true}, false},
+		{"weak magic alone", nefHeader, "DSC_0001", Candidate{"TIFF", ConfidenceMedium, true, false}, false},
+		{"TIFF named .jpg", nefHeader, "DSC_0001.jpg", Candidate{"TIFF", ConfidenceMedium, true, false}, true},
+		{"extension only", mp3Header, "song.mp3", Candidate{"MP3", ConfidenceLow, false, true}, false},
+		{"no magic and no extension", mp3Header, "song", Candidate{}, false},
+		{"unknown extension", mp3Header, "song.xyz", Candidate{}, false},
+		{"weak bitmap magic alone", bmpHeader, "image", Candidate{"BMP", ConfidenceMedium, true, false}, false},
+		// DIR is only recognized by extension, so weak magic numbers are not tested
+		{"recognized extension suppresses weak magic", bmpHeader, "image.dir", Candidate{"DIR", ConfidenceLow, false, true}, false},
+		{"recognized extension keeps distinctive magic", jpegHeader, "photo.dir", Candidate{"JPEG", ConfidenceHigh, true, false}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := Identify(tt.header, tt.filename)
+			got, ok := id.Best()
+			if ok == (tt.want == Candidate{}) || got != tt.want {
+				t.Errorf("Best = %+v, %v, want %+v", got, ok, tt.want)
+			}
+			if id.Mismatch != tt.mismatch {
+				t.Errorf("Mismatch = %v, want %v", id.Mismatch, tt.mismatch)
+			}
+		})
+	}
+}
+
+func TestIdentifyExtension(t *testing.T) {
+	id := Identify(nefHeader, "/photos/DSC_0001.nef")
+	if id.Extension != "NEF" || id.ExtensionType != "TIFF" {
+		t.Errorf("Extension %q, ExtensionType %q, want NEF and TIFF", id.Extension, id.ExtensionType)
+	}
+	id = Identify(jpegHeader, "photo.JPE")
+	if id.Extension != "JPE" || id.ExtensionType != "JPEG" {
+		t.Errorf("Extension %q, ExtensionType %q, want JPE and JPEG", id.Extension, id.ExtensionType)
+	}
+}
+
+// Candidates are ranked by confidence, then in ExifTool's test order
+func TestIdentifyRanking(t *testing.T) {
+	// A bitmap named .mp3: the extension's type is tested first but only
+	// the weak magic number matches
+	id := Identify(bmpHeader, "image.mp3")
+	want := []Candidate{
+		{"BMP", ConfidenceMedium, true, false},
+		{"MP3", ConfidenceLow, false, true},
+	}
+	if !reflect.DeepEqual(id.Candidates, want) {
+		t.Errorf("bitmap named .mp3: Candidates = %+v, want %+v", id.Candidates, want)
+	}
+
+	// An ID3 tag may start several audio formats
+	var got []string
+	for _, c := range Identify(id3Header, "").Candidates {
+		if c.Confidence != ConfidenceHigh {
+			t.Errorf("%s confidence %s, want high", c.FileType, c.Confidence)
+		}
+		got = append(got, c.FileType)
+	}
+	if want := []string{"OGG", "FLAC", "APE", "MPC"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ID3 header: Candidates %q, want %q", got, want)
+	}
+}
+
+func TestConfidenceString(t *testing.T) {
+	for c, want := range []string{"none", "low", "medium", "high", "certain"} {
+		if got := Confidence(c).String(); got != want {
+			t.Errorf("Confidence(%d) = %q, want %q", c, got, want)
+		}
+	}
+}
+
+func TestIdentifyReader(t *testing.T) {
+	// A file shorter than the header is identified from what there is
+	short := jpegHeader[:20]
+	id, err := IdentifyReader(bytes.NewReader(short), int64(len(short)), "photo.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if best, _ := id.Best(); best.FileType != "JPEG" || best.Confidence != ConfidenceCertain {
+		t.Errorf("Best = %+v, want JPEG with certain confidence", best)
+	}
+}
@@ -0,0 +1,183 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+func init() {
+	Register("MOV", quickTimeHandler{})
+}
+
+// isoBox is a box of an ISO base media file, called an atom in QuickTime
+type isoBox struct {
+	Type   string
+	Offset int64 // Offset of the box header
+	Data   int64 // Offset of the contents, after the header
+	End    int64 // Offset after the box
+}
+
+// Size returns the length of the box contents
+func (b isoBox) Size() int64 {
+	return b.End - b.Data
+}
+
+// walkBoxes calls fn for each box from start to end. A box's 32-bit size
+// includes its header; a size of 1 means a 64-bit size follows the type,
+// and 0 means the box runs to end. Walking stops at the first error from fn.
+func walkBoxes(r io.ReaderAt, start, end int64, sink Sink, fn func(box isoBox) error) error {
+	header := make([]byte, 16)
+	for offset := start; offset+8 <= end; {
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return fmt.Errorf("reading box at offset %d: %w", offset, err)
+		}
+		box := isoBox{Type: string(header[4:8]), Offset: offset, Data: offset + 8}
+		size := int64(binary.BigEndian.Uint32(header[0:4]))
+		switch size {
+		case 0:
+			size = end - offset
+		case 1:
+			if offset+16 > end {
+				sink.Warn("%s box at offset %d is truncated", box.Type, offset)
+				return nil
+			}
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return fmt.Errorf("reading %s box size at offset %d: %w", box.Type, offset, err)
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			box.Data += 8
+		}
+		if size < box.Data-offset {
+			sink.Warn("Invalid %s box size at offset %d", box.Type, offset)
+			return nil
+		}
+		box.End = offset + size
+		if box.End > end || box.End < offset {
+			sink.Warn("%s box at offset %d runs past the end of its container", box.Type, offset)
+			box.End = end
+		}
+		if err := fn(box); err != nil {
+			return err
+		}
+		offset = box.End
+	}
+	return nil
+}
+
+// boxReader reads the big-endian fields of a box's contents, noting
+// rather than failing on reads past the end
+type boxReader struct {
+	data  []byte
+	pos   int
+	short bool // A read ran past the end of data
+}
+
+// uint reads an unsigned integer of n bytes, where n may be 0
+func (b *boxReader) uint(n int) uint64 {
+	if b.pos+n > len(b.data) {
+		b.short, b.pos = true, len(b.data)
+		return 0
+	}
+	var v uint64
+	for _, c := range b.data[b.pos : b.pos+n] {
+		v = v<<8 | uint64(c)
+	}
+	b.pos += n
+	return v
+}
+
+// bytes reads n bytes
+func (b *boxReader) bytes(n int) []byte {
+	if n < 0 || b.pos+n > len(b.data) {
+		b.short, b.pos = true, len(b.data)
+		return nil
+	}
+	v := b.data[b.pos : b.pos+n]
+	b.pos += n
+	return v
+}
+
+// string reads a null-terminated string, or the rest of the data if it
+// has no terminator
+func (b *boxReader) string() string {
+	s, _, _ := bytes.Cut(b.data[b.pos:], []byte{0})
+	b.pos = min(b.pos+len(s)+1, len(b.data))
+	return string(s)
+}
+
+// fullBox reads the version and flags that start an ISO full box
+func (b *boxReader) fullBox() (version int, flags uint32) {
+	v := b.uint(4)
+	return int(v >> 24), uint32(v & 0xFFFFFF)
+}
+
+// quickTimeHandler walks the boxes of QuickTime movies and ISO base media
+// files: MP4, HEIF, AVIF and the other types ExifTool reads as MOV
+type quickTimeHandler struct{}
+
+// Sniff reports whether header starts with a box type that begins these files
+func (quickTimeHandler) Sniff(header []byte) bool {
+	if len(header) < 12 {
+		return false
+	}
+	switch string(header[4:8]) {
+	case "ftyp", "moov", "mdat", "free", "skip", "wide", "pnot":
+		return true
+	}
+	return false
+}
+
+// Parse records the file type brands, decodes the movie in the moov box
+// and the HEIF meta box, and works out the average bitrate of the media data
+func (quickTimeHandler) Parse(r io.ReaderAt, size int64, sink Sink) error {
+	var mov movie
+	var mediaSize int64
+	err := walkBoxes(r, 0, size, sink, func(box isoBox) error {
+		sink.Segment(box.Offset, box.Type, fmt.Sprintf("%d bytes", box.Size()))
+		switch box.Type {
+		case "ftyp":
+			data, err := readBlock(r, box.Data, min(box.Size(), 256))
+			if err != nil {
+				return fmt.Errorf("reading ftyp box: %w", err)
+			}
+			parseFileTypeBox(data, sink)
+		case "moov":
+			return parseMovie(r, box, &mov, sink)
+		case "meta":
+			return parseHEIFMeta(r, size, box, sink)
+		case "mdat":
+			mediaSize += box.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if mov.duration > 0 && mediaSize > 0 {
+		sink.Tag(Tag{Group: "QuickTime", Name: "AvgBitrate", Value: int(float64(mediaSize)*8/mov.duration + 0.5)})
+	}
+	return nil
+}
+
+// parseFileTypeBox records the major brand, its version and the brands
+// the file is compatible with
+func parseFileTypeBox(data []byte, sink Sink) {
+	if len(data) < 8 {
+		return
+	}
+	var compatible []string
+	for i := 8; i+4 <= len(data); i += 4 {
+		compatible = append(compatible, string(data[i:i+4]))
+	}
+	// ExifTool shows the minor version as major.minor.patch
+	fields := []tableField{
+		{"0", "MajorBrand", string(data[0:4])},
+		{"1", "MinorVersion", fmt.Sprintf("%x.%x.%x", binary.BigEndian.Uint16(data[4:6]), data[6], data[7])},
+		{"2", "CompatibleBrands", compatible},
+	}
+	for _, f := range fields {
+		sink.Tag(Tag{Group: "QuickTime", Table: "QuickTime::FileType", ID: f.id, Name: f.name, Value: f.value})
+	}
+}
@@ -0,0 +1,78 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// testBox builds an ISO base media box of the given type holding parts
+func testBox(boxType string, parts ...[]byte) []byte {
+	body := bytes.Join(parts, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(b, boxType...), body...)
+}
+
+// testFullBox builds an ISO full box: a version and flags, then parts
+func testFullBox(boxType string, version int, flags uint32, parts ...[]byte) []byte {
+	return testBox(boxType, append([][]byte{be(uint32(version)<<24 | flags)}, parts...)...)
+}
+
+func TestWalkBoxes(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []isoBox
+		warning string
+	}{
+		{
+			"sizes",
+			bytes.Join([][]byte{
+				testBox("free", []byte("abcd")),
+				be(uint32(1)), []byte("wide"), be(uint64(20)), []byte("abcd"),
+				be(uint32(0)), []byte("mdat"), []byte("to the end"),
+			}, nil),
+			[]isoBox{{"free", 0, 8, 12}, {"wide", 12, 28, 32}, {"mdat", 32, 40, 50}},
+			"",
+		},
+		{"too small", append(testBox("free"), be(uint32(4), uint32(0))...), []isoBox{{"free", 0, 8, 8}}, "Invalid \x00\x00\x00\x00 box size at offset 8"},
+		{"64-bit too small", bytes.Join([][]byte{be(uint32(1)), []byte("mdat"), be(uint64(8))}, nil), nil, "Invalid mdat box size at offset 0"},
+		{"64-bit truncated", bytes.Join([][]byte{be(uint32(1)), []byte("mdat"), be(uint32(0))}, nil), nil, "mdat box at offset 0 is truncated"},
+		{"past the end", testBox("moov", make([]byte, 8))[:12], []isoBox{{"moov", 0, 8, 12}}, "moov box at offset 0 runs past the end of its container"},
+		{"negative", bytes.Join([][]byte{be(uint32(1)), []byte("mdat"), be(uint64(1 << 63))}, nil), nil, "Invalid mdat box size at offset 0"},
+		{"64-bit past the end", bytes.Join([][]byte{be(uint32(1)), []byte("mdat"), be(uint64(1<<63 - 1))}, nil), []isoBox{{"mdat", 0, 16, 16}}, "mdat box at offset 0 runs past the end of its container"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &testSink{}
+			var got []isoBox
+			err := walkBoxes(bytes.NewReader(tt.data), 0, int64(len(tt.data)), sink, func(box isoBox) error {
+				got = append(got, box)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("boxes = %+v, want %+v", got, tt.want)
+			}
+			if tt.warning != "" {
+				sink.checkWarning(t, tt.warning)
+			} else if len(sink.warnings) > 0 {
+				t.Errorf("warnings: %q", sink.warnings)
+			}
+		})
+	}
+}
+
+func FuzzQuickTime(f *testing.F) {
+	f.Add(heifTestFile())
+	// The movie without its media data
+	mov := movTestFile()
+	f.Add(mov[bytes.Index(mov, []byte("moov"))-4:])
+	f.Add(mp4TestFile())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzParse(quickTimeHandler{}, data)
+	})
+}
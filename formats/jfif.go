@@ -0,0 +1,218 @@
+package formats
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// jfifNamespace prefixes the JFIF header in a JPEG APP0 segment
+const jfifNamespace = "JFIF\x00"
+
+// jfxxNamespace prefixes a JFIF extension APP0 segment, which holds a thumbnail
+const jfxxNamespace = "JFXX\x00"
+
+// adobeNamespace prefixes Adobe's APP14 segment, which says how the color
+// components were transformed
+const adobeNamespace = "Adobe"
+
+// isSOF reports whether marker starts a frame. 0xC4, 0xC8 and 0xCC share
+// the range but define Huffman tables, a reserved extension and arithmetic
+// coding conditioning.
+func isSOF(marker byte) bool {
+	return marker&0xF0 == 0xC0 && (marker == 0xC0 || marker&3 != 0)
+}
+
+// parseSOF records the image size, precision and components of a frame
+// header, and for three-component images the chroma subsampling
+func parseSOF(marker byte, data []byte, sink Sink) {
+	if len(data) < 6 {
+		sink.Warn("JPEG SOF%d segment is too short (%d bytes)", marker-0xC0, len(data))
+		return
+	}
+	// JPEG::SOF tags are keyed by name rather than position
+	components := int(data[5])
+	fields := []tableField{
+		{"ImageWidth", "ImageWidth", int(binary.BigEndian.Uint16(data[3:5]))},
+		{"ImageHeight", "ImageHeight", int(binary.BigEndian.Uint16(data[1:3]))},
+		{"EncodingProcess", "EncodingProcess", int(marker - 0xC0)},
+		{"BitsPerSample", "BitsPerSample", int(data[0])},
+		{"ColorComponents", "ColorComponents", components},
+	}
+	if components == 3 && len(data) >= 15 {
+		if sub := ycbcrSubSampling(data[6:15]); sub != nil {
+			fields = append(fields, tableField{"YCbCrSubSampling", "YCbCrSubSampling", sub})
+		}
+	}
+	for _, f := range fields {
+		sink.Tag(Tag{Group: "File", Table: "JPEG::SOF", ID: f.id, Name: f.name, Value: f.value})
+	}
+}
+
+// ycbcrSubSampling returns the horizontal and vertical chroma subsampling
+// of three frame components, each an ID, a byte of horizontal and vertical
+// sampling factors and a quantization table number: the ratio of the
+// largest factor to the smallest in each direction
+func ycbcrSubSampling(components []byte) []int {
+	hMin, hMax, vMin, vMax := 255, 0, 255, 0
+	for i := 0; i < 3; i++ {
+		h, v := int(components[i*3+1]>>4), int(components[i*3+1]&0x0F)
+		hMin, hMax = min(hMin, h), max(hMax, h)
+		vMin, vMax = min(vMin, v), max(vMax, v)
+	}
+	if hMin == 0 || vMin == 0 {
+		return nil
+	}
+	return []int{hMax / hMin, vMax / vMin}
+}
+
+// parseJFIF records the version, density and thumbnail size of a JFIF
+// header. JFIF::Main is a binary table indexed by byte offset after the
+// "JFIF" identifier.
+func parseJFIF(data []byte, sink Sink) {
+	if len(data) < 9 {
+		sink.Warn("JFIF segment is too short (%d bytes)", len(data)+len(jfifNamespace))
+		return
+	}
+	fields := []tableField{
+		{"0", "JFIFVersion", []int{int(data[0]), int(data[1])}},
+		{"2", "ResolutionUnit", int(data[2])},
+		{"3", "XResolution", int(binary.BigEndian.Uint16(data[3:5]))},
+		{"5", "YResolution", int(binary.BigEndian.Uint16(data[5:7]))},
+	}
+	// A thumbnail, stored as 24-bit RGB, is rare
+	if w, h := int(data[7]), int(data[8]); w > 0 && h > 0 {
+		fields = append(fields, tableField{"7", "ThumbnailWidth", w}, tableField{"8", "ThumbnailHeight", h})
+		if n := 3 * w * h; len(data) >= 9+n {
+			fields = append(fields, tableField{"9", "ThumbnailTIFF", data[9 : 9+n]})
+		}
+	}
+	for _, f := range fields {
+		sink.Tag(Tag{Group: "JFIF", Table: "JFIF::Main", ID: f.id, Name: f.name, Value: f.value})
+	}
+}
+
+// parseJFXX records the thumbnail of a JFIF extension segment: JPEG data
+// after code 0x10, or a bitmap of one-byte palette indexes or three-byte
+// pixels after 0x11 or 0x13
+func parseJFXX(data []byte, sink Sink) {
+	if len(data) < 1 {
+		return
+	}
+	code := data[0]
+	var name string
+	var thumb []byte
+	switch code {
+	case 0x10:
+		name, thumb = "ThumbnailImage", data[1:]
+	case 0x11, 0x13:
+		name, thumb = "ThumbnailTIFF", data[1:]
+	default:
+		sink.Warn("Unknown JFXX extension code 0x%02X", code)
+		return
+	}
+	if len(thumb) == 0 {
+		return
+	}
+	sink.Tag(Tag{Group: "JFIF", Directory: "JFXX", Table: "JFIF::Extension", ID: fmt.Sprintf("0x%X", code), Name: name, Value: thumb})
+}
+
+// parseAdobe records Adobe's APP14 segment. JPEG::Adobe is a binary table
+// of 16-bit words; ColorTransform is the single byte after the first three.
+func parseAdobe(data []byte, sink Sink) {
+	if len(data) < 7 {
+		sink.Warn("Adobe APP14 segment is too short (%d bytes)", len(data)+len(adobeNamespace))
+		return
+	}
+	fields := []tableField{
+		{"0", "DCTEncodeVersion", int(binary.BigEndian.Uint16(data[0:2]))},
+		{"1", "APP14Flags0", int(binary.BigEndian.Uint16(data[2:4]))},
+		{"2", "APP14Flags1", int(binary.BigEndian.Uint16(data[4:6]))},
+		{"3", "ColorTransform", int(data[6])},
+	}
+	for _, f := range fields {
+		sink.Tag(Tag{Group: "APP14", Directory: "Adobe", Table: "JPEG::Adobe", ID: f.id, Name: f.name, Value: f.value})
+	}
+}
+
+// jpegZigZag maps the position of a quantization table entry as stored in a
+// DQT segment to its position in the 8x8 block
+var jpegZigZag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10, 17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34, 27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36, 29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46, 53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// jpegStandardLuminance is the example luminance quantization table of the
+// JPEG standard, which the IJG library scales for quality 50, in block order
+var jpegStandardLuminance = [64]int{
+	16, 11, 10, 16, 24, 40, 51, 61,
+	12, 12, 14, 19, 26, 58, 60, 55,
+	14, 13, 16, 24, 40, 57, 69, 56,
+	14, 17, 22, 29, 51, 87, 80, 62,
+	18, 22, 37, 56, 68, 109, 103, 77,
+	24, 35, 55, 64, 81, 104, 113, 92,
+	49, 64, 78, 87, 103, 121, 120, 101,
+	72, 92, 95, 98, 112, 100, 103, 99,
+}
+
+// quantTables collects the quantization tables of DQT segments, by table
+// number, in block order
+type quantTables struct {
+	tables [4][]int
+}
+
+// add records the tables of one DQT segment, each a byte of precision and
+// table number followed by 64 entries of one or two bytes
+func (q *quantTables) add(data []byte, offset int64, sink Sink) {
+	for len(data) > 0 {
+		precision, num := data[0]>>4, int(data[0]&0x0F)
+		size := 64
+		if precision != 0 {
+			size = 128
+		}
+		if num > 3 || len(data) < 1+size {
+			sink.Warn("Invalid JPEG DQT segment at offset %d", offset)
+			return
+		}
+		table := make([]int, 64)
+		for i := range table {
+			if precision != 0 {
+				table[jpegZigZag[i]] = int(binary.BigEndian.Uint16(data[1+i*2:]))
+			} else {
+				table[jpegZigZag[i]] = int(data[1+i])
+			}
+		}
+		q.tables[num] = table
+		data = data[1+size:]
+	}
+}
+
+// flush records JPEGQualityEstimate, the IJG quality setting that would
+// scale the standard luminance table closest to table 0. Images saved by
+// other encoders get the quality their tables are equivalent to.
+func (q *quantTables) flush(sink Sink) {
+	table := q.tables[0]
+	if table == nil || !sink.Wants("File", "JPEGQualityEstimate") {
+		return
+	}
+	sum, standard := 0, 0
+	for i, v := range table {
+		sum += v
+		standard += jpegStandardLuminance[i]
+	}
+	// The IJG library scales by 5000/quality below 50 and by 200-2*quality
+	// above, as a percentage, with every entry at least 1
+	var quality int
+	scale := float64(sum) * 100 / float64(standard)
+	switch {
+	case sum == len(table):
+		quality = 100
+	case scale <= 100:
+		quality = int((200-scale)/2 + 0.5)
+	default:
+		quality = int(5000/scale + 0.5)
+	}
+	quality = min(max(quality, 1), 100)
+	sink.Tag(Tag{Group: "File", Name: "JPEGQualityEstimate", Value: quality})
+}
@@ -0,0 +1,341 @@
+package formats
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+func init() {
+	Register("JPEG", jpegHandler{})
+}
+
+// exifNamespace prefixes the TIFF structure of EXIF data in JPEG APP1 segments
+const exifNamespace = "Exif\x00\x00"
+
+// xmpNamespace prefixes XMP packets stored in JPEG APP1 segments
+const xmpNamespace = "http://ns.adobe.com/xap/1.0/\x00"
+
+// xmpExtensionNamespace prefixes the chunks of extended XMP packets, which
+// hold what does not fit in the standard packet's 64 KB segment
+const xmpExtensionNamespace = "http://ns.adobe.com/xmp/extension/\x00"
+
+// jpegHandler walks the marker segments of a JPEG file up to the image data
+type jpegHandler struct{}
+
+// Sniff reports whether header starts with a JPEG SOI marker
+func (jpegHandler) Sniff(header []byte) bool {
+	return len(header) > 2 && header[0] == 0xFF && header[1] == 0xD8
+}
+
+// photoshopNamespace prefixes Photoshop image resources stored in JPEG APP13 segments
+const photoshopNamespace = "Photoshop 3.0\x00"
+
+// iccNamespace prefixes the chunks of an ICC profile stored in JPEG APP2
+// segments, followed by the chunk number and count
+const iccNamespace = "ICC_PROFILE\x00"
+
+// mpfNamespace prefixes the Multi-Picture Format index in a JPEG APP2
+// segment, which locates the other images stored after the first
+const mpfNamespace = "MPF\x00"
+
+// jpegBlocks collects metadata that is split across several segments
+type jpegBlocks struct {
+	extended  extendedXMP
+	photoshop photoshopSegments
+	icc       iccChunks
+	quant     quantTables
+}
+
+// Parse passes the EXIF, XMP, ICC, MPF and Photoshop segments to sink and
+// records the frame, JFIF and Adobe headers and comments. Metadata split
+// across segments is passed once all of its parts have been read.
+func (jpegHandler) Parse(r io.ReaderAt, size int64, sink Sink) error {
+	var blocks jpegBlocks
+	err := parseJPEGSegments(r, size, sink, &blocks)
+	blocks.photoshop.flush(r, sink)
+	blocks.icc.flush(sink)
+	blocks.extended.flush(sink)
+	blocks.quant.flush(sink)
+	return err
+}
+
+// parseJPEGSegments walks the marker segments up to the image data,
+// collecting the parts of split metadata in blocks
+func parseJPEGSegments(r io.ReaderAt, size int64, sink Sink, blocks *jpegBlocks) error {
+	sink.Segment(0, "JPEG", "JPEG structure")
+
+	offset := int64(2) // Skip SOI
+	markerBuf := make([]byte, 4)
+	frameSeen := false // Only the first frame header describes the main image
+
+	for offset+4 <= size {
+		if _, err := r.ReadAt(markerBuf, offset); err != nil {
+			return fmt.Errorf("reading JPEG marker at offset %d: %w", offset, err)
+		}
+		if markerBuf[0] != 0xFF {
+			offset++
+			continue
+		}
+
+		marker := markerBuf[1]
+		offset += 2
+		if marker != 0xED {
+			// Photoshop resources only continue in the very next segment
+			blocks.photoshop.flush(r, sink)
+		}
+
+		// Skip stuffing bytes and standalone markers
+		if marker == 0xFF || marker == 0x00 || marker == 0x01 ||
+			(marker >= 0xD0 && marker <= 0xD8) {
+			continue
+		}
+
+		if marker == 0xDA {
+			return nil // Start of scan - image data follows
+		}
+
+		// Read segment length
+		segLen := int64(binary.BigEndian.Uint16(markerBuf[2:4]))
+		offset += 2
+
+		if segLen < 2 || offset+segLen-2 > size {
+			sink.Warn("JPEG segment 0x%02X at offset %d runs past the end of the file", marker, offset-4)
+			return nil
+		}
+
+		// Only metadata-bearing segments are read into memory
+		switch {
+		case marker == 0xE0, marker == 0xE1, marker == 0xE2, marker == 0xEE, marker == 0xED, marker == 0xFE:
+		case marker == 0xDB && sink.Wants("File", "JPEGQualityEstimate"):
+		case isSOF(marker) && !frameSeen && sink.Wants("File", ""):
+		default:
+			offset += segLen - 2
+			continue
+		}
+
+		segData, err := readBlock(r, offset, segLen-2)
+		if err != nil {
+			return fmt.Errorf("reading JPEG segment at offset %d: %w", offset, err)
+		}
+
+		// Check for known metadata markers
+		switch {
+		case isSOF(marker):
+			sink.Segment(offset, fmt.Sprintf("SOF%d", marker-0xC0), "Frame header")
+			frameSeen = true
+			parseSOF(marker, segData, sink)
+		case marker == 0xDB:
+			blocks.quant.add(segData, offset, sink)
+		case marker == 0xE0 && bytes.HasPrefix(segData, []byte(jfifNamespace)):
+			sink.Segment(offset, "APP0", "JFIF")
+			if sink.Wants("JFIF", "") {
+				parseJFIF(segData[len(jfifNamespace):], sink)
+			}
+		case marker == 0xE0 && bytes.HasPrefix(segData, []byte(jfxxNamespace)):
+			sink.Segment(offset, "APP0", "JFXX")
+			if sink.Wants("JFIF", "") {
+				parseJFXX(segData[len(jfxxNamespace):], sink)
+			}
+		case marker == 0xEE && bytes.HasPrefix(segData, []byte(adobeNamespace)):
+			sink.Segment(offset, "APP14", "Adobe")
+			if sink.Wants("APP14", "") {
+				parseAdobe(segData[len(adobeNamespace):], sink)
+			}
+		case marker == 0xE1 && bytes.HasPrefix(segData, []byte(exifNamespace)):
+			sink.Segment(offset, "APP1", "EXIF")
+			sink.Block(BlockTIFF, r, offset+6, segLen-8)
+		case marker == 0xE1 && bytes.HasPrefix(segData, []byte(xmpNamespace)):
+			sink.Segment(offset, "APP1", "XMP")
+			sink.Block(BlockXMP, r, offset+int64(len(xmpNamespace)), segLen-2-int64(len(xmpNamespace)))
+		case marker == 0xE1 && bytes.HasPrefix(segData, []byte(xmpExtensionNamespace)):
+			sink.Segment(offset, "APP1", "Extended XMP")
+			if sink.Wants("XMP", "") {
+				blocks.extended.add(segData[len(xmpExtensionNamespace):], offset, sink)
+			}
+		case marker == 0xE2 && bytes.HasPrefix(segData, []byte(iccNamespace)) && len(segData) >= len(iccNamespace)+2:
+			sink.Segment(offset, "APP2", fmt.Sprintf("ICC_Profile chunk %d of %d", segData[12], segData[13]))
+			if sink.Wants("ICC_Profile", "") {
+				blocks.icc.add(segData, offset, sink)
+			}
+		case marker == 0xE2 && bytes.HasPrefix(segData, []byte(mpfNamespace)):
+			sink.Segment(offset, "APP2", "MPF")
+			sink.Block(BlockMPF, r, offset+int64(len(mpfNamespace)), segLen-2-int64(len(mpfNamespace)))
+		case marker == 0xED && bytes.HasPrefix(segData, []byte(photoshopNamespace)):
+			sink.Segment(offset, "APP13", "Photoshop")
+			blocks.photoshop.add(offset+int64(len(photoshopNamespace)), segData[len(photoshopNamespace):])
+		case marker == 0xED:
+			blocks.photoshop.flush(r, sink)
+		case marker == 0xFE:
+			if comment := strings.TrimSpace(string(segData)); comment != "" {
+				sink.Tag(Tag{Group: "File", Name: "Comment", Value: comment})
+			}
+		}
+
+		offset += segLen - 2
+	}
+
+	return nil
+}
+
+// photoshopSegments collects the data of consecutive APP13 segments, which
+// writers use to split image resources larger than one segment
+type photoshopSegments struct {
+	offset int64    // File offset of the data in the first segment
+	data   [][]byte // Resource data of each segment
+}
+
+// add records the resource data of one segment at offset
+func (p *photoshopSegments) add(offset int64, data []byte) {
+	if len(p.data) == 0 {
+		p.offset = offset
+	}
+	p.data = append(p.data, data)
+}
+
+// flush passes the collected resources to sink as a single block, in place
+// when they came from one segment
+func (p *photoshopSegments) flush(r io.ReaderAt, sink Sink) {
+	switch len(p.data) {
+	case 0:
+		return
+	case 1:
+		sink.Block(BlockPhotoshop, r, p.offset, int64(len(p.data[0])))
+	default:
+		combined := bytes.Join(p.data, nil)
+		sink.Block(BlockPhotoshop, bytes.NewReader(combined), 0, int64(len(combined)))
+	}
+	p.data = nil
+}
+
+// iccChunks collects the chunks of an ICC profile, numbered from 1. The
+// profile is passed on as soon as the number of chunks the first one gave
+// has been read; as in ExifTool, chunks that disagree about the count end
+// the profile.
+type iccChunks struct {
+	total    int            // Chunk count from the first chunk
+	received int            // Chunks read so far
+	chunks   map[int][]byte // Chunk data by number
+	invalid  bool           // Set once the profile was passed on or abandoned
+}
+
+// add records the APP2 segment data of one chunk
+func (c *iccChunks) add(segData []byte, offset int64, sink Sink) {
+	num := int(segData[len(iccNamespace)])
+	total := int(segData[len(iccNamespace)+1])
+	if c.chunks == nil && !c.invalid {
+		c.total = total
+		c.chunks = make(map[int][]byte)
+		if total == 0 {
+			sink.Warn("ICC_Profile chunk count is zero")
+		}
+	} else if total != c.total {
+		c.invalid = true
+	}
+	if c.invalid {
+		sink.Warn("Invalid or extraneous ICC_Profile chunk(s)")
+		return
+	}
+	data := segData[len(iccNamespace)+2:]
+	if _, dup := c.chunks[num]; dup {
+		sink.Warn("Duplicate ICC_Profile chunk number(s)")
+	}
+	c.chunks[num] = append(c.chunks[num], data...)
+	c.received++
+	if c.received < c.total {
+		return
+	}
+
+	nums := make([]int, 0, len(c.chunks))
+	for n := range c.chunks {
+		nums = append(nums, n)
+	}
+	sort.Ints(nums)
+	var profile []byte
+	for _, n := range nums {
+		profile = append(profile, c.chunks[n]...)
+	}
+	c.chunks, c.invalid = nil, true
+	sink.Block(BlockICC, bytes.NewReader(profile), 0, int64(len(profile)))
+}
+
+// flush warns about a profile whose chunks were not all found
+func (c *iccChunks) flush(sink Sink) {
+	if !c.invalid && c.received > 0 {
+		sink.Warn("ICC_Profile is incomplete (%d of %d chunks)", c.received, c.total)
+	}
+}
+
+// extendedXMP collects the chunks of extended XMP packets by GUID, the
+// upper-case hex MD5 of the whole packet
+type extendedXMP struct {
+	guids   []string // In the order first seen
+	packets map[string]*xmpChunks
+}
+
+// xmpChunks holds the chunks of one extended XMP packet
+type xmpChunks struct {
+	size   uint32
+	chunks map[uint32][]byte // Chunk data by offset in the packet
+}
+
+// add records a chunk: the GUID, the packet size, the chunk's offset in the
+// packet and then its data
+func (x *extendedXMP) add(data []byte, offset int64, sink Sink) {
+	if len(data) < 40 {
+		sink.Warn("Extended XMP segment at offset %d is too short", offset)
+		return
+	}
+	guid := string(data[:32])
+	size := binary.BigEndian.Uint32(data[32:36])
+	chunkOffset := binary.BigEndian.Uint32(data[36:40])
+	if size > maxBlockSize {
+		sink.Warn("Extended XMP with GUID %s is too large (%d bytes)", guid, size)
+		return
+	}
+	if x.packets == nil {
+		x.packets = make(map[string]*xmpChunks)
+	}
+	p := x.packets[guid]
+	if p == nil {
+		p = &xmpChunks{size: size, chunks: make(map[uint32][]byte)}
+		x.packets[guid] = p
+		x.guids = append(x.guids, guid)
+	}
+	p.chunks[chunkOffset] = data[40:]
+}
+
+// flush passes each complete packet whose MD5 matches its GUID to sink
+func (x *extendedXMP) flush(sink Sink) {
+	for _, guid := range x.guids {
+		p := x.packets[guid]
+		offsets := make([]uint32, 0, len(p.chunks))
+		for off := range p.chunks {
+			offsets = append(offsets, off)
+		}
+		sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+		packet := make([]byte, 0, p.size)
+		for _, off := range offsets {
+			if int(off) != len(packet) {
+				break
+			}
+			packet = append(packet, p.chunks[off]...)
+		}
+		if len(packet) != int(p.size) {
+			sink.Warn("Extended XMP with GUID %s is incomplete (%d of %d bytes)", guid, len(packet), p.size)
+			continue
+		}
+		sum := md5.Sum(packet)
+		if !strings.EqualFold(hex.EncodeToString(sum[:]), guid) {
+			sink.Warn("Extended XMP with GUID %s does not match its MD5", guid)
+			continue
+		}
+		sink.Block(BlockExtendedXMP, bytes.NewReader(packet), 0, int64(len(packet)))
+	}
+}
@@ -0,0 +1,279 @@
+package formats
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// jpegSegment encodes a marker segment
+func jpegSegment(marker byte, parts ...[]byte) []byte {
+	data := bytes.Join(parts, nil)
+	return append(binary.BigEndian.AppendUint16([]byte{0xFF, marker}, uint16(len(data)+2)), data...)
+}
+
+// jpegFile encodes a JPEG holding segments, ending at the start of scan
+func jpegFile(segments ...[]byte) []byte {
+	return bytes.Join([][]byte{{0xFF, 0xD8}, bytes.Join(segments, nil), {0xFF, 0xDA, 0x00, 0x02}}, nil)
+}
+
+// extendedXMPChunk encodes an APP1 segment holding the part of packet at
+// offset, naming the packet by guid
+func extendedXMPChunk(guid string, packet []byte, offset, length int) []byte {
+	return jpegSegment(0xE1, []byte(xmpExtensionNamespace), []byte(guid),
+		be(uint32(len(packet)), uint32(offset)), packet[offset:offset+length])
+}
+
+// xmpGUID returns the GUID naming an extended XMP packet
+func xmpGUID(packet []byte) string {
+	sum := md5.Sum(packet)
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func TestJPEGExtendedXMP(t *testing.T) {
+	packet := bytes.Repeat([]byte("<extended/>"), 10)
+	other := []byte("<other extended packet/>")
+	guid, otherGUID := xmpGUID(packet), xmpGUID(other)
+
+	tests := []struct {
+		name    string
+		file    []byte
+		blocks  []testBlock
+		warning string
+	}{
+		{
+			"out of order",
+			jpegFile(
+				extendedXMPChunk(guid, packet, 60, 50),
+				extendedXMPChunk(guid, packet, 0, 30),
+				extendedXMPChunk(guid, packet, 30, 30),
+			),
+			[]testBlock{{BlockExtendedXMP, packet}}, "",
+		},
+		{
+			"two packets interleaved",
+			jpegFile(
+				extendedXMPChunk(otherGUID, other, 10, len(other)-10),
+				extendedXMPChunk(guid, packet, 50, 60),
+				extendedXMPChunk(otherGUID, other, 0, 10),
+				extendedXMPChunk(guid, packet, 0, 50),
+			),
+			[]testBlock{{BlockExtendedXMP, other}, {BlockExtendedXMP, packet}}, "",
+		},
+		{
+			"missing chunk",
+			jpegFile(extendedXMPChunk(guid, packet, 0, 30), extendedXMPChunk(guid, packet, 60, 50)),
+			nil, "Extended XMP with GUID " + guid + " is incomplete (30 of 110 bytes)",
+		},
+		{
+			"wrong GUID",
+			jpegFile(extendedXMPChunk(otherGUID, packet, 0, len(packet))),
+			nil, "Extended XMP with GUID " + otherGUID + " does not match its MD5",
+		},
+		{
+			"short segment",
+			jpegFile(jpegSegment(0xE1, []byte(xmpExtensionNamespace), []byte(guid))),
+			nil, "Extended XMP segment at offset 6 is too short",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := parseTest(t, jpegHandler{}, tt.file)
+			sink.checkBlocks(t, tt.blocks...)
+			if tt.warning != "" {
+				sink.checkWarning(t, tt.warning)
+			}
+		})
+	}
+}
+
+// ijgQuality75 is the luminance quantization table the IJG library writes
+// at quality 75, in block order
+var ijgQuality75 = []int{
+	8, 6, 5, 8, 12, 20, 26, 31,
+	6, 6, 7, 10, 13, 29, 30, 28,
+	7, 7, 8, 12, 20, 29, 35, 28,
+	7, 9, 11, 15, 26, 44, 40, 31,
+	9, 11, 19, 28, 34, 55, 52, 39,
+	12, 18, 28, 32, 41, 52, 57, 46,
+	25, 32, 39, 44, 52, 61, 60, 51,
+	36, 46, 48, 49, 56, 50, 52, 50,
+}
+
+// dqtTable encodes a quantization table given in block order as stored in a
+// DQT segment: precision and number, then the entries in zigzag order
+func dqtTable(num byte, sixteenBit bool, table []int) []byte {
+	data := []byte{num}
+	if sixteenBit {
+		data[0] |= 0x10
+	}
+	for _, pos := range jpegZigZag {
+		if sixteenBit {
+			data = binary.BigEndian.AppendUint16(data, uint16(table[pos]))
+		} else {
+			data = append(data, byte(table[pos]))
+		}
+	}
+	return data
+}
+
+// baselineJPEG is the header of a 640x480 baseline JPEG with 4:2:0 chroma
+// subsampling saved at IJG quality 75
+var baselineJPEG = jpegFile(
+	jpegSegment(0xE0, []byte(jfifNamespace), []byte{1, 2, 1}, be(uint16(300), uint16(300)), []byte{0, 0}),
+	jpegSegment(0xEE, []byte(adobeNamespace), be(uint16(100), uint16(0), uint16(0)), []byte{1}),
+	jpegSegment(0xDB, dqtTable(0, false, ijgQuality75), dqtTable(1, false, ijgQuality75)),
+	jpegSegment(0xC0, []byte{8}, be(uint16(480), uint16(640)), []byte{3, 1, 0x22, 0, 2, 0x11, 1, 3, 0x11, 1}),
+	jpegSegment(0xC4, make([]byte, 17)),
+	// A second frame, as of an embedded thumbnail, is not the main image
+	jpegSegment(0xC2, []byte{8}, be(uint16(120), uint16(160)), []byte{1, 1, 0x11, 0}),
+)
+
+func TestJPEGBaseline(t *testing.T) {
+	sink := parseTest(t, jpegHandler{}, baselineJPEG)
+	sink.checkTags(t, []tagCheck{
+		{"JFIF::Main", "JFIFVersion", []int{1, 2}},
+		{"JFIF::Main", "ResolutionUnit", 1},
+		{"JFIF::Main", "XResolution", 300},
+		{"JFIF::Main", "YResolution", 300},
+		{"JPEG::Adobe", "DCTEncodeVersion", 100},
+		{"JPEG::Adobe", "APP14Flags0", 0},
+		{"JPEG::Adobe", "APP14Flags1", 0},
+		{"JPEG::Adobe", "ColorTransform", 1},
+		{"JPEG::SOF", "ImageWidth", 640},
+		{"JPEG::SOF", "ImageHeight", 480},
+		{"JPEG::SOF", "EncodingProcess", 0},
+		{"JPEG::SOF", "BitsPerSample", 8},
+		{"JPEG::SOF", "ColorComponents", 3},
+		{"JPEG::SOF", "YCbCrSubSampling", []int{2, 2}},
+		{"", "JPEGQualityEstimate", 75},
+	})
+	for _, tag := range sink.tags {
+		if tag.Table == "JFIF::Main" && strings.HasPrefix(tag.Name, "Thumbnail") {
+			t.Errorf("%s recorded for a JFIF header without a thumbnail", tag.Name)
+		}
+		if tag.Table == "JPEG::SOF" && tag.Name == "ImageWidth" && tag.Value != 640 {
+			t.Errorf("ImageWidth %v recorded from the second frame", tag.Value)
+		}
+	}
+	if len(sink.warnings) > 0 {
+		t.Errorf("warnings: %q", sink.warnings)
+	}
+}
+
+func TestIsSOF(t *testing.T) {
+	for marker := 0xB0; marker <= 0xDF; marker++ {
+		want := marker >= 0xC0 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC
+		if got := isSOF(byte(marker)); got != want {
+			t.Errorf("isSOF(0x%02X) = %v, want %v", marker, got, want)
+		}
+	}
+}
+
+func TestJPEGQualityEstimate(t *testing.T) {
+	ones := make([]int, 64)
+	for i := range ones {
+		ones[i] = 1
+	}
+	tests := []struct {
+		name  string
+		dqt   []byte
+		want  int
+		warns string
+	}{
+		{"quality 75", dqtTable(0, false, ijgQuality75), 75, ""},
+		{"16-bit entries", dqtTable(0, true, ijgQuality75), 75, ""},
+		{"standard table", dqtTable(0, false, jpegStandardLuminance[:]), 50, ""},
+		{"quality 100", dqtTable(0, false, ones), 100, ""},
+		// Only table 0, the luminance table, is compared
+		{"chrominance table first", append(dqtTable(1, false, ones), dqtTable(0, false, ijgQuality75)...), 75, ""},
+		{"no luminance table", dqtTable(1, false, ijgQuality75), 0, ""},
+		{"table number out of range", dqtTable(4, false, ijgQuality75), 0, "Invalid JPEG DQT segment at offset 6"},
+		{"truncated table", dqtTable(0, false, ijgQuality75)[:40], 0, "Invalid JPEG DQT segment at offset 6"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := parseTest(t, jpegHandler{}, jpegFile(jpegSegment(0xDB, tt.dqt)))
+			var got []interface{}
+			for _, tag := range sink.tags {
+				if tag.Name == "JPEGQualityEstimate" {
+					got = append(got, tag.Value)
+				}
+			}
+			switch {
+			case tt.want == 0 && len(got) > 0:
+				t.Errorf("JPEGQualityEstimate = %v, want none", got)
+			case tt.want != 0 && (len(got) != 1 || got[0] != tt.want):
+				t.Errorf("JPEGQualityEstimate = %v, want %d", got, tt.want)
+			}
+			if tt.warns != "" {
+				sink.checkWarning(t, tt.warns)
+			}
+		})
+	}
+}
+
+func TestJPEGAPP0(t *testing.T) {
+	thumbnail := []byte("\xff\xd8\xff\xdb thumbnail \xff\xd9")
+	pixels := []byte{0xFF, 0, 0, 0, 0xFF, 0}
+	tests := []struct {
+		name    string
+		segment []byte
+		checks  []tagCheck
+		warning string
+	}{
+		{
+			"JFIF thumbnail",
+			jpegSegment(0xE0, []byte(jfifNamespace), []byte{1, 1, 0}, be(uint16(1), uint16(1)), []byte{2, 1}, pixels),
+			[]tagCheck{
+				{"JFIF::Main", "ThumbnailWidth", 2},
+				{"JFIF::Main", "ThumbnailHeight", 1},
+				{"JFIF::Main", "ThumbnailTIFF", pixels},
+			}, "",
+		},
+		{
+			"JFXX JPEG thumbnail",
+			jpegSegment(0xE0, []byte(jfxxNamespace), []byte{0x10}, thumbnail),
+			[]tagCheck{{"JFIF::Extension", "ThumbnailImage", thumbnail}}, "",
+		},
+		{
+			"JFXX RGB thumbnail",
+			jpegSegment(0xE0, []byte(jfxxNamespace), []byte{0x13, 2, 1}, pixels),
+			[]tagCheck{{"JFIF::Extension", "ThumbnailTIFF", append([]byte{2, 1}, pixels...)}}, "",
+		},
+		{
+			"JFXX unknown code",
+			jpegSegment(0xE0, []byte(jfxxNamespace), []byte{0x12}, thumbnail),
+			nil, "Unknown JFXX extension code 0x12",
+		},
+		{
+			"short JFIF",
+			jpegSegment(0xE0, []byte(jfifNamespace), []byte{1, 1, 0}),
+			nil, "JFIF segment is too short (8 bytes)",
+		},
+		{
+			"short Adobe",
+			jpegSegment(0xEE, []byte(adobeNamespace), be(uint16(100))),
+			nil, "Adobe APP14 segment is too short (7 bytes)",
+		},
+		{
+			"short SOF",
+			jpegSegment(0xC1, []byte{8, 0, 1}),
+			nil, "JPEG SOF1 segment is too short (3 bytes)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := parseTest(t, jpegHandler{}, jpegFile(tt.segment))
+			sink.checkTags(t, tt.checks)
+			if tt.warning != "" {
+				sink.checkWarning(t, tt.warning)
+			} else if len(sink.warnings) > 0 {
+				t.Errorf("warnings: %q", sink.warnings)
+			}
+		})
+	}
+}
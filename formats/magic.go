@@ -0,0 +1,107 @@
+package formats
+
+import (
+	"fmt"
+	"sort"
+
+	"greg-hacke/go-metadata/internal/perlre"
+	"greg-hacke/go-metadata/tags"
+)
+
+// testLen is how much of the file ExifTool reads to test magic numbers.
+// Patterns that can look further, such as those ending in $, only ever see
+// this much.
+const testLen = 1024
+
+// weakMagicBits is the specificity below which a magic number matches too
+// many unrelated files to identify one on its own
+const weakMagicBits = 24
+
+// weakMagic lists types ExifTool itself treats as weakly identified
+var weakMagic = map[string]bool{"MP3": true}
+
+// MagicNumberStatus describes how the magic number of one file type is matched
+type MagicNumberStatus struct {
+	FileType    string
+	Pattern     string  // Perl pattern from ExifTool's %magicNumber
+	Engine      string  // perlre.EngineRE2 or perlre.EngineBacktrack, empty if unusable
+	Reason      string  // Why the backtracking matcher is needed
+	Span        int     // Header bytes the pattern can examine
+	Specificity float64 // Bits a match must find, see perlre.Regexp.Specificity
+	Weak        bool    // A match alone is weak evidence of the type
+	Err         error   // Why the pattern cannot be matched at all
+}
+
+// magicNumber is a compiled magic number pattern
+type magicNumber struct {
+	re      *perlre.Regexp
+	span    int  // Header bytes the pattern can examine
+	bounded bool // span does not depend on how much of the file is read
+	weak    bool
+}
+
+var (
+	magicNumbers map[string]*magicNumber // Compiled patterns by file type
+	magicErrors  map[string]error        // Patterns that could not be compiled
+	headerSize   = testLen               // Header bytes needed by the longest pattern
+)
+
+func init() {
+	compileMagicNumbers()
+}
+
+// compileMagicNumbers compiles every magic number pattern. Like ExifTool's
+// /^$magicNumber{$type}/s, each must match at the start of the file and .
+// matches any byte.
+func compileMagicNumbers() {
+	magicNumbers = make(map[string]*magicNumber)
+	magicErrors = make(map[string]error)
+	for fileType, pattern := range tags.ExifToolFileTypes.MagicNumbers {
+		if pattern == "RawConv" {
+			// Not a pattern: the type is recognized in its module
+			magicErrors[fileType] = fmt.Errorf("no magic number pattern")
+			continue
+		}
+		re, err := perlre.Compile("(?s)^" + pattern)
+		if err != nil {
+			magicErrors[fileType] = err
+			continue
+		}
+		m := &magicNumber{
+			re:   re,
+			weak: weakMagic[fileType] || re.Specificity() < weakMagicBits,
+		}
+		m.span, m.bounded = re.Span()
+		if !m.bounded {
+			m.span = testLen
+		}
+		headerSize = max(headerSize, m.span)
+		magicNumbers[fileType] = m
+	}
+}
+
+// HeaderSize returns how many bytes from the start of a file Identify needs
+// to test every magic number
+func HeaderSize() int {
+	return headerSize
+}
+
+// MagicNumberReport lists how each file type's magic number is matched,
+// sorted by file type. Entries with a non-nil Err are never matched, so
+// those types are only recognized by extension.
+func MagicNumberReport() []MagicNumberStatus {
+	report := make([]MagicNumberStatus, 0, len(tags.ExifToolFileTypes.MagicNumbers))
+	for fileType, pattern := range tags.ExifToolFileTypes.MagicNumbers {
+		status := MagicNumberStatus{FileType: fileType, Pattern: pattern, Err: magicErrors[fileType]}
+		if m, ok := magicNumbers[fileType]; ok {
+			status.Engine = m.re.Engine()
+			status.Reason = m.re.FallbackReason()
+			status.Span = m.span
+			status.Specificity = m.re.Specificity()
+			status.Weak = m.weak
+		}
+		report = append(report, status)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].FileType < report[j].FileType })
+	return report
+}
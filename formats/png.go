@@ -0,0 +1,358 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"greg-hacke/go-metadata/internal/latin1"
+)
+
+func init() {
+	Register("PNG", pngHandler{})
+}
+
+// pngSignature starts every PNG file
+var pngSignature = []byte{0x89, 'P', 'N', 'G', 0x0D, 0x0A, 0x1A, 0x0A}
+
+// pngXMPKeyword is the iTXt keyword of an XMP packet
+const pngXMPKeyword = "XML:com.adobe.xmp"
+
+// pngRawProfile prefixes the keywords of ImageMagick's text chunks holding
+// hex-encoded metadata, such as "Raw profile type exif"
+const pngRawProfile = "Raw profile type "
+
+// pngChunks are the chunks read into memory and decoded; image data and
+// other chunks are skipped without being read
+var pngChunks = map[string]bool{
+	"IHDR": true, "tEXt": true, "zTXt": true, "iTXt": true, "iCCP": true,
+	"eXIf": true, "tIME": true, "pHYs": true, "gAMA": true, "cHRM": true,
+	"sRGB": true, "acTL": true, "fcTL": true,
+}
+
+// pngChromaticities names the eight values of a cHRM chunk, in order
+var pngChromaticities = []string{
+	"WhitePointX", "WhitePointY", "RedX", "RedY", "GreenX", "GreenY", "BlueX", "BlueY",
+}
+
+// pngHandler walks the chunks of a PNG file
+type pngHandler struct{}
+
+// Sniff reports whether header starts with the PNG signature
+func (pngHandler) Sniff(header []byte) bool {
+	return len(header) > 8 && bytes.Equal(header[0:8], pngSignature)
+}
+
+// Parse decodes the header, text, time, density, color and animation
+// chunks and passes EXIF, XMP, ICC and ImageMagick raw profiles to sink.
+// The CRC of every chunk that is read is checked.
+func (pngHandler) Parse(r io.ReaderAt, size int64, sink Sink) error {
+	sink.Segment(0, "PNG", "PNG structure")
+
+	offset := int64(8) // Skip PNG signature
+	chunkHeader := make([]byte, 8)
+	var duration float64 // Sum of the APNG frame delays
+	frames := 0
+
+	for offset+12 <= size {
+		if _, err := r.ReadAt(chunkHeader, offset); err != nil {
+			return fmt.Errorf("reading PNG chunk at offset %d: %w", offset, err)
+		}
+		chunkLen := int64(binary.BigEndian.Uint32(chunkHeader[0:4]))
+		chunkType := string(chunkHeader[4:8])
+		if offset+8+chunkLen+4 > size {
+			sink.Warn("PNG %s chunk at offset %d runs past the end of the file", chunkType, offset)
+			break
+		}
+
+		if chunkType == "IEND" {
+			break
+		}
+
+		if pngChunks[chunkType] {
+			// The CRC covers the chunk type and data
+			chunk, err := readBlock(r, offset+4, chunkLen+8)
+			if err != nil {
+				return fmt.Errorf("reading PNG %s chunk at offset %d: %w", chunkType, offset, err)
+			}
+			data := chunk[4 : 4+chunkLen]
+			if crc32.ChecksumIEEE(chunk[:4+chunkLen]) != binary.BigEndian.Uint32(chunk[4+chunkLen:]) {
+				sink.Warn("Bad CRC for PNG %s chunk at offset %d", chunkType, offset)
+			}
+
+			if chunkType == "fcTL" {
+				// Frame control: the delay is a fraction of a second, with a
+				// denominator of 0 meaning 100
+				if len(data) >= 24 {
+					num, den := binary.BigEndian.Uint16(data[20:22]), binary.BigEndian.Uint16(data[22:24])
+					if den == 0 {
+						den = 100
+					}
+					duration += float64(num) / float64(den)
+					frames++
+				}
+			} else {
+				parsePNGChunk(r, chunkType, data, offset, sink)
+			}
+		}
+
+		offset += 8 + chunkLen + 4 // length + type + data + CRC
+	}
+
+	if frames > 0 {
+		sink.Tag(Tag{Group: "PNG", Name: "AnimationDuration", Value: duration})
+	}
+	return nil
+}
+
+// parsePNGChunk decodes one chunk read by Parse
+func parsePNGChunk(r io.ReaderAt, chunkType string, data []byte, offset int64, sink Sink) {
+	be := binary.BigEndian
+	switch chunkType {
+	case "IHDR":
+		if len(data) < 13 {
+			sink.Warn("PNG IHDR chunk is too short (%d bytes)", len(data))
+			return
+		}
+		fields := []tableField{
+			{"0", "ImageWidth", int(be.Uint32(data[0:4]))},
+			{"4", "ImageHeight", int(be.Uint32(data[4:8]))},
+			{"8", "BitDepth", int(data[8])},
+			{"9", "ColorType", int(data[9])},
+			{"10", "Compression", int(data[10])},
+			{"11", "Filter", int(data[11])},
+			{"12", "Interlace", int(data[12])},
+		}
+		for _, f := range fields {
+			sink.Tag(Tag{Group: "PNG", Table: "PNG::ImageHeader", ID: f.id, Name: f.name, Value: f.value})
+		}
+
+	case "pHYs":
+		if len(data) < 9 {
+			return
+		}
+		fields := []tableField{
+			{"0", "PixelsPerUnitX", int(be.Uint32(data[0:4]))},
+			{"4", "PixelsPerUnitY", int(be.Uint32(data[4:8]))},
+			{"8", "PixelUnits", int(data[8])},
+		}
+		for _, f := range fields {
+			sink.Tag(Tag{Group: "PNG", Directory: "PNG-pHYs", Table: "PNG::PhysicalPixel", ID: f.id, Name: f.name, Value: f.value})
+		}
+
+	case "cHRM":
+		// Chromaticities are stored multiplied by 100000
+		for i, name := range pngChromaticities {
+			if len(data) < i*4+4 {
+				break
+			}
+			value := float64(be.Uint32(data[i*4:])) / 100000
+			sink.Tag(Tag{Group: "PNG", Table: "PNG::PrimaryChromaticities", ID: strconv.Itoa(i * 4), Name: name, Value: value})
+		}
+
+	case "gAMA":
+		// The file gamma multiplied by 100000; ExifTool gives the display
+		// gamma, its inverse, to four decimal places
+		if len(data) < 4 {
+			return
+		}
+		if g := be.Uint32(data); g != 0 {
+			sink.Tag(Tag{Group: "PNG", Table: "PNG::Main", ID: "gAMA", Name: "Gamma", Value: float64(int(1e9/float64(g)+0.5)) / 1e4})
+		}
+
+	case "sRGB":
+		if len(data) >= 1 {
+			sink.Tag(Tag{Group: "PNG", Table: "PNG::Main", ID: "sRGB", Name: "SRGBRendering", Value: int(data[0])})
+		}
+
+	case "tIME":
+		if len(data) < 7 {
+			return
+		}
+		t := time.Date(int(be.Uint16(data[0:2])), time.Month(data[2]), int(data[3]), int(data[4]), int(data[5]), int(data[6]), 0, time.UTC)
+		sink.Tag(Tag{Group: "PNG", Table: "PNG::Main", ID: "tIME", Name: "ModifyDate", Value: t})
+
+	case "acTL":
+		if len(data) < 8 {
+			return
+		}
+		sink.Tag(Tag{Group: "PNG", Table: "PNG::AnimationControl", ID: "0", Name: "AnimationFrames", Value: int(be.Uint32(data[0:4]))})
+		sink.Tag(Tag{Group: "PNG", Table: "PNG::AnimationControl", ID: "4", Name: "AnimationPlays", Value: int(be.Uint32(data[4:8]))})
+
+	case "tEXt", "zTXt", "iTXt":
+		parsePNGText(chunkType, data, offset, sink)
+
+	case "iCCP":
+		// A profile name, the compression method and the zlib-compressed profile
+		name, compressed, ok := bytes.Cut(data, []byte{0})
+		if !ok || len(compressed) < 1 {
+			sink.Warn("PNG iCCP chunk at offset %d is invalid", offset)
+			return
+		}
+		sink.Tag(Tag{Group: "PNG", Table: "PNG::Main", ID: "iCCP-name", Name: "ProfileName", Value: latin1.Decode(name)})
+		if !sink.Wants("ICC_Profile", "") {
+			return
+		}
+		profile, err := inflate(compressed[1:])
+		if err != nil {
+			sink.Warn("PNG iCCP chunk at offset %d could not be decompressed: %v", offset, err)
+			return
+		}
+		sink.Segment(offset, "iCCP", "ICC_Profile")
+		sink.Block(BlockICC, bytes.NewReader(profile), 0, int64(len(profile)))
+
+	case "eXIf":
+		sink.Segment(offset, "eXIf", "EXIF")
+		sink.Block(BlockTIFF, r, offset+8, int64(len(data)))
+	}
+}
+
+// parsePNGText decodes a text chunk: a keyword, then for zTXt a compression
+// method and compressed Latin-1 text, for iTXt a compression flag and
+// method, a language, a translated keyword and UTF-8 text, and for tEXt
+// plain Latin-1 text. XMP and ImageMagick raw profiles go to their decoders.
+// A translated keyword, which ExifTool ignores, is recorded as a tag of its
+// own named after the text tag, like Title-fr-TranslatedKeyword.
+func parsePNGText(chunkType string, data []byte, offset int64, sink Sink) {
+	keyword, rest, ok := bytes.Cut(data, []byte{0})
+	if !ok || len(keyword) == 0 {
+		sink.Warn("PNG %s chunk at offset %d has no keyword", chunkType, offset)
+		return
+	}
+	key := latin1.Decode(keyword)
+	compressed := false
+	lang, translated := "", ""
+	switch chunkType {
+	case "zTXt":
+		if len(rest) < 1 {
+			return
+		}
+		compressed, rest = true, rest[1:]
+	case "iTXt":
+		if len(rest) < 2 {
+			return
+		}
+		compressed = rest[0] != 0
+		var language, transKey []byte
+		if language, rest, ok = bytes.Cut(rest[2:], []byte{0}); !ok {
+			return
+		}
+		if transKey, rest, ok = bytes.Cut(rest, []byte{0}); !ok {
+			return
+		}
+		lang, translated = string(language), string(transKey)
+		sink.Segment(offset, "iTXt", fmt.Sprintf("%s, language %q, translated keyword %q", key, lang, translated))
+	}
+	if compressed {
+		text, err := inflate(rest)
+		if err != nil {
+			sink.Warn("PNG %s chunk %q at offset %d could not be decompressed: %v", chunkType, key, offset, err)
+			return
+		}
+		rest = text
+	}
+
+	switch {
+	case key == pngXMPKeyword:
+		sink.Segment(offset, chunkType, "XMP")
+		sink.Block(BlockXMP, bytes.NewReader(rest), 0, int64(len(rest)))
+		return
+	case strings.HasPrefix(key, pngRawProfile):
+		if parseRawProfile(strings.TrimPrefix(key, pngRawProfile), rest, offset, sink) {
+			return
+		}
+	}
+
+	var value interface{}
+	if chunkType == "iTXt" {
+		value = string(rest)
+	} else {
+		value = latin1.Decode(rest)
+	}
+	if key == "Creation Time" {
+		if t, ok := pngCreationTime(value.(string)); ok {
+			value = t
+		}
+	}
+	id, name, suffix := key, tagNameFor(key), ""
+	if lang != "" {
+		// Translations are named like XMP language alternatives
+		suffix = "-" + lang
+	}
+	sink.Tag(Tag{Group: "PNG", Table: "PNG::TextualData", ID: id + suffix, Name: name + suffix, Value: value})
+	if translated != "" {
+		// Not an ExifTool tag, so named in a way no PNG::TextualData tag can be
+		sink.Tag(Tag{Group: "PNG", Table: "PNG::TextualData", ID: id + suffix + "-TranslatedKeyword", Name: name + suffix + "-TranslatedKeyword", Value: translated})
+	}
+}
+
+// parseRawProfile decodes an ImageMagick raw profile: a newline, the
+// profile type, the length of the data and then the data in hex, split
+// over lines. It reports whether the profile was of a kind passed to sink.
+func parseRawProfile(kind string, text []byte, offset int64, sink Sink) bool {
+	fields := strings.Fields(string(text))
+	if len(fields) < 3 {
+		return false
+	}
+	size, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return false
+	}
+	data, err := hex.DecodeString(strings.Join(fields[2:], ""))
+	if err != nil || len(data) != size {
+		sink.Warn("Invalid PNG raw profile type %s at offset %d", kind, offset)
+		return true
+	}
+
+	var block BlockKind
+	switch strings.ToLower(kind) {
+	case "exif", "app1":
+		data = bytes.TrimPrefix(data, []byte(exifNamespace))
+		if bytes.HasPrefix(data, []byte(xmpNamespace)) {
+			// ImageMagick names APP1 XMP the same way as EXIF
+			block, data = BlockXMP, data[len(xmpNamespace):]
+		} else {
+			block = BlockTIFF
+		}
+	case "iptc", "8bim":
+		// Usually Photoshop resources, sometimes bare IPTC
+		data = bytes.TrimPrefix(data, []byte(photoshopNamespace))
+		if len(data) > 0 && data[0] == 0x1C {
+			block = BlockIPTC
+		} else {
+			block = BlockPhotoshop
+		}
+	case "xmp":
+		block = BlockXMP
+	case "icc", "icm":
+		block = BlockICC
+	default:
+		return false
+	}
+	sink.Segment(offset, "Raw profile", fmt.Sprintf("%s, %d bytes", kind, len(data)))
+	sink.Block(block, bytes.NewReader(data), 0, int64(len(data)))
+	return true
+}
+
+// pngCreationTimeLayouts are the forms writers use for the Creation Time
+// keyword, which the PNG specification suggests be RFC 1123
+var pngCreationTimeLayouts = []string{
+	time.RFC1123Z, time.RFC1123, time.RFC3339, "2006:01:02 15:04:05", "2006-01-02T15:04:05",
+}
+
+// pngCreationTime parses the text of a Creation Time keyword
+func pngCreationTime(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range pngCreationTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
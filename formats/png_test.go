@@ -0,0 +1,146 @@
+package formats
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"testing"
+	"time"
+)
+
+// pngChunk builds a PNG chunk with its CRC
+func pngChunk(chunkType string, parts ...[]byte) []byte {
+	data := bytes.Join(parts, nil)
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(append(chunk, chunkType...), data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// deflate compresses data with zlib, as PNG does
+func deflate(data []byte) []byte {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+// pngTestFile builds an APNG with two frames, its header, color, density,
+// time and text chunks, and EXIF, XMP and ICC profiles
+func pngTestFile() []byte {
+	exif := append([]byte("Exif\x00\x00"), testTIFF...)
+	rawProfile := fmt.Sprintf("\nexif\n%8d\n%s\n", len(exif), hex.EncodeToString(exif))
+	badCRC := pngChunk("tEXt", []byte("Creation Time\x00Mon, 06 May 2024 07:08:09 +0200"))
+	badCRC[len(badCRC)-1] ^= 1
+
+	return bytes.Join([][]byte{
+		pngSignature,
+		pngChunk("IHDR", be(uint32(4), uint32(3)), []byte{8, 6, 0, 0, 1}),
+		pngChunk("gAMA", be(uint32(45455))),
+		pngChunk("cHRM", be(uint32(31270), uint32(32900), uint32(64000), uint32(33000), uint32(30000), uint32(60000), uint32(15000), uint32(6000))),
+		pngChunk("sRGB", []byte{0}),
+		pngChunk("iCCP", []byte("Display P3\x00\x00"), deflate([]byte("icc profile"))),
+		pngChunk("pHYs", be(uint32(2835), uint32(2835)), []byte{1}),
+		pngChunk("tIME", be(uint16(2024)), []byte{5, 6, 7, 8, 9}),
+		pngChunk("acTL", be(uint32(2), uint32(0))),
+		pngChunk("fcTL", be(uint32(0), uint32(4), uint32(3), uint32(0), uint32(0), uint16(1), uint16(2)), []byte{0, 0}),
+		pngChunk("tEXt", []byte("Title\x00Caf\xe9")),
+		pngChunk("zTXt", []byte("Comment\x00\x00"), deflate([]byte("compressed comment"))),
+		pngChunk("iTXt", []byte("Title\x00\x00\x00fr\x00Titre\x00Le caf\xc3\xa9")),
+		pngChunk("iTXt", []byte("Description\x00\x01\x00\x00\x00"), deflate([]byte("zipped \xc3\xbctf"))),
+		badCRC,
+		pngChunk("zTXt", []byte("Raw profile type exif\x00\x00"), deflate([]byte(rawProfile))),
+		pngChunk("iTXt", []byte(pngXMPKeyword+"\x00\x00\x00\x00\x00"), testXMP),
+		pngChunk("IDAT", deflate(make([]byte, 51))),
+		pngChunk("fcTL", be(uint32(1), uint32(4), uint32(3), uint32(0), uint32(0), uint16(0), uint16(0)), []byte{0, 0}),
+		pngChunk("eXIf", testTIFF),
+		pngChunk("IEND"),
+	}, nil)
+}
+
+func TestPNG(t *testing.T) {
+	sink := parseTest(t, pngHandler{}, pngTestFile())
+	sink.checkTags(t, []tagCheck{
+		{"PNG::ImageHeader", "ImageWidth", 4},
+		{"PNG::ImageHeader", "ImageHeight", 3},
+		{"PNG::ImageHeader", "BitDepth", 8},
+		{"PNG::ImageHeader", "ColorType", 6},
+		{"PNG::ImageHeader", "Interlace", 1},
+		{"PNG::Main", "Gamma", 2.2},
+		{"PNG::Main", "SRGBRendering", 0},
+		{"PNG::Main", "ProfileName", "Display P3"},
+		{"PNG::Main", "ModifyDate", time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)},
+		{"PNG::PrimaryChromaticities", "WhitePointX", 0.3127},
+		{"PNG::PrimaryChromaticities", "BlueY", 0.06},
+		{"PNG::PhysicalPixel", "PixelsPerUnitX", 2835},
+		{"PNG::PhysicalPixel", "PixelUnits", 1},
+		{"PNG::AnimationControl", "AnimationFrames", 2},
+		{"PNG::AnimationControl", "AnimationPlays", 0},
+		{"", "AnimationDuration", 0.5},
+		{"PNG::TextualData", "Title", "Café"},
+		{"PNG::TextualData", "Comment", "compressed comment"},
+		{"PNG::TextualData", "Title-fr", "Le café"},
+		{"PNG::TextualData", "Title-fr-TranslatedKeyword", "Titre"},
+		{"PNG::TextualData", "Description", "zipped ütf"},
+		{"PNG::TextualData", "CreationTime", time.Date(2024, 5, 6, 5, 8, 9, 0, time.UTC)},
+	})
+	sink.checkBlocks(t,
+		testBlock{BlockICC, []byte("icc profile")},
+		testBlock{BlockTIFF, testTIFF},
+		testBlock{BlockXMP, testXMP},
+		testBlock{BlockTIFF, testTIFF},
+	)
+	sink.checkWarning(t, "Bad CRC for PNG tEXt chunk")
+}
+
+// pngTextFile builds a PNG holding the given text chunk
+func pngTextFile(chunkType, data string) []byte {
+	return bytes.Join([][]byte{
+		pngSignature,
+		pngChunk("IHDR", be(uint32(1), uint32(1)), []byte{8, 0, 0, 0, 0}),
+		pngChunk(chunkType, []byte(data)),
+		pngChunk("IEND"),
+	}, nil)
+}
+
+func TestPNGText(t *testing.T) {
+	iptc := []byte("\x1C\x02\x05\x00\x05Title")
+	tests := []struct {
+		name      string
+		chunkType string
+		data      string
+		tags      []tagCheck
+		blocks    []testBlock
+		warning   string
+	}{
+		{"Latin-1", "tEXt", "Author\x00Andr\xe9", []tagCheck{{"PNG::TextualData", "Author", "André"}}, nil, ""},
+		{"no keyword", "tEXt", "\x00text", nil, nil, "has no keyword"},
+		{"bad zlib", "zTXt", "Comment\x00\x00not zlib", nil, nil, `zTXt chunk "Comment" at offset 33 could not be decompressed`},
+		{"no translation", "iTXt", "Title\x00\x00\x00\x00\x00Caf\xc3\xa9", []tagCheck{{"PNG::TextualData", "Title", "Café"}}, nil, ""},
+		{"RFC 3339 time", "tEXt", "Creation Time\x002024-05-06T07:08:09Z", []tagCheck{{"PNG::TextualData", "CreationTime", time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)}}, nil, ""},
+		{"odd time", "tEXt", "Creation Time\x00yesterday", []tagCheck{{"PNG::TextualData", "CreationTime", "yesterday"}}, nil, ""},
+		{"raw IPTC", "tEXt", fmt.Sprintf("Raw profile type iptc\x00\niptc\n%8d\n%x\n", len(iptc), iptc), nil, []testBlock{{BlockIPTC, iptc}}, ""},
+		{"raw profile length", "tEXt", "Raw profile type xmp\x00\nxmp\n5\n3c3f\n", nil, nil, "Invalid PNG raw profile type xmp"},
+		{"unknown raw profile", "tEXt", "Raw profile type zzz\x00\nzzz\n1\n00\n", []tagCheck{{"PNG::TextualData", "RawProfileTypeZzz", "\nzzz\n1\n00\n"}}, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := parseTest(t, pngHandler{}, pngTextFile(tt.chunkType, tt.data))
+			sink.checkTags(t, tt.tags)
+			sink.checkBlocks(t, tt.blocks...)
+			if tt.warning != "" {
+				sink.checkWarning(t, tt.warning)
+			}
+		})
+	}
+}
+
+func FuzzPNG(f *testing.F) {
+	f.Add(pngTestFile())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzParse(pngHandler{}, data)
+	})
+}
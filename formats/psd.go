@@ -0,0 +1,73 @@
+package formats
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+func init() {
+	Register("PSD", psdHandler{})
+}
+
+// psdHeaderSize is the length of the fixed PSD/PSB file header
+const psdHeaderSize = 26
+
+// psdHandler reads the header and image resources of Photoshop documents
+type psdHandler struct{}
+
+// Sniff reports whether header starts a PSD (version 1) or PSB (version 2) file
+func (psdHandler) Sniff(header []byte) bool {
+	return len(header) >= 6 && string(header[0:4]) == "8BPS" &&
+		(header[5] == 1 || header[5] == 2) && header[4] == 0
+}
+
+// Parse records the image dimensions from the header and passes the image
+// resource section, which holds the IPTC, XMP, EXIF and ICC data, to sink
+func (psdHandler) Parse(r io.ReaderAt, size int64, sink Sink) error {
+	sink.Segment(0, "PSD", "Photoshop document")
+
+	header := make([]byte, psdHeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return fmt.Errorf("reading PSD header: %w", err)
+	}
+
+	// Photoshop::Header is a binary table indexed in 16-bit words
+	fields := []struct {
+		id    string
+		name  string
+		value int
+	}{
+		{"6", "NumChannels", int(binary.BigEndian.Uint16(header[12:14]))},
+		{"7", "ImageHeight", int(binary.BigEndian.Uint32(header[14:18]))},
+		{"9", "ImageWidth", int(binary.BigEndian.Uint32(header[18:22]))},
+		{"11", "BitDepth", int(binary.BigEndian.Uint16(header[22:24]))},
+		{"12", "ColorMode", int(binary.BigEndian.Uint16(header[24:26]))},
+	}
+	if sink.Wants("Photoshop", "") {
+		for _, f := range fields {
+			sink.Tag(Tag{Group: "Photoshop", Table: "Photoshop::Header", ID: f.id, Name: f.name, Value: f.value})
+		}
+	}
+
+	// The color mode data section comes first, then the image resources
+	offset := int64(psdHeaderSize)
+	lenBuf := make([]byte, 4)
+	for _, section := range []string{"color mode data", "image resources"} {
+		if _, err := r.ReadAt(lenBuf, offset); err != nil {
+			return fmt.Errorf("reading PSD %s length at offset %d: %w", section, offset, err)
+		}
+		sectionLen := int64(binary.BigEndian.Uint32(lenBuf))
+		offset += 4
+		if offset+sectionLen > size {
+			sink.Warn("PSD %s at offset %d runs past the end of the file", section, offset)
+			return nil
+		}
+		if section == "image resources" && sectionLen > 0 {
+			sink.Segment(offset, "ImageResources", fmt.Sprintf("%d bytes", sectionLen))
+			sink.Block(BlockPhotoshop, r, offset, sectionLen)
+		}
+		offset += sectionLen
+	}
+	return nil
+}
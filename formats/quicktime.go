@@ -0,0 +1,554 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"greg-hacke/go-metadata/internal/latin1"
+)
+
+// quickTimeEpoch is the Unix time of 1904-01-01, which QuickTime and MP4 time
+// stamps count seconds from
+const quickTimeEpoch = -2082844800
+
+// quickTimeMaxTime is the last time stamp taken as a time, at the end of year
+// 9999; 64-bit ones beyond it are corrupt
+var quickTimeMaxTime = uint64(time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC).Unix() - quickTimeEpoch)
+
+// quickTimeKeyPrefix is dropped from Apple metadata keys to look them up in
+// QuickTime::Keys, as ExifTool does
+const quickTimeKeyPrefix = "com.apple.quicktime."
+
+// iso6709 matches a location in the decimal degrees form of ISO 6709, such
+// as "+37.3318-122.0312+012.345/", as written to QuickTime metadata
+var iso6709 = regexp.MustCompile(`^([-+]\d+(?:\.\d*)?)([-+]\d+(?:\.\d*)?)([-+]\d+(?:\.\d*)?)?`)
+
+// quickTimeDateLayouts are the forms of dates stored as text in user data
+// and Apple metadata
+var quickTimeDateLayouts = []string{
+	"2006-01-02T15:04:05-0700", "2006-01-02T15:04:05Z0700", time.RFC3339, "2006-01-02T15:04:05", "2006-01-02",
+}
+
+// quickTimeGPSTags and quickTimeDateTags list the IDs, in QuickTime::Keys,
+// QuickTime::UserData and QuickTime::ItemList, of text values converted to
+// coordinates and times
+var (
+	quickTimeGPSTags  = map[string]bool{"location.ISO6709": true, "©xyz": true}
+	quickTimeDateTags = map[string]bool{"creationdate": true, "©day": true}
+)
+
+// movie collects what is needed from a movie's boxes once they are walked
+type movie struct {
+	timeScale uint32  // Time units per second of the movie header
+	duration  float64 // In seconds
+	tracks    int
+}
+
+// track collects the values of a track's boxes that are combined into
+// VideoFrameRate and Rotation
+type track struct {
+	dir       string // Family 1 group, "Track1", "Track2", ...
+	handler   string // "vide", "soun", ...
+	timeScale uint32 // Time units per second of the media header
+	matrix    []float64
+	samples   uint64 // Sample count from stts
+	sampleDur uint64 // Total sample duration from stts, in media time units
+}
+
+// quickTimeTag records a value from one of the QuickTime tables
+func quickTimeTag(sink Sink, dir, table, id, name string, value interface{}) {
+	sink.Tag(Tag{Group: "QuickTime", Directory: dir, Table: table, ID: id, Name: name, Value: value})
+}
+
+// quickTimeTime converts a time stamp to a time, or nil for an unset or
+// out of range one
+func quickTimeTime(secs uint64) interface{} {
+	if secs == 0 || secs > quickTimeMaxTime {
+		return nil
+	}
+	return time.Unix(int64(secs)+quickTimeEpoch, 0).UTC()
+}
+
+// fixed16 converts a 16.16 fixed-point number
+func fixed16(v uint64) float64 {
+	return float64(int32(v)) / 0x10000
+}
+
+// parseMovie walks a moov box: the movie header, each track and the user
+// data and metadata of the movie
+func parseMovie(r io.ReaderAt, moov isoBox, mov *movie, sink Sink) error {
+	return walkBoxes(r, moov.Data, moov.End, sink, func(box isoBox) error {
+		sink.Segment(box.Offset, box.Type, fmt.Sprintf("%d bytes", box.Size()))
+		switch box.Type {
+		case "mvhd":
+			data, err := readBlock(r, box.Data, min(box.Size(), 256))
+			if err != nil {
+				return fmt.Errorf("reading mvhd box: %w", err)
+			}
+			parseMovieHeader(data, mov, sink)
+		case "trak":
+			mov.tracks++
+			return parseTrack(r, box, mov, &track{dir: fmt.Sprintf("Track%d", mov.tracks)}, sink)
+		case "udta":
+			return parseUserData(r, box, sink)
+		case "meta":
+			return parseItemMeta(r, box, sink)
+		}
+		return nil
+	})
+}
+
+// parseMovieHeader records the mvhd box. QuickTime::MovieHeader is indexed
+// in 32-bit words of the version 0 layout; version 1 has 64-bit times.
+func parseMovieHeader(data []byte, mov *movie, sink Sink) {
+	b := &boxReader{data: data}
+	version, _ := b.fullBox()
+	n := 4
+	if version == 1 {
+		n = 8
+	}
+	created, modified := b.uint(n), b.uint(n)
+	mov.timeScale = uint32(b.uint(4))
+	duration := b.uint(n)
+	rate, volume := fixed16(b.uint(4)), float64(b.uint(2))/0x100
+	b.bytes(10)
+	matrix := quickTimeMatrix(b.bytes(36))
+	b.bytes(24) // Preview, poster, selection and current times
+	next := b.uint(4)
+	if b.short {
+		sink.Warn("QuickTime mvhd box is truncated")
+		return
+	}
+	if mov.timeScale != 0 {
+		mov.duration = float64(duration) / float64(mov.timeScale)
+	}
+
+	fields := []tableField{
+		{"0", "MovieHeaderVersion", version},
+		{"1", "CreateDate", quickTimeTime(created)},
+		{"2", "ModifyDate", quickTimeTime(modified)},
+		{"3", "TimeScale", int(mov.timeScale)},
+		{"4", "Duration", mov.duration},
+		{"5", "PreferredRate", rate},
+		{"6", "PreferredVolume", volume},
+		{"9", "MatrixStructure", matrix},
+		{"24", "NextTrackID", int(next)},
+	}
+	for _, f := range fields {
+		if f.value != nil {
+			quickTimeTag(sink, "", "QuickTime::MovieHeader", f.id, f.name, f.value)
+		}
+	}
+}
+
+// quickTimeMatrix decodes a transformation matrix: rows of two 16.16 and
+// one 2.30 fixed-point numbers
+func quickTimeMatrix(data []byte) []float64 {
+	if len(data) < 36 {
+		return nil
+	}
+	m := make([]float64, 9)
+	for i := range m {
+		v := int32(binary.BigEndian.Uint32(data[i*4:]))
+		if i%3 == 2 {
+			m[i] = float64(v) / (1 << 30)
+		} else {
+			m[i] = float64(v) / 0x10000
+		}
+	}
+	return m
+}
+
+// parseTrack walks a trak box, recording its header, media header, handler
+// and first sample description, then VideoFrameRate and Rotation for video
+func parseTrack(r io.ReaderAt, trak isoBox, mov *movie, t *track, sink Sink) error {
+	err := walkBoxes(r, trak.Data, trak.End, sink, func(box isoBox) error {
+		return parseTrackBox(r, box, mov, t, sink)
+	})
+	if err != nil {
+		return err
+	}
+
+	if t.handler != "vide" {
+		return nil
+	}
+	if t.samples > 0 && t.sampleDur > 0 && t.timeScale > 0 {
+		rate := float64(t.samples) * float64(t.timeScale) / float64(t.sampleDur)
+		quickTimeTag(sink, t.dir, "", "", "VideoFrameRate", math.Round(rate*1000)/1000)
+	}
+	if len(t.matrix) == 9 {
+		// The angle of the first row, clockwise on screen
+		angle := math.Atan2(t.matrix[1], t.matrix[0]) * 180 / math.Pi
+		quickTimeTag(sink, t.dir, "", "", "Rotation", (int(math.Round(angle))+360)%360)
+	}
+	return nil
+}
+
+// parseTrackBox decodes one box of a track, including the boxes nested in
+// its mdia, minf and stbl boxes
+func parseTrackBox(r io.ReaderAt, box isoBox, mov *movie, t *track, sink Sink) error {
+	switch box.Type {
+	case "mdia", "minf", "stbl":
+		return walkBoxes(r, box.Data, box.End, sink, func(child isoBox) error {
+			return parseTrackBox(r, child, mov, t, sink)
+		})
+	case "tkhd", "mdhd", "hdlr", "stsd":
+	case "stts":
+		return parseTimeToSample(r, box, t, sink)
+	default:
+		return nil
+	}
+
+	data, err := readBlock(r, box.Data, min(box.Size(), 512))
+	if err != nil {
+		return fmt.Errorf("reading %s box: %w", box.Type, err)
+	}
+	b := &boxReader{data: data}
+	version, _ := b.fullBox()
+	n := 4
+	if version == 1 {
+		n = 8
+	}
+	var table string
+	var fields []tableField
+	switch box.Type {
+	case "tkhd":
+		// QuickTime::TrackHeader is indexed in 32-bit words of the version 0 layout
+		created, modified := b.uint(n), b.uint(n)
+		id := b.uint(4)
+		b.uint(4)
+		duration := b.uint(n)
+		b.bytes(8)
+		layer := int(int16(b.uint(2)))
+		b.uint(2) // Alternate group
+		volume := float64(b.uint(2)) / 0x100
+		b.uint(2)
+		t.matrix = quickTimeMatrix(b.bytes(36))
+		width, height := fixed16(b.uint(4)), fixed16(b.uint(4))
+		table = "QuickTime::TrackHeader"
+		fields = []tableField{
+			{"0", "TrackHeaderVersion", version},
+			{"1", "TrackCreateDate", quickTimeTime(created)},
+			{"2", "TrackModifyDate", quickTimeTime(modified)},
+			{"3", "TrackID", int(id)},
+			{"8", "TrackLayer", layer},
+			{"9", "TrackVolume", volume},
+			{"10", "MatrixStructure", t.matrix},
+		}
+		if mov.timeScale != 0 {
+			fields = append(fields, tableField{"5", "TrackDuration", float64(duration) / float64(mov.timeScale)})
+		}
+		if width > 0 && height > 0 {
+			fields = append(fields, tableField{"19", "ImageWidth", width}, tableField{"20", "ImageHeight", height})
+		}
+
+	case "mdhd":
+		created, modified := b.uint(n), b.uint(n)
+		t.timeScale = uint32(b.uint(4))
+		duration := b.uint(n)
+		table = "QuickTime::MediaHeader"
+		fields = []tableField{
+			{"0", "MediaHeaderVersion", version},
+			{"1", "MediaCreateDate", quickTimeTime(created)},
+			{"2", "MediaModifyDate", quickTimeTime(modified)},
+			{"3", "MediaTimeScale", int(t.timeScale)},
+		}
+		if t.timeScale != 0 {
+			fields = append(fields, tableField{"4", "MediaDuration", float64(duration) / float64(t.timeScale)})
+		}
+		if lang := quickTimeLanguage(uint16(b.uint(2))); lang != "" {
+			fields = append(fields, tableField{"5", "MediaLanguageCode", lang})
+		}
+
+	case "hdlr":
+		// The component type, "mhlr" in QuickTime and 0 in MP4, the handler
+		// type, three reserved words and the name, which QuickTime stores as
+		// a Pascal string and MP4 null-terminated
+		b.uint(4)
+		t.handler = string(b.bytes(4))
+		b.bytes(12)
+		name := b.data[b.pos:]
+		if len(name) > 0 && int(name[0]) == len(name)-1 {
+			name = name[1:]
+		}
+		table = "QuickTime::Handler"
+		fields = []tableField{{"8", "HandlerType", t.handler}}
+		if s := strings.TrimRight(string(name), "\x00"); s != "" {
+			fields = append(fields, tableField{"24", "HandlerDescription", s})
+		}
+
+	case "stsd":
+		// Only the first sample description is read
+		if b.uint(4) == 0 {
+			return nil
+		}
+		table, fields = parseSampleDescription(b.data[b.pos:], t.handler)
+	}
+	if b.short {
+		sink.Warn("QuickTime %s box at offset %d is truncated", box.Type, box.Offset)
+		return nil
+	}
+	for _, f := range fields {
+		if f.value != nil {
+			quickTimeTag(sink, t.dir, table, f.id, f.name, f.value)
+		}
+	}
+	return nil
+}
+
+// quickTimeLanguage decodes a media language: an ISO 639-2/T code packed
+// as three 5-bit letters, or a Macintosh language code below 0x400
+func quickTimeLanguage(code uint16) string {
+	if code < 0x400 || code == 0x7FFF {
+		return ""
+	}
+	return string([]byte{byte(code>>10&0x1F) + 0x60, byte(code>>5&0x1F) + 0x60, byte(code&0x1F) + 0x60})
+}
+
+// parseSampleDescription decodes a video or audio sample description. The
+// QuickTime tables are indexed in 16-bit words from the start of the entry.
+func parseSampleDescription(entry []byte, handler string) (string, []tableField) {
+	be := binary.BigEndian
+	switch handler {
+	case "vide":
+		if len(entry) < 86 {
+			return "", nil
+		}
+		// A Pascal string in a 32-byte field
+		name := entry[51:82]
+		name = name[:min(int(entry[50]), len(name))]
+		return "QuickTime::VideoSampleDesc", []tableField{
+			{"2", "CompressorID", string(entry[4:8])},
+			{"16", "SourceImageWidth", int(be.Uint16(entry[32:34]))},
+			{"17", "SourceImageHeight", int(be.Uint16(entry[34:36]))},
+			{"18", "XResolution", fixed16(uint64(be.Uint32(entry[36:40])))},
+			{"20", "YResolution", fixed16(uint64(be.Uint32(entry[40:44])))},
+			{"25", "CompressorName", string(name)},
+			{"41", "BitDepth", int(be.Uint16(entry[82:84]))},
+		}
+	case "soun":
+		if len(entry) < 36 {
+			return "", nil
+		}
+		return "QuickTime::AudioSampleDesc", []tableField{
+			{"2", "AudioFormat", string(entry[4:8])},
+			{"12", "AudioChannels", int(be.Uint16(entry[24:26]))},
+			{"13", "AudioBitsPerSample", int(be.Uint16(entry[26:28]))},
+			{"16", "AudioSampleRate", float64(be.Uint32(entry[32:36])) / 0x10000},
+		}
+	}
+	return "", nil
+}
+
+// parseTimeToSample totals the sample counts and durations of an stts box
+func parseTimeToSample(r io.ReaderAt, box isoBox, t *track, sink Sink) error {
+	data, err := readBlock(r, box.Data, box.Size())
+	if err != nil {
+		return fmt.Errorf("reading stts box: %w", err)
+	}
+	b := &boxReader{data: data}
+	b.fullBox()
+	count := int(b.uint(4))
+	for i := 0; i < count && !b.short; i++ {
+		n, delta := b.uint(4), b.uint(4)
+		t.samples += n
+		t.sampleDur += n * delta
+	}
+	if b.short {
+		sink.Warn("QuickTime stts box at offset %d is truncated", box.Offset)
+	}
+	return nil
+}
+
+// parseUserData walks a udta box: text atoms whose type starts with ©,
+// each a 16-bit length and language followed by the text, and the
+// metadata box of MP4 files
+func parseUserData(r io.ReaderAt, udta isoBox, sink Sink) error {
+	return walkBoxes(r, udta.Data, udta.End, sink, func(box isoBox) error {
+		if box.Type == "meta" {
+			return parseItemMeta(r, box, sink)
+		}
+		if box.Type[0] != 0xA9 {
+			return nil
+		}
+		data, err := readBlock(r, box.Data, min(box.Size(), maxBlockSize))
+		if err != nil {
+			return fmt.Errorf("reading udta %q box: %w", box.Type, err)
+		}
+		if len(data) >= 4 {
+			if n := int(binary.BigEndian.Uint16(data[0:2])); n <= len(data)-4 {
+				data = data[4 : 4+n]
+			}
+		}
+		id := latin1.Decode([]byte(box.Type))
+		value := quickTimeText(id, strings.TrimRight(string(data), "\x00"))
+		quickTimeTag(sink, "UserData", "QuickTime::UserData", id, tagNameFor(id), value)
+		return nil
+	})
+}
+
+// parseItemMeta walks a metadata box holding an item list: iTunes-style
+// atoms in MP4 user data, or Apple's keys and the values for them. The
+// QuickTime box has no version and flags, the MP4 one does.
+func parseItemMeta(r io.ReaderAt, meta isoBox, sink Sink) error {
+	start := meta.Data
+	peek := make([]byte, 8)
+	if _, err := r.ReadAt(peek, start); err == nil && string(peek[4:8]) != "hdlr" {
+		start += 4
+	}
+	var keys []string
+	return walkBoxes(r, start, meta.End, sink, func(box isoBox) error {
+		switch box.Type {
+		case "keys":
+			data, err := readBlock(r, box.Data, box.Size())
+			if err != nil {
+				return fmt.Errorf("reading keys box: %w", err)
+			}
+			keys = parseKeys(data)
+		case "ilst":
+			return walkBoxes(r, box.Data, box.End, sink, func(item isoBox) error {
+				return parseListItem(r, item, keys, sink)
+			})
+		}
+		return nil
+	})
+}
+
+// parseKeys reads a keys box: a count, then for each key its size, a
+// namespace such as "mdta" and the key
+func parseKeys(data []byte) []string {
+	b := &boxReader{data: data}
+	b.fullBox()
+	count := int(b.uint(4))
+	var keys []string
+	for i := 0; i < count && !b.short; i++ {
+		size := int(b.uint(4))
+		b.bytes(4)
+		keys = append(keys, string(b.bytes(size-8)))
+	}
+	return keys
+}
+
+// parseListItem decodes an item of an ilst box. With keys the item's type
+// is the 1-based index of its key; otherwise it names the item, and "----"
+// items name themselves in their own mean and name boxes.
+func parseListItem(r io.ReaderAt, item isoBox, keys []string, sink Sink) error {
+	data, err := readBlock(r, item.Data, min(item.Size(), maxBlockSize))
+	if err != nil {
+		return fmt.Errorf("reading ilst item at offset %d: %w", item.Offset, err)
+	}
+	dir, table, id := "ItemList", "QuickTime::ItemList", latin1.Decode([]byte(item.Type))
+	if keys != nil {
+		index := int(binary.BigEndian.Uint32([]byte(item.Type)))
+		if index < 1 || index > len(keys) {
+			sink.Warn("QuickTime item list refers to missing key %d", index)
+			return nil
+		}
+		dir, table, id = "Keys", "QuickTime::Keys", strings.TrimPrefix(keys[index-1], quickTimeKeyPrefix)
+	}
+
+	var values []interface{}
+	walkBoxes(bytes.NewReader(data), 0, int64(len(data)), sink, func(box isoBox) error {
+		content := data[box.Data:box.End]
+		switch box.Type {
+		case "name":
+			if item.Type == "----" && len(content) > 4 {
+				table, id = "QuickTime::iTunesInfo", string(content[4:])
+			}
+		case "data":
+			if len(content) >= 8 {
+				values = append(values, quickTimeDataValue(id, binary.BigEndian.Uint32(content[0:4])&0xFFFFFF, content[8:]))
+			}
+		}
+		return nil
+	})
+	for _, value := range values {
+		quickTimeTag(sink, dir, table, id, tagNameFor(id), quickTimeText(id, value))
+	}
+	return nil
+}
+
+// quickTimeDataValue decodes the value of a data box by its well-known type
+func quickTimeDataValue(id string, dataType uint32, data []byte) interface{} {
+	switch dataType {
+	case 1: // UTF-8
+		return string(data)
+	case 2: // UTF-16
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(data[i*2:])
+		}
+		return string(utf16.Decode(units))
+	case 21, 22: // Big-endian signed or unsigned integer
+		if len(data) > 8 || len(data) == 0 {
+			break
+		}
+		v := (&boxReader{data: data}).uint(len(data))
+		if dataType == 21 {
+			shift := 64 - 8*len(data)
+			return int(int64(v<<shift) >> shift)
+		}
+		return int(v)
+	case 23:
+		if len(data) == 4 {
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+		}
+	case 24:
+		if len(data) == 8 {
+			return math.Float64frombits(binary.BigEndian.Uint64(data))
+		}
+	case 0:
+		// Track and disk numbers are stored as binary: the number, then the total
+		if (id == "trkn" || id == "disk") && len(data) >= 6 {
+			n, total := binary.BigEndian.Uint16(data[2:4]), binary.BigEndian.Uint16(data[4:6])
+			if total == 0 {
+				return strconv.Itoa(int(n))
+			}
+			return fmt.Sprintf("%d of %d", n, total)
+		}
+	}
+	return data
+}
+
+// quickTimeText converts text holding a location or date: ISO 6709
+// coordinates become latitude, longitude and any altitude, and dates a time
+func quickTimeText(id string, value interface{}) interface{} {
+	s, ok := value.(string)
+	if !ok {
+		return value
+	}
+	switch {
+	case quickTimeGPSTags[id]:
+		m := iso6709.FindStringSubmatch(s)
+		if m == nil {
+			return s
+		}
+		var coords []float64
+		for _, part := range m[1:] {
+			if part == "" {
+				continue
+			}
+			v, err := strconv.ParseFloat(part, 64)
+			if err != nil {
+				return s
+			}
+			coords = append(coords, v)
+		}
+		return coords
+	case quickTimeDateTags[id]:
+		for _, layout := range quickTimeDateLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t
+			}
+		}
+	}
+	return s
+}
@@ -0,0 +1,223 @@
+package formats
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// quickTimeTestTime is 2024-01-01 as a QuickTime time stamp
+const quickTimeTestTime = 3786912000
+
+// fixed builds 16.16 fixed-point numbers
+func fixed(values ...float64) []byte {
+	var b []byte
+	for _, v := range values {
+		b = append(b, be(int32(v*0x10000))...)
+	}
+	return b
+}
+
+// testMatrix builds a transformation matrix with the given first two rows
+func testMatrix(a, b, c, d float64) []byte {
+	return bytes.Join([][]byte{fixed(a, b), be(uint32(0)), fixed(c, d), be(uint32(0)), fixed(0, 0), be(uint32(1 << 30))}, nil)
+}
+
+// testHandler builds an hdlr box, with a QuickTime Pascal string name or
+// an MP4 null-terminated one
+func testHandler(handlerType, name string, pascal bool) []byte {
+	if pascal {
+		return testFullBox("hdlr", 0, 0, []byte("mhlr"+handlerType), make([]byte, 12), []byte{byte(len(name))}, []byte(name))
+	}
+	return testFullBox("hdlr", 0, 0, make([]byte, 4), []byte(handlerType), make([]byte, 12), []byte(name+"\x00"))
+}
+
+// testData builds a data box of an item list
+func testData(dataType uint32, value []byte) []byte {
+	return testBox("data", be(dataType, uint32(0)), value)
+}
+
+// testAudioTrack builds a trak box for 10 seconds of 44.1 kHz stereo audio
+func testAudioTrack(id uint32) []byte {
+	entry := bytes.Join([][]byte{be(uint32(36)), []byte("mp4a"), make([]byte, 6), be(uint16(1)), make([]byte, 8), be(uint16(2), uint16(16), uint32(0), uint32(44100<<16))}, nil)
+	return testBox("trak",
+		testFullBox("tkhd", 0, 3, be(uint32(quickTimeTestTime), uint32(quickTimeTestTime), id, uint32(0), uint32(6000)), make([]byte, 8), be(int16(0), int16(0), uint16(0x100), uint16(0)), testMatrix(1, 0, 0, 1), fixed(0, 0)),
+		testBox("mdia",
+			testFullBox("mdhd", 0, 0, be(uint32(quickTimeTestTime), uint32(quickTimeTestTime), uint32(44100), uint32(441000), uint16(0x15C7), uint16(0))),
+			testHandler("soun", "SoundHandler", false),
+			testBox("minf", testBox("stbl", testFullBox("stsd", 0, 0, be(uint32(1)), entry))),
+		),
+	)
+}
+
+// movTestFile builds a QuickTime movie with a rotated video track, an audio
+// track, user data text and Apple metadata keys, and a 64-bit mdat box
+func movTestFile() []byte {
+	name := make([]byte, 31)
+	copy(name, "H.264")
+	video := bytes.Join([][]byte{
+		be(uint32(86)), []byte("avc1"), make([]byte, 6), be(uint16(1)), make([]byte, 4), []byte("appl"), be(uint32(0), uint32(512), uint16(1920), uint16(1080)),
+		fixed(72, 72), be(uint32(0), uint16(1)), {5}, name, be(int16(24), int16(-1)),
+	}, nil)
+	videoTrack := testBox("trak",
+		testFullBox("tkhd", 0, 3, be(uint32(quickTimeTestTime), uint32(quickTimeTestTime), uint32(1), uint32(0), uint32(6000)), make([]byte, 8), be(int16(0), int16(0), uint16(0), uint16(0)), testMatrix(0, 1, -1, 0), fixed(1920, 1080)),
+		testBox("mdia",
+			testFullBox("mdhd", 0, 0, be(uint32(quickTimeTestTime), uint32(quickTimeTestTime), uint32(30000), uint32(300300), uint16(0x15C7), uint16(0))),
+			testHandler("vide", "Core Media Video", true),
+			testBox("minf", testBox("stbl",
+				testFullBox("stsd", 0, 0, be(uint32(1)), video),
+				testFullBox("stts", 0, 0, be(uint32(1), uint32(300), uint32(1001))),
+			)),
+		),
+	)
+
+	text := func(atomType, s string) []byte {
+		return testBox(atomType, be(uint16(len(s)), uint16(0x15C7)), []byte(s))
+	}
+	keys := []string{"com.apple.quicktime.make", "com.apple.quicktime.location.ISO6709", "com.apple.quicktime.creationdate", "com.apple.quicktime.live-photo.auto"}
+	keyBoxes := [][]byte{be(uint32(len(keys)))}
+	for _, k := range keys {
+		keyBoxes = append(keyBoxes, be(uint32(8+len(k))), []byte("mdta"+k))
+	}
+	values := [][]byte{
+		testData(1, []byte("Apple")),
+		testData(1, []byte("+48.8577+002.2950+035.000/")),
+		testData(1, []byte("2024-05-06T07:08:09-0700")),
+		testData(22, []byte{1}),
+	}
+	var items [][]byte
+	for i, v := range values {
+		items = append(items, testBox(string(be(uint32(i+1))), v))
+	}
+
+	moov := testBox("moov",
+		testFullBox("mvhd", 0, 0, be(uint32(quickTimeTestTime), uint32(quickTimeTestTime+60), uint32(600), uint32(6000)), fixed(1), be(uint16(0x100)), make([]byte, 10), testMatrix(1, 0, 0, 1), make([]byte, 24), be(uint32(3))),
+		videoTrack,
+		testAudioTrack(2),
+		testBox("udta", text("\xA9xyz", "+37.3318-122.0312+012.345/"), text("\xA9day", "2024-01-02T03:04:05+0100"), text("\xA9mak", "Apple")),
+		testBox("meta", testHandler("mdta", "", false), testFullBox("keys", 0, 0, keyBoxes...), testBox("ilst", items...)),
+	)
+	media := make([]byte, 125000)
+	mdat := bytes.Join([][]byte{be(uint32(1)), []byte("mdat"), be(uint64(16 + len(media))), media}, nil)
+	return bytes.Join([][]byte{testBox("ftyp", []byte("qt  "), be(uint32(0x20050300)), []byte("qt  ")), mdat, moov}, nil)
+}
+
+// mp4TestFile builds an MP4 audio file with iTunes metadata and a version 1
+// movie header
+func mp4TestFile() []byte {
+	ilst := testBox("ilst",
+		testBox("\xA9nam", testData(1, []byte("Song Title"))),
+		testBox("trkn", testData(0, be(uint16(0), uint16(3), uint16(12), uint16(0)))),
+		testBox("tmpo", testData(21, be(int16(-120)))),
+		testBox("----", testFullBox("mean", 0, 0, []byte("com.apple.iTunes")), testFullBox("name", 0, 0, []byte("iTunSMPB")), testData(1, []byte(" 00000000 00000840"))),
+	)
+	moov := testBox("moov",
+		testFullBox("mvhd", 1, 0, be(uint64(quickTimeTestTime), uint64(quickTimeTestTime), uint32(1000), uint64(200000)), fixed(1), be(uint16(0x100)), make([]byte, 10), testMatrix(1, 0, 0, 1), make([]byte, 24), be(uint32(2))),
+		testAudioTrack(1),
+		testBox("udta", testFullBox("meta", 0, 0, testHandler("mdir", "", false), ilst)),
+	)
+	return bytes.Join([][]byte{testBox("ftyp", []byte("M4A \x00\x00\x00\x00M4A isom")), moov, testBox("mdat", make([]byte, 1000))}, nil)
+}
+
+func TestQuickTime(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		file []byte
+		tags []tagCheck
+	}{
+		{
+			"mov", movTestFile(),
+			[]tagCheck{
+				{"QuickTime::FileType", "MajorBrand", "qt  "},
+				{"QuickTime::FileType", "MinorVersion", "2005.3.0"},
+				{"QuickTime::MovieHeader", "CreateDate", created},
+				{"QuickTime::MovieHeader", "ModifyDate", created.Add(time.Minute)},
+				{"QuickTime::MovieHeader", "Duration", 10.0},
+				{"QuickTime::MovieHeader", "PreferredVolume", 1.0},
+				{"QuickTime::MovieHeader", "NextTrackID", 3},
+				{"QuickTime::TrackHeader", "TrackID", 1},
+				{"QuickTime::TrackHeader", "TrackID", 2},
+				{"QuickTime::TrackHeader", "ImageWidth", 1920.0},
+				{"QuickTime::TrackHeader", "TrackVolume", 1.0},
+				{"QuickTime::MediaHeader", "MediaTimeScale", 30000},
+				{"QuickTime::MediaHeader", "MediaLanguageCode", "eng"},
+				{"QuickTime::Handler", "HandlerType", "vide"},
+				{"QuickTime::Handler", "HandlerDescription", "Core Media Video"},
+				{"QuickTime::Handler", "HandlerDescription", "SoundHandler"},
+				{"QuickTime::VideoSampleDesc", "CompressorID", "avc1"},
+				{"QuickTime::VideoSampleDesc", "SourceImageWidth", 1920},
+				{"QuickTime::VideoSampleDesc", "CompressorName", "H.264"},
+				{"QuickTime::VideoSampleDesc", "BitDepth", 24},
+				{"QuickTime::AudioSampleDesc", "AudioChannels", 2},
+				{"QuickTime::AudioSampleDesc", "AudioSampleRate", 44100.0},
+				{"", "VideoFrameRate", 29.97},
+				{"", "Rotation", 90},
+				{"", "AvgBitrate", 100000},
+				{"QuickTime::UserData", "Xyz", []float64{37.3318, -122.0312, 12.345}},
+				{"QuickTime::UserData", "Day", time.Date(2024, 1, 2, 2, 4, 5, 0, time.UTC)},
+				{"QuickTime::UserData", "Mak", "Apple"},
+				{"QuickTime::Keys", "Make", "Apple"},
+				{"QuickTime::Keys", "LocationISO6709", []float64{48.8577, 2.295, 35}},
+				{"QuickTime::Keys", "Creationdate", time.Date(2024, 5, 6, 14, 8, 9, 0, time.UTC)},
+				{"QuickTime::Keys", "Live-photoAuto", 1},
+			},
+		},
+		{
+			"mp4", mp4TestFile(),
+			[]tagCheck{
+				{"QuickTime::FileType", "CompatibleBrands", []string{"M4A ", "isom"}},
+				{"QuickTime::MovieHeader", "MovieHeaderVersion", 1},
+				{"QuickTime::MovieHeader", "CreateDate", created},
+				{"QuickTime::MovieHeader", "Duration", 200.0},
+				{"QuickTime::MediaHeader", "MediaDuration", 10.0},
+				{"QuickTime::ItemList", "Nam", "Song Title"},
+				{"QuickTime::ItemList", "Trkn", "3 of 12"},
+				{"QuickTime::ItemList", "Tmpo", -120},
+				{"QuickTime::iTunesInfo", "ITunSMPB", " 00000000 00000840"},
+				{"", "AvgBitrate", 40},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := parseTest(t, quickTimeHandler{}, tt.file)
+			sink.checkTags(t, tt.tags)
+			if len(sink.warnings) > 0 {
+				t.Errorf("warnings: %q", sink.warnings)
+			}
+		})
+	}
+}
+
+// A udta text atom whose 16-bit length is near the maximum, in an atom big
+// enough to pass the length check, once made the slice bounds wrap around
+func TestUserDataLongText(t *testing.T) {
+	text := strings.Repeat("a", 0x10000)
+	atom := testBox("\xA9nam", be(uint16(0xFFFF), uint16(0)), []byte(text))
+	file := append(testBox("ftyp", []byte("qt  "), be(uint32(0))), testBox("moov", testBox("udta", atom))...)
+
+	sink := parseTest(t, quickTimeHandler{}, file)
+	sink.checkTags(t, []tagCheck{
+		{"QuickTime::UserData", "Nam", text[:0xFFFF]},
+	})
+}
+
+func TestQuickTimeTime(t *testing.T) {
+	tests := []struct {
+		secs uint64
+		want interface{}
+	}{
+		{0, nil},
+		{3786912000, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		// Version 1 boxes have 64-bit time stamps, once wrapped around as a time.Duration
+		{1 << 40, nil},
+		{1<<64 - 1, nil},
+	}
+	for _, tt := range tests {
+		if got := quickTimeTime(tt.secs); got != tt.want {
+			t.Errorf("quickTimeTime(%d) = %v, want %v", tt.secs, got, tt.want)
+		}
+	}
+}
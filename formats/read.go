@@ -0,0 +1,45 @@
+package formats
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+)
+
+// maxBlockSize caps how much of a single segment or chunk is held in memory
+const maxBlockSize = 16 << 20
+
+// readBlock reads exactly n bytes at off, refusing blocks larger than maxBlockSize
+func readBlock(r io.ReaderAt, off int64, n int64) ([]byte, error) {
+	if n < 0 || n > maxBlockSize {
+		return nil, fmt.Errorf("block of %d bytes at offset %d exceeds limit", n, off)
+	}
+	buf := make([]byte, n)
+	read, err := r.ReadAt(buf, off)
+	if read == len(buf) {
+		// ReadAt may report io.EOF alongside a complete read at the end of the file
+		return buf, nil
+	}
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return nil, err
+}
+
+// inflate decompresses zlib data, refusing output larger than maxBlockSize
+func inflate(data []byte) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	out, err := io.ReadAll(io.LimitReader(zr, maxBlockSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > maxBlockSize {
+		return nil, fmt.Errorf("decompressed data exceeds %d bytes", maxBlockSize)
+	}
+	return out, nil
+}
@@ -0,0 +1,61 @@
+package formats
+
+import (
+	"strings"
+	"sync"
+
+	"greg-hacke/go-metadata/tags"
+)
+
+var (
+	registryMu sync.RWMutex
+	handlers   = make(map[string]Handler)
+	order      []string // Registration order, used when sniffing
+)
+
+// Register makes a handler available for a file type. name is an ExifTool
+// FileType or module name such as "JPEG" or "TIFF"; names are matched
+// without regard to case. Registering a name again replaces its handler,
+// so built-in handlers can be overridden.
+func Register(name string, h Handler) {
+	if h == nil {
+		panic("formats: Register handler is nil")
+	}
+	key := strings.ToUpper(name)
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, exists := handlers[key]; !exists {
+		order = append(order, key)
+	}
+	handlers[key] = h
+}
+
+// Lookup returns the handler registered for a FileType, module or
+// extension name. Names without a handler of their own are resolved through
+// tags.ExifToolFileTypes, so "NEF" finds the handler for "TIFF".
+func Lookup(name string) Handler {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	seen := make(map[string]bool)
+	for key := strings.ToUpper(name); key != "" && !seen[key]; {
+		seen[key] = true
+		if h, ok := handlers[key]; ok {
+			return h
+		}
+		if module := tags.ExifToolFileTypes.ModuleNames[key]; module != "" && module != "0" && !seen[strings.ToUpper(module)] {
+			key = strings.ToUpper(module)
+			continue
+		}
+		key = strings.ToUpper(tags.ExifToolFileTypes.Extensions[key].Type)
+	}
+	return nil
+}
+
+// Names returns the registered names in registration order
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return append([]string(nil), order...)
+}
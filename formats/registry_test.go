@@ -0,0 +1,43 @@
+package formats
+
+import "testing"
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name string
+		want Handler
+	}{
+		{"JPEG", jpegHandler{}},
+		{"jpeg", jpegHandler{}},
+		{"TIFF", tiffHandler{}},
+		{"NEF", tiffHandler{}}, // An extension resolved to its file type
+		{"CR2", tiffHandler{}},
+		{"TXT", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := Lookup(tt.name); got != tt.want {
+			t.Errorf("Lookup(%q) = %T, want %T", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRegisterReplaces(t *testing.T) {
+	defer Register("TIFF", tiffHandler{})
+	before := len(Names())
+	Register("tiff", pngHandler{})
+	if got := Lookup("NEF"); got != (pngHandler{}) {
+		t.Errorf("Lookup(NEF) after replacing TIFF = %T", got)
+	}
+	if len(Names()) != before {
+		t.Errorf("Names grew from %d to %d on replacing a handler", before, len(Names()))
+	}
+}
+
+func TestTIFFHandler(t *testing.T) {
+	sink := parseTest(t, tiffHandler{}, testTIFF)
+	sink.checkBlocks(t, testBlock{BlockTIFF, testTIFF})
+	if (tiffHandler{}).Sniff([]byte("MM\x2A\x00")) {
+		t.Error("Sniff accepted a TIFF header with the wrong byte order for its magic number")
+	}
+}
@@ -0,0 +1,175 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+func init() {
+	Register("RIFF", riffHandler{})
+}
+
+// riffHandler walks the chunks of a RIFF file. WebP is the form decoded;
+// the chunks of other forms are skipped.
+type riffHandler struct{}
+
+// Sniff reports whether header starts a RIFF file
+func (riffHandler) Sniff(header []byte) bool {
+	return len(header) >= 12 && string(header[0:4]) == "RIFF"
+}
+
+// Parse records the image size and flags of a WebP file from its VP8,
+// VP8L and VP8X chunks, the animation parameters and frames, and passes
+// the EXIF, XMP and ICC chunks to sink
+func (riffHandler) Parse(r io.ReaderAt, size int64, sink Sink) error {
+	header := make([]byte, 12)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return fmt.Errorf("reading RIFF header: %w", err)
+	}
+	form := string(header[8:12])
+	sink.Segment(0, "RIFF", fmt.Sprintf("%q form", form))
+	if form != "WEBP" {
+		return nil
+	}
+
+	// The RIFF size counts from the form type
+	end := int64(binary.LittleEndian.Uint32(header[4:8])) + 8
+	if end > size {
+		sink.Warn("RIFF data runs past the end of the file")
+		end = size
+	}
+
+	offset := int64(12)
+	chunkHeader := make([]byte, 8)
+	frames, duration := 0, 0 // Frame durations are in milliseconds
+	for offset+8 <= end {
+		if _, err := r.ReadAt(chunkHeader, offset); err != nil {
+			return fmt.Errorf("reading RIFF chunk at offset %d: %w", offset, err)
+		}
+		chunkType := string(chunkHeader[0:4])
+		chunkLen := int64(binary.LittleEndian.Uint32(chunkHeader[4:8]))
+		dataOffset := offset + 8
+		if dataOffset+chunkLen > end {
+			sink.Warn("RIFF %s chunk at offset %d runs past the end of the file", chunkType, offset)
+			return nil
+		}
+		sink.Segment(offset, chunkType, fmt.Sprintf("%d bytes", chunkLen))
+
+		switch chunkType {
+		case "VP8 ", "VP8L", "VP8X", "ANIM", "ANMF":
+			// Only the headers are needed, not the image data
+			data, err := readBlock(r, dataOffset, min(chunkLen, 16))
+			if err != nil {
+				return fmt.Errorf("reading RIFF %s chunk at offset %d: %w", chunkType, offset, err)
+			}
+			if chunkType == "ANMF" {
+				// The frame position and size, then a 24-bit duration
+				if len(data) >= 15 {
+					frames++
+					duration += int(data[12]) | int(data[13])<<8 | int(data[14])<<16
+				}
+			} else {
+				parseWebPChunk(chunkType, data, offset, sink)
+			}
+
+		case "EXIF":
+			if !sink.Wants("EXIF", "") {
+				break
+			}
+			// The WebP specification has the TIFF header first, but some
+			// writers keep the JPEG APP1 identifier
+			start, n := dataOffset, chunkLen
+			prefix := make([]byte, len(exifNamespace))
+			if n >= int64(len(prefix)) {
+				if _, err := r.ReadAt(prefix, start); err == nil && string(prefix) == exifNamespace {
+					start, n = start+int64(len(prefix)), n-int64(len(prefix))
+				}
+			}
+			sink.Block(BlockTIFF, r, start, n)
+
+		case "XMP ":
+			sink.Block(BlockXMP, r, dataOffset, chunkLen)
+
+		case "ICCP":
+			sink.Block(BlockICC, r, dataOffset, chunkLen)
+		}
+
+		offset = dataOffset + chunkLen + chunkLen&1 // Chunks are padded to an even length
+	}
+
+	if frames > 0 {
+		sink.Tag(Tag{Group: "RIFF", Name: "FrameCount", Value: frames})
+		sink.Tag(Tag{Group: "RIFF", Name: "Duration", Value: float64(duration) / 1000})
+	}
+	return nil
+}
+
+// parseWebPChunk records the values of a WebP header chunk. Each chunk type
+// has its own binary table in RIFF, indexed by byte offset in the chunk.
+func parseWebPChunk(chunkType string, data []byte, offset int64, sink Sink) {
+	var table string
+	var fields []tableField
+	switch chunkType {
+	case "VP8 ":
+		// A frame tag with the version in bits 1-3, a start code, then
+		// 14-bit dimensions each with a 2-bit scale
+		if len(data) < 10 || !bytes.Equal(data[3:6], []byte{0x9D, 0x01, 0x2A}) {
+			sink.Warn("Invalid WebP VP8 chunk at offset %d", offset)
+			return
+		}
+		w, h := binary.LittleEndian.Uint16(data[6:8]), binary.LittleEndian.Uint16(data[8:10])
+		table = "RIFF::VP8"
+		fields = []tableField{
+			{"0", "VP8Version", int(data[0] >> 1 & 0x07)},
+			{"6", "ImageWidth", int(w & 0x3FFF)},
+			{"6.1", "HorizontalScale", int(w >> 14)},
+			{"8", "ImageHeight", int(h & 0x3FFF)},
+			{"8.1", "VerticalScale", int(h >> 14)},
+		}
+
+	case "VP8L":
+		// A signature byte, then the width and height less one in 14 bits
+		// each, the alpha flag and a 3-bit version
+		if len(data) < 5 || data[0] != 0x2F {
+			sink.Warn("Invalid WebP VP8L chunk at offset %d", offset)
+			return
+		}
+		bits := binary.LittleEndian.Uint32(data[1:5])
+		table = "RIFF::VP8L"
+		fields = []tableField{
+			{"1", "ImageWidth", int(bits&0x3FFF) + 1},
+			{"2", "ImageHeight", int(bits>>14&0x3FFF) + 1},
+			{"4", "AlphaIsUsed", int(bits >> 28 & 1)},
+		}
+
+	case "VP8X":
+		// Feature flags, then the canvas width and height less one in 24 bits each
+		if len(data) < 10 {
+			sink.Warn("Invalid WebP VP8X chunk at offset %d", offset)
+			return
+		}
+		table = "RIFF::VP8X"
+		fields = []tableField{
+			{"0", "WebP_Flags", int(binary.LittleEndian.Uint32(data[0:4]))},
+			{"4", "ImageWidth", int(data[4]) | int(data[5])<<8 | int(data[6])<<16 + 1},
+			{"6", "ImageHeight", int(data[7]) | int(data[8])<<8 | int(data[9])<<16 + 1},
+		}
+
+	case "ANIM":
+		// The background color, stored as blue, green, red and alpha, and
+		// the number of times to loop, 0 for ever
+		if len(data) < 6 {
+			return
+		}
+		table = "RIFF::ANIM"
+		fields = []tableField{
+			{"0", "BackgroundColor", []int{int(data[2]), int(data[1]), int(data[0]), int(data[3])}},
+			{"4", "AnimationLoopCount", int(binary.LittleEndian.Uint16(data[4:6]))},
+		}
+	}
+	for _, f := range fields {
+		sink.Tag(Tag{Group: "RIFF", Table: table, ID: f.id, Name: f.name, Value: f.value})
+	}
+}
@@ -0,0 +1,158 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// webPChunk builds a RIFF chunk, padded to an even length
+func webPChunk(chunkType string, parts ...[]byte) []byte {
+	data := bytes.Join(parts, nil)
+	chunk := binary.LittleEndian.AppendUint32([]byte(chunkType), uint32(len(data)))
+	chunk = append(chunk, data...)
+	if len(data)%2 != 0 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+// webPTestFile builds a WebP file from its chunks
+func webPTestFile(chunks ...[]byte) []byte {
+	body := append([]byte("WEBP"), bytes.Join(chunks, nil)...)
+	return append(binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(len(body))), body...)
+}
+
+// u24 encodes a 24-bit little-endian number
+func u24(v int) []byte {
+	return []byte{byte(v), byte(v >> 8), byte(v >> 16)}
+}
+
+// webPAnimated builds an extended WebP with an ICC profile, two frames, and
+// EXIF with the JPEG APP1 identifier some writers keep
+func webPAnimated() []byte {
+	frame := func(ms int) []byte {
+		return webPChunk("ANMF", u24(0), u24(0), u24(639), u24(479), u24(ms), []byte{0},
+			webPChunk("VP8L", []byte{0x2F, 0, 0, 0, 0, 0, 0, 0, 0}))
+	}
+	return webPTestFile(
+		webPChunk("VP8X", []byte{0x3E, 0, 0, 0}, u24(639), u24(479)),
+		webPChunk("ICCP", []byte("icc profile")),
+		webPChunk("ANIM", []byte{10, 20, 30, 255, 3, 0}),
+		frame(100), frame(250),
+		webPChunk("EXIF", []byte(exifNamespace), testTIFF),
+		webPChunk("XMP ", testXMP),
+	)
+}
+
+func TestWebP(t *testing.T) {
+	lossless := binary.LittleEndian.AppendUint32([]byte{0x2F}, 99|49<<14|1<<28)
+	lossy := append([]byte{0x10, 0, 0, 0x9D, 0x01, 0x2A}, binary.LittleEndian.AppendUint16(binary.LittleEndian.AppendUint16(nil, 320), 240|1<<14)...)
+
+	tests := []struct {
+		name   string
+		file   []byte
+		tags   []tagCheck
+		blocks []testBlock
+	}{
+		{
+			"animated", webPAnimated(),
+			[]tagCheck{
+				{"RIFF::VP8X", "WebP_Flags", 0x3E},
+				{"RIFF::VP8X", "ImageWidth", 640},
+				{"RIFF::VP8X", "ImageHeight", 480},
+				{"RIFF::ANIM", "BackgroundColor", []int{30, 20, 10, 255}},
+				{"RIFF::ANIM", "AnimationLoopCount", 3},
+				{"", "FrameCount", 2},
+				{"", "Duration", 0.35},
+			},
+			[]testBlock{{BlockICC, []byte("icc profile")}, {BlockTIFF, testTIFF}, {BlockXMP, testXMP}},
+		},
+		{
+			"lossless", webPTestFile(webPChunk("VP8L", lossless, make([]byte, 4))),
+			[]tagCheck{
+				{"RIFF::VP8L", "ImageWidth", 100},
+				{"RIFF::VP8L", "ImageHeight", 50},
+				{"RIFF::VP8L", "AlphaIsUsed", 1},
+			},
+			nil,
+		},
+		{
+			"lossy", webPTestFile(webPChunk("VP8 ", lossy, make([]byte, 7)), webPChunk("EXIF", testTIFF)),
+			[]tagCheck{
+				{"RIFF::VP8", "VP8Version", 0},
+				{"RIFF::VP8", "ImageWidth", 320},
+				{"RIFF::VP8", "HorizontalScale", 0},
+				{"RIFF::VP8", "ImageHeight", 240},
+				{"RIFF::VP8", "VerticalScale", 1},
+			},
+			[]testBlock{{BlockTIFF, testTIFF}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := parseTest(t, riffHandler{}, tt.file)
+			sink.checkTags(t, tt.tags)
+			sink.checkBlocks(t, tt.blocks...)
+		})
+	}
+}
+
+func TestWebPChunks(t *testing.T) {
+	vp8x := webPChunk("VP8X", []byte{0x08, 0, 0, 0}, u24(0), u24(0))
+	tests := []struct {
+		name    string
+		file    []byte
+		tags    []tagCheck
+		blocks  []testBlock
+		warning string
+	}{
+		{
+			// As the WebP specification has it, unlike webPAnimated
+			"VP8X EXIF without header",
+			webPTestFile(vp8x, webPChunk("EXIF", testTIFF)),
+			[]tagCheck{{"RIFF::VP8X", "WebP_Flags", 0x08}, {"RIFF::VP8X", "ImageWidth", 1}},
+			[]testBlock{{BlockTIFF, testTIFF}}, "",
+		},
+		{
+			"odd chunk padding",
+			webPTestFile(webPChunk("ICCP", []byte("odd")), webPChunk("XMP ", testXMP)),
+			nil, []testBlock{{BlockICC, []byte("odd")}, {BlockXMP, testXMP}}, "",
+		},
+		{
+			"bad VP8 start code",
+			webPTestFile(webPChunk("VP8 ", make([]byte, 10))),
+			nil, nil, "Invalid WebP VP8 chunk at offset 12",
+		},
+		{
+			"RIFF size past the end",
+			webPTestFile(vp8x)[:len(vp8x)+8],
+			nil, nil, "RIFF data runs past the end of the file",
+		},
+		{
+			"other RIFF forms",
+			append(binary.LittleEndian.AppendUint32([]byte("RIFF"), 4+uint32(len(vp8x))), append([]byte("AVI "), vp8x...)...),
+			nil, nil, "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := parseTest(t, riffHandler{}, tt.file)
+			sink.checkTags(t, tt.tags)
+			sink.checkBlocks(t, tt.blocks...)
+			if tt.warning != "" {
+				sink.checkWarning(t, tt.warning)
+			}
+			if tt.tags == nil && len(sink.tags) > 0 {
+				t.Errorf("unexpected tags: %v", sink.tags)
+			}
+		})
+	}
+}
+
+func FuzzRIFF(f *testing.F) {
+	f.Add(webPAnimated())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzParse(riffHandler{}, data)
+	})
+}
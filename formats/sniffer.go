@@ -0,0 +1,14 @@
+package formats
+
+// Sniff returns the name and handler of the first registered format whose
+// handler recognizes header, or "" and nil if none does
+func Sniff(header []byte) (string, Handler) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	for _, name := range order {
+		if h := handlers[name]; h.Sniff(header) {
+			return name, h
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,30 @@
+package formats
+
+import "io"
+
+func init() {
+	Register("TIFF", tiffHandler{})
+}
+
+// tiffHandler passes TIFF-based files, including most camera RAW formats,
+// to the TIFF decoder as a single block
+type tiffHandler struct{}
+
+// Sniff reports whether header starts with a TIFF byte-order mark and magic number
+func (tiffHandler) Sniff(header []byte) bool {
+	return IsTIFFHeader(header)
+}
+
+// Parse hands the whole file to the TIFF decoder
+func (tiffHandler) Parse(r io.ReaderAt, size int64, sink Sink) error {
+	sink.Segment(0, "TIFF", "TIFF structure")
+	sink.Block(BlockTIFF, r, 0, size)
+	return nil
+}
+
+// IsTIFFHeader reports whether data starts with a TIFF byte-order mark and magic number
+func IsTIFFHeader(data []byte) bool {
+	return len(data) >= 4 &&
+		((data[0] == 'I' && data[1] == 'I' && data[2] == 42 && data[3] == 0) ||
+			(data[0] == 'M' && data[1] == 'M' && data[2] == 0 && data[3] == 42))
+}
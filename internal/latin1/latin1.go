@@ -0,0 +1,24 @@
+// Package latin1 decodes ISO 8859-1 text, the character set ExifTool
+// assumes for IPTC, PNG text and QuickTime atom types when nothing says
+// otherwise.
+package latin1
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Decode returns data as a UTF-8 string, each byte becoming the rune with
+// the same value
+func Decode(data []byte) string {
+	var b strings.Builder
+	b.Grow(len(data) + len(data)/2)
+	for _, c := range data {
+		if c < utf8.RuneSelf {
+			b.WriteByte(c)
+		} else {
+			b.WriteRune(rune(c))
+		}
+	}
+	return b.String()
+}
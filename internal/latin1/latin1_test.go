@@ -0,0 +1,19 @@
+package latin1
+
+import "testing"
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		data, want string
+	}{
+		{"", ""},
+		{"plain", "plain"},
+		{"a\xe9\x00\xff", "aé\x00ÿ"},
+		{"\xa9day", "©day"},
+	}
+	for _, tt := range tests {
+		if got := Decode([]byte(tt.data)); got != tt.want {
+			t.Errorf("Decode(%q) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package perlre
+
+import (
+	"math"
+	"math/bits"
+)
+
+// Span returns how many bytes from the start of the data a match can
+// examine. bounded is false when that depends on the length of the data:
+// for unanchored patterns, unlimited repeats, end-of-text assertions and
+// backreferences.
+func (r *Regexp) Span() (n int, bounded bool) {
+	if !r.anchored {
+		return 0, false
+	}
+	_, reach, ok := extent(r.tree)
+	return reach, ok
+}
+
+// extent returns the most bytes n can consume and the furthest offset from
+// its start it can examine, or ok false if either is unbounded
+func extent(n *node) (consume, reach int, ok bool) {
+	switch n.op {
+	case opEmpty, opBeginText, opBeginLine, opLookbehind:
+		return 0, 0, true
+	case opClass:
+		return 1, 1, true
+	case opWordBoundary, opNoWordBoundary:
+		// Looks at the byte after the current position
+		return 0, 1, true
+	case opEndText, opEndTextNewline, opEndLine, opBackref:
+		return 0, 0, false
+	case opCapture, opAtomic:
+		return extent(n.subs[0])
+	case opLookahead:
+		_, reach, ok := extent(n.subs[0])
+		return 0, reach, ok
+	case opConcat:
+		for _, sub := range n.subs {
+			c, r, ok := extent(sub)
+			if !ok {
+				return 0, 0, false
+			}
+			reach = max(reach, consume+r)
+			consume += c
+		}
+		return consume, reach, true
+	case opAlternate:
+		for _, sub := range n.subs {
+			c, r, ok := extent(sub)
+			if !ok {
+				return 0, 0, false
+			}
+			consume, reach = max(consume, c), max(reach, r)
+		}
+		return consume, reach, true
+	case opRepeat:
+		c, r, ok := extent(n.subs[0])
+		if !ok {
+			return 0, 0, false
+		}
+		if n.max < 0 {
+			if c > 0 {
+				return 0, 0, false
+			}
+			return 0, r, true
+		}
+		if n.max == 0 {
+			return 0, 0, true
+		}
+		return c * n.max, c*(n.max-1) + r, true
+	}
+	return 0, 0, false
+}
+
+// Specificity returns the number of bits of information that any match
+// must find in the data: a fixed byte counts 8 bits, a class of k bytes
+// log2(256/k) bits, and optional parts nothing. Patterns with a low
+// specificity match many unrelated files.
+func (r *Regexp) Specificity() float64 {
+	return specificity(r.tree)
+}
+
+// specificity returns the least information any match of n requires
+func specificity(n *node) float64 {
+	switch n.op {
+	case opClass:
+		count := 0
+		for _, word := range n.set {
+			count += bits.OnesCount64(word)
+		}
+		if count == 0 {
+			return math.Inf(1)
+		}
+		return math.Log2(256 / float64(count))
+	case opConcat:
+		total := 0.0
+		for _, sub := range n.subs {
+			total += specificity(sub)
+		}
+		return total
+	case opAlternate:
+		least := math.Inf(1)
+		for _, sub := range n.subs {
+			least = math.Min(least, specificity(sub))
+		}
+		return least
+	case opRepeat:
+		return float64(n.min) * specificity(n.subs[0])
+	case opCapture, opAtomic:
+		return specificity(n.subs[0])
+	case opLookahead, opLookbehind:
+		if n.neg {
+			return 0
+		}
+		return specificity(n.subs[0])
+	}
+	return 0
+}
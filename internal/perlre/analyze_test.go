@@ -0,0 +1,54 @@
+package perlre
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSpan(t *testing.T) {
+	tests := []struct {
+		expr    string
+		n       int
+		bounded bool
+	}{
+		{`^\xff\xd8\xff`, 3, true},
+		{`(?s)^.{4}(ftyp|moov)`, 8, true},
+		{`(?s)^(.{10}|.{522})(\x11\x01|\x00\x11)`, 524, true},
+		{`^a{2,5}b?`, 6, true},
+		{`^(?=.{8}x)`, 9, true},
+		{`^\bA`, 1, true},
+		{`^a*`, 0, false},
+		{`^a*b`, 0, false},
+		{`^a$`, 0, false},
+		{`^(a)\1`, 0, false},
+		{`abc`, 0, false}, // Unanchored
+	}
+	for _, tt := range tests {
+		n, bounded := MustCompile(tt.expr).Span()
+		if n != tt.n || bounded != tt.bounded {
+			t.Errorf("%q Span = %d, %t, want %d, %t", tt.expr, n, bounded, tt.n, tt.bounded)
+		}
+	}
+}
+
+func TestSpecificity(t *testing.T) {
+	tests := []struct {
+		expr string
+		bits float64
+	}{
+		{`^\xff\xd8\xff`, 24},
+		{`(?s)^.{4}ftyp`, 32},
+		{`^[\x01\x02]`, 7},
+		{`^(II|MM)`, 16}, // The least of the alternatives
+		{`^(abc|d)`, 8},
+		{`^ab?c*`, 8},
+		{`^a{3,}`, 24},
+		{`^(?!x)a`, 8},
+		{`^(?=ab)`, 16},
+	}
+	for _, tt := range tests {
+		if got := MustCompile(tt.expr).Specificity(); math.Abs(got-tt.bits) > 1e-9 {
+			t.Errorf("%q Specificity = %g, want %g", tt.expr, got, tt.bits)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package perlre
+
+// maxSteps bounds the work done by one backtracking match, so that
+// pathological patterns fail rather than hang
+const maxSteps = 1 << 20
+
+// maxLookbehind bounds how far back a variable-length lookbehind may start
+const maxLookbehind = 255
+
+// matcher runs a node tree over data by backtracking
+type matcher struct {
+	data  []byte
+	caps  []int // Start and end of each capturing group, -1 when unset
+	steps int
+}
+
+// errBudget is raised to abandon a match that has taken too many steps
+type errBudget struct{}
+
+// run reports whether the tree matches data at or after any position. It
+// returns false with exhausted set if the step budget ran out.
+func (m *matcher) run(tree *node, anchored bool) (matched, exhausted bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(errBudget); !ok {
+				panic(r)
+			}
+			matched, exhausted = false, true
+		}
+	}()
+
+	for start := 0; start <= len(m.data); start++ {
+		for i := range m.caps {
+			m.caps[i] = -1
+		}
+		if m.match(tree, start, func(int) bool { return true }) {
+			return true, false
+		}
+		if anchored {
+			break
+		}
+	}
+	return false, false
+}
+
+// match tries n at pos, calling k with each position where n can end until k accepts
+func (m *matcher) match(n *node, pos int, k func(int) bool) bool {
+	m.steps++
+	if m.steps > maxSteps {
+		panic(errBudget{})
+	}
+
+	switch n.op {
+	case opEmpty:
+		return k(pos)
+
+	case opClass:
+		return pos < len(m.data) && n.set.has(m.data[pos]) && k(pos+1)
+
+	case opConcat:
+		return m.concat(n.subs, pos, k)
+
+	case opAlternate:
+		for _, sub := range n.subs {
+			if m.match(sub, pos, k) {
+				return true
+			}
+		}
+		return false
+
+	case opCapture:
+		i := n.index * 2
+		oldStart, oldEnd := m.caps[i], m.caps[i+1]
+		if m.match(n.subs[0], pos, func(end int) bool {
+			prevStart, prevEnd := m.caps[i], m.caps[i+1]
+			m.caps[i], m.caps[i+1] = pos, end
+			if k(end) {
+				return true
+			}
+			m.caps[i], m.caps[i+1] = prevStart, prevEnd
+			return false
+		}) {
+			return true
+		}
+		m.caps[i], m.caps[i+1] = oldStart, oldEnd
+		return false
+
+	case opRepeat:
+		if n.subs[0].op == opClass {
+			return m.repeatClass(n, pos, k)
+		}
+		return m.repeat(n, 0, pos, k)
+
+	case opBeginText:
+		return pos == 0 && k(pos)
+	case opBeginLine:
+		return (pos == 0 || (m.data[pos-1] == '\n' && pos < len(m.data))) && k(pos)
+	case opEndText:
+		return pos == len(m.data) && k(pos)
+	case opEndTextNewline:
+		return (pos == len(m.data) || (pos == len(m.data)-1 && m.data[pos] == '\n')) && k(pos)
+	case opEndLine:
+		return (pos == len(m.data) || m.data[pos] == '\n') && k(pos)
+	case opWordBoundary, opNoWordBoundary:
+		before := pos > 0 && isWordByte(m.data[pos-1])
+		after := pos < len(m.data) && isWordByte(m.data[pos])
+		return (before != after) == (n.op == opWordBoundary) && k(pos)
+
+	case opLookahead:
+		found := m.match(n.subs[0], pos, func(int) bool { return true })
+		return found != n.neg && k(pos)
+
+	case opLookbehind:
+		found := false
+		for start := pos; start >= 0 && start >= pos-maxLookbehind && !found; start-- {
+			found = m.match(n.subs[0], start, func(end int) bool { return end == pos })
+		}
+		return found != n.neg && k(pos)
+
+	case opAtomic:
+		// Take the first way the group can match and never backtrack into it
+		end := -1
+		if !m.match(n.subs[0], pos, func(e int) bool { end = e; return true }) {
+			return false
+		}
+		return k(end)
+
+	case opBackref:
+		i := n.index * 2
+		start, end := m.caps[i], m.caps[i+1]
+		if start < 0 {
+			// Perl fails a reference to a group that hasn't matched
+			return false
+		}
+		length := end - start
+		if pos+length > len(m.data) {
+			return false
+		}
+		for j := 0; j < length; j++ {
+			a, b := m.data[start+j], m.data[pos+j]
+			if n.fold {
+				a, b = lowerASCII(a), lowerASCII(b)
+			}
+			if a != b {
+				return false
+			}
+		}
+		return k(pos + length)
+	}
+	return false
+}
+
+// concat matches subs in sequence
+func (m *matcher) concat(subs []*node, pos int, k func(int) bool) bool {
+	if len(subs) == 0 {
+		return k(pos)
+	}
+	return m.match(subs[0], pos, func(next int) bool {
+		return m.concat(subs[1:], next, k)
+	})
+}
+
+// repeatClass matches a repeated single-byte class without recursing per byte
+func (m *matcher) repeatClass(n *node, pos int, k func(int) bool) bool {
+	set := &n.subs[0].set
+	run := 0
+	for pos+run < len(m.data) && (n.max < 0 || run < n.max) && set.has(m.data[pos+run]) {
+		run++
+	}
+	if run < n.min {
+		return false
+	}
+	if n.greedy {
+		for count := run; count >= n.min; count-- {
+			m.steps++
+			if k(pos + count) {
+				return true
+			}
+		}
+		return false
+	}
+	for count := n.min; count <= run; count++ {
+		m.steps++
+		if k(pos + count) {
+			return true
+		}
+	}
+	return false
+}
+
+// repeat matches the remaining iterations of a general repeat, count done so far
+func (m *matcher) repeat(n *node, count, pos int, k func(int) bool) bool {
+	more := n.max < 0 || count < n.max
+	iterate := func() bool {
+		return more && m.match(n.subs[0], pos, func(next int) bool {
+			// An empty iteration past the minimum can't make progress
+			if next == pos && count >= n.min {
+				return false
+			}
+			return m.repeat(n, count+1, next, k)
+		})
+	}
+	if count < n.min {
+		return iterate()
+	}
+	if n.greedy {
+		return iterate() || k(pos)
+	}
+	return k(pos) || iterate()
+}
+
+// isWordByte reports whether c is an ASCII word character, as \w matches in byte strings
+func isWordByte(c byte) bool {
+	return c == '_' || (c >= '0' && c <= '9') || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
+}
+
+// lowerASCII folds an ASCII capital to lower case
+func lowerASCII(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
@@ -0,0 +1,118 @@
+package perlre
+
+import (
+	"testing"
+
+	"greg-hacke/go-metadata/tags"
+)
+
+// compileMagic compiles a magic number as formats does, anchored at the
+// start of the file with . matching any byte
+func compileMagic(pattern string) (*Regexp, error) {
+	return Compile("(?s)^" + pattern)
+}
+
+// Every magic number ExifTool has must compile, or its type can only be
+// recognized by extension
+func TestMagicNumbersCompile(t *testing.T) {
+	for fileType, pattern := range tags.ExifToolFileTypes.MagicNumbers {
+		if pattern == "RawConv" {
+			// Not a pattern: the type is recognized in its module
+			continue
+		}
+		if _, err := compileMagic(pattern); err != nil {
+			t.Errorf("%s: %v", fileType, err)
+		}
+	}
+}
+
+// magicHeaders are the first bytes of files of known types
+var magicHeaders = map[string]string{
+	"JPEG": "\xff\xd8\xff\xe0\x00\x10JFIF\x00",
+	"PNG":  "\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR",
+	"GIF":  "GIF89a\x01\x00\x01\x00",
+	"TIFF": "II*\x00\x08\x00\x00\x00",
+	"BTF":  "II+\x00\x08\x00\x00\x00",
+	"MOV":  "\x00\x00\x00\x18ftypheic\x00\x00\x00\x00",
+	"RIFF": "RIFF\x24\x00\x00\x00WEBPVP8 ",
+	"PSD":  "8BPS\x00\x01\x00\x00",
+	"PDF":  "%PDF-1.7\n",
+	"ZIP":  "PK\x03\x04\x14\x00",
+	"BMP":  "BM\x36\x00\x00\x00",
+	"HTML": "\xef\xbb\xbf  <!DOCTYPE html>",
+	"XMP":  "<?xpacket begin=",
+	"JP2":  "\x00\x00\x00\x0cjP  \x0d\x0a\x87\x0a",
+	"ICC":  "\x00\x00\x0c\x48Lino\x02\x10\x00\x00mntrRGB XYZ \x07\xce",
+	"TAR":  "file.txt" + string(make([]byte, 249)) + "ustar\x00",
+}
+
+var magicTests = []struct {
+	fileType string
+	header   string // Key of magicHeaders
+	want     bool
+}{
+	{"JPEG", "JPEG", true},
+	{"JPEG", "PNG", false},
+	{"PNG", "PNG", true},
+	{"PNG", "JPEG", false},
+	{"GIF", "GIF", true},
+	{"GIF", "PSD", false},
+	{"TIFF", "TIFF", true},
+	{"TIFF", "JPEG", false},
+	{"BTF", "BTF", true},
+	{"BTF", "TIFF", false},
+	{"MOV", "MOV", true},
+	{"MOV", "RIFF", false},
+	{"RIFF", "RIFF", true},
+	{"RIFF", "MOV", false},
+	{"PSD", "PSD", true},
+	{"PDF", "PDF", true},
+	{"PDF", "ZIP", false},
+	{"ZIP", "ZIP", true},
+	{"BMP", "BMP", true},
+	{"BMP", "GIF", false},
+	{"HTML", "HTML", true},
+	{"HTML", "XMP", false},
+	{"XMP", "XMP", true},
+	{"XMP", "JPEG", false},
+	{"JP2", "JP2", true},
+	{"JP2", "MOV", false},
+	{"ICC", "ICC", true},
+	{"ICC", "TIFF", false},
+	{"TAR", "TAR", true},
+	{"TAR", "ZIP", false},
+}
+
+func TestMagicNumbers(t *testing.T) {
+	for _, tt := range magicTests {
+		pattern, ok := tags.ExifToolFileTypes.MagicNumbers[tt.fileType]
+		if !ok {
+			t.Errorf("%s has no magic number", tt.fileType)
+			continue
+		}
+		r, err := compileMagic(pattern)
+		if err != nil {
+			t.Errorf("%s: %v", tt.fileType, err)
+			continue
+		}
+		if got := r.Match([]byte(magicHeaders[tt.header])); got != tt.want {
+			t.Errorf("%s magic number on a %s header = %t, want %t", tt.fileType, tt.header, got, tt.want)
+		}
+	}
+}
+
+// Every magic number gives the same result on both engines for every header
+func TestMagicNumbersEnginesAgree(t *testing.T) {
+	for fileType, pattern := range tags.ExifToolFileTypes.MagicNumbers {
+		r, err := compileMagic(pattern)
+		if err != nil || r.Engine() != EngineRE2 {
+			continue
+		}
+		for name, header := range magicHeaders {
+			got := r.Match([]byte(header))
+			if bt, exhausted := backtrack(r, []byte(header)); exhausted || bt != got {
+				t.Errorf("%s magic number on a %s header: RE2 %t, backtracking %t", fileType, name, got, bt)
+			}
+		}
+	}
+}
@@ -0,0 +1,928 @@
+package perlre
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Error describes a pattern that cannot be parsed
+type Error struct {
+	Pattern string // The pattern being parsed
+	Offset  int    // Byte offset of the problem in the pattern
+	Msg     string // What is wrong
+}
+
+// Error returns a description of the problem and where it was found
+func (e *Error) Error() string {
+	return fmt.Sprintf("perlre: %s at offset %d in %q", e.Msg, e.Offset, e.Pattern)
+}
+
+// byteSet is a set of byte values
+type byteSet [4]uint64
+
+func (s *byteSet) add(b byte)      { s[b>>6] |= 1 << (b & 63) }
+func (s *byteSet) has(b byte) bool { return s[b>>6]&(1<<(b&63)) != 0 }
+func (s *byteSet) union(o *byteSet) {
+	s[0], s[1], s[2], s[3] = s[0]|o[0], s[1]|o[1], s[2]|o[2], s[3]|o[3]
+}
+func (s *byteSet) invert()    { s[0], s[1], s[2], s[3] = ^s[0], ^s[1], ^s[2], ^s[3] }
+func (s *byteSet) full() bool { return s[0]&s[1]&s[2]&s[3] == ^uint64(0) }
+
+// addRange adds the bytes from lo to hi inclusive
+func addRange(s *byteSet, lo, hi int) {
+	for c := lo; c <= hi; c++ {
+		s.add(byte(c))
+	}
+}
+
+// single returns the only member of the set
+func (s *byteSet) single() (byte, bool) {
+	n, last := 0, 0
+	for c := 0; c < 256; c++ {
+		if s.has(byte(c)) {
+			n++
+			last = c
+		}
+	}
+	return byte(last), n == 1
+}
+
+// foldASCII adds the other case of every ASCII letter in the set. Perl
+// matches byte strings case-insensitively only for ASCII letters.
+func (s *byteSet) foldASCII() {
+	for c := 'A'; c <= 'Z'; c++ {
+		lower := c + 'a' - 'A'
+		if s.has(byte(c)) || s.has(byte(lower)) {
+			s.add(byte(c))
+			s.add(byte(lower))
+		}
+	}
+}
+
+// op identifies the kind of a parsed node
+type op int
+
+const (
+	opEmpty          op = iota // Matches the empty string
+	opClass                    // One byte from set
+	opConcat                   // subs in sequence
+	opAlternate                // One of subs
+	opCapture                  // Capturing group index around subs[0]
+	opRepeat                   // subs[0] repeated min to max times
+	opBeginText                // \A, ^ without /m, \G at the start of a match
+	opBeginLine                // ^ with /m
+	opEndText                  // \z
+	opEndTextNewline           // $ without /m and \Z: end of text or before a final newline
+	opEndLine                  // $ with /m
+	opWordBoundary             // \b
+	opNoWordBoundary           // \B
+	opLookahead                // (?=...) or, with neg, (?!...)
+	opLookbehind               // (?<=...) or, with neg, (?<!...)
+	opAtomic                   // (?>...) and possessive quantifiers
+	opBackref                  // Text matched by group index
+)
+
+// node is one element of a parsed pattern
+type node struct {
+	op       op
+	set      byteSet // opClass
+	subs     []*node
+	min, max int  // opRepeat; max -1 for no limit
+	greedy   bool // opRepeat
+	neg      bool // opLookahead, opLookbehind
+	index    int  // opCapture, opBackref
+	fold     bool // opBackref compared without regard to ASCII case
+}
+
+// flags are the pattern modifiers in effect
+type flags struct {
+	fold      bool // i
+	dotAll    bool // s
+	multiLine bool // m
+	extended  bool // x
+}
+
+// parser turns Perl pattern syntax into a node tree. Patterns are read as
+// bytes, as Perl does for byte strings.
+type parser struct {
+	src      string
+	pos      int
+	groups   int            // Capturing groups opened so far
+	names    map[string]int // Named groups
+	backrefs []*backrefUse  // Backreferences, checked once all groups are known
+}
+
+// backrefUse is a backreference waiting for its group to be resolved
+type backrefUse struct {
+	n      *node
+	name   string
+	offset int
+}
+
+// parse parses a complete pattern
+func parse(src string) (*node, int, error) {
+	p := &parser{src: src, names: make(map[string]int)}
+	n, err := p.alternation(flags{})
+	if err != nil {
+		return nil, 0, err
+	}
+	if p.pos < len(p.src) {
+		return nil, 0, p.errorf("unmatched )")
+	}
+	for _, use := range p.backrefs {
+		if use.name != "" {
+			index, ok := p.names[use.name]
+			if !ok {
+				return nil, 0, &Error{Pattern: src, Offset: use.offset, Msg: "reference to nonexistent named group " + use.name}
+			}
+			use.n.index = index
+		}
+		if use.n.index < 1 || use.n.index > p.groups {
+			return nil, 0, &Error{Pattern: src, Offset: use.offset, Msg: "reference to nonexistent group"}
+		}
+	}
+	return n, p.groups, nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &Error{Pattern: p.src, Offset: p.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) more() bool { return p.pos < len(p.src) }
+
+func (p *parser) peek() byte { return p.src[p.pos] }
+
+func (p *parser) lookingAt(s string) bool { return strings.HasPrefix(p.src[p.pos:], s) }
+
+// skipExtended skips whitespace and comments in /x mode
+func (p *parser) skipExtended(f flags) {
+	if !f.extended {
+		return
+	}
+	for p.more() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v':
+			p.pos++
+		case c == '#':
+			for p.more() && p.peek() != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// alternation parses branches separated by | up to a closing ) or the end
+func (p *parser) alternation(f flags) (*node, error) {
+	var branches []*node
+	for {
+		branch, next, err := p.concatenation(f)
+		if err != nil {
+			return nil, err
+		}
+		branches = append(branches, branch)
+		// Inline modifiers carry over to later branches of the same group
+		f = next
+		if !p.more() || p.peek() != '|' {
+			break
+		}
+		p.pos++
+	}
+	if len(branches) == 1 {
+		return branches[0], nil
+	}
+	return &node{op: opAlternate, subs: branches}, nil
+}
+
+// concatenation parses a sequence of quantified atoms, returning the flags in effect after it
+func (p *parser) concatenation(f flags) (*node, flags, error) {
+	var items []*node
+	for {
+		p.skipExtended(f)
+		if !p.more() || p.peek() == '|' || p.peek() == ')' {
+			break
+		}
+
+		// A bare modifier group changes the flags for the rest of the enclosing group
+		if next, ok, err := p.inlineFlags(f); err != nil {
+			return nil, f, err
+		} else if ok {
+			f = next
+			continue
+		}
+
+		if p.lookingAt(`\Q`) {
+			p.pos += 2
+			for p.more() && !p.lookingAt(`\E`) {
+				items = append(items, literal(p.peek(), f))
+				p.pos++
+			}
+			if p.more() {
+				p.pos += 2
+			}
+			continue
+		}
+		if p.lookingAt(`\E`) {
+			p.pos += 2
+			continue
+		}
+
+		atom, err := p.atom(f)
+		if err != nil {
+			return nil, f, err
+		}
+		if atom == nil {
+			continue // Comment
+		}
+		atom, err = p.quantifier(atom, f)
+		if err != nil {
+			return nil, f, err
+		}
+		items = append(items, atom)
+	}
+
+	switch len(items) {
+	case 0:
+		return &node{op: opEmpty}, f, nil
+	case 1:
+		return items[0], f, nil
+	}
+	return &node{op: opConcat, subs: items}, f, nil
+}
+
+// inlineFlags parses a (?imsx-imsx) modifier group at the current position
+func (p *parser) inlineFlags(f flags) (flags, bool, error) {
+	if !p.lookingAt("(?") {
+		return f, false, nil
+	}
+	end := p.pos + 2
+	for end < len(p.src) && strings.IndexByte("^imsxn-adlup", p.src[end]) >= 0 {
+		end++
+	}
+	if end >= len(p.src) || p.src[end] != ')' || end == p.pos+2 {
+		return f, false, nil
+	}
+	next, err := p.applyFlags(f, p.src[p.pos+2:end])
+	if err != nil {
+		return f, false, err
+	}
+	p.pos = end + 1
+	return next, true, nil
+}
+
+// applyFlags applies modifier letters such as "i", "^s" or "s-i" to f
+func (p *parser) applyFlags(f flags, spec string) (flags, error) {
+	on := true
+	for i := 0; i < len(spec); i++ {
+		switch c := spec[i]; c {
+		case '^':
+			// Perl 5.14 shorthand for the default modifiers
+			f = flags{}
+		case '-':
+			on = false
+		case 'i':
+			f.fold = on
+		case 's':
+			f.dotAll = on
+		case 'm':
+			f.multiLine = on
+		case 'x':
+			f.extended = on
+		case 'n', 'a', 'd', 'l', 'u', 'p':
+			// No effect on byte strings or on whether a pattern matches
+		default:
+			return f, p.errorf("unknown modifier %q", c)
+		}
+	}
+	return f, nil
+}
+
+// atom parses a single matchable element, returning nil for a comment
+func (p *parser) atom(f flags) (*node, error) {
+	switch c := p.peek(); c {
+	case '(':
+		return p.group(f)
+	case '[':
+		return p.class(f)
+	case '.':
+		p.pos++
+		n := &node{op: opClass}
+		n.set.invert()
+		if !f.dotAll {
+			n.set[0] &^= 1 << '\n'
+		}
+		return n, nil
+	case '^':
+		p.pos++
+		if f.multiLine {
+			return &node{op: opBeginLine}, nil
+		}
+		return &node{op: opBeginText}, nil
+	case '$':
+		p.pos++
+		if f.multiLine {
+			return &node{op: opEndLine}, nil
+		}
+		return &node{op: opEndTextNewline}, nil
+	case '\\':
+		return p.escape(f)
+	case '*', '+', '?':
+		return nil, p.errorf("quantifier %q follows nothing", c)
+	case '{':
+		if _, _, ok := p.counted(); ok {
+			return nil, p.errorf("quantifier follows nothing")
+		}
+	}
+	// Anything else, including ] and a { that doesn't start a quantifier, is literal
+	c := p.peek()
+	p.pos++
+	return literal(c, f), nil
+}
+
+// literal returns a node matching byte c
+func literal(c byte, f flags) *node {
+	n := &node{op: opClass}
+	n.set.add(c)
+	if f.fold {
+		n.set.foldASCII()
+	}
+	return n
+}
+
+// group parses a parenthesized group
+func (p *parser) group(f flags) (*node, error) {
+	start := p.pos
+	p.pos++ // (
+
+	kind := ""
+	var name string
+	if p.lookingAt("?") {
+		p.pos++
+		switch {
+		case p.lookingAt("#"):
+			for p.more() && p.peek() != ')' {
+				p.pos++
+			}
+			if !p.more() {
+				return nil, p.errorf("unterminated comment")
+			}
+			p.pos++
+			return nil, nil
+		case p.lookingAt(":"), p.lookingAt("="), p.lookingAt("!"), p.lookingAt(">"):
+			kind = p.src[p.pos : p.pos+1]
+			p.pos++
+		case p.lookingAt("<="), p.lookingAt("<!"):
+			kind = p.src[p.pos : p.pos+2]
+			p.pos += 2
+		case p.lookingAt("<"), p.lookingAt("P<"), p.lookingAt("'"):
+			if p.peek() == 'P' {
+				p.pos++
+			}
+			closer := byte('>')
+			if p.peek() == '\'' {
+				closer = '\''
+			}
+			p.pos++
+			end := strings.IndexByte(p.src[p.pos:], closer)
+			if end <= 0 {
+				return nil, p.errorf("malformed group name")
+			}
+			name = p.src[p.pos : p.pos+end]
+			p.pos += end + 1
+		case p.lookingAt("|"):
+			return nil, p.errorf("branch reset groups are not supported")
+		default:
+			// Scoped modifiers: (?i:...)
+			end := p.pos
+			for end < len(p.src) && strings.IndexByte("^imsxn-adlup", p.src[end]) >= 0 {
+				end++
+			}
+			if end >= len(p.src) || p.src[end] != ':' {
+				return nil, p.errorf("unknown group syntax")
+			}
+			var err error
+			if f, err = p.applyFlags(f, p.src[p.pos:end]); err != nil {
+				return nil, err
+			}
+			p.pos = end + 1
+			kind = ":"
+		}
+	}
+
+	index := 0
+	if kind == "" {
+		p.groups++
+		index = p.groups
+		if name != "" {
+			if _, exists := p.names[name]; !exists {
+				p.names[name] = index
+			}
+		}
+	}
+
+	sub, err := p.alternation(f)
+	if err != nil {
+		return nil, err
+	}
+	if !p.more() || p.peek() != ')' {
+		return nil, &Error{Pattern: p.src, Offset: start, Msg: "unmatched ("}
+	}
+	p.pos++
+
+	switch kind {
+	case "":
+		return &node{op: opCapture, index: index, subs: []*node{sub}}, nil
+	case "=", "!":
+		return &node{op: opLookahead, neg: kind == "!", subs: []*node{sub}}, nil
+	case "<=", "<!":
+		return &node{op: opLookbehind, neg: kind == "<!", subs: []*node{sub}}, nil
+	case ">":
+		return &node{op: opAtomic, subs: []*node{sub}}, nil
+	}
+	return sub, nil
+}
+
+// counted parses a {n}, {n,}, {n,m} or {,m} quantifier at the current
+// position without consuming it. It reports false if the brace is literal.
+func (p *parser) counted() (min, max int, ok bool) {
+	end := strings.IndexByte(p.src[p.pos:], '}')
+	if end < 0 {
+		return 0, 0, false
+	}
+	body := strings.TrimSpace(p.src[p.pos+1 : p.pos+end])
+	lo, hi, hasComma := strings.Cut(body, ",")
+	lo, hi = strings.TrimSpace(lo), strings.TrimSpace(hi)
+	if lo == "" && (!hasComma || hi == "") {
+		return 0, 0, false
+	}
+	if lo != "" {
+		n, err := strconv.Atoi(lo)
+		if err != nil || n < 0 {
+			return 0, 0, false
+		}
+		min = n
+	}
+	max = min
+	if hasComma {
+		max = -1
+		if hi != "" {
+			n, err := strconv.Atoi(hi)
+			if err != nil || n < 0 {
+				return 0, 0, false
+			}
+			max = n
+		}
+	}
+	return min, max, true
+}
+
+// quantifier applies any quantifier following atom
+func (p *parser) quantifier(atom *node, f flags) (*node, error) {
+	for {
+		p.skipExtended(f)
+		if !p.more() {
+			return atom, nil
+		}
+		start := p.pos
+		var min, max int
+		switch p.peek() {
+		case '*':
+			min, max = 0, -1
+			p.pos++
+		case '+':
+			min, max = 1, -1
+			p.pos++
+		case '?':
+			min, max = 0, 1
+			p.pos++
+		case '{':
+			var ok bool
+			if min, max, ok = p.counted(); !ok {
+				return atom, nil
+			}
+			p.pos += strings.IndexByte(p.src[p.pos:], '}') + 1
+		default:
+			return atom, nil
+		}
+		if max >= 0 && min > max {
+			return nil, &Error{Pattern: p.src, Offset: start, Msg: "quantifier minimum exceeds maximum"}
+		}
+		if isAssertion(atom) {
+			// Quantified assertions match at most once
+			if max < 0 || max > 1 {
+				max = 1
+			}
+		}
+
+		rep := &node{op: opRepeat, min: min, max: max, greedy: true, subs: []*node{atom}}
+		if p.more() && p.peek() == '?' {
+			rep.greedy = false
+			p.pos++
+		} else if p.more() && p.peek() == '+' {
+			p.pos++
+			atom = &node{op: opAtomic, subs: []*node{rep}}
+			continue
+		}
+		atom = rep
+	}
+}
+
+// isAssertion reports whether n matches without consuming input
+func isAssertion(n *node) bool {
+	switch n.op {
+	case opBeginText, opBeginLine, opEndText, opEndTextNewline, opEndLine,
+		opWordBoundary, opNoWordBoundary, opLookahead, opLookbehind:
+		return true
+	}
+	return false
+}
+
+// escape parses a backslash sequence outside a character class
+func (p *parser) escape(f flags) (*node, error) {
+	start := p.pos
+	p.pos++ // backslash
+	if !p.more() {
+		return nil, p.errorf("trailing backslash")
+	}
+	c := p.peek()
+	p.pos++
+
+	switch c {
+	case 'A':
+		return &node{op: opBeginText}, nil
+	case 'G':
+		// Matching always starts afresh, so \G is where the match attempt begins
+		return &node{op: opBeginText}, nil
+	case 'z':
+		return &node{op: opEndText}, nil
+	case 'Z':
+		return &node{op: opEndTextNewline}, nil
+	case 'b':
+		if p.lookingAt("{") {
+			return nil, p.errorf("\\b{...} boundaries are not supported")
+		}
+		return &node{op: opWordBoundary}, nil
+	case 'B':
+		return &node{op: opNoWordBoundary}, nil
+	case 'K':
+		return nil, p.errorf("\\K is not supported")
+	case 'p', 'P', 'X', 'R':
+		return nil, p.errorf("\\%c is not supported for byte strings", c)
+	case 'g', 'k':
+		return p.backref(c, start, f)
+	}
+
+	if c >= '1' && c <= '9' {
+		// \1 to \9 always refer to groups; larger numbers only when that many groups exist
+		end := p.pos
+		for end < len(p.src) && p.src[end] >= '0' && p.src[end] <= '9' {
+			end++
+		}
+		n, _ := strconv.Atoi(p.src[p.pos-1 : end])
+		if n < 10 || n <= p.groups {
+			p.pos = end
+			ref := &node{op: opBackref, index: n, fold: f.fold}
+			p.backrefs = append(p.backrefs, &backrefUse{n: ref, offset: start})
+			return ref, nil
+		}
+	}
+
+	p.pos = start
+	set, err := p.escapeSet(false)
+	if err != nil {
+		return nil, err
+	}
+	n := &node{op: opClass, set: set}
+	if f.fold {
+		n.set.foldASCII()
+	}
+	return n, nil
+}
+
+// backref parses \g and \k group references
+func (p *parser) backref(c byte, start int, f flags) (*node, error) {
+	ref := &node{op: opBackref, fold: f.fold}
+	use := &backrefUse{n: ref, offset: start}
+
+	body := ""
+	switch {
+	case p.lookingAt("{"):
+		end := strings.IndexByte(p.src[p.pos:], '}')
+		if end < 0 {
+			return nil, p.errorf("unterminated \\%c{", c)
+		}
+		body = p.src[p.pos+1 : p.pos+end]
+		p.pos += end + 1
+	case c == 'k' && (p.lookingAt("<") || p.lookingAt("'")):
+		closer := byte('>')
+		if p.peek() == '\'' {
+			closer = '\''
+		}
+		end := strings.IndexByte(p.src[p.pos+1:], closer)
+		if end < 0 {
+			return nil, p.errorf("unterminated \\k")
+		}
+		body = p.src[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+	case c == 'g':
+		end := p.pos
+		if end < len(p.src) && p.src[end] == '-' {
+			end++
+		}
+		for end < len(p.src) && p.src[end] >= '0' && p.src[end] <= '9' {
+			end++
+		}
+		body = p.src[p.pos:end]
+		p.pos = end
+	}
+	if body == "" {
+		return nil, p.errorf("malformed \\%c reference", c)
+	}
+
+	if n, err := strconv.Atoi(body); err == nil && c == 'g' {
+		if n < 0 {
+			// Relative to the groups opened so far
+			n = p.groups + 1 + n
+		}
+		ref.index = n
+	} else {
+		use.name = body
+	}
+	p.backrefs = append(p.backrefs, use)
+	return ref, nil
+}
+
+// escapeSet parses a backslash sequence that stands for a byte or a set of
+// bytes. inClass selects the meaning of \b, which is backspace in a class.
+func (p *parser) escapeSet(inClass bool) (byteSet, error) {
+	var set byteSet
+	p.pos++ // backslash
+	if !p.more() {
+		return set, p.errorf("trailing backslash")
+	}
+	c := p.peek()
+	p.pos++
+
+	switch c {
+	case 'd', 'D':
+		addRange(&set, '0', '9')
+	case 's', 'S':
+		// Since Perl 5.18 \s includes the vertical tab
+		for _, b := range []byte{'\t', '\n', '\v', '\f', '\r', ' '} {
+			set.add(b)
+		}
+	case 'w', 'W':
+		addRange(&set, '0', '9')
+		addRange(&set, 'A', 'Z')
+		addRange(&set, 'a', 'z')
+		set.add('_')
+	case 'h', 'H':
+		// \h and \v follow Unicode rules even for byte strings
+		set.add('\t')
+		set.add(' ')
+		set.add(0xA0)
+	case 'v', 'V':
+		addRange(&set, '\n', '\r')
+		set.add(0x85)
+	case 'N':
+		if p.lookingAt("{") {
+			return set, p.errorf("named characters are not supported")
+		}
+		set.add('\n')
+		set.invert()
+		return set, nil
+	default:
+		b, err := p.escapeByte(c, inClass)
+		if err != nil {
+			return set, err
+		}
+		set.add(b)
+		return set, nil
+	}
+	if c >= 'A' && c <= 'Z' {
+		set.invert()
+	}
+	return set, nil
+}
+
+// escapeByte decodes an escape standing for a single byte; c follows the backslash
+func (p *parser) escapeByte(c byte, inClass bool) (byte, error) {
+	switch c {
+	case 'n':
+		return '\n', nil
+	case 'r':
+		return '\r', nil
+	case 't':
+		return '\t', nil
+	case 'f':
+		return '\f', nil
+	case 'e':
+		return 0x1B, nil
+	case 'a':
+		return 0x07, nil
+	case 'b':
+		if inClass {
+			return '\b', nil
+		}
+	case 'c':
+		if !p.more() {
+			return 0, p.errorf("missing control character")
+		}
+		ctl := p.peek()
+		p.pos++
+		if ctl >= 'a' && ctl <= 'z' {
+			ctl -= 'a' - 'A'
+		}
+		return ctl ^ 0x40, nil
+	case 'x':
+		return p.numberEscape(16, 2, "{")
+	case 'o':
+		if !p.lookingAt("{") {
+			return 0, p.errorf("missing braces on \\o")
+		}
+		return p.numberEscape(8, 0, "{")
+	case '0':
+		// \0 takes up to two more octal digits
+		p.pos--
+		return p.numberEscape(8, 3, "")
+	}
+	if c >= '1' && c <= '7' {
+		// Octal escape that is not a backreference
+		p.pos--
+		return p.numberEscape(8, 3, "")
+	}
+	if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+		return 0, p.errorf("unknown escape \\%c", c)
+	}
+	// Escaped punctuation and bytes stand for themselves
+	return c, nil
+}
+
+// numberEscape reads up to maxDigits digits in base, or a braced number
+// when braced is "{", and returns the byte value
+func (p *parser) numberEscape(base, maxDigits int, braced string) (byte, error) {
+	digits := ""
+	if braced != "" && p.lookingAt(braced) {
+		end := strings.IndexByte(p.src[p.pos:], '}')
+		if end < 0 {
+			return 0, p.errorf("unterminated escape")
+		}
+		digits = strings.TrimSpace(p.src[p.pos+1 : p.pos+end])
+		p.pos += end + 1
+	} else {
+		end := p.pos
+		for end < len(p.src) && end-p.pos < maxDigits && isDigit(p.src[end], base) {
+			end++
+		}
+		digits = p.src[p.pos:end]
+		p.pos = end
+	}
+	if digits == "" {
+		return 0, nil
+	}
+	v, err := strconv.ParseUint(digits, base, 32)
+	if err != nil {
+		return 0, p.errorf("malformed number %q", digits)
+	}
+	if v > 0xFF {
+		return 0, p.errorf("character 0x%X is beyond the byte range", v)
+	}
+	return byte(v), nil
+}
+
+// isDigit reports whether c is a digit in base 8 or 16
+func isDigit(c byte, base int) bool {
+	switch {
+	case c >= '0' && c <= '7':
+		return true
+	case base == 16 && (c == '8' || c == '9' || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')):
+		return true
+	}
+	return false
+}
+
+// posixClasses are the [:name:] classes, with their ASCII meaning
+var posixClasses = map[string]func(c byte) bool{
+	"alpha": func(c byte) bool { return (c|0x20) >= 'a' && (c|0x20) <= 'z' },
+	"digit": func(c byte) bool { return c >= '0' && c <= '9' },
+	"alnum": func(c byte) bool { return (c|0x20) >= 'a' && (c|0x20) <= 'z' || c >= '0' && c <= '9' },
+	"upper": func(c byte) bool { return c >= 'A' && c <= 'Z' },
+	"lower": func(c byte) bool { return c >= 'a' && c <= 'z' },
+	"space": func(c byte) bool { return c == ' ' || c >= '\t' && c <= '\r' },
+	"blank": func(c byte) bool { return c == ' ' || c == '\t' },
+	"punct": func(c byte) bool {
+		return c > ' ' && c < 0x7F && !((c|0x20) >= 'a' && (c|0x20) <= 'z' || c >= '0' && c <= '9')
+	},
+	"print":  func(c byte) bool { return c >= ' ' && c < 0x7F },
+	"graph":  func(c byte) bool { return c > ' ' && c < 0x7F },
+	"cntrl":  func(c byte) bool { return c < ' ' || c == 0x7F },
+	"xdigit": func(c byte) bool { return isDigit(c, 16) },
+	"word":   func(c byte) bool { return c == '_' || (c|0x20) >= 'a' && (c|0x20) <= 'z' || c >= '0' && c <= '9' },
+	"ascii":  func(c byte) bool { return c < 0x80 },
+}
+
+// class parses a bracketed character class
+func (p *parser) class(f flags) (*node, error) {
+	start := p.pos
+	p.pos++ // [
+	negate := false
+	if p.more() && p.peek() == '^' {
+		negate = true
+		p.pos++
+	}
+
+	n := &node{op: opClass}
+	first := true
+	for {
+		if !p.more() {
+			return nil, &Error{Pattern: p.src, Offset: start, Msg: "unmatched ["}
+		}
+		c := p.peek()
+		if c == ']' && !first {
+			p.pos++
+			break
+		}
+		first = false
+
+		// POSIX class such as [:alpha:] or [:^digit:]
+		if p.lookingAt("[:") {
+			if end := strings.Index(p.src[p.pos:], ":]"); end > 0 {
+				name := p.src[p.pos+2 : p.pos+end]
+				neg := strings.HasPrefix(name, "^")
+				test, ok := posixClasses[strings.TrimPrefix(name, "^")]
+				if !ok {
+					return nil, p.errorf("unknown POSIX class %q", name)
+				}
+				var set byteSet
+				for b := 0; b < 256; b++ {
+					if test(byte(b)) != neg {
+						set.add(byte(b))
+					}
+				}
+				n.set.union(&set)
+				p.pos += end + 2
+				continue
+			}
+		}
+
+		lo, set, isSet, err := p.classItem()
+		if err != nil {
+			return nil, err
+		}
+		if isSet {
+			n.set.union(&set)
+			continue
+		}
+
+		// A range, unless the - is last in the class
+		if p.lookingAt("-") && p.pos+1 < len(p.src) && p.src[p.pos+1] != ']' {
+			p.pos++
+			hi, hiSet, hiIsSet, err := p.classItem()
+			if err != nil {
+				return nil, err
+			}
+			if hiIsSet {
+				// Perl treats [a-\d] as a, - and the digits
+				n.set.add(lo)
+				n.set.add('-')
+				n.set.union(&hiSet)
+				continue
+			}
+			if hi < lo {
+				return nil, p.errorf("invalid range in class")
+			}
+			addRange(&n.set, int(lo), int(hi))
+			continue
+		}
+		n.set.add(lo)
+	}
+
+	if f.fold {
+		n.set.foldASCII()
+	}
+	if negate {
+		n.set.invert()
+	}
+	return n, nil
+}
+
+// classItem parses one byte or escaped set inside a class
+func (p *parser) classItem() (byte, byteSet, bool, error) {
+	c := p.peek()
+	if c != '\\' {
+		p.pos++
+		return c, byteSet{}, false, nil
+	}
+	if p.pos+1 >= len(p.src) {
+		return 0, byteSet{}, false, p.errorf("trailing backslash")
+	}
+	if strings.IndexByte("dDsSwWhHvVN", p.src[p.pos+1]) >= 0 {
+		set, err := p.escapeSet(true)
+		return 0, set, true, err
+	}
+	p.pos += 2
+	b, err := p.escapeByte(p.src[p.pos-1], true)
+	return b, byteSet{}, false, err
+}
@@ -0,0 +1,47 @@
+package perlre
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		msg  string
+	}{
+		{`\`, "trailing backslash"},
+		{`[\`, "trailing backslash"},
+		{`[a\`, "trailing backslash"},
+		{`[a-\`, "trailing backslash"},
+		{`[a`, "unmatched ["},
+		{`[z-a]`, "invalid range in class"},
+		{`(a`, ""},
+		{`a)`, ""},
+		{`*a`, ""},
+	}
+	for _, tt := range tests {
+		_, err := Compile(tt.expr)
+		if err == nil {
+			t.Errorf("Compile(%q) succeeded, want an error", tt.expr)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("Compile(%q) = %v, want %q", tt.expr, err, tt.msg)
+		}
+	}
+}
+
+// A range whose upper end is an escaped set is the low byte, a hyphen and
+// the set, as in Perl
+func TestClassRangeToSet(t *testing.T) {
+	r := MustCompile(`^[a-\d]$`)
+	for _, s := range []string{"a", "-", "5"} {
+		if !r.Match([]byte(s)) {
+			t.Errorf("%s does not match %q", r, s)
+		}
+	}
+	if r.Match([]byte("b")) {
+		t.Errorf("%s matches %q", r, "b")
+	}
+}
@@ -0,0 +1,126 @@
+// Package perlre matches byte data against Perl regular expressions, such as
+// the %magicNumber patterns ExifTool uses to identify files.
+//
+// Patterns are translated to Go's regexp syntax where RE2 can express them,
+// and run by a backtracking matcher otherwise. Either way the data is
+// treated as bytes, as Perl does for strings read in binary mode: . matches
+// any single byte and \xHH matches the byte HH, not a UTF-8 sequence.
+package perlre
+
+import (
+	"regexp"
+	"strings"
+
+	"greg-hacke/go-metadata/internal/latin1"
+)
+
+// Engines that can run a compiled pattern
+const (
+	EngineRE2       = "re2"       // Go's regexp package
+	EngineBacktrack = "backtrack" // Fallback for constructs RE2 cannot express
+)
+
+// Regexp is a compiled Perl pattern
+type Regexp struct {
+	expr     string
+	re       *regexp.Regexp // Translated pattern, nil to backtrack
+	tree     *node
+	groups   int
+	anchored bool   // Every match must start at the beginning of the data
+	reason   string // Why the backtracking matcher is used
+}
+
+// Compile parses a Perl pattern. Matches are searched for anywhere in the
+// data unless the pattern is anchored, as in Perl's m//.
+func Compile(expr string) (*Regexp, error) {
+	tree, groups, err := parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	r := &Regexp{expr: expr, tree: tree, groups: groups, anchored: anchoredAtStart(tree)}
+
+	var b strings.Builder
+	err = emit(&b, tree, true)
+	if err == nil {
+		r.re, err = regexp.Compile(b.String())
+	}
+	if err != nil {
+		r.reason = err.Error()
+	}
+	return r, nil
+}
+
+// MustCompile is like Compile but panics if the pattern cannot be parsed
+func MustCompile(expr string) *Regexp {
+	r, err := Compile(expr)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// String returns the source pattern
+func (r *Regexp) String() string {
+	return r.expr
+}
+
+// Engine returns EngineRE2 or EngineBacktrack
+func (r *Regexp) Engine() string {
+	if r.re != nil {
+		return EngineRE2
+	}
+	return EngineBacktrack
+}
+
+// FallbackReason explains why the backtracking matcher is used, or returns
+// "" when the pattern runs on RE2
+func (r *Regexp) FallbackReason() string {
+	return r.reason
+}
+
+// Match reports whether data contains a match of the pattern. A
+// backtracking match that exceeds its step budget reports no match.
+func (r *Regexp) Match(data []byte) bool {
+	if r.re != nil {
+		return r.re.MatchString(latin1.Decode(data))
+	}
+	m := &matcher{data: data, caps: make([]int, 2*(r.groups+1))}
+	matched, _ := m.run(r.tree, r.anchored)
+	return matched
+}
+
+// MatchLatin1 is like Match for data already decoded by latin1.Decode, the
+// form translated patterns are matched against. It saves converting the
+// same data again when many patterns are tried against it.
+func (r *Regexp) MatchLatin1(text string) bool {
+	if r.re != nil {
+		return r.re.MatchString(text)
+	}
+	data := make([]byte, 0, len(text))
+	for _, c := range text {
+		data = append(data, byte(c))
+	}
+	m := &matcher{data: data, caps: make([]int, 2*(r.groups+1))}
+	matched, _ := m.run(r.tree, r.anchored)
+	return matched
+}
+
+// anchoredAtStart reports whether every match of n must begin at offset 0
+func anchoredAtStart(n *node) bool {
+	switch n.op {
+	case opBeginText:
+		return true
+	case opConcat:
+		return len(n.subs) > 0 && anchoredAtStart(n.subs[0])
+	case opCapture, opAtomic:
+		return anchoredAtStart(n.subs[0])
+	case opAlternate:
+		for _, sub := range n.subs {
+			if !anchoredAtStart(sub) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package perlre
+
+import (
+	"strings"
+	"testing"
+
+	"greg-hacke/go-metadata/internal/latin1"
+)
+
+// backtrack runs the backtracking matcher over data whichever engine r uses
+func backtrack(r *Regexp, data []byte) (matched, exhausted bool) {
+	m := &matcher{data: data, caps: make([]int, 2*(r.groups+1))}
+	return m.run(r.tree, r.anchored)
+}
+
+var matchTests = []struct {
+	expr   string
+	engine string
+	data   string
+	want   bool
+}{
+	// Bytes, not UTF-8
+	{`^\xff\xd8\xff`, EngineRE2, "\xff\xd8\xff\xe0", true},
+	{`^\xff\xd8\xff`, EngineRE2, "\xff\xd8\xfe", false},
+	{`(?s)^.{2}\x80`, EngineRE2, "\xc3\xa9\x80", true},
+	{`(?s)^.{2}\x80`, EngineRE2, "\xc3\x80", false},
+	{`^[\x80-\xff]+$`, EngineRE2, "\x80\xfe\xff", true},
+	{`^[^\0]`, EngineRE2, "\x00", false},
+	{`^\0{3}a`, EngineRE2, "\x00\x00\x00a", true},
+	{`^\x{41}\101\cA`, EngineRE2, "AA\x01", true},
+
+	// . does not match a newline without /s
+	{`^a.b`, EngineRE2, "a\nb", false},
+	{`(?s)^a.b`, EngineRE2, "a\nb", true},
+
+	// Anchors: $ and \Z allow a final newline, \z does not
+	{`abc$`, EngineRE2, "abc\n", true},
+	{`abc$`, EngineRE2, "abc\n\n", false},
+	{`abc\Z`, EngineRE2, "abc\n", true},
+	{`abc\z`, EngineRE2, "abc\n", false},
+	{`(?m)^b$`, EngineBacktrack, "a\nb\nc", true},
+	{`^b`, EngineRE2, "a\nb", false},
+	{`\Ab`, EngineRE2, "ab", false},
+
+	// Case folding is ASCII only
+	{`(?i)^<html`, EngineRE2, "<HtMl>", true},
+	{`(?i)^\xe9`, EngineRE2, "\xc9", false},
+	{`^(?i:ab)c`, EngineRE2, "ABc", true},
+	{`^(?i:ab)c`, EngineRE2, "ABC", false},
+
+	// Classes and escapes
+	{`^\d+\s\w+$`, EngineRE2, "42 abc_1", true},
+	{`^[[:alpha:]]+$`, EngineRE2, "abcXYZ", true},
+	{`^[[:^digit:]]$`, EngineRE2, "5", false},
+	{`^[a-c\d-]+$`, EngineRE2, "ab-9c", true},
+	{`^[]a]$`, EngineRE2, "]", true},
+	{`^\h\v`, EngineRE2, "\t\n", true},
+	{`^\N`, EngineRE2, "\n", false},
+	{`\bword\b`, EngineRE2, "a word.", true},
+	{`\bword\b`, EngineRE2, "swordfish", false},
+	{`(?x) ^ a b # comment
+	  c $`, EngineRE2, "abc", true},
+
+	// Repeats
+	{`^a{2,3}$`, EngineRE2, "aaaa", false},
+	{`^a{2,}$`, EngineRE2, "aaaa", true},
+	{`^a{,2}$`, EngineRE2, "aa", true},
+	{`^a{,2}$`, EngineRE2, "aaa", false},
+	{`^a{x}`, EngineRE2, "a{x}", true},
+	{`^(ab)*?c`, EngineRE2, "ababc", true},
+
+	// Constructs only the backtracking matcher runs
+	{`^(?=.{4}ftyp)....`, EngineBacktrack, "\x00\x00\x00\x18ftypisom", true},
+	{`^(?!GIF)...`, EngineBacktrack, "GIF89a", false},
+	{`^.{3}(?<=abc)d`, EngineBacktrack, "abcd", true},
+	{`^.{3}(?<!abc)d`, EngineBacktrack, "abcd", false},
+	{`^(II|MM)\1`, EngineBacktrack, "IIII", true},
+	{`^(II|MM)\1`, EngineBacktrack, "IIMM", false},
+	{`^(?<order>II|MM)\k<order>`, EngineBacktrack, "MMMM", true},
+	{`(?i)^(a)\1`, EngineBacktrack, "aA", true},
+	{`^(?>a+)a`, EngineBacktrack, "aaa", false},
+	{`^a++a`, EngineBacktrack, "aaa", false},
+	{`^a*+b`, EngineBacktrack, "aaab", true},
+	{`^a{1001}$`, EngineBacktrack, strings.Repeat("a", 1001), true},
+}
+
+func TestMatch(t *testing.T) {
+	for _, tt := range matchTests {
+		r, err := Compile(tt.expr)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", tt.expr, err)
+			continue
+		}
+		if r.Engine() != tt.engine {
+			t.Errorf("%q runs on %s, want %s (%s)", tt.expr, r.Engine(), tt.engine, r.FallbackReason())
+		}
+		if got := r.Match([]byte(tt.data)); got != tt.want {
+			t.Errorf("%q Match(%q) = %t, want %t", tt.expr, tt.data, got, tt.want)
+		}
+		if got := r.MatchLatin1(latin1.Decode([]byte(tt.data))); got != tt.want {
+			t.Errorf("%q MatchLatin1(%q) = %t, want %t", tt.expr, tt.data, got, tt.want)
+		}
+	}
+}
+
+// Patterns RE2 runs must give the same result on the backtracking matcher
+func TestEnginesAgree(t *testing.T) {
+	for _, tt := range matchTests {
+		r, err := Compile(tt.expr)
+		if err != nil || r.Engine() != EngineRE2 {
+			continue
+		}
+		if got, _ := backtrack(r, []byte(tt.data)); got != tt.want {
+			t.Errorf("%q backtracking Match(%q) = %t, want %t", tt.expr, tt.data, got, tt.want)
+		}
+	}
+}
+
+// A pathological pattern runs out of steps instead of hanging
+func TestBacktrackBudget(t *testing.T) {
+	r := MustCompile(`^(a|a)*(?=b)`)
+	matched, exhausted := backtrack(r, []byte(strings.Repeat("a", 64)))
+	if matched || !exhausted {
+		t.Errorf("backtrack = %t, %t, want false, true", matched, exhausted)
+	}
+}
+
+// FuzzMatch checks that no pattern makes Compile or Match panic, and that
+// the two engines agree on patterns RE2 can run
+func FuzzMatch(f *testing.F) {
+	for _, tt := range matchTests {
+		f.Add(tt.expr, []byte(tt.data))
+	}
+	f.Add(`[\`, []byte{})
+	f.Add(`(?s)^.{4}(free|skip|wide|ftyp)`, []byte("\x00\x00\x00\x18ftypheic"))
+	f.Fuzz(func(t *testing.T, expr string, data []byte) {
+		r, err := Compile(expr)
+		if err != nil {
+			return
+		}
+		got := r.Match(data)
+		r.Span()
+		r.Specificity()
+		if r.Engine() != EngineRE2 {
+			return
+		}
+		if bt, exhausted := backtrack(r, data); !exhausted && bt != got {
+			t.Errorf("%q on %q: RE2 %t, backtracking %t", expr, data, got, bt)
+		}
+	})
+}
@@ -0,0 +1,177 @@
+package perlre
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxRE2Repeat is the largest repeat count the regexp package accepts
+const maxRE2Repeat = 1000
+
+// unsupportedError explains why a pattern has no RE2 equivalent
+type unsupportedError struct {
+	construct string
+}
+
+func (e *unsupportedError) Error() string {
+	return "perlre: " + e.construct + " has no RE2 equivalent"
+}
+
+// Translate converts a Perl pattern to the syntax of Go's regexp package.
+// The result matches Latin-1 text in which each rune stands for one byte of
+// the original data, which is how Regexp gives it Perl's byte semantics.
+// Patterns using constructs RE2 cannot express, such as lookaround or
+// backreferences, return an error; Compile matches those with a
+// backtracking matcher instead.
+func Translate(pattern string) (string, error) {
+	tree, _, err := parse(pattern)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := emit(&b, tree, true); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// emit writes n in RE2 syntax. tail reports whether nothing follows n in
+// the pattern, where an end-of-text assertion may consume a final newline.
+func emit(b *strings.Builder, n *node, tail bool) error {
+	switch n.op {
+	case opEmpty:
+		b.WriteString("(?:)")
+
+	case opClass:
+		emitClass(b, &n.set)
+
+	case opConcat:
+		b.WriteString("(?:")
+		for i, sub := range n.subs {
+			if err := emit(b, sub, tail && i == len(n.subs)-1); err != nil {
+				return err
+			}
+		}
+		b.WriteString(")")
+
+	case opAlternate:
+		b.WriteString("(?:")
+		for i, sub := range n.subs {
+			if i > 0 {
+				b.WriteString("|")
+			}
+			if err := emit(b, sub, tail); err != nil {
+				return err
+			}
+		}
+		b.WriteString(")")
+
+	case opCapture:
+		// Matching only reports success, so groups need not capture
+		return emit(b, n.subs[0], tail)
+
+	case opRepeat:
+		if n.min > maxRE2Repeat || n.max > maxRE2Repeat {
+			return &unsupportedError{fmt.Sprintf("repeat count above %d", maxRE2Repeat)}
+		}
+		b.WriteString("(?:")
+		if err := emit(b, n.subs[0], false); err != nil {
+			return err
+		}
+		b.WriteString(")")
+		switch {
+		case n.min == 0 && n.max == -1:
+			b.WriteString("*")
+		case n.min == 1 && n.max == -1:
+			b.WriteString("+")
+		case n.min == 0 && n.max == 1:
+			b.WriteString("?")
+		case n.max == -1:
+			fmt.Fprintf(b, "{%d,}", n.min)
+		case n.min == n.max:
+			fmt.Fprintf(b, "{%d}", n.min)
+		default:
+			fmt.Fprintf(b, "{%d,%d}", n.min, n.max)
+		}
+		if !n.greedy {
+			b.WriteString("?")
+		}
+
+	case opBeginText:
+		b.WriteString(`\A`)
+	case opEndText:
+		b.WriteString(`\z`)
+	case opEndTextNewline:
+		// Perl's $ also matches before a final newline. When nothing follows,
+		// consuming that newline gives the same answer.
+		if !tail {
+			return &unsupportedError{"$ or \\Z before the end of the pattern"}
+		}
+		b.WriteString(`(?:\n?\z)`)
+	case opEndLine:
+		b.WriteString(`(?m:$)`)
+	case opBeginLine:
+		// Perl's /m ^ doesn't match after a newline that ends the text, RE2's does
+		return &unsupportedError{"^ with /m"}
+	case opWordBoundary:
+		b.WriteString(`\b`)
+	case opNoWordBoundary:
+		b.WriteString(`\B`)
+
+	case opLookahead:
+		return &unsupportedError{"lookahead"}
+	case opLookbehind:
+		return &unsupportedError{"lookbehind"}
+	case opAtomic:
+		return &unsupportedError{"atomic group or possessive quantifier"}
+	case opBackref:
+		return &unsupportedError{"backreference"}
+	default:
+		return &unsupportedError{fmt.Sprintf("node %d", n.op)}
+	}
+	return nil
+}
+
+// emitClass writes a byte set as a literal, a class or a dot
+func emitClass(b *strings.Builder, set *byteSet) {
+	if set.full() {
+		b.WriteString(`(?s:.)`)
+		return
+	}
+	if c, ok := set.single(); ok {
+		writeByte(b, c)
+		return
+	}
+	b.WriteString("[")
+	empty := true
+	for lo := 0; lo < 256; lo++ {
+		if !set.has(byte(lo)) {
+			continue
+		}
+		hi := lo
+		for hi+1 < 256 && set.has(byte(hi+1)) {
+			hi++
+		}
+		writeByte(b, byte(lo))
+		if hi > lo {
+			b.WriteString("-")
+			writeByte(b, byte(hi))
+		}
+		lo = hi
+		empty = false
+	}
+	if empty {
+		// An empty set never matches; the input holds no runes above 0xFF
+		b.WriteString(`\x{100}`)
+	}
+	b.WriteString("]")
+}
+
+// writeByte writes one byte as a rune of the Latin-1 input
+func writeByte(b *strings.Builder, c byte) {
+	if (c >= '0' && c <= '9') || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') {
+		b.WriteByte(c)
+		return
+	}
+	fmt.Fprintf(b, `\x{%02X}`, c)
+}
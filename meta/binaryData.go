@@ -0,0 +1,219 @@
+package meta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"greg-hacke/go-metadata/tags"
+)
+
+// binaryConv converts a value read from a binary table whose meaning
+// differs from its storage format, as an ExifTool ValueConv does
+type binaryConv func(value interface{}) interface{}
+
+// extractBinaryData reads big-endian data laid out as one of ExifTool's
+// binary tables, where tag IDs are offsets in units of the table's default
+// format. Tags are read in ID order, and a variable-length string moves
+// every tag after it along by its length. convs holds value conversions by
+// tag name.
+func (e *MetadataExtractor) extractBinaryData(namespace, directory string, table *tags.TagTable, format string, data []byte, offset int64, convs map[string]binaryConv) bool {
+	type entry struct {
+		index int
+		id    string
+	}
+	var entries []entry
+	for id := range table.Tags {
+		if n, err := strconv.Atoi(id); err == nil {
+			entries = append(entries, entry{n, id})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].index < entries[j].index })
+
+	unit := binaryFormatSize(format)
+	shift := 0
+	found := false
+	for _, en := range entries {
+		def := table.Tags[en.id]
+		f := def.Format
+		if f == "" {
+			f = format
+		}
+		pos := en.index*unit + shift
+		if pos >= len(data) {
+			break
+		}
+		var value interface{}
+		if f == "var_ustr32" {
+			s, n, ok := unicodeString32(data[pos:])
+			if !ok {
+				break
+			}
+			// The tag occupies four bytes in the table's layout
+			shift += n - 4
+			value = s
+		} else {
+			size := binaryFormatSize(f)
+			if pos+size > len(data) {
+				break
+			}
+			value = binaryValue(f, data[pos:pos+size])
+		}
+		if conv := convs[def.Name]; conv != nil {
+			value = conv(value)
+		}
+		if e.addTableField(namespace, directory, table, &def, en.id, value, offset+int64(pos)) {
+			found = true
+		}
+	}
+	return found
+}
+
+// addTableField records a value decoded from a tag table if it was requested
+func (e *MetadataExtractor) addTableField(namespace, directory string, table *tags.TagTable, def *tags.TagDef, id string, value interface{}, offset int64) bool {
+	g := groupsFor(namespace, directory, def)
+	if !e.filter.wants(g, def.Name) {
+		return false
+	}
+	printed := e.printConv(def, value)
+	e.metadata.addField(Field{
+		Namespace: g[0],
+		Directory: g[1],
+		Category:  g[2],
+		Key:       def.Name,
+		TagID:     id,
+		Raw:       value,
+		Value:     value,
+		Print:     printed,
+		Table:     tagTableName(table),
+	})
+	e.opts.emit(TraceEvent{Kind: TraceTag, Level: TraceLevelTags, Offset: offset, Name: def.Name, Detail: fmt.Sprintf("%s %s = %s", g[1], id, traceValue(printed))})
+	return true
+}
+
+// binaryFormat splits an ExifTool format such as "int16u[6]" into its base
+// format and count, which is 1 when not given or not a constant
+func binaryFormat(format string) (string, int) {
+	base, count, ok := strings.Cut(format, "[")
+	if !ok {
+		return base, 1
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(count, "]"))
+	if err != nil || n < 1 {
+		return base, 1
+	}
+	return base, n
+}
+
+// binaryFormatSize returns the size in bytes of a value in a binary table format
+func binaryFormatSize(format string) int {
+	base, count := binaryFormat(format)
+	size := 1
+	switch strings.TrimRight(base, "us") {
+	case "int16":
+		size = 2
+	case "int32", "fixed32":
+		size = 4
+	}
+	return size * count
+}
+
+// binaryValue decodes a big-endian value in the given format: integers as
+// int and fixed-point numbers as float64, or slices of them for arrays,
+// strings without their terminating null, and anything else as text when
+// it is printable and as binary data otherwise
+func binaryValue(format string, data []byte) interface{} {
+	base, count := binaryFormat(format)
+	switch base {
+	case "int8u", "int8s", "int16u", "int16s", "int32u", "int32s":
+		size := binaryFormatSize(base)
+		ints := make([]int, 0, count)
+		for pos := 0; pos+size <= len(data) && len(ints) < count; pos += size {
+			ints = append(ints, binaryInt(base, data[pos:]))
+		}
+		switch len(ints) {
+		case 0:
+			return data
+		case 1:
+			return ints[0]
+		}
+		return ints
+	case "fixed32u", "fixed32s":
+		floats := make([]float64, 0, count)
+		for pos := 0; pos+4 <= len(data) && len(floats) < count; pos += 4 {
+			floats = append(floats, fixed32(base, data[pos:]))
+		}
+		switch len(floats) {
+		case 0:
+			return data
+		case 1:
+			return floats[0]
+		}
+		return floats
+	case "string":
+		s, _, _ := bytes.Cut(data, []byte{0})
+		return string(s)
+	case "undef", "binary":
+		return data
+	}
+	if text := bytes.TrimRight(data, "\x00"); len(text) > 0 && isPrintable(text) {
+		return string(text)
+	}
+	return data
+}
+
+// binaryInt decodes one big-endian integer of an intNN[us] format
+func binaryInt(format string, data []byte) int {
+	switch format {
+	case "int8u":
+		return int(data[0])
+	case "int8s":
+		return int(int8(data[0]))
+	case "int16u":
+		return int(binary.BigEndian.Uint16(data))
+	case "int16s":
+		return int(int16(binary.BigEndian.Uint16(data)))
+	case "int32u":
+		return int(binary.BigEndian.Uint32(data))
+	}
+	return int(int32(binary.BigEndian.Uint32(data)))
+}
+
+// fixed32 decodes a 16.16 fixed-point number, rounded to five decimal
+// places as ExifTool does to drop insignificant digits
+func fixed32(format string, data []byte) float64 {
+	var v float64
+	if format == "fixed32s" {
+		v = float64(int32(binary.BigEndian.Uint32(data))) / 0x10000
+	} else {
+		v = float64(binary.BigEndian.Uint32(data)) / 0x10000
+	}
+	return math.Round(v*1e5) / 1e5
+}
+
+// unicodeString32 decodes a string stored as a 32-bit count of UTF-16 code
+// units followed by the units, returning the string and the number of
+// bytes it occupied
+func unicodeString32(data []byte) (string, int, bool) {
+	if len(data) < 4 {
+		return "", 0, false
+	}
+	count := int(binary.BigEndian.Uint32(data))
+	if count > (len(data)-4)/2 {
+		return "", 0, false
+	}
+	units := make([]uint16, count)
+	for i := range units {
+		units[i] = binary.BigEndian.Uint16(data[4+i*2:])
+	}
+	// Some writers count a terminating null
+	for len(units) > 0 && units[len(units)-1] == 0 {
+		units = units[:len(units)-1]
+	}
+	return string(utf16.Decode(units)), 4 + count*2, true
+}
@@ -2,9 +2,9 @@ package meta
 
 import (
 	"encoding/json"
-	"fmt"
 	"io"
 
+	"greg-hacke/go-metadata/formats"
 	"greg-hacke/go-metadata/tags"
 )
 
@@ -12,6 +12,17 @@ import (
 type Metadata struct {
 	// Dynamic storage for all metadata
 	Fields map[string]interface{} `json:"fields"`
+
+	// Every extracted field in the order it was found
+	List []Field `json:"-"`
+
+	// Non-fatal problems found while parsing, such as corrupt directories
+	Warnings []string `json:"-"`
+
+	numeric   bool           // Fields shows ValueConv instead of PrintConv values
+	names     map[string]int // Tag name -> index in List of the field keyed by the plain name
+	qualified map[string]int // "Group:Name" -> index in List of the first such field
+	document  int            // Embedded document fields are being added for, 0 for the file itself
 }
 
 // ToJSON converts metadata to JSON string
@@ -24,48 +35,64 @@ func (m *Metadata) ToJSON() (string, error) {
 }
 
 // CaptureMetadata extracts metadata dynamically based on tag tables
-func CaptureMetadata(file io.ReadSeeker, fileType *FileType, tagTables []*tags.TagTable, requested MetadataRequest) (*Metadata, error) {
-	fmt.Printf("\n=== Dynamic Metadata Extraction ===\n")
+func CaptureMetadata(file io.ReadSeeker, fileType *FileType, tagTables []*tags.TagTable, requested MetadataRequest, opts ...Option) (*Metadata, error) {
+	r, size, err := readerAtFor(file)
+	if err != nil {
+		return nil, err
+	}
+	return captureMetadata(r, size, fileType, tagTables, requested, newOptions(opts))
+}
+
+// CaptureMetadataAt extracts metadata from size bytes of random-access data
+func CaptureMetadataAt(r io.ReaderAt, size int64, fileType *FileType, tagTables []*tags.TagTable, requested MetadataRequest, opts ...Option) (*Metadata, error) {
+	return captureMetadata(r, size, fileType, tagTables, requested, newOptions(opts))
+}
+
+// captureMetadata extracts metadata using already resolved options
+func captureMetadata(r io.ReaderAt, size int64, fileType *FileType, tagTables []*tags.TagTable, requested MetadataRequest, o *options) (*Metadata, error) {
 
 	// Initialize metadata with dynamic fields
 	metadata := &Metadata{
-		Fields: make(map[string]interface{}),
+		Fields:  make(map[string]interface{}),
+		numeric: o.numeric,
+	}
+
+	filter := newTagFilter(requested)
+	addFileField := func(key string, value string) {
+		if filter.wants(groupsFor("File", "", nil), key) {
+			metadata.addField(Field{Namespace: "File", Key: key, Raw: value, Value: value})
+		}
 	}
 
 	// Add basic file type info
-	metadata.Fields["FileType"] = fileType.Format
+	addFileField("FileType", fileType.Format)
 	if fileType.Description != "" {
-		metadata.Fields["FileTypeDescription"] = fileType.Description
+		addFileField("FileTypeDescription", fileType.Description)
 	}
 
 	// Add MIME type if available
 	if mime, ok := tags.ExifToolFileTypes.MimeTypes[fileType.Format]; ok {
-		metadata.Fields["MIMEType"] = mime
-	}
-
-	// Reset file position
-	if _, err := file.Seek(0, io.SeekStart); err != nil {
-		return nil, fmt.Errorf("failed to seek to start: %w", err)
-	}
-
-	// Read file data (limited to 50MB)
-	fileData := make([]byte, 50*1024*1024)
-	n, err := file.Read(fileData)
-	if err != nil && err != io.EOF {
-		return nil, fmt.Errorf("failed to read file: %w", err)
+		addFileField("MIMEType", mime)
 	}
-	fileData = fileData[:n]
 
-	fmt.Printf("Scanning %d bytes for metadata patterns...\n", len(fileData))
+	o.logger.Debug("scanning for metadata patterns", "bytes", size)
 
 	// Create extractor and process
-	extractor := NewMetadataExtractor(fileData, metadata, tagTables)
+	extractor := newMetadataExtractor(r, size, metadata, tagTables, o)
+	extractor.filter = filter
+	extractor.fileType = fileType
+	switch {
+	case fileType.ExtensionMismatch && fileType.Confidence > formats.ConfidenceLow:
+		extractor.warnf("File has %s content but a %s extension", fileType.Format, fileType.Extension)
+	case fileType.ExtensionMismatch:
+		extractor.warnf("File content does not match its %s extension", fileType.Extension)
+	}
 	foundEmbedded, foundContainer := extractor.ExtractAll()
 
 	if !foundEmbedded && !foundContainer {
-		fmt.Println("No metadata patterns found")
+		o.logger.Debug("no metadata patterns found")
 	} else {
-		fmt.Printf("Extracted %d metadata fields\n", len(metadata.Fields)-3) // -3 for the file type fields
+		o.logger.Debug("metadata extracted", "fields", len(metadata.List))
 	}
 
 	return metadata, nil
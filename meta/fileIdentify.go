@@ -1,20 +1,18 @@
 package meta
 
 import (
-	"bytes"
-	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
-	"regexp"
-	"strconv"
 	"strings"
 
+	"greg-hacke/go-metadata/formats"
 	"greg-hacke/go-metadata/tags"
 )
 
-// MetadataRequest represents requested metadata fields
+// MetadataRequest selects which metadata fields to extract. Keys use
+// ExifTool's tag syntax ("EXIF:Make", "XMP-dc:*", "*GPS*", "-IPTC:All");
+// a false value or leading "-" excludes. An empty request selects everything.
 type MetadataRequest map[string]bool
 
 // FileType represents the identified file format
@@ -23,36 +21,37 @@ type FileType struct {
 	Module      string // Module name from ExifTool
 	Description string // Format description
 	Extension   string // File extension (e.g., "NEF", "CR2")
+
+	Confidence        formats.Confidence // How strongly the content supports Format
+	ExtensionMismatch bool               // The extension names a type the content does not match
 }
 
-// ProcessFileByPath processes a file from its path
-func ProcessFileByPath(filePath string, requested MetadataRequest) error {
+// ProcessFileByPath processes a file from its path and prints the result as JSON
+func ProcessFileByPath(filePath string, requested MetadataRequest, opts ...Option) error {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return fmt.Errorf("cannot open file: %w", err)
 	}
 	defer file.Close()
 
-	return processFileRawWithPath(file, filePath, requested)
+	return processFileRawWithPath(file, filePath, requested, newOptions(opts))
 }
 
-// ProcessFileRaw processes an already opened file
-func ProcessFileRaw(file io.ReadSeeker, requested MetadataRequest) error {
-	return processFileRawWithPath(file, "", requested)
+// ProcessFileRaw processes an already opened file and prints the result as JSON
+func ProcessFileRaw(file io.ReadSeeker, requested MetadataRequest, opts ...Option) error {
+	return processFileRawWithPath(file, "", requested, newOptions(opts))
 }
 
 // processFileRawWithPath processes a file with optional path for extension detection
-func processFileRawWithPath(file io.ReadSeeker, filePath string, requested MetadataRequest) error {
-	// Identify the file type
-	fileType, err := identifyFileWithPath(file, filePath)
+func processFileRawWithPath(file io.ReadSeeker, filePath string, requested MetadataRequest, o *options) error {
+	r, size, err := readerAtFor(file)
 	if err != nil {
-		return fmt.Errorf("cannot identify file: %w", err)
+		return err
 	}
 
-	// Reset to beginning for processing
-	_, err = file.Seek(0, io.SeekStart)
+	metadata, fileType, err := extractFromFile(r, size, filePath, requested, o)
 	if err != nil {
-		return fmt.Errorf("cannot seek to start: %w", err)
+		return err
 	}
 
 	// Log identified file information
@@ -63,17 +62,6 @@ func processFileRawWithPath(file io.ReadSeeker, filePath string, requested Metad
 		fmt.Printf("Extension:     %s\n", fileType.Extension)
 	}
 
-	// Find appropriate tag tables for this file type
-	tagTables := findTagTablesForFileType(fileType)
-	fmt.Printf("Tag Tables:    %d found\n", len(tagTables))
-
-	// Extract metadata based on file type
-	fmt.Println("\n=== Extracting Metadata ===")
-	metadata, err := CaptureMetadata(file, fileType, tagTables, requested)
-	if err != nil {
-		return fmt.Errorf("metadata extraction error: %w", err)
-	}
-
 	// Output as JSON
 	jsonOutput, err := metadata.ToJSON()
 	if err != nil {
@@ -86,15 +74,32 @@ func processFileRawWithPath(file io.ReadSeeker, filePath string, requested Metad
 	return nil
 }
 
-// findTagTablesForFileType finds the appropriate tag tables for a file type
-func findTagTablesForFileType(fileType *FileType) []*tags.TagTable {
-	fmt.Printf("\n=== Dynamic Tag Table Loading for %s ===\n", fileType.Format)
+// extractFromFile identifies a file, loads its tag tables and captures its metadata
+func extractFromFile(r io.ReaderAt, size int64, filePath string, requested MetadataRequest, o *options) (*Metadata, *FileType, error) {
+	// Identify the file type
+	fileType, err := identifyFileWithPath(r, size, filePath, o)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot identify file: %w", err)
+	}
+
+	// Find appropriate tag tables for this file type
+	tagTables := findTagTablesForFileType(fileType, o)
+
+	// Extract metadata based on file type
+	metadata, err := captureMetadata(r, size, fileType, tagTables, requested, o)
+	if err != nil {
+		return nil, nil, fmt.Errorf("metadata extraction error: %w", err)
+	}
+
+	return metadata, fileType, nil
+}
 
+// findTagTablesForFileType finds the appropriate tag tables for a file type
+func findTagTablesForFileType(fileType *FileType, o *options) []*tags.TagTable {
 	// Use a map to track loaded tables and avoid duplicates
 	loadedTables := make(map[string]*tags.TagTable)
 
 	// Step 1: Load base tables for the format
-	fmt.Println("Step 1: Loading base tables...")
 	baseCount := 0
 
 	// Collect modules to load
@@ -140,37 +145,36 @@ func findTagTablesForFileType(fileType *FileType) []*tags.TagTable {
 	}
 
 	// Load tables for all identified modules
-	for tableName, table := range tags.AllTags {
+	for _, tableName := range sortedTableNames() {
+		table := tags.AllTags[tableName]
 		for module := range modulesToLoad {
 			if strings.EqualFold(table.ModuleName, module) {
 				loadedTables[tableName] = table
 				baseCount++
-				fmt.Printf("  Loaded: %s\n", tableName)
+				o.emit(TraceEvent{Kind: TraceTable, Level: TraceLevelStructure, Offset: -1, Name: tableName, Detail: "base table for " + fileType.Format})
 				break
 			}
 		}
 	}
-	fmt.Printf("Base tables loaded: %d\n", baseCount)
 
 	// Step 2: Follow SubIFD references recursively
-	fmt.Println("\nStep 2: Following SubIFD references...")
-	followedCount := followSubIFDReferences(loadedTables)
-	fmt.Printf("Additional tables loaded via SubIFD: %d\n", followedCount)
+	followedCount := followSubIFDReferences(loadedTables, o)
 
-	// Convert to slice
+	// Convert to slice, in name order so tag lookups are deterministic
 	var tables []*tags.TagTable
-	for _, table := range loadedTables {
-		tables = append(tables, table)
+	for _, tableName := range sortedTableNames() {
+		if table, ok := loadedTables[tableName]; ok {
+			tables = append(tables, table)
+		}
 	}
 
-	fmt.Printf("\nTotal pre-loaded tables: %d\n", len(tables))
-	fmt.Println("Note: Additional tables will be loaded dynamically during parsing")
+	o.logger.Debug("tag tables pre-loaded", "format", fileType.Format, "base", baseCount, "subifd", followedCount, "total", len(tables))
 
 	return tables
 }
 
 // followSubIFDReferences recursively loads tables referenced via SubIFD
-func followSubIFDReferences(loadedTables map[string]*tags.TagTable) int {
+func followSubIFDReferences(loadedTables map[string]*tags.TagTable, o *options) int {
 	addedCount := 0
 	maxDepth := 5 // Prevent infinite recursion
 
@@ -192,7 +196,7 @@ func followSubIFDReferences(loadedTables map[string]*tags.TagTable) int {
 						if foundTable := findTableByName(tableName); foundTable != nil {
 							tablesToAdd[tableName] = foundTable
 							newTablesFound = true
-							fmt.Printf("  Following SubIFD: %s -> %s\n", tagDef.SubIFD, tableName)
+							o.emit(TraceEvent{Kind: TraceTable, Level: TraceLevelStructure, Offset: -1, Name: tableName, Detail: "SubIFD " + tagDef.SubIFD})
 						}
 					}
 				}
@@ -241,7 +245,8 @@ func findTableByName(tableName string) *tags.TagTable {
 
 	// Try variations of the name
 	// Sometimes the table name in SubIFD doesn't exactly match the key in AllTags
-	for name, table := range tags.AllTags {
+	for _, name := range sortedTableNames() {
+		table := tags.AllTags[name]
 		if strings.HasSuffix(name, "::"+tableName) ||
 			strings.EqualFold(name, tableName) {
 			return table
@@ -252,8 +257,8 @@ func findTableByName(tableName string) *tags.TagTable {
 }
 
 // LoadTablesForMetadataType dynamically loads tables when a metadata type is discovered during parsing
-func LoadTablesForMetadataType(metadataType string, currentTables map[string]*tags.TagTable) int {
-	fmt.Printf("  Dynamic: Loading tables for discovered %s metadata\n", metadataType)
+func LoadTablesForMetadataType(metadataType string, currentTables map[string]*tags.TagTable, opts ...Option) int {
+	o := newOptions(opts)
 
 	loadedCount := 0
 	metadataUpper := strings.ToUpper(metadataType)
@@ -270,13 +275,13 @@ func LoadTablesForMetadataType(metadataType string, currentTables map[string]*ta
 			strings.Contains(moduleUpper, metadataUpper) {
 			currentTables[tableName] = table
 			loadedCount++
-			fmt.Printf("    Loaded: %s\n", tableName)
+			o.emit(TraceEvent{Kind: TraceTable, Level: TraceLevelStructure, Offset: -1, Name: tableName, Detail: "discovered " + metadataType + " metadata"})
 		}
 	}
 
 	// Also follow any new SubIFD references
 	if loadedCount > 0 {
-		additionalCount := followSubIFDReferences(currentTables)
+		additionalCount := followSubIFDReferences(currentTables, o)
 		loadedCount += additionalCount
 	}
 
@@ -284,71 +289,49 @@ func LoadTablesForMetadataType(metadataType string, currentTables map[string]*ta
 }
 
 // identifyFileWithPath determines file type using ExifTool data
-func identifyFileWithPath(file io.ReadSeeker, filePath string) (*FileType, error) {
-	// Read header for magic byte detection
-	header := make([]byte, 1024)
-	n, err := file.Read(header)
-	if err != nil && err != io.EOF {
-		return nil, fmt.Errorf("cannot read file header: %w", err)
-	}
-	header = header[:n]
-
-	// Reset position
-	_, err = file.Seek(0, io.SeekStart)
+func identifyFileWithPath(r io.ReaderAt, size int64, filePath string, o *options) (*FileType, error) {
+	id, err := formats.IdentifyReader(r, size, filePath)
 	if err != nil {
 		return nil, err
 	}
-
-	// Debug: show first 16 bytes
-	fmt.Printf("Header bytes: %s\n", hex.EncodeToString(header[:16]))
-
-	// Get extension for later use
-	var ext string
-	if filePath != "" {
-		ext = strings.ToUpper(strings.TrimPrefix(filepath.Ext(filePath), "."))
+	ext := id.Extension
+	for _, c := range id.Candidates {
+		o.logger.Debug("file type candidate", "type", c.FileType, "confidence", c.Confidence, "magic", c.Magic, "extension", c.Extension)
 	}
 
-	// Try magic byte detection first (in TestOrder)
-	// This ensures we check in the priority order ExifTool uses
-	for _, fileType := range tags.ExifToolFileTypes.TestOrder {
-		pattern, hasPattern := tags.ExifToolFileTypes.MagicNumbers[fileType]
-		if !hasPattern {
-			continue
-		}
-
-		matched, err := matchPerlPattern(header, pattern)
-		if err != nil {
-			continue
-		}
-
-		if matched {
-			fmt.Printf("Magic match: %s (pattern: %s)\n", fileType, pattern)
-
-			// For formats that have many variants (like TIFF-based RAW files),
-			// check if we can get more specific info from the extension
-			if shouldUseExtensionForSpecificity(fileType, filePath) {
-				if extInfo, ok := tags.ExifToolFileTypes.Extensions[ext]; ok {
-					// Only use extension if it maps to the same base type
-					if extInfo.Type == fileType || resolveBaseType(extInfo.Type) == fileType {
-						fmt.Printf("Using extension for specific format: %s\n", ext)
-						return resolveFileType(extInfo.Type, ext)
-					}
-				}
+	best, ok := id.Best()
+	if !ok {
+		// Fall back to extension if available
+		if extInfo, ok := tags.ExifToolFileTypes.Extensions[ext]; ok {
+			o.logger.Debug("extension fallback", "extension", ext)
+			fileType, err := resolveFileType(extInfo.Type, ext)
+			if err == nil {
+				fileType.Confidence = formats.ConfidenceLow
+				fileType.ExtensionMismatch = id.Mismatch
 			}
-
-			return resolveFileType(fileType, ext)
+			return fileType, err
 		}
+		return &FileType{Format: "UNKNOWN", Module: "", Description: "Unknown format", Extension: ""}, nil
 	}
 
-	// Fall back to extension if available
-	if ext != "" {
-		fmt.Printf("Extension fallback: %s\n", ext)
+	fileType, err := resolveFileType(best.FileType, ext)
+	// For formats that have many variants (like TIFF-based RAW files),
+	// check if we can get more specific info from the extension
+	if best.Magic && shouldUseExtensionForSpecificity(best.FileType, filePath) {
 		if extInfo, ok := tags.ExifToolFileTypes.Extensions[ext]; ok {
-			return resolveFileType(extInfo.Type, ext)
+			// Only use extension if it maps to the same base type
+			if extInfo.Type == best.FileType || resolveBaseType(extInfo.Type) == best.FileType {
+				o.logger.Debug("using extension for specific format", "extension", ext)
+				fileType, err = resolveFileType(extInfo.Type, ext)
+			}
 		}
 	}
-
-	return &FileType{Format: "UNKNOWN", Module: "", Description: "Unknown format", Extension: ""}, nil
+	if err != nil {
+		return nil, err
+	}
+	fileType.Confidence = best.Confidence
+	fileType.ExtensionMismatch = id.Mismatch
+	return fileType, nil
 }
 
 // shouldUseExtensionForSpecificity checks if we should prefer extension for certain base types
@@ -445,193 +428,3 @@ func resolveFileType(fileType string, originalExt string) (*FileType, error) {
 		Extension:   originalExt,
 	}, nil
 }
-
-// matchPerlPattern matches data against a Perl-style regex pattern
-func matchPerlPattern(data []byte, pattern string) (bool, error) {
-	// Skip non-pattern entries
-	if pattern == "RawConv" {
-		return false, nil
-	}
-
-	// Try to parse as a simple byte pattern first
-	if simpleMatch, matched := trySimpleByteMatch(data, pattern); matched {
-		return simpleMatch, nil
-	}
-
-	// For complex patterns, we need to be more careful about regex matching
-	// Many patterns should only match at the start of the file
-
-	// Convert pattern to Go regex
-	goPattern, err := convertPerlToGoRegex(pattern)
-	if err != nil {
-		return false, err
-	}
-
-	re, err := regexp.Compile(goPattern)
-	if err != nil {
-		return false, nil // Silently fail on bad regex
-	}
-
-	// For patterns with alternatives separated by |, we need to check each part
-	// Some parts might be too generic (like \s*< in PLIST pattern)
-	if strings.Contains(pattern, "|") {
-		// Split alternatives and check each one more carefully
-		// But for now, just ensure we match at position 0
-		loc := re.FindIndex(data)
-		return loc != nil && loc[0] == 0, nil
-	}
-
-	// For patterns with optional prefix like (....)?, check both positions
-	if strings.Contains(pattern, ")?") {
-		// These patterns can match at offset 0 or after the optional part
-		matches := re.FindAllIndex(data, -1)
-		for _, match := range matches {
-			// Only accept matches at expected positions
-			if match[0] == 0 || (strings.HasPrefix(pattern, "(....)") && match[0] == 4) {
-				return true, nil
-			}
-		}
-		return false, nil
-	}
-
-	// Standard match - must be at start
-	loc := re.FindIndex(data)
-	return loc != nil && loc[0] == 0, nil
-}
-
-// trySimpleByteMatch attempts to match simple byte patterns without regex
-func trySimpleByteMatch(data []byte, pattern string) (matches bool, handled bool) {
-	// Check for offset patterns like ".{4}\x57\x90\x75\x36"
-	if strings.HasPrefix(pattern, ".{") {
-		endIdx := strings.Index(pattern, "}")
-		if endIdx > 2 {
-			offsetStr := pattern[2:endIdx]
-			offset, err := strconv.Atoi(offsetStr)
-			if err == nil && endIdx+1 < len(pattern) {
-				// Parse the bytes after the offset
-				remaining := pattern[endIdx+1:]
-				if matchBytes, ok := parseSimpleBytes(remaining); ok {
-					if len(data) >= offset+len(matchBytes) {
-						return bytes.Equal(data[offset:offset+len(matchBytes)], matchBytes), true
-					}
-					return false, true
-				}
-			}
-		}
-	}
-
-	// Try to parse as simple byte sequence
-	if matchBytes, ok := parseSimpleBytes(pattern); ok {
-		return bytes.HasPrefix(data, matchBytes), true
-	}
-
-	return false, false
-}
-
-// parseSimpleBytes tries to parse a pattern as a simple byte sequence
-func parseSimpleBytes(pattern string) ([]byte, bool) {
-	// Quick check for regex metacharacters
-	if strings.ContainsAny(pattern, "()[]{}|?*+^$.") {
-		return nil, false
-	}
-
-	result := []byte{}
-	i := 0
-
-	for i < len(pattern) {
-		if i+1 < len(pattern) && pattern[i] == '\\' {
-			switch pattern[i+1] {
-			case 'x':
-				if i+3 < len(pattern) {
-					if b, err := strconv.ParseUint(pattern[i+2:i+4], 16, 8); err == nil {
-						result = append(result, byte(b))
-						i += 4
-						continue
-					}
-				}
-				return nil, false
-			case '0':
-				result = append(result, 0)
-				i += 2
-				continue
-			case 'r':
-				result = append(result, '\r')
-				i += 2
-				continue
-			case 'n':
-				result = append(result, '\n')
-				i += 2
-				continue
-			case 's', 'S', 'd', 'D', 'w', 'W':
-				// These are regex constructs
-				return nil, false
-			default:
-				// Escaped character
-				result = append(result, pattern[i+1])
-				i += 2
-				continue
-			}
-		} else {
-			result = append(result, pattern[i])
-			i++
-		}
-	}
-
-	return result, true
-}
-
-// convertPerlToGoRegex converts a Perl regex pattern to Go regex
-func convertPerlToGoRegex(pattern string) (string, error) {
-	// First pass: convert hex escapes and special sequences
-	result := ""
-	i := 0
-
-	for i < len(pattern) {
-		if i+3 < len(pattern) && pattern[i] == '\\' && pattern[i+1] == 'x' {
-			// Convert \xHH to Go format
-			if b, err := strconv.ParseUint(pattern[i+2:i+4], 16, 8); err == nil {
-				// Use hex format in Go regex
-				result += fmt.Sprintf("\\x%02x", b)
-				i += 4
-				continue
-			}
-		}
-
-		if i+1 < len(pattern) && pattern[i] == '\\' && pattern[i+1] == '0' {
-			// Convert \0 to \x00
-			result += "\\x00"
-			i += 2
-			continue
-		}
-
-		// Handle character classes that need conversion
-		if i+1 < len(pattern) && pattern[i] == '\\' {
-			switch pattern[i+1] {
-			case 's':
-				result += `\s`
-				i += 2
-				continue
-			case 'S':
-				result += `\S`
-				i += 2
-				continue
-			case 'd':
-				result += `\d`
-				i += 2
-				continue
-			case 'D':
-				result += `\D`
-				i += 2
-				continue
-			}
-		}
-
-		// Copy other characters as-is
-		result += string(pattern[i])
-		i++
-	}
-
-	// Don't add anchors - let the match function handle positioning
-
-	return result, nil
-}
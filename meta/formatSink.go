@@ -0,0 +1,191 @@
+package meta
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"sort"
+	"strings"
+
+	"greg-hacke/go-metadata/formats"
+	"greg-hacke/go-metadata/tags"
+)
+
+// formatSink receives what a formats.Handler finds and records it through the extractor
+type formatSink struct {
+	e     *MetadataExtractor
+	found bool // Whether any field was recorded
+
+	xmp      [][]byte          // XMP packets, decoded once the walk is done
+	extended map[string][]byte // Extended XMP packets by GUID
+}
+
+// formatHandler returns the handler for the identified file type, or the
+// first registered handler that recognizes the file's header
+func (e *MetadataExtractor) formatHandler() (string, formats.Handler) {
+	if e.fileType != nil {
+		for _, name := range []string{e.fileType.Format, e.fileType.Module} {
+			if h := formats.Lookup(name); h != nil {
+				return name, h
+			}
+		}
+	}
+	return formats.Sniff(e.header)
+}
+
+// Wants reports whether a requested tag could be in group
+func (s *formatSink) Wants(group, name string) bool {
+	if name != "" {
+		return s.e.filter.wants(tagGroups{group, "", ""}, name)
+	}
+	if group == "EXIF" {
+		return s.e.wantsEXIF()
+	}
+	return s.e.filter.wantsGroup(tagGroups{group, ""})
+}
+
+// Tag resolves a handler's tag against its table and records it if requested
+func (s *formatSink) Tag(tag formats.Tag) {
+	table := tags.AllTags[tag.Table]
+	var def *tags.TagDef
+	if table != nil {
+		if d, ok := table.Tags[tag.ID]; ok {
+			def = &d
+		}
+	}
+	name := tag.Name
+	if def != nil && def.Name != "" {
+		name = def.Name
+	}
+
+	g := groupsFor(tag.Group, tag.Directory, def)
+	if !s.e.filter.wants(g, name) {
+		return
+	}
+	field := Field{
+		Namespace: g[0],
+		Directory: g[1],
+		Category:  g[2],
+		Key:       name,
+		TagID:     tag.ID,
+		Raw:       tag.Value,
+		Value:     tag.Value,
+		Print:     s.e.printConv(def, tag.Value),
+		Table:     tagTableName(table),
+	}
+	if def == nil {
+		field.Print = printValue(name, tag.Value)
+	}
+	s.e.metadata.addField(field)
+	s.e.opts.emit(TraceEvent{Kind: TraceTag, Level: TraceLevelTags, Offset: -1, Name: g[1] + ":" + name, Detail: traceValue(field.Print)})
+	s.found = true
+}
+
+// Block decodes an embedded metadata block, skipping blocks that cannot hold a requested tag
+func (s *formatSink) Block(kind formats.BlockKind, r io.ReaderAt, offset, size int64) bool {
+	e := s.e
+	var found bool
+	switch kind {
+	case formats.BlockTIFF:
+		found = e.extractTIFFMetadata(r, offset, size)
+
+	case formats.BlockXMP:
+		if !e.filter.wantsGroup(tagGroups{"XMP", ""}) {
+			return false
+		}
+		data, err := readBlock(r, offset, size)
+		if err != nil {
+			e.warnf("XMP at offset %d could not be read: %v", offset, err)
+			return false
+		}
+		s.xmp = append(s.xmp, data)
+		found = true
+
+	case formats.BlockExtendedXMP:
+		if !e.filter.wantsGroup(tagGroups{"XMP", ""}) {
+			return false
+		}
+		data, err := readBlock(r, offset, size)
+		if err != nil {
+			e.warnf("Extended XMP could not be read: %v", err)
+			return false
+		}
+		sum := md5.Sum(data)
+		if s.extended == nil {
+			s.extended = make(map[string][]byte)
+		}
+		s.extended[strings.ToUpper(hex.EncodeToString(sum[:]))] = data
+		found = true
+
+	case formats.BlockIPTC:
+		if !e.filter.wantsGroup(groupsFor("IPTC", "", nil)) {
+			return false
+		}
+		data, err := readBlock(r, offset, size)
+		if err != nil {
+			e.warnf("IPTC data at offset %d could not be read: %v", offset, err)
+			return false
+		}
+		e.loadModuleIfNeeded("IPTC")
+		found = e.extractIPTCData(data, int(offset))
+
+	case formats.BlockPhotoshop:
+		if !e.wantsPhotoshop() {
+			return false
+		}
+		data, err := readBlock(r, offset, size)
+		if err != nil {
+			e.warnf("Photoshop data at offset %d could not be read: %v", offset, err)
+			return false
+		}
+		found = e.extractPhotoshop(data, offset)
+
+	case formats.BlockICC:
+		if !e.filter.wantsGroup(tagGroups{"ICC_Profile", ""}) {
+			return false
+		}
+		data, err := readBlock(r, offset, size)
+		if err != nil {
+			e.warnf("ICC_Profile at offset %d could not be read: %v", offset, err)
+			return false
+		}
+		found = e.extractICCProfile(data, offset)
+
+	case formats.BlockMPF:
+		// Read even when no MPF tag is wanted, to find the embedded documents
+		found = e.extractMPF(r, offset, size)
+
+	default:
+		e.opts.logger.Debug("unsupported metadata block", "kind", kind.String(), "offset", offset)
+	}
+	s.found = s.found || found
+	return found
+}
+
+// flushXMP decodes the XMP packets found in the file, merging in the
+// extended XMP each names. Extended packets are only found after the
+// standard one, so decoding waits until the whole file has been walked.
+func (s *formatSink) flushXMP() {
+	for _, data := range s.xmp {
+		s.e.extractXMPPacket(data, s.extended)
+	}
+	guids := make([]string, 0, len(s.extended))
+	for guid := range s.extended {
+		guids = append(guids, guid)
+	}
+	sort.Strings(guids)
+	for _, guid := range guids {
+		s.e.warnf("Ignored extended XMP with GUID %s not named by HasExtendedXMP", guid)
+	}
+	s.xmp, s.extended = nil, nil
+}
+
+// Segment emits a trace event for a structural element of the file
+func (s *formatSink) Segment(offset int64, name, detail string) {
+	s.e.opts.emit(TraceEvent{Kind: TraceSegment, Level: TraceLevelStructure, Offset: offset, Name: name, Detail: detail})
+}
+
+// Warn records a non-fatal problem with the file
+func (s *formatSink) Warn(format string, args ...interface{}) {
+	s.e.warnf(format, args...)
+}
@@ -0,0 +1,170 @@
+package meta
+
+import (
+	"fmt"
+	"strings"
+
+	"greg-hacke/go-metadata/tags"
+)
+
+// tagGroups names the groups a tag belongs to, indexed by family, as in ExifTool:
+// family 0 is the general location ("EXIF", "XMP"), family 1 the specific
+// directory or namespace ("IFD0", "XMP-dc") and family 2 the kind of
+// information ("Camera", "Time"). An empty name means not yet known.
+type tagGroups [3]string
+
+// groupsFor derives the groups of a tag from its namespace, the directory it
+// was read from and its definition. An empty directory defaults to the
+// namespace, and the category comes from the definition when it names one.
+func groupsFor(namespace, directory string, def *tags.TagDef) tagGroups {
+	if directory == "" {
+		directory = namespace
+	}
+	category := ""
+	if def != nil {
+		category = def.Groups["2"]
+	}
+	if category == "" {
+		category = defaultCategory(namespace, directory)
+	}
+	return tagGroups{namespace, directory, category}
+}
+
+// defaultCategory returns the family 2 group used by a directory's table
+// when the tag itself doesn't name one
+func defaultCategory(namespace, directory string) string {
+	switch {
+	case namespace == "MakerNotes":
+		return "Camera"
+	case directory == "GPS":
+		return "Location"
+	case namespace == "EXIF", namespace == "PNG", namespace == "Photoshop", namespace == "ICC_Profile":
+		return "Image"
+	case namespace == "JFIF", namespace == "APP14", namespace == "MPF", namespace == "GIF", namespace == "RIFF":
+		return "Image"
+	case namespace == "QuickTime" && directory == "Meta":
+		return "Image"
+	case namespace == "QuickTime":
+		return "Video"
+	case namespace == "ExifTool":
+		return "ExifTool"
+	}
+	return "Other"
+}
+
+// Group returns the name of the field's group in the given family (0 to 3)
+func (f Field) Group(family int) string {
+	switch family {
+	case 0:
+		return f.Namespace
+	case 1:
+		return f.Directory
+	case 2:
+		return f.Category
+	case 3:
+		if f.Document > 0 {
+			return fmt.Sprintf("Doc%d", f.Document)
+		}
+		return "Main"
+	}
+	return ""
+}
+
+// QualifiedKey returns the tag name qualified by its family 1 group, e.g.
+// "IFD1:XResolution", and for embedded documents by its family 3 group
+// too, e.g. "Doc1:IFD0:Make"
+func (f Field) QualifiedKey() string {
+	if f.Document > 0 {
+		return f.Group(3) + ":" + f.Directory + ":" + f.Key
+	}
+	return f.Directory + ":" + f.Key
+}
+
+// groupPriority orders groups for the unqualified tag name: when several
+// fields share a name, the one from the earliest group here keeps the plain
+// name and the others are keyed by their family 1 group. Groups not listed
+// rank after these, and ties go to the field found first.
+var groupPriority = []string{
+	"File", "IFD0", "ExifIFD", "GPS", "InteropIFD", "SubIFD", "IFD1",
+	"MakerNotes", "IPTC", "XMP", "PNG",
+}
+
+// groupRank returns the position of the field's group in groupPriority.
+// Fields of embedded documents rank after every field of the file itself.
+func groupRank(f *Field) int {
+	if f.Document > 0 {
+		main := *f
+		main.Document = 0
+		return (len(groupPriority)+1)*f.Document + groupRank(&main)
+	}
+	// Numbered directories rank with their base: SubIFD1 as SubIFD, IFD2 as IFD1
+	dir := f.Directory
+	switch base := strings.TrimRight(dir, "0123456789"); {
+	case base == "IFD" && dir != "IFD0":
+		dir = "IFD1"
+	case base == "SubIFD":
+		dir = base
+	}
+	for i, name := range groupPriority {
+		if name == dir || name == f.Namespace {
+			return i
+		}
+	}
+	return len(groupPriority)
+}
+
+// addField records a field in both the ordered list and the keyed view.
+// Missing groups are filled in, and the field is keyed by its plain tag
+// name unless a higher-priority field already has it, in which case the
+// key is qualified with its family 1 group.
+func (m *Metadata) addField(field Field) {
+	if field.Document == 0 {
+		field.Document = m.document
+	}
+	g := groupsFor(field.Namespace, field.Directory, nil)
+	field.Directory = g[1]
+	if field.Category == "" {
+		field.Category = g[2]
+	}
+	if field.Print == "" {
+		field.Print = formatValue(field.Value)
+	}
+	if m.names == nil {
+		m.names = make(map[string]int)
+		m.qualified = make(map[string]int)
+	}
+
+	index := len(m.List)
+	qualified := field.QualifiedKey()
+	if _, exists := m.qualified[qualified]; exists {
+		// The same tag again in the same group is only kept in the list
+		m.List = append(m.List, field)
+		return
+	}
+	m.qualified[qualified] = index
+
+	field.key = field.Key
+	if holder, exists := m.names[field.Key]; exists {
+		if groupRank(&field) < groupRank(&m.List[holder]) {
+			// The new field outranks the current holder, which moves to its qualified key
+			old := &m.List[holder]
+			delete(m.Fields, old.key)
+			old.key = old.QualifiedKey()
+			m.Fields[old.key] = m.outputValue(*old)
+			m.names[field.Key] = index
+		} else {
+			field.key = qualified
+		}
+	} else {
+		m.names[field.Key] = index
+	}
+
+	m.List = append(m.List, field)
+	m.Fields[field.key] = m.outputValue(field)
+}
+
+// lookup returns the index of the first field with the given family 1 group and tag name
+func (m *Metadata) lookup(directory, name string) (int, bool) {
+	i, ok := m.qualified[directory+":"+name]
+	return i, ok
+}
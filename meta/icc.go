@@ -0,0 +1,217 @@
+package meta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"greg-hacke/go-metadata/tags"
+)
+
+// iccHeaderSize is the length of the fixed profile header before the tag table
+const iccHeaderSize = 128
+
+// iccBinaryTables maps the binary tables ICC tags point to onto the family
+// 1 group ExifTool gives them
+var iccBinaryTables = map[string]string{
+	"ICC_Profile::Header":            "ICC-header",
+	"ICC_Profile::Measurement":       "ICC-meas",
+	"ICC_Profile::ViewingConditions": "ICC-view",
+	"ICC_Profile::Chromaticity":      "ICC-chrm",
+}
+
+// iccValueConvs convert header values to the form ExifTool returns
+var iccValueConvs = map[string]binaryConv{
+	"ProfileDateTime": iccDateTime,
+	"ProfileID": func(v interface{}) interface{} {
+		ints, ok := v.([]int)
+		if !ok {
+			return v
+		}
+		id := make([]byte, len(ints))
+		for i, b := range ints {
+			id[i] = byte(b)
+		}
+		return hex.EncodeToString(id)
+	},
+}
+
+// extractICCProfile decodes an ICC color profile: the header, then each tag
+// in the tag table that has a definition in ICC_Profile::Main. Text is
+// read from desc, text and mluc tags, with translations named like
+// ExifTool's "ProfileDescription-fr-FR".
+func (e *MetadataExtractor) extractICCProfile(data []byte, offset int64) bool {
+	if len(data) < iccHeaderSize+4 {
+		e.warnf("ICC_Profile at offset %d is too short (%d bytes)", offset, len(data))
+		return false
+	}
+	size := int64(binary.BigEndian.Uint32(data[0:4]))
+	if size < iccHeaderSize+4 || size > int64(len(data)) {
+		e.warnf("Bad ICC_Profile length (%d)", size)
+		return false
+	}
+	data = data[:size]
+	e.loadModuleIfNeeded("ICC_Profile")
+
+	found := false
+	if header := tags.AllTags["ICC_Profile::Header"]; header != nil {
+		found = e.extractBinaryData("ICC_Profile", iccBinaryTables["ICC_Profile::Header"], header, "int8u", data[:iccHeaderSize], offset, iccValueConvs)
+	}
+
+	table := tags.AllTags["ICC_Profile::Main"]
+	count := int(binary.BigEndian.Uint32(data[iccHeaderSize:]))
+	if count > (len(data)-iccHeaderSize-4)/12 {
+		e.warnf("ICC_Profile tag table at offset %d is truncated", offset+iccHeaderSize)
+		count = (len(data) - iccHeaderSize - 4) / 12
+	}
+	for i := 0; i < count; i++ {
+		entry := data[iccHeaderSize+4+i*12:]
+		sig := string(entry[0:4])
+		tagOffset := int(binary.BigEndian.Uint32(entry[4:8]))
+		tagSize := int(binary.BigEndian.Uint32(entry[8:12]))
+		if tagOffset < iccHeaderSize || tagSize > len(data)-tagOffset {
+			e.warnf("ICC_Profile tag %q at offset %d runs past the end of the profile", sig, offset+int64(tagOffset))
+			continue
+		}
+		value := data[tagOffset : tagOffset+tagSize]
+		valueOffset := offset + int64(tagOffset)
+
+		def := lookupTag(table, sig)
+		if def == nil {
+			e.opts.emit(TraceEvent{Kind: TraceTag, Level: TraceLevelDetail, Offset: valueOffset, Name: sig, Detail: fmt.Sprintf("unknown ICC tag, %d bytes", tagSize)})
+			continue
+		}
+		if sub := subDirectoryTable(*def); sub != nil {
+			if dir, ok := iccBinaryTables[tagTableName(sub)]; ok && e.extractBinaryData("ICC_Profile", dir, sub, "int8u", value, valueOffset, iccValueConvs) {
+				found = true
+			}
+			continue
+		}
+
+		for _, s := range iccTagValue(value) {
+			d := *def
+			if s.lang != "" {
+				d.Name += "-" + s.lang
+			}
+			if e.addTableField("ICC_Profile", "", table, &d, sig, s.value, valueOffset) {
+				found = true
+			}
+		}
+	}
+
+	// The whole profile, as ExifTool's ICC_Profile tag
+	if e.filter.wants(groupsFor("ICC_Profile", "", nil), "ICC_Profile") {
+		e.metadata.addField(Field{Namespace: "ICC_Profile", Key: "ICC_Profile", Raw: data, Value: data})
+	}
+	return found
+}
+
+// iccValue is a decoded tag value and the language of its text, such as
+// "fr-FR", or "" for the default
+type iccValue struct {
+	lang  string
+	value interface{}
+}
+
+// iccTagValue decodes an ICC tag according to the type signature that
+// starts its data. Types without a simple value are returned as binary data.
+func iccTagValue(data []byte) []iccValue {
+	if len(data) < 8 {
+		return []iccValue{{value: data}}
+	}
+	var value interface{} = data
+	switch string(data[0:4]) {
+	case "desc":
+		// textDescriptionType: an ASCII count and string, then Unicode and
+		// ScriptCode versions that ExifTool ignores
+		if len(data) >= 12 {
+			n := min(int(binary.BigEndian.Uint32(data[8:12])), len(data)-12)
+			value = iccASCII(data[12 : 12+n])
+		}
+	case "text":
+		value = iccASCII(data[8:])
+	case "sig ":
+		if len(data) >= 12 {
+			value = string(data[8:12])
+		}
+	case "XYZ ":
+		value = binaryValue(fmt.Sprintf("fixed32s[%d]", (len(data)-8)/4), data[8:])
+	case "dtim":
+		if len(data) >= 20 {
+			value = iccDateTime(binaryValue("int16u[6]", data[8:20]))
+		}
+	case "mluc":
+		return iccMultiLocalized(data)
+	}
+	return []iccValue{{value: value}}
+}
+
+// iccMultiLocalized decodes a multiLocalizedUnicodeType: records of a
+// language, a country and the offset and length of a UTF-16 string. US
+// English is the default, as are records without a valid language.
+func iccMultiLocalized(data []byte) []iccValue {
+	if len(data) < 16 {
+		return nil
+	}
+	count := int(binary.BigEndian.Uint32(data[8:12]))
+	recordSize := int(binary.BigEndian.Uint32(data[12:16]))
+	if recordSize < 12 {
+		return nil
+	}
+	var values []iccValue
+	for i := 0; i < count; i++ {
+		pos := 16 + i*recordSize
+		if pos+12 > len(data) {
+			break
+		}
+		lang := iccLanguage(data[pos : pos+4])
+		n := int(binary.BigEndian.Uint32(data[pos+4 : pos+8]))
+		start := int(binary.BigEndian.Uint32(data[pos+8 : pos+12]))
+		if start > len(data) || n > len(data)-start {
+			break
+		}
+		units := make([]uint16, n/2)
+		for j := range units {
+			units[j] = binary.BigEndian.Uint16(data[start+j*2:])
+		}
+		text := strings.TrimRight(string(utf16.Decode(units)), "\x00")
+		values = append(values, iccValue{lang, text})
+	}
+	return values
+}
+
+// iccLanguage converts the language and country of a multiLocalizedUnicode
+// record, such as "frFR", to "fr-FR". It returns "" for US English and for
+// codes that are not four letters.
+func iccLanguage(code []byte) string {
+	for _, c := range code {
+		// Folds upper case onto lower case, and other bytes outside a-z
+		if c|0x20 < 'a' || c|0x20 > 'z' {
+			return ""
+		}
+	}
+	lang := strings.ToLower(string(code[0:2])) + "-" + strings.ToUpper(string(code[2:4]))
+	if lang == "en-US" {
+		return ""
+	}
+	return lang
+}
+
+// iccASCII returns text up to its terminating null
+func iccASCII(data []byte) string {
+	s, _, _ := bytes.Cut(data, []byte{0})
+	return string(s)
+}
+
+// iccDateTime converts the six 16-bit fields of an ICC dateTimeNumber to a time
+func iccDateTime(v interface{}) interface{} {
+	f, ok := v.([]int)
+	if !ok || len(f) != 6 {
+		return v
+	}
+	return time.Date(f[0], time.Month(f[1]), f[2], f[3], f[4], f[5], 0, time.UTC)
+}
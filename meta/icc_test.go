@@ -0,0 +1,118 @@
+package meta
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"unicode/utf16"
+
+	"greg-hacke/go-metadata/tags"
+)
+
+// iccTestProfile builds a profile with a multiLocalizedUnicode description
+// in US English, French and an invalid language, a text copyright and an
+// XYZ white point
+func iccTestProfile() []byte {
+	utf16be := func(s string) []byte {
+		var b []byte
+		for _, u := range utf16.Encode([]rune(s)) {
+			b = append(b, byte(u>>8), byte(u))
+		}
+		return b
+	}
+	records := []struct{ code, text string }{{"enUS", "Display"}, {"frFR", "Écran"}, {"d1EU", "Anzeige"}}
+	mluc := be([]byte("mluc"), uint32(0), uint32(len(records)), uint32(12))
+	var strs []byte
+	for _, r := range records {
+		text := utf16be(r.text)
+		mluc = append(mluc, r.code...)
+		mluc = append(mluc, be(uint32(len(text)), uint32(16+12*len(records)+len(strs)))...)
+		strs = append(strs, text...)
+	}
+	mluc = append(mluc, strs...)
+
+	elements := []struct {
+		sig  string
+		data []byte
+	}{
+		{"desc", mluc},
+		{"cprt", append([]byte("text\x00\x00\x00\x00"), "No copyright\x00"...)},
+		{"wtpt", be([]byte("XYZ "), uint32(0), int32(0xF351), int32(0x10000), int32(0x116CC))},
+	}
+	var table, data []byte
+	offset := iccHeaderSize + 4 + 12*len(elements)
+	for _, el := range elements {
+		table = append(table, be([]byte(el.sig), uint32(offset+len(data)), uint32(len(el.data)))...)
+		data = append(data, el.data...)
+	}
+	size := offset + len(data)
+	header := append(be(uint32(size)), make([]byte, iccHeaderSize-4)...)
+	return bytes.Join([][]byte{header, be(uint32(len(elements))), table, data}, nil)
+}
+
+func TestICCProfile(t *testing.T) {
+	withTables(t, map[string]*tags.TagTable{
+		"ICC_Profile::Main": {ModuleName: "ICC_Profile", Tags: map[string]tags.TagDef{
+			"desc": {ID: "desc", Name: "ProfileDescription"},
+			"cprt": {ID: "cprt", Name: "ProfileCopyright"},
+			"wtpt": {ID: "wtpt", Name: "MediaWhitePoint"},
+		}},
+	})
+	e := testExtractor(nil)
+	profile := iccTestProfile()
+	if !e.extractICCProfile(profile, 0) {
+		t.Fatal("no tags found")
+	}
+	if len(e.metadata.Warnings) > 0 {
+		t.Errorf("warnings: %q", e.metadata.Warnings)
+	}
+	checkFields(t, e.metadata, []fieldCheck{
+		{"ICC_Profile", "ProfileDescription", "Display"},
+		{"ICC_Profile", "ProfileDescription-fr-FR", "Écran"},
+		{"ICC_Profile", "ProfileCopyright", "No copyright"},
+		{"ICC_Profile", "MediaWhitePoint", []float64{0.95045, 1, 1.08905}},
+		{"ICC_Profile", "ICC_Profile", profile},
+	})
+	if got := e.metadata.count("ProfileDescription"); got != 2 {
+		t.Errorf("%d ProfileDescription fields, want the default and the invalid language", got)
+	}
+}
+
+func TestICCLanguage(t *testing.T) {
+	tests := []struct{ code, want string }{
+		{"frFR", "fr-FR"},
+		{"FRfr", "fr-FR"},
+		{"enUS", ""},
+		{"d1EU", ""},
+		{"de@[", ""},
+		{"\x00\x00\x00\x00", ""},
+	}
+	for _, tt := range tests {
+		if got := iccLanguage([]byte(tt.code)); got != tt.want {
+			t.Errorf("iccLanguage(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestICCProfileInvalid(t *testing.T) {
+	profile := iccTestProfile()
+	tests := []struct {
+		name    string
+		data    []byte
+		warning string
+	}{
+		{"too short", profile[:100], "ICC_Profile at offset 0 is too short (100 bytes)"},
+		{"bad length", append(be(uint32(len(profile)+1)), profile[4:]...), fmt.Sprintf("Bad ICC_Profile length (%d)", len(profile)+1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := testExtractor(nil)
+			if e.extractICCProfile(tt.data, 0) {
+				t.Error("tags found")
+			}
+			if len(e.metadata.Warnings) != 1 || e.metadata.Warnings[0] != tt.warning {
+				t.Errorf("warnings = %q, want %q", e.metadata.Warnings, tt.warning)
+			}
+		})
+	}
+}
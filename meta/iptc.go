@@ -0,0 +1,324 @@
+package meta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"greg-hacke/go-metadata/internal/latin1"
+	"greg-hacke/go-metadata/tags"
+)
+
+// iptcRecordTables names the tag table of each IPTC-IIM record
+var iptcRecordTables = map[byte]string{
+	1: "IPTC::EnvelopeRecord",
+	2: "IPTC::ApplicationRecord",
+	3: "IPTC::NewsPhoto",
+	7: "IPTC::PreObjectData",
+	8: "IPTC::ObjectData",
+	9: "IPTC::PostObjectData",
+}
+
+// iptcListDatasets are the datasets the IIM specification allows to
+// repeat. ExifTool returns them as lists even when they occur once.
+var iptcListDatasets = map[string]bool{
+	"1:5":   true, // Destination
+	"2:4":   true, // ObjectAttributeReference
+	"2:12":  true, // SubjectReference
+	"2:20":  true, // SupplementalCategories
+	"2:25":  true, // Keywords
+	"2:26":  true, // ContentLocationCode
+	"2:27":  true, // ContentLocationName
+	"2:45":  true, // ReferenceService
+	"2:47":  true, // ReferenceDate
+	"2:50":  true, // ReferenceNumber
+	"2:80":  true, // By-line
+	"2:85":  true, // By-lineTitle
+	"2:118": true, // Contact
+	"2:122": true, // Writer-Editor
+}
+
+// iptcTimeTags maps each IPTC date dataset to the time dataset holding its
+// time of day and zone
+var iptcTimeTags = map[string]string{
+	"DateSent":            "TimeSent",
+	"ReleaseDate":         "ReleaseTime",
+	"ExpirationDate":      "ExpirationTime",
+	"DateCreated":         "TimeCreated",
+	"DigitalCreationDate": "DigitalCreationTime",
+}
+
+// iptcCodedCharacterSet is the envelope dataset naming the character set
+// of the text in the records that follow it
+const iptcCodedCharacterSet = "1:90"
+
+// iptcDataset is the values of one dataset of an IPTC block
+type iptcDataset struct {
+	id     string // "record:dataset"
+	def    *tags.TagDef
+	table  *tags.TagTable
+	offset int64 // Offset of the first occurrence
+	values []interface{}
+}
+
+// extractIPTCData decodes an IPTC-IIM block. Datasets are collected first,
+// so repeated ones become a single list field; text is decoded in the
+// character set named by CodedCharacterSet.
+func (e *MetadataExtractor) extractIPTCData(data []byte, baseOffset int) bool {
+	var datasets []*iptcDataset
+	byID := make(map[string]*iptcDataset)
+	utf8Text := false
+	firstField := len(e.metadata.List)
+	offset := 0
+
+	for offset+5 <= len(data) {
+		if data[offset] != 0x1C {
+			break
+		}
+		record := data[offset+1]
+		number := data[offset+2]
+		offset += 3
+
+		// The length is two bytes, or with the high bit set the number of
+		// bytes holding an extended length
+		dataLen := int(binary.BigEndian.Uint16(data[offset : offset+2]))
+		offset += 2
+		if dataLen&0x8000 != 0 {
+			lenBytes := dataLen & 0x7FFF
+			if lenBytes > 4 || offset+lenBytes > len(data) {
+				e.warnf("Invalid IPTC extended length at offset %d", baseOffset+offset)
+				break
+			}
+			dataLen = 0
+			for _, b := range data[offset : offset+lenBytes] {
+				dataLen = dataLen<<8 | int(b)
+			}
+			offset += lenBytes
+		}
+		if offset+dataLen > len(data) {
+			e.warnf("IPTC dataset %d:%d at offset %d runs past the end of the block", record, number, baseOffset+offset)
+			break
+		}
+		value := data[offset : offset+dataLen]
+		id := fmt.Sprintf("%d:%d", record, number)
+		valueOffset := int64(baseOffset + offset)
+		offset += dataLen
+
+		if id == iptcCodedCharacterSet {
+			utf8Text = iptcCharset(string(value)) == "UTF8"
+		}
+
+		table := tags.AllTags[iptcRecordTables[record]]
+		def := lookupTag(table, id)
+		if def == nil {
+			e.opts.emit(TraceEvent{Kind: TraceTag, Level: TraceLevelDetail, Offset: valueOffset, Name: id, Detail: fmt.Sprintf("unknown IPTC dataset, %d bytes", dataLen)})
+			continue
+		}
+		if !e.filter.wants(groupsFor("IPTC", "", def), def.Name) {
+			// Not requested, so leave the value undecoded
+			continue
+		}
+
+		decoded := decodeIPTCValue(def, value, utf8Text)
+		e.opts.emit(TraceEvent{Kind: TraceTag, Level: TraceLevelTags, Offset: valueOffset, Name: def.Name, Detail: fmt.Sprintf("IPTC %s = %.50s", id, formatValue(decoded))})
+		ds := byID[id]
+		if ds == nil {
+			ds = &iptcDataset{id: id, def: def, table: table, offset: valueOffset}
+			byID[id] = ds
+			datasets = append(datasets, ds)
+		}
+		ds.values = append(ds.values, decoded)
+	}
+
+	for _, ds := range datasets {
+		var raw interface{} = ds.values[0]
+		if iptcListDatasets[ds.id] || len(ds.values) > 1 {
+			raw = iptcList(ds.values)
+		}
+		value := iptcDates(raw)
+		g := groupsFor("IPTC", "", ds.def)
+		field := Field{
+			Namespace: g[0],
+			Directory: g[1],
+			Category:  g[2],
+			Key:       ds.def.Name,
+			TagID:     ds.id,
+			Raw:       raw,
+			Value:     value,
+			Print:     e.printConv(ds.def, value),
+			Table:     tagTableName(ds.table),
+		}
+		if ds.id == iptcCodedCharacterSet {
+			if escape, ok := value.(string); ok {
+				if name := iptcCharset(escape); name != "" {
+					field.Print = name
+				}
+			}
+		}
+		e.metadata.addField(field)
+	}
+	e.metadata.mergeIPTCValues(firstField)
+	return len(datasets) > 0
+}
+
+// iptcDates converts "YYYY:MM:DD" dates, alone or in a list, to times
+func iptcDates(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if t, ok := parseExifTime(v); ok {
+			return t
+		}
+	case []string:
+		times := make([]time.Time, 0, len(v))
+		for _, s := range v {
+			t, ok := parseExifTime(s)
+			if !ok {
+				return value
+			}
+			times = append(times, t)
+		}
+		return times
+	}
+	return value
+}
+
+// mergeIPTCValues adds the time of day and zone of the time datasets to
+// their dates, for IPTC fields recorded from index start on
+func (m *Metadata) mergeIPTCValues(start int) {
+	raw := make(map[string]interface{})
+	for _, f := range m.List[start:] {
+		if f.Namespace == "IPTC" {
+			raw[f.Key] = f.Raw
+		}
+	}
+
+	for i := start; i < len(m.List); i++ {
+		f := &m.List[i]
+		if f.Namespace != "IPTC" {
+			continue
+		}
+		timeTag, ok := iptcTimeTags[f.Key]
+		if !ok {
+			continue
+		}
+		date, ok := f.Value.(time.Time)
+		if !ok {
+			continue
+		}
+		s, ok := raw[timeTag].(string)
+		if !ok || len(s) < len("15:04:05") {
+			continue
+		}
+		clock, err := time.Parse("15:04:05", s[:8])
+		if err != nil {
+			continue
+		}
+		date = time.Date(date.Year(), date.Month(), date.Day(), clock.Hour(), clock.Minute(), clock.Second(), 0, time.UTC)
+		if len(s) > 8 {
+			date, _ = withOffset(date, s[8:])
+		}
+		m.setValue(i, date)
+	}
+}
+
+// iptcCharset returns ExifTool's name for an ISO 2022 character set
+// designation, or "" if it is not recognized
+func iptcCharset(escape string) string {
+	switch escape {
+	case "\x1b%G", "\x1b%/G", "\x1b%/H", "\x1b%/I":
+		return "UTF8"
+	case "\x1b.A", "\x1b-A":
+		return "Latin1"
+	}
+	return ""
+}
+
+// decodeIPTCValue converts a dataset value according to the format in its
+// tag definition: numbers for int formats, ExifTool's "YYYY:MM:DD" and
+// "HH:MM:SS+HH:MM" forms for dates and times, and text in UTF-8. Dates are
+// made times once the datasets are collected.
+func decodeIPTCValue(def *tags.TagDef, value []byte, utf8Text bool) interface{} {
+	format, _, _ := strings.Cut(def.Format, "[")
+	switch format {
+	case "int8u", "int16u", "int32u":
+		if len(value) == 0 || len(value) > 4 {
+			return value
+		}
+		n := 0
+		for _, b := range value {
+			n = n<<8 | int(b)
+		}
+		return n
+	case "digits":
+		s := strings.TrimSpace(string(value))
+		if def.Format == "digits[8]" && len(s) == 8 {
+			// Dates are the only eight-digit datasets
+			return s[0:4] + ":" + s[4:6] + ":" + s[6:8]
+		}
+		if n, err := strconv.Atoi(s); err == nil {
+			return n
+		}
+		return s
+	case "undef", "binary":
+		return value
+	}
+	if format == "" && !utf8.Valid(value) && bytes.IndexByte(value, 0) >= 0 {
+		// Undocumented binary data
+		return value
+	}
+
+	s := iptcText(value, utf8Text)
+	if strings.Contains(def.Name, "Time") && def.Format == "string[11]" {
+		return iptcTime(s)
+	}
+	return s
+}
+
+// iptcText decodes text as UTF-8 when CodedCharacterSet says so and it is
+// valid, and as Latin-1 otherwise, which is ExifTool's default for IPTC
+func iptcText(value []byte, utf8Text bool) string {
+	if utf8Text && utf8.Valid(value) || isASCII(value) {
+		return string(value)
+	}
+	return latin1.Decode(value)
+}
+
+// iptcTime converts an IIM time, "HHMMSS±HHMM", to "HH:MM:SS±HH:MM", or
+// "HHMMSS" without a zone to "HH:MM:SS"
+func iptcTime(s string) string {
+	switch {
+	case len(s) == 6:
+		return s[0:2] + ":" + s[2:4] + ":" + s[4:6]
+	case len(s) != 11 || (s[6] != '+' && s[6] != '-'):
+		return s
+	}
+	return s[0:2] + ":" + s[2:4] + ":" + s[4:6] + s[6:9] + ":" + s[9:11]
+}
+
+// iptcList returns the values of a repeated dataset as a typed slice when
+// they share a type, as lists from other directories are
+func iptcList(values []interface{}) interface{} {
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			return values
+		}
+		strs = append(strs, s)
+	}
+	return strs
+}
+
+// isASCII reports whether data holds only 7-bit characters
+func isASCII(data []byte) bool {
+	for _, b := range data {
+		if b >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,183 @@
+package meta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+
+	"greg-hacke/go-metadata/tags"
+)
+
+// iimDataset encodes one IPTC-IIM dataset, with an extended length when
+// the value needs more than 15 bits
+func iimDataset(record, number byte, value string) []byte {
+	b := []byte{0x1C, record, number}
+	if len(value) < 0x8000 {
+		b = binary.BigEndian.AppendUint16(b, uint16(len(value)))
+	} else {
+		b = binary.BigEndian.AppendUint32(append(b, 0x80, 0x04), uint32(len(value)))
+	}
+	return append(b, value...)
+}
+
+// testExtractor returns an extractor with no file behind it, for decoding
+// blocks directly
+func testExtractor(requested MetadataRequest) *MetadataExtractor {
+	e := newMetadataExtractor(bytes.NewReader(nil), 0, &Metadata{Fields: make(map[string]interface{})}, nil, newOptions(nil))
+	e.filter = newTagFilter(requested)
+	return e
+}
+
+// field returns the first field named key, or nil
+func (m *Metadata) field(key string) *Field {
+	for i := range m.List {
+		if m.List[i].Key == key {
+			return &m.List[i]
+		}
+	}
+	return nil
+}
+
+// withIPTCTables installs the IPTC record tables the tests use
+func withIPTCTables(t *testing.T) {
+	def := func(id, name, format string) tags.TagDef {
+		return tags.TagDef{ID: id, Name: name, Format: format}
+	}
+	withTables(t, map[string]*tags.TagTable{
+		"IPTC::EnvelopeRecord": {ModuleName: "IPTC", Tags: map[string]tags.TagDef{
+			"1:0":  def("1:0", "EnvelopeRecordVersion", "int16u"),
+			"1:90": def("1:90", "CodedCharacterSet", "string[0,32]"),
+		}},
+		"IPTC::ApplicationRecord": {ModuleName: "IPTC", Tags: map[string]tags.TagDef{
+			"2:5":   def("2:5", "ObjectName", "string[0,64]"),
+			"2:25":  def("2:25", "Keywords", "string[0,64]"),
+			"2:80":  def("2:80", "By-line", "string[0,32]"),
+			"2:120": def("2:120", "Caption-Abstract", "string[0,2000]"),
+		}},
+		"IPTC::PreObjectData": {ModuleName: "IPTC", Tags: map[string]tags.TagDef{
+			"7:10": def("7:10", "SizeMode", "int8u"),
+		}},
+		"IPTC::PostObjectData": {ModuleName: "IPTC", Tags: map[string]tags.TagDef{
+			"9:10": def("9:10", "ConfirmedObjectSize", ""),
+		}},
+	})
+}
+
+func TestIPTC(t *testing.T) {
+	withIPTCTables(t)
+	caption := strings.Repeat("x", 0x8000)
+
+	tests := []struct {
+		name  string
+		data  []byte
+		key   string
+		id    string
+		value interface{}
+	}{
+		{
+			"repeated keywords",
+			bytes.Join([][]byte{iimDataset(2, 25, "one"), iimDataset(2, 5, "name"), iimDataset(2, 25, "two")}, nil),
+			"Keywords", "2:25", []string{"one", "two"},
+		},
+		{
+			"list dataset found once",
+			iimDataset(2, 80, "Ansel"),
+			"By-line", "2:80", []string{"Ansel"},
+		},
+		{
+			"UTF-8 escape",
+			append(iimDataset(1, 90, "\x1b%G"), iimDataset(2, 5, "caf\xc3\xa9")...),
+			"ObjectName", "2:5", "café",
+		},
+		{
+			"Latin-1 by default",
+			iimDataset(2, 5, "caf\xe9"),
+			"ObjectName", "2:5", "café",
+		},
+		{
+			"Latin-1 when UTF-8 is invalid",
+			append(iimDataset(1, 90, "\x1b%G"), iimDataset(2, 5, "caf\xe9")...),
+			"ObjectName", "2:5", "café",
+		},
+		{
+			"extended length",
+			iimDataset(2, 120, caption),
+			"Caption-Abstract", "2:120", caption,
+		},
+		{
+			"empty last dataset",
+			append(iimDataset(2, 80, "Ansel"), iimDataset(2, 5, "")...),
+			"ObjectName", "2:5", "",
+		},
+		{"envelope record", iimDataset(1, 0, "\x00\x04"), "EnvelopeRecordVersion", "1:0", 4},
+		{"pre-object data record", iimDataset(7, 10, "\x01"), "SizeMode", "7:10", 1},
+		{"post-object data record", iimDataset(9, 10, "1000"), "ConfirmedObjectSize", "9:10", "1000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := testExtractor(nil)
+			if !e.extractIPTCData(tt.data, 0) {
+				t.Fatal("no datasets found")
+			}
+			f := e.metadata.field(tt.key)
+			if f == nil {
+				t.Fatalf("no %s field in %v", tt.key, e.metadata.List)
+			}
+			if f.Namespace != "IPTC" || f.TagID != tt.id {
+				t.Errorf("%s is %s %s, want IPTC %s", tt.key, f.Namespace, f.TagID, tt.id)
+			}
+			if !reflect.DeepEqual(f.Value, tt.value) {
+				t.Errorf("%s = %#v, want %#v", tt.key, f.Value, tt.value)
+			}
+			if len(e.metadata.Warnings) > 0 {
+				t.Errorf("warnings: %q", e.metadata.Warnings)
+			}
+		})
+	}
+}
+
+func TestIPTCCodedCharacterSet(t *testing.T) {
+	withIPTCTables(t)
+	e := testExtractor(nil)
+	e.extractIPTCData(iimDataset(1, 90, "\x1b%G"), 0)
+	if f := e.metadata.field("CodedCharacterSet"); f == nil || f.Print != "UTF8" {
+		t.Errorf("CodedCharacterSet = %+v, want UTF8", f)
+	}
+}
+
+func TestIPTCInvalid(t *testing.T) {
+	withIPTCTables(t)
+	tests := []struct {
+		name    string
+		data    []byte
+		warning string
+	}{
+		{"extended length too long", []byte{0x1C, 2, 5, 0x80, 0x05, 0, 0, 0, 0, 1, 'a'}, "Invalid IPTC extended length at offset 105"},
+		{"value past the end", iimDataset(2, 5, "name")[:7], "IPTC dataset 2:5 at offset 105 runs past the end of the block"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := testExtractor(nil)
+			if e.extractIPTCData(tt.data, 100) {
+				t.Error("datasets found")
+			}
+			if len(e.metadata.Warnings) == 0 || e.metadata.Warnings[0] != tt.warning {
+				t.Errorf("warnings = %q, want %q", e.metadata.Warnings, tt.warning)
+			}
+		})
+	}
+}
+
+func TestIPTCRequest(t *testing.T) {
+	withIPTCTables(t)
+	e := testExtractor(MetadataRequest{"IPTC:Keywords": true})
+	e.extractIPTCData(append(iimDataset(2, 5, "name"), iimDataset(2, 25, "one")...), 0)
+	if e.metadata.field("ObjectName") != nil {
+		t.Error("ObjectName decoded though not requested")
+	}
+	if e.metadata.field("Keywords") == nil {
+		t.Error("Keywords not decoded")
+	}
+}
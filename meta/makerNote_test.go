@@ -0,0 +1,69 @@
+package meta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"greg-hacke/go-metadata/tags"
+)
+
+// nikonTestFile builds a big-endian TIFF whose ExifIFD holds note as its
+// maker note
+func nikonTestFile(note []byte) []byte {
+	// IFD0 at 8 points to the ExifIFD at 38, and the note follows at 56
+	return append(tiffFile(
+		tiffIFD(0, tiffText(0x010F, "NIK"), tiffEntry(0x8769, 4, 1, 38)),
+		tiffIFD(0, tiffEntry(0x927C, 7, uint32(len(note)), 56)),
+	), note...)
+}
+
+// nikonNote builds a type 3 Nikon maker note: the header and version, then
+// a little-endian TIFF structure whose IFD is after some padding. Read
+// big-endian, its entry count would still look plausible.
+func nikonNote(tiffHeader string) []byte {
+	le := binary.LittleEndian
+	ifd := le.AppendUint16(nil, 1)
+	ifd = le.AppendUint16(ifd, 0x0004)
+	ifd = le.AppendUint16(ifd, 2)
+	ifd = le.AppendUint32(ifd, 4)
+	ifd = append(ifd, "BAS\x00"...)
+	ifd = le.AppendUint32(ifd, 0)
+	return bytes.Join([][]byte{[]byte("Nikon\x00\x02\x10\x00\x00"), []byte(tiffHeader), make([]byte, 8), ifd}, nil)
+}
+
+func TestNikonMakerNote(t *testing.T) {
+	withExifTables(t)
+	withTables(t, map[string]*tags.TagTable{
+		"Nikon::Main": {ModuleName: "Nikon", Tags: map[string]tags.TagDef{
+			"0x0004": {ID: "0x0004", Name: "Quality"},
+		}},
+	})
+
+	tests := []struct {
+		name    string
+		note    []byte
+		quality interface{}
+		warning string
+	}{
+		{"inner TIFF header", nikonNote("II\x2A\x00\x10\x00\x00\x00"), "BAS", ""},
+		{"invalid TIFF header", nikonNote("XX\x2A\x00\x10\x00\x00\x00"), nil, "MakerNote at offset 56 has an invalid TIFF header"},
+		{"too short", []byte("Nikon\x00\x02\x10\x00\x00II\x2A\x00"), nil, "MakerNote at offset 56 is too short for its TIFF header"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := extractTestTIFF(t, nikonTestFile(tt.note))
+			if tt.quality != nil {
+				checkFields(t, m, []fieldCheck{{"Nikon", "Quality", tt.quality}})
+			} else if f := m.field("Quality"); f != nil {
+				t.Errorf("Quality = %v read from a bad header", f.Value)
+			}
+			switch {
+			case tt.warning == "" && len(m.Warnings) > 0:
+				t.Errorf("warnings: %q", m.Warnings)
+			case tt.warning != "" && (len(m.Warnings) != 1 || m.Warnings[0] != tt.warning):
+				t.Errorf("warnings = %q, want %q", m.Warnings, tt.warning)
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package meta
+
+import (
+	"strings"
+
+	"greg-hacke/go-metadata/tags"
+)
+
+// requestRule is one parsed entry of a MetadataRequest
+type requestRule struct {
+	family int    // Group family the group name applies to, -1 for any
+	group  string // Lower-case group name, empty for any group
+	tag    string // Lower-case tag name pattern, empty for all tags
+}
+
+// tagFilter decides which tags a MetadataRequest selects.
+// A nil filter selects everything.
+type tagFilter struct {
+	includes []requestRule
+	excludes []requestRule
+	tables   map[tableKey]bool // Cached wantsTable results
+	visiting map[tableKey]bool // Tables wantsTable is searching
+}
+
+// tableKey identifies a table read in the context of particular groups
+type tableKey struct {
+	groups tagGroups
+	table  *tags.TagTable
+}
+
+// newTagFilter compiles a MetadataRequest using ExifTool's tag syntax:
+//
+//	"Make"          tag in any group
+//	"EXIF:Make"     tag in a group of any family
+//	"1IFD0:Make"    tag in a group of family 1 only
+//	"XMP-dc:*"      wildcards (* and ?) in tag names
+//	"IPTC:All"      every tag in a group
+//	"-IPTC:All"     exclusion, as is a false value
+//
+// Names are case-insensitive. Without inclusions every tag not excluded is selected.
+func newTagFilter(requested MetadataRequest) *tagFilter {
+	if len(requested) == 0 {
+		return nil
+	}
+
+	f := &tagFilter{tables: make(map[tableKey]bool), visiting: make(map[tableKey]bool)}
+	for key, want := range requested {
+		key = strings.TrimSpace(key)
+		exclude := !want
+		if rest, ok := strings.CutPrefix(key, "-"); ok {
+			key = rest
+			exclude = true
+		}
+		if key == "" {
+			continue
+		}
+
+		rule := parseRequestRule(key)
+		if exclude {
+			f.excludes = append(f.excludes, rule)
+		} else {
+			f.includes = append(f.includes, rule)
+		}
+	}
+	return f
+}
+
+// parseRequestRule splits "[family]group:tag" into a rule
+func parseRequestRule(key string) requestRule {
+	rule := requestRule{family: -1}
+
+	group, tag, hasGroup := strings.Cut(key, ":")
+	if !hasGroup {
+		tag, group = group, ""
+	}
+
+	// An optional leading digit selects the group family
+	if len(group) > 1 && group[0] >= '0' && group[0] <= '9' {
+		rule.family = int(group[0] - '0')
+		group = group[1:]
+	}
+	if !strings.EqualFold(group, "all") && group != "*" {
+		rule.group = strings.ToLower(group)
+	}
+
+	if !strings.EqualFold(tag, "all") && tag != "*" {
+		rule.tag = strings.ToLower(tag)
+	}
+	return rule
+}
+
+// matchGroup reports whether the rule's group matches g. A family that is
+// not known yet counts as unknownMatches.
+func (r *requestRule) matchGroup(g tagGroups, unknownMatches bool) bool {
+	if r.group == "" {
+		return true
+	}
+	for family, name := range g {
+		if r.family >= 0 && r.family != family {
+			continue
+		}
+		if name == "" {
+			if unknownMatches && r.impliesNamespace(g[0]) {
+				return true
+			}
+			continue
+		}
+		if strings.EqualFold(name, r.group) {
+			return true
+		}
+	}
+	return false
+}
+
+// impliesNamespace reports whether a tag in a group named like the rule's
+// could live in the family 0 group namespace
+func (r *requestRule) impliesNamespace(namespace string) bool {
+	ns := strings.ToLower(namespace)
+	switch {
+	case family0Groups[r.group]:
+		// Already compared against the family 0 name
+		return false
+	case strings.HasPrefix(r.group, "xmp-"):
+		return ns == "xmp"
+	case exifDirectoryGroups[r.group]:
+		return ns == "exif"
+	}
+	return true
+}
+
+// family0Groups are the general locations used as family 0 group names
+var family0Groups = map[string]bool{
+	"exif": true, "makernotes": true, "iptc": true, "xmp": true,
+	"file": true, "png": true, "exiftool": true,
+}
+
+// exifDirectoryGroups are the family 1 groups of TIFF/EXIF directories
+var exifDirectoryGroups = map[string]bool{
+	"ifd0": true, "ifd1": true, "exififd": true, "gps": true,
+	"interopifd": true, "subifd": true,
+}
+
+// matchTag reports whether the rule's tag pattern matches name
+func (r *requestRule) matchTag(name string) bool {
+	return r.tag == "" || matchWildcard(r.tag, strings.ToLower(name))
+}
+
+// wants reports whether the tag name in groups g is selected
+func (f *tagFilter) wants(g tagGroups, name string) bool {
+	if f == nil {
+		return true
+	}
+	for i := range f.excludes {
+		if f.excludes[i].matchGroup(g, false) && f.excludes[i].matchTag(name) {
+			return false
+		}
+	}
+	if len(f.includes) == 0 {
+		return true
+	}
+	for i := range f.includes {
+		if f.includes[i].matchGroup(g, true) && f.includes[i].matchTag(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// wantsGroup reports whether any tag in groups g could be selected, so
+// blocks belonging to an unwanted group can be skipped without decoding
+func (f *tagFilter) wantsGroup(g tagGroups) bool {
+	if f == nil {
+		return true
+	}
+	for i := range f.excludes {
+		if f.excludes[i].tag == "" && f.excludes[i].matchGroup(g, false) {
+			return false
+		}
+	}
+	if len(f.includes) == 0 {
+		return true
+	}
+	for i := range f.includes {
+		if f.includes[i].matchGroup(g, true) {
+			return true
+		}
+	}
+	return false
+}
+
+// wantsTable reports whether any tag of table, or of the tables its
+// directory pointers lead to, could be selected in groups g
+func (f *tagFilter) wantsTable(g tagGroups, table *tags.TagTable) bool {
+	if f == nil || table == nil {
+		return true
+	}
+	if !f.wantsGroup(g) {
+		return false
+	}
+	if len(f.includes) == 0 {
+		return true
+	}
+	// The outermost search reaches every table it depends on, so its answer
+	// is final even where a pointer loop left a nested one incomplete
+	key := tableKey{g, table}
+	want, _ := f.searchTable(key)
+	f.tables[key] = want
+	return want
+}
+
+// searchTable does the work of wantsTable. A table already being searched
+// further up is taken as unwanted, which holds only once that search ends,
+// so a false result that relied on it is reported as incomplete and not
+// cached. A true result is always complete.
+func (f *tagFilter) searchTable(key tableKey) (want, complete bool) {
+	if want, ok := f.tables[key]; ok {
+		return want, true
+	}
+	if f.visiting[key] {
+		return false, false
+	}
+	f.visiting[key] = true
+	defer delete(f.visiting, key)
+
+	complete = true
+	for _, def := range key.table.Tags {
+		if f.wants(key.groups, def.Name) {
+			want = true
+			break
+		}
+		if sub := subDirectoryTable(def); sub != nil {
+			subWant, subComplete := f.searchTable(tableKey{key.groups, sub})
+			if subWant {
+				want = true
+				break
+			}
+			complete = complete && subComplete
+		}
+	}
+	if want || complete {
+		f.tables[key] = want
+		return want, true
+	}
+	return false, false
+}
+
+// subDirectoryTable returns the table a directory pointer tag leads to
+func subDirectoryTable(def tags.TagDef) *tags.TagTable {
+	if def.SubIFD == "" {
+		return nil
+	}
+	return findTableByName(extractTableName(def.SubIFD))
+}
+
+// matchWildcard matches name against a pattern where * matches any run of
+// characters and ? matches exactly one
+func matchWildcard(pattern, name string) bool {
+	p, n := 0, 0
+	starP, starN := -1, 0
+	for n < len(name) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == name[n]):
+			p++
+			n++
+		case p < len(pattern) && pattern[p] == '*':
+			starP, starN = p, n
+			p++
+		case starP >= 0:
+			// Let the last star absorb one more character
+			starN++
+			p, n = starP+1, starN
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
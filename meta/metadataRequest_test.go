@@ -0,0 +1,165 @@
+package meta
+
+import (
+	"sync"
+	"testing"
+
+	"greg-hacke/go-metadata/tags"
+)
+
+// withTables adds tables to AllTags for the length of a test, in place of
+// any of the same name. The index of table names is rebuilt each way.
+func withTables(t *testing.T, tables map[string]*tags.TagTable) {
+	for name, table := range tables {
+		old, ok := tags.AllTags[name]
+		tags.AllTags[name] = table
+		t.Cleanup(func() {
+			if ok {
+				tags.AllTags[name] = old
+			} else {
+				delete(tags.AllTags, name)
+			}
+			resetTableNames()
+		})
+	}
+	resetTableNames()
+}
+
+// resetTableNames has the index of AllTags rebuilt when next used
+func resetTableNames() {
+	tableNamesOnce = sync.Once{}
+	sortedNames = nil
+}
+
+func TestTagFilterWants(t *testing.T) {
+	ifd0 := tagGroups{"EXIF", "IFD0", "Image"}
+	gps := tagGroups{"EXIF", "GPS", "Location"}
+	iptc := tagGroups{"IPTC", "IPTC", "Other"}
+	xmp := tagGroups{"XMP", "XMP-dc", "Other"}
+	pending := tagGroups{"XMP", "", "Other"} // Family 1 not known yet
+
+	tests := []struct {
+		name    string
+		request MetadataRequest
+		groups  tagGroups
+		tag     string
+		want    bool
+	}{
+		{"empty request", MetadataRequest{}, iptc, "Keywords", true},
+		{"tag in any group", MetadataRequest{"Make": true}, ifd0, "Make", true},
+		{"other tag", MetadataRequest{"Make": true}, ifd0, "Model", false},
+		{"case-insensitive", MetadataRequest{"exif:MAKE": true}, ifd0, "Make", true},
+		{"family 0 group", MetadataRequest{"EXIF:Make": true}, ifd0, "Make", true},
+		{"family 1 group", MetadataRequest{"IFD0:Make": true}, ifd0, "Make", true},
+		{"wrong group", MetadataRequest{"GPS:Make": true}, ifd0, "Make", false},
+		{"family digit", MetadataRequest{"1IFD0:Make": true}, ifd0, "Make", true},
+		{"family digit on the wrong family", MetadataRequest{"0IFD0:Make": true}, ifd0, "Make", false},
+		{"family 2 group", MetadataRequest{"2Location:All": true}, gps, "GPSLatitude", true},
+		{"group All", MetadataRequest{"IPTC:All": true}, iptc, "Keywords", true},
+		{"group star", MetadataRequest{"XMP-dc:*": true}, xmp, "Creator", true},
+		{"wildcard", MetadataRequest{"*GPS*": true}, gps, "GPSLatitude", true},
+		{"wildcard elsewhere", MetadataRequest{"*GPS*": true}, ifd0, "Make", false},
+		{"question mark", MetadataRequest{"Mak?": true}, ifd0, "Make", true},
+		{"leading minus", MetadataRequest{"-IPTC:All": true}, iptc, "Keywords", false},
+		{"leading minus elsewhere", MetadataRequest{"-IPTC:All": true}, ifd0, "Make", true},
+		{"false value", MetadataRequest{"IPTC:Keywords": false}, iptc, "Keywords", false},
+		{"exclusion beats inclusion", MetadataRequest{"IPTC:All": true, "-Keywords": true}, iptc, "Keywords", false},
+		{"included beside exclusion", MetadataRequest{"IPTC:All": true, "-Keywords": true}, iptc, "By-line", true},
+		{"unknown family 1 included", MetadataRequest{"XMP-dc:Creator": true}, pending, "Creator", true},
+		{"unknown family 1 in another namespace", MetadataRequest{"IFD0:Creator": true}, pending, "Creator", false},
+		{"unknown family 1 not excluded", MetadataRequest{"-XMP-dc:Creator": true}, pending, "Creator", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTagFilter(tt.request)
+			if got := f.wants(tt.groups, tt.tag); got != tt.want {
+				t.Errorf("wants(%v, %q) = %v, want %v", tt.groups, tt.tag, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTagFilterWantsGroup(t *testing.T) {
+	iptc := tagGroups{"IPTC", "IPTC", "Other"}
+	tests := []struct {
+		name    string
+		request MetadataRequest
+		want    bool
+	}{
+		{"tag in any group", MetadataRequest{"Keywords": true}, true},
+		{"other group", MetadataRequest{"EXIF:Make": true}, false},
+		{"group excluded", MetadataRequest{"-IPTC:All": true}, false},
+		{"one tag excluded", MetadataRequest{"-IPTC:Keywords": true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newTagFilter(tt.request).wantsGroup(iptc); got != tt.want {
+				t.Errorf("wantsGroup = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchWildcard(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"*gps*", "gpslatitude", true},
+		{"*gps*", "gps", true},
+		{"*gps*", "make", false},
+		{"gps*", "subjectgps", false},
+		{"*date", "modifydate", true},
+		{"*date", "dateoriginal", false},
+		{"?ake", "make", true},
+		{"?ake", "ake", false},
+		{"a*b*c", "aXXbYYc", true},
+		{"a*b*c", "aXXbYY", false},
+		{"**", "", true},
+	}
+	for _, tt := range tests {
+		if got := matchWildcard(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchWildcard(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestWantsTableLoop searches SubIFD tables that point back at each other.
+// Loop::A leads to Loop::B, which leads back to A, and A also leads to
+// Loop::C holding the wanted tag. Searching A first must not leave B cached
+// as unwanted on account of the loop.
+func TestWantsTableLoop(t *testing.T) {
+	pointer := func(name, table string) tags.TagDef {
+		return tags.TagDef{Name: name, SubIFD: "Image::ExifTool::Loop::" + table}
+	}
+	a := &tags.TagTable{ModuleName: "Loop", Tags: map[string]tags.TagDef{
+		"0x0001": pointer("BOffset", "B"),
+		"0x0002": pointer("COffset", "C"),
+	}}
+	b := &tags.TagTable{ModuleName: "Loop", Tags: map[string]tags.TagDef{
+		"0x0001": pointer("AOffset", "A"),
+		"0x0002": pointer("BOffset", "B"), // A self-referencing SubIFD
+	}}
+	c := &tags.TagTable{ModuleName: "Loop", Tags: map[string]tags.TagDef{
+		"0x0001": {Name: "LoopTag"},
+	}}
+	withTables(t, map[string]*tags.TagTable{"Loop::A": a, "Loop::B": b, "Loop::C": c})
+
+	g := tagGroups{"EXIF", "SubIFD", "Image"}
+	f := newTagFilter(MetadataRequest{"LoopTag": true})
+	for _, name := range []string{"Loop::A", "Loop::B", "Loop::C", "Loop::A", "Loop::B"} {
+		if !f.wantsTable(g, tags.AllTags[name]) {
+			t.Errorf("wantsTable(%s) = false", name)
+		}
+	}
+	if len(f.visiting) > 0 {
+		t.Errorf("visiting left set: %v", f.visiting)
+	}
+
+	f = newTagFilter(MetadataRequest{"NoSuchTag": true})
+	for _, name := range []string{"Loop::B", "Loop::A"} {
+		if f.wantsTable(g, tags.AllTags[name]) {
+			t.Errorf("wantsTable(%s) = true with no wanted tag", name)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package meta
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"greg-hacke/go-metadata/formats"
+	"greg-hacke/go-metadata/tags"
+)
+
+// mpfImageList is the MPF tag holding an entry for each image of the file
+const mpfImageList = 0xB002
+
+// mpfEntrySize is the size of an MP image list entry: attributes, length,
+// offset and the numbers of two dependent images
+const mpfEntrySize = 16
+
+// EmbeddedImage locates an image stored inside a file, such as the
+// secondary images of a Multi-Picture Format JPEG: large previews, depth
+// maps, gain maps and the other views of stereo cameras
+type EmbeddedImage struct {
+	Name     string // Tag name, e.g. "MPImage2"
+	Document int    // Embedded document the image's metadata was read as, 0 if it wasn't
+	Offset   int64  // Offset of the image data in the file
+	Length   int64  // Length of the image data
+}
+
+// String summarizes the image as ExifTool shows binary data
+func (img EmbeddedImage) String() string {
+	return fmt.Sprintf("(Binary data %d bytes)", img.Length)
+}
+
+// Reader returns the image data from r, the file the image was found in
+func (img EmbeddedImage) Reader(r io.ReaderAt) *io.SectionReader {
+	return io.NewSectionReader(r, img.Offset, img.Length)
+}
+
+// EmbeddedImages returns the images located by fields, as returned by ReadMetadata
+func EmbeddedImages(fields []Field) []EmbeddedImage {
+	var images []EmbeddedImage
+	for _, f := range fields {
+		if img, ok := f.Value.(EmbeddedImage); ok {
+			images = append(images, img)
+		}
+	}
+	return images
+}
+
+// ReadEmbeddedImage returns the data of the embedded image with the given
+// tag name, such as "MPImage2", from the file at filePath
+func ReadEmbeddedImage(filePath, name string) ([]byte, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open file: %w", err)
+	}
+	defer file.Close()
+
+	fields, err := readMetadataWithPath(file, filePath, newOptions([]Option{WithRequest(MetadataRequest{name: true})}))
+	if err != nil {
+		return nil, err
+	}
+	for _, img := range EmbeddedImages(fields) {
+		if img.Name != name {
+			continue
+		}
+		// Images can be larger than any metadata block, so they are not
+		// read with readBlock, but they must lie within the file
+		info, err := file.Stat()
+		if err != nil {
+			return nil, fmt.Errorf("cannot stat file: %w", err)
+		}
+		if img.Offset < 0 || img.Length < 0 || img.Offset > info.Size() || img.Length > info.Size()-img.Offset {
+			return nil, fmt.Errorf("%s at offset %d runs past the end of the file", name, img.Offset)
+		}
+		data, err := io.ReadAll(img.Reader(file))
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", name, err)
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("no %s image in %s", name, filePath)
+}
+
+// extractMPF decodes a Multi-Picture Format index, a TIFF structure whose
+// first directory is MPF0. The images it lists are read as embedded
+// documents once the file has been walked.
+func (e *MetadataExtractor) extractMPF(r io.ReaderAt, base, size int64) bool {
+	t, ifdOffset, ok := openTIFF(r, base, size)
+	if !ok {
+		e.warnf("Invalid MPF header at offset %d", e.base+base)
+		return false
+	}
+	e.loadModuleIfNeeded("MPF")
+	_, count := e.processIFD(t, ifdOffset, tagGroups{"MPF", "MPF0"}, tags.AllTags["MPF::Main"], 0)
+	return count > 0
+}
+
+// processMPImages decodes the MP image list, recording each entry in its
+// own MPImage1, MPImage2, ... group. The first image is the file's own;
+// the others are located for EmbeddedImages and, when they are JPEG
+// images of the file itself rather than of an embedded document, queued
+// to be read as documents Doc1, Doc2, ...
+func (e *MetadataExtractor) processMPImages(t *tiffBlock, pointer *tags.TagDef, count, valueOffset uint32) int {
+	table := subDirectoryTable(*pointer)
+	if table == nil {
+		table = tags.AllTags["MPF::MPImage"]
+	}
+	if count%mpfEntrySize != 0 {
+		e.warnf("MP image list at offset %d has a partial entry", e.base+t.base+int64(valueOffset))
+	}
+	data, ok := t.read(valueOffset, count-count%mpfEntrySize)
+	if !ok {
+		e.warnf("MP image list at offset %d is outside the MPF data", e.base+t.base+int64(valueOffset))
+		return 0
+	}
+
+	type mpfField struct {
+		id    string
+		name  string
+		value interface{}
+	}
+	recorded := 0
+	for i := 0; i+mpfEntrySize <= len(data); i += mpfEntrySize {
+		entry := data[i : i+mpfEntrySize]
+		dir := fmt.Sprintf("MPImage%d", i/mpfEntrySize+1)
+		offset := e.base + t.base + int64(valueOffset) + int64(i)
+		attributes := t.byteOrder.Uint32(entry[0:4])
+		length := int64(t.byteOrder.Uint32(entry[4:8]))
+		start := int64(t.byteOrder.Uint32(entry[8:12]))
+
+		// Image offsets are from the MPF header, except that the first
+		// image, the file's own, is at 0; MPImageStart is given in the file
+		imageStart := start
+		if start != 0 {
+			imageStart = e.base + t.base + start
+		}
+		fields := []mpfField{
+			{"0.1", "MPImageFlags", int(attributes >> 27)},
+			{"0.2", "MPImageFormat", int(attributes >> 24 & 0x07)},
+			{"0.3", "MPImageType", int(attributes & 0xFFFFFF)},
+			{"1", "MPImageLength", int(length)},
+			{"2", "MPImageStart", int(imageStart)},
+			{"3", "DependentImage1EntryNumber", int(t.byteOrder.Uint16(entry[12:14]))},
+			{"3.5", "DependentImage2EntryNumber", int(t.byteOrder.Uint16(entry[14:16]))},
+		}
+		if start != 0 && length > 0 {
+			if t.base+start+length > e.size {
+				e.warnf("%s at offset %d runs past the end of the file", dir, imageStart)
+			} else {
+				img := EmbeddedImage{Name: dir, Offset: imageStart, Length: length}
+				if e.metadata.document == 0 && attributes>>24&0x07 == 0 && e.isJPEG(t.base+start) {
+					img.Document = len(e.images) + 1
+					e.images = append(e.images, img)
+				}
+				fields = append(fields, mpfField{"4", dir, img})
+			}
+		}
+
+		for _, f := range fields {
+			// Tables generated before fractional IDs were parsed lack the
+			// bit fields, and the image is named by its entry number
+			def := tags.TagDef{ID: f.id, Name: f.name}
+			if found := lookupTag(table, f.id); found != nil && f.id != "4" {
+				def = *found
+			} else if f.id == "4" {
+				def.Groups = map[string]string{"2": "Preview"}
+			}
+			if e.addTableField("MPF", dir, table, &def, f.id, f.value, offset) {
+				recorded++
+			}
+		}
+	}
+	return recorded
+}
+
+// isJPEG reports whether the data at offset in the file starts with a JPEG SOI marker
+func (e *MetadataExtractor) isJPEG(offset int64) bool {
+	soi := make([]byte, 2)
+	if _, err := e.r.ReadAt(soi, offset); err != nil {
+		return false
+	}
+	return soi[0] == 0xFF && soi[1] == 0xD8
+}
+
+// extractDocuments reads the metadata of each image queued by
+// processMPImages, with its fields in family 3 group Doc1, Doc2, ...
+func (e *MetadataExtractor) extractDocuments() bool {
+	h := formats.Lookup("JPEG")
+	if h == nil {
+		return false
+	}
+	found := false
+	for _, img := range e.images {
+		e.opts.emit(TraceEvent{Kind: TraceSegment, Level: TraceLevelStructure, Offset: img.Offset, Name: fmt.Sprintf("Doc%d", img.Document), Detail: fmt.Sprintf("%s, %d bytes", img.Name, img.Length)})
+		sub := newMetadataExtractor(io.NewSectionReader(e.r, img.Offset-e.base, img.Length), img.Length, e.metadata, e.tagTables, e.opts)
+		sub.filter = e.filter
+		sub.base = img.Offset
+
+		e.metadata.document = img.Document
+		sink := &formatSink{e: sub}
+		if err := h.Parse(sub.r, sub.size, sink); err != nil {
+			sub.warnf("%s structure could not be fully read: %v", img.Name, err)
+		}
+		sink.flushXMP()
+		e.metadata.document = 0
+		found = found || sink.found
+	}
+	e.images = nil
+	return found
+}
@@ -0,0 +1,117 @@
+package meta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"greg-hacke/go-metadata/tags"
+)
+
+// testJPEGSegment encodes a JPEG marker segment
+func testJPEGSegment(marker byte, parts ...[]byte) []byte {
+	data := bytes.Join(parts, nil)
+	return append(binary.BigEndian.AppendUint16([]byte{0xFF, marker}, uint16(len(data)+2)), data...)
+}
+
+// testJPEG encodes a JPEG holding segments and an empty scan
+func testJPEG(segments ...[]byte) []byte {
+	return bytes.Join([][]byte{{0xFF, 0xD8}, bytes.Join(segments, nil), {0xFF, 0xDA, 0x00, 0x02, 0xFF, 0xD9}}, nil)
+}
+
+// testExifSegment encodes an APP1 segment with EXIF giving the camera make
+func testExifSegment(make string) []byte {
+	return testJPEGSegment(0xE1, []byte("Exif\x00\x00"), tiffFile(tiffIFD(0, tiffText(0x010F, make))))
+}
+
+// mpfTestFile builds a JPEG whose MPF index lists itself and a second JPEG
+// stored after it, each with its own EXIF
+func mpfTestFile() (file, second []byte) {
+	second = testJPEG(testExifSegment("Two"))
+	exif := testExifSegment("One")
+
+	// The index is a TIFF structure; image offsets count from its header
+	mpf := func(secondStart uint32) []byte {
+		list := be(uint32(0x030000), uint32(0), uint32(0), uint16(0), uint16(0))
+		list = append(list, be(uint32(0x020002), uint32(len(second)), secondStart, uint16(0), uint16(0))...)
+		ifd := tiffIFD(0,
+			tiffEntry(0xB000, 7, 4, binary.BigEndian.Uint32([]byte("0100"))),
+			tiffEntry(0xB001, 4, 1, 2),
+			tiffEntry(0xB002, 7, uint32(len(list)), 8+6+3*12),
+		)
+		return testJPEGSegment(0xE2, []byte("MPF\x00"), tiffFile(ifd), list)
+	}
+	mpfHeader := 2 + len(exif) + 4 + len("MPF\x00")
+	first := testJPEG(exif, mpf(0))
+	first = testJPEG(exif, mpf(uint32(len(first)-mpfHeader)))
+	return append(first, second...), second
+}
+
+// withMPFTables installs the EXIF and MPF tables the tests use
+func withMPFTables(t *testing.T) {
+	withExifTables(t)
+	withTables(t, map[string]*tags.TagTable{
+		"MPF::Main": {ModuleName: "MPF", Tags: map[string]tags.TagDef{
+			"0xB000": {ID: "0xB000", Name: "MPFVersion"},
+			"0xB001": {ID: "0xB001", Name: "NumberOfImages"},
+			"0xB002": {ID: "0xB002", Name: "MPImageList", SubIFD: "Image::ExifTool::MPF::MPImage"},
+		}},
+		"MPF::MPImage": {ModuleName: "MPF", Tags: map[string]tags.TagDef{
+			"1": {ID: "1", Name: "MPImageLength"},
+			"2": {ID: "2", Name: "MPImageStart"},
+		}},
+	})
+}
+
+func TestMPFDocuments(t *testing.T) {
+	withMPFTables(t)
+	file, second := mpfTestFile()
+	fields, err := ReadMetadataAt(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	makes := make(map[int]interface{})
+	for _, f := range fields {
+		if f.Key == "Make" {
+			makes[f.Document] = f.Value
+		}
+	}
+	if makes[0] != "One" || makes[1] != "Two" || len(makes) != 2 {
+		t.Errorf("Make by document = %v, want One in the file and Two in Doc1", makes)
+	}
+
+	images := EmbeddedImages(fields)
+	if len(images) != 1 {
+		t.Fatalf("embedded images = %v, want MPImage2", images)
+	}
+	img := images[0]
+	if img.Name != "MPImage2" || img.Document != 1 || img.Offset != int64(len(file)-len(second)) || img.Length != int64(len(second)) {
+		t.Errorf("MPImage2 = %+v", img)
+	}
+}
+
+func TestReadEmbeddedImage(t *testing.T) {
+	withMPFTables(t)
+	file, second := mpfTestFile()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "mpf.jpg")
+	if err := os.WriteFile(path, file, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ReadEmbeddedImage(path, "MPImage2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, second) {
+		t.Errorf("MPImage2 is %d bytes, want the %d of the second image", len(data), len(second))
+	}
+
+	if _, err := ReadEmbeddedImage(path, "MPImage3"); err == nil || !strings.Contains(err.Error(), "no MPImage3 image") {
+		t.Errorf("MPImage3 error = %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package meta
+
+import (
+	"log/slog"
+)
+
+// Option configures how metadata is read
+type Option func(*options)
+
+// options holds the settings collected from Option values
+type options struct {
+	logger    *slog.Logger
+	trace     TraceFunc
+	verbosity int
+	requested MetadataRequest
+	numeric   bool
+}
+
+// newOptions applies opts on top of the quiet defaults
+func newOptions(opts []Option) *options {
+	o := &options{
+		logger:    slog.New(slog.DiscardHandler),
+		verbosity: 1,
+	}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(o)
+		}
+	}
+	return o
+}
+
+// WithLogger sends debug output to logger instead of discarding it
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		if logger != nil {
+			o.logger = logger
+		}
+	}
+}
+
+// WithTrace registers a callback that receives structured parse events
+func WithTrace(fn TraceFunc) Option {
+	return func(o *options) {
+		o.trace = fn
+	}
+}
+
+// WithVerbosity sets the highest TraceEvent level that is emitted (default 1)
+func WithVerbosity(level int) Option {
+	return func(o *options) {
+		o.verbosity = level
+	}
+}
+
+// WithRequest limits ReadMetadata to the tags selected by requested
+func WithRequest(requested MetadataRequest) Option {
+	return func(o *options) {
+		o.requested = requested
+	}
+}
+
+// WithNumeric makes Metadata.Fields hold ValueConv values instead of
+// PrintConv values, like ExifTool's -n option (Orientation=1 rather than
+// "Horizontal (normal)"). Each Field always carries both forms.
+func WithNumeric(numeric bool) Option {
+	return func(o *options) {
+		o.numeric = numeric
+	}
+}
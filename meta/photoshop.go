@@ -0,0 +1,200 @@
+package meta
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+
+	"greg-hacke/go-metadata/tags"
+)
+
+// photoshopSignatures are the resource types ExifTool reads; 8BIM is the
+// usual one and the others come from older or third-party writers
+var photoshopSignatures = map[string]bool{
+	"8BIM": true,
+	"PHUT": true,
+	"AgHg": true,
+	"DCSR": true,
+	"MeSa": true,
+}
+
+// Photoshop resources decoded by their own parsers rather than from Photoshop::Main
+const (
+	psResourceIPTC       = 0x0404
+	psResourceBGRThumb   = 0x0409
+	psResourceThumbnail  = 0x040C
+	psResourceICC        = 0x040F
+	psResourceXMP        = 0x0424
+	psResourceIPTCDigest = 0x0425
+)
+
+// psThumbnailHeader is the size of the header before the JPEG data of a thumbnail resource
+const psThumbnailHeader = 28
+
+// photoshopBinaryFormats gives the default format of the binary tables
+// resources point to; tag IDs in them are offsets in units of this format
+var photoshopBinaryFormats = map[string]string{
+	"Photoshop::Resolution":   "int16u",
+	"Photoshop::JPEG_Quality": "int16s",
+	"Photoshop::VersionInfo":  "int8u",
+	"Photoshop::SliceInfo":    "int8u",
+}
+
+// photoshopValueConvs convert raw values whose meaning differs from their storage format
+var photoshopValueConvs = map[string]binaryConv{
+	"XResolution": fixedResolution,
+	"YResolution": fixedResolution,
+	// Stored as -4..8 for the quality levels 0..12 shown in the Save dialog
+	"PhotoshopQuality": func(v interface{}) interface{} {
+		if n, ok := v.(int); ok {
+			return n + 4
+		}
+		return v
+	},
+}
+
+// fixedResolution converts a resolution stored as a 16.16 fixed-point int32u
+func fixedResolution(v interface{}) interface{} {
+	if n, ok := v.(int); ok {
+		return float64(n) / 0x10000
+	}
+	return v
+}
+
+// photoshopDigest tracks the IPTC data of a resource block and the digest
+// Photoshop recorded for it, to tell whether another program changed the IPTC
+type photoshopDigest struct {
+	current string // MD5 of the IPTC resource, empty if there is none
+	stored  string // IPTCDigest resource, empty if there is none
+}
+
+// wantsPhotoshop reports whether a requested tag could be in Photoshop
+// image resources, including the IPTC, XMP and ICC data they can hold
+func (e *MetadataExtractor) wantsPhotoshop() bool {
+	for _, group := range []string{"Photoshop", "IPTC", "XMP", "ICC_Profile"} {
+		if e.filter.wantsGroup(tagGroups{group, ""}) {
+			return true
+		}
+	}
+	return e.filter.wants(groupsFor("File", "", nil), "CurrentIPTCDigest")
+}
+
+// extractPhotoshop walks a block of Photoshop image resources, as found in
+// JPEG APP13 segments, PSD files and TIFF tag 0x8649. Each resource is a
+// signature, a 16-bit ID, a Pascal name padded to an even length and a
+// 32-bit size followed by data padded to an even length.
+func (e *MetadataExtractor) extractPhotoshop(data []byte, baseOffset int64) bool {
+	e.loadModuleIfNeeded("Photoshop")
+	table := tags.AllTags["Photoshop::Main"]
+
+	var digest photoshopDigest
+	found := false
+	offset := 0
+	for offset+12 <= len(data) {
+		signature := string(data[offset : offset+4])
+		if !photoshopSignatures[signature] {
+			if len(bytes.Trim(data[offset:], "\x00")) > 0 {
+				e.warnf("Bad Photoshop resource signature at offset %d", baseOffset+int64(offset))
+			}
+			break
+		}
+		id := binary.BigEndian.Uint16(data[offset+4 : offset+6])
+		nameLen := int(data[offset+6])
+		pos := offset + 6 + (nameLen+2)&^1
+		if pos+4 > len(data) {
+			e.warnf("Photoshop resource 0x%04X at offset %d is truncated", id, baseOffset+int64(offset))
+			break
+		}
+		size := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		pos += 4
+		if size > len(data)-pos {
+			e.warnf("Photoshop resource 0x%04X at offset %d runs past the end of the data", id, baseOffset+int64(offset))
+			break
+		}
+		value := data[pos : pos+size]
+		valueOffset := baseOffset + int64(pos)
+		offset = pos + size + size&1
+
+		e.opts.emit(TraceEvent{Kind: TraceSegment, Level: TraceLevelStructure, Offset: valueOffset, Name: fmt.Sprintf("%s 0x%04X", signature, id), Detail: fmt.Sprintf("%d bytes", size)})
+		if e.extractPhotoshopResource(table, id, value, valueOffset, &digest) {
+			found = true
+		}
+	}
+
+	if digest.current != "" {
+		if e.filter.wants(groupsFor("File", "", nil), "CurrentIPTCDigest") {
+			e.metadata.addField(Field{Namespace: "File", Key: "CurrentIPTCDigest", Raw: digest.current, Value: digest.current})
+		}
+		if digest.stored != "" && digest.stored != digest.current {
+			e.warnf("IPTCDigest is not current. XMP may be out of sync")
+		}
+	}
+	return found
+}
+
+// extractPhotoshopResource decodes one image resource, handing embedded
+// IPTC, XMP and ICC data to their parsers and reading the rest from the
+// Photoshop tag tables
+func (e *MetadataExtractor) extractPhotoshopResource(table *tags.TagTable, id uint16, value []byte, offset int64, digest *photoshopDigest) bool {
+	switch id {
+	case psResourceIPTC:
+		sum := md5.Sum(value)
+		digest.current = hex.EncodeToString(sum[:])
+		if !e.filter.wantsGroup(groupsFor("IPTC", "", nil)) {
+			return false
+		}
+		e.loadModuleIfNeeded("IPTC")
+		return e.extractIPTCData(value, int(offset))
+	case psResourceXMP:
+		if !e.filter.wantsGroup(tagGroups{"XMP", ""}) {
+			return false
+		}
+		e.extractXMPPacket(value, nil)
+		return true
+	case psResourceICC:
+		if !e.filter.wantsGroup(tagGroups{"ICC_Profile", ""}) {
+			return false
+		}
+		return e.extractICCProfile(value, offset)
+	case psResourceIPTCDigest:
+		digest.stored = hex.EncodeToString(value)
+	}
+
+	def := photoshopTag(table, id)
+	if def == nil {
+		e.opts.emit(TraceEvent{Kind: TraceTag, Level: TraceLevelDetail, Offset: offset, Name: fmt.Sprintf("0x%04X", id), Detail: fmt.Sprintf("unknown Photoshop resource, %d bytes", len(value))})
+		return false
+	}
+	if sub := subDirectoryTable(*def); sub != nil {
+		format, ok := photoshopBinaryFormats[tagTableName(sub)]
+		if !ok || !e.filter.wantsTable(tagGroups{"Photoshop", ""}, sub) {
+			return false
+		}
+		return e.extractBinaryData("Photoshop", "", sub, format, value, offset, photoshopValueConvs)
+	}
+
+	var decoded interface{}
+	switch id {
+	case psResourceBGRThumb, psResourceThumbnail:
+		if len(value) <= psThumbnailHeader {
+			return false
+		}
+		decoded = value[psThumbnailHeader:]
+	case psResourceIPTCDigest:
+		decoded = digest.stored
+	default:
+		decoded = binaryValue(def.Format, value)
+	}
+	return e.addTableField("Photoshop", "", table, def, fmt.Sprintf("0x%04X", id), decoded, offset)
+}
+
+// photoshopTag looks up a resource ID in Photoshop::Main, whose IDs may or
+// may not be zero-padded
+func photoshopTag(table *tags.TagTable, id uint16) *tags.TagDef {
+	if def := lookupTag(table, fmt.Sprintf("0x%04X", id)); def != nil {
+		return def
+	}
+	return lookupTag(table, fmt.Sprintf("0x%X", id))
+}
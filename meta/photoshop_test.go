@@ -0,0 +1,131 @@
+package meta
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	"greg-hacke/go-metadata/tags"
+)
+
+// psResource encodes an image resource, padding its name and data
+func psResource(signature string, id uint16, name string, data []byte) []byte {
+	b := binary.BigEndian.AppendUint16([]byte(signature), id)
+	b = append(b, byte(len(name)))
+	b = append(b, name...)
+	if len(name)%2 == 0 {
+		b = append(b, 0)
+	}
+	b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+	b = append(b, data...)
+	if len(data)%2 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// be builds big-endian bytes from values of fixed-size integer types
+func be(values ...interface{}) []byte {
+	var buf bytes.Buffer
+	for _, v := range values {
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+	return buf.Bytes()
+}
+
+// withPhotoshopTables installs the Photoshop tables the tests use
+func withPhotoshopTables(t *testing.T) {
+	withTables(t, map[string]*tags.TagTable{
+		"Photoshop::Main": {ModuleName: "Photoshop", Tags: map[string]tags.TagDef{
+			"0x03ED": {ID: "0x03ED", Name: "ResolutionInfo", SubIFD: "Image::ExifTool::Photoshop::Resolution"},
+			"0x0404": {ID: "0x0404", Name: "IPTCData", SubIFD: "Image::ExifTool::IPTC::Main"},
+			"0x040A": {ID: "0x040A", Name: "CopyrightFlag", Format: "int8u", Values: map[string]string{"0": "False", "1": "True"}},
+			"0x040B": {ID: "0x040B", Name: "URL", Format: "string"},
+			"0x0425": {ID: "0x0425", Name: "IPTCDigest", Format: "undef"},
+		}},
+		"Photoshop::Resolution": {ModuleName: "Photoshop", Tags: map[string]tags.TagDef{
+			"0": {ID: "0", Name: "XResolution", Format: "int32u"},
+			"2": {ID: "2", Name: "DisplayedUnitsX", Values: map[string]string{"1": "inches", "2": "cm"}},
+			"4": {ID: "4", Name: "YResolution", Format: "int32u"},
+			"6": {ID: "6", Name: "DisplayedUnitsY", Values: map[string]string{"1": "inches", "2": "cm"}},
+		}},
+	})
+}
+
+func TestPhotoshop(t *testing.T) {
+	withPhotoshopTables(t)
+	withIPTCTables(t)
+
+	iptc := append(iimDataset(2, 25, "one"), iimDataset(2, 25, "two")...)
+	digest := md5.Sum(iptc)
+	// Each resolution is followed by its display unit and the unit of the width or height
+	resolution := be(uint32(300<<16), uint16(1), uint16(1), uint32(72<<16|0x8000), uint16(2), uint16(2))
+
+	data := bytes.Join([][]byte{
+		psResource("8BIM", 0x03ED, "", resolution),
+		psResource("8BIM", 0x0404, "IPTC", iptc),
+		psResource("8BIM", 0x0425, "", digest[:]),
+		psResource("PHUT", 0x040A, "odd", []byte{1}),
+		psResource("8BIM", 0x040B, "", []byte("http://example.com")),
+		psResource("8BIM", 0x7777, "unknown", []byte{1, 2, 3}),
+	}, nil)
+
+	e := testExtractor(nil)
+	if !e.extractPhotoshop(data, 0) {
+		t.Fatal("no resources found")
+	}
+	if len(e.metadata.Warnings) > 0 {
+		t.Errorf("warnings: %q", e.metadata.Warnings)
+	}
+	checkFields(t, e.metadata, []fieldCheck{
+		{"Photoshop", "XResolution", 300.0},
+		{"Photoshop", "DisplayedUnitsX", 1},
+		{"Photoshop", "YResolution", 72.5},
+		{"Photoshop", "DisplayedUnitsY", 2},
+		{"Photoshop", "CopyrightFlag", 1},
+		{"Photoshop", "URL", "http://example.com"},
+		{"IPTC", "Keywords", []string{"one", "two"}},
+		{"File", "CurrentIPTCDigest", hex.EncodeToString(digest[:])},
+	})
+	if f := e.metadata.field("CopyrightFlag"); f == nil || f.Print != "True" {
+		t.Errorf("CopyrightFlag = %+v, want True", f)
+	}
+}
+
+func TestPhotoshopWarnings(t *testing.T) {
+	withPhotoshopTables(t)
+	withIPTCTables(t)
+	iptc := iimDataset(2, 25, "one")
+	tests := []struct {
+		name    string
+		data    []byte
+		warning string
+	}{
+		{
+			"stale digest",
+			append(psResource("8BIM", 0x0404, "", iptc), psResource("8BIM", 0x0425, "", make([]byte, 16))...),
+			"IPTCDigest is not current. XMP may be out of sync",
+		},
+		{
+			"bad signature",
+			append(psResource("8BIM", 0x040B, "", []byte("url")), []byte("XXXX\x04\x0B\x00\x00\x00\x00\x00\x00")...),
+			"Bad Photoshop resource signature at offset 16",
+		},
+		{
+			"past the end",
+			psResource("8BIM", 0x040B, "", []byte("url"))[:14],
+			"Photoshop resource 0x040B at offset 0 runs past the end of the data",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := testExtractor(nil)
+			e.extractPhotoshop(tt.data, 0)
+			if len(e.metadata.Warnings) != 1 || e.metadata.Warnings[0] != tt.warning {
+				t.Errorf("warnings = %q, want %q", e.metadata.Warnings, tt.warning)
+			}
+		})
+	}
+}
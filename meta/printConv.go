@@ -0,0 +1,246 @@
+package meta
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"greg-hacke/go-metadata/tags"
+)
+
+// printConvs holds the print conversions that aren't expressed as value
+// mappings in the tag tables, keyed by tag name
+var printConvs = map[string]func(value interface{}) (string, bool){
+	"ExposureTime":            printExposureTime,
+	"FNumber":                 printFixed("%.1f"),
+	"FocalLength":             printFixed("%.1f mm"),
+	"FocalLengthIn35mmFormat": printFixed("%.0f mm"),
+	"GPSLatitude":             printCoordinate("N", "S"),
+	"GPSLongitude":            printCoordinate("E", "W"),
+	"GPSDestLatitude":         printCoordinate("N", "S"),
+	"GPSDestLongitude":        printCoordinate("E", "W"),
+	"GPSAltitude":             printAltitude,
+	"ExposureCompensation":    printFixed("%+.2g"),
+	"ExposureBiasValue":       printFixed("%+.2g"),
+	"DigitalZoomRatio":        printFixed("%.2g"),
+	"CompressedBitsPerPixel":  printFixed("%.4g"),
+	"SubjectDistance":         printFixed("%.2g m"),
+	"GPSDOP":                  printFixed("%.4g"),
+	"GPSImgDirection":         printFixed("%.4g"),
+	"GPSDestBearing":          printFixed("%.4g"),
+	"GPSSpeed":                printFixed("%.4g"),
+	"GPSTrack":                printFixed("%.4g"),
+	"GPSHPositioningError":    printFixed("%.4g m"),
+	"ExposureIndex":           printFixed("%.4g"),
+	"BrightnessValue":         printFixed("%.4g"),
+	"ProfileVersion":          printProfileVersion,
+	"JFIFVersion":             printJFIFVersion,
+	"YCbCrSubSampling":        printMapped(yCbCrSubSampling),
+	"Duration":                printDuration,
+	"TrackDuration":           printDuration,
+	"MediaDuration":           printDuration,
+	"AvgBitrate":              printBitrate,
+	"GPSCoordinates":          printGPSCoordinates,
+}
+
+// yCbCrSubSampling names the horizontal and vertical chroma subsampling
+// factors, as read from EXIF or a JPEG frame header
+var yCbCrSubSampling = map[string]string{
+	"1 1": "YCbCr4:4:4 (1 1)",
+	"2 1": "YCbCr4:2:2 (2 1)",
+	"2 2": "YCbCr4:2:0 (2 2)",
+	"4 1": "YCbCr4:1:1 (4 1)",
+	"4 2": "YCbCr4:1:0 (4 2)",
+	"1 2": "YCbCr4:4:0 (1 2)",
+	"1 4": "YCbCr4:4:1 (1 4)",
+	"2 4": "YCbCr4:2:1 (2 4)",
+}
+
+// printConv returns the human-readable form of value, applying the tag's
+// value mappings first and then any conversion known for the tag name
+func (e *MetadataExtractor) printConv(tagDef *tags.TagDef, value interface{}) string {
+	if tagDef != nil && len(tagDef.Values) > 0 {
+		if mapped, ok := e.applyValueMapping(value, tagDef).(string); ok && mapped != fmt.Sprint(value) {
+			return mapped
+		}
+	}
+	name := ""
+	if tagDef != nil {
+		name = tagDef.Name
+	}
+	return printValue(name, value)
+}
+
+// printValue converts value using the conversion registered for the tag name
+func printValue(name string, value interface{}) string {
+	if conv := printConvs[name]; conv != nil {
+		if s, ok := conv(value); ok {
+			return s
+		}
+	}
+	return formatValue(value)
+}
+
+// toFloat returns numeric values, including rationals, as a float
+func toFloat(value interface{}) (float64, bool) {
+	f := Field{Value: value}
+	return f.Float()
+}
+
+// printFixed formats numbers with a fixed printf layout
+func printFixed(layout string) func(interface{}) (string, bool) {
+	return func(value interface{}) (string, bool) {
+		x, ok := toFloat(value)
+		if !ok {
+			return "", false
+		}
+		return fmt.Sprintf(layout, x), true
+	}
+}
+
+// printExposureTime writes short exposures as fractions, like "1/250"
+func printExposureTime(value interface{}) (string, bool) {
+	secs, ok := toFloat(value)
+	if !ok || secs <= 0 {
+		return "", false
+	}
+	if secs < 0.25001 {
+		return fmt.Sprintf("1/%d", int(math.Round(1/secs))), true
+	}
+	return strings.TrimSuffix(fmt.Sprintf("%.1f", secs), ".0"), true
+}
+
+// printCoordinate writes signed decimal degrees as degrees, minutes and seconds
+func printCoordinate(positive, negative string) func(interface{}) (string, bool) {
+	return func(value interface{}) (string, bool) {
+		deg, ok := value.(float64)
+		if !ok {
+			return "", false
+		}
+		ref := positive
+		if deg < 0 {
+			ref = negative
+			deg = -deg
+		}
+		// Round to the printed precision first so 59.999" doesn't show as 60.00"
+		total := math.Round(deg*3600*100) / 100
+		d := math.Floor(total / 3600)
+		m := math.Floor((total - d*3600) / 60)
+		s := total - d*3600 - m*60
+		return fmt.Sprintf("%.0f deg %.0f' %.2f\" %s", d, m, s, ref), true
+	}
+}
+
+// printAltitude writes a signed altitude relative to sea level
+func printAltitude(value interface{}) (string, bool) {
+	alt, ok := toFloat(value)
+	if !ok {
+		return "", false
+	}
+	if alt < 0 {
+		return fmt.Sprintf("%.1f m Below Sea Level", -alt), true
+	}
+	return fmt.Sprintf("%.1f m Above Sea Level", alt), true
+}
+
+// printDuration writes seconds as "12.34 s" below 30 s and as "1:02:03" above
+func printDuration(value interface{}) (string, bool) {
+	secs, ok := toFloat(value)
+	if !ok {
+		return "", false
+	}
+	sign := ""
+	if secs < 0 {
+		sign, secs = "-", -secs
+	}
+	switch {
+	case secs == 0:
+		return "0 s", true
+	case secs < 30:
+		return fmt.Sprintf("%s%.2f s", sign, secs), true
+	}
+	total := int(secs + 0.5)
+	return fmt.Sprintf("%s%d:%.2d:%.2d", sign, total/3600, total/60%60, total%60), true
+}
+
+// printBitrate writes bits per second with the largest unit that keeps the
+// number at least 1, like "1.23 Mbps"
+func printBitrate(value interface{}) (string, bool) {
+	rate, ok := toFloat(value)
+	if !ok {
+		return "", false
+	}
+	units := []string{"bps", "kbps", "Mbps", "Gbps"}
+	i := 0
+	for ; rate >= 1000 && i < len(units)-1; i++ {
+		rate /= 1000
+	}
+	if rate < 100 {
+		return fmt.Sprintf("%.3g %s", rate, units[i]), true
+	}
+	return fmt.Sprintf("%.0f %s", rate, units[i]), true
+}
+
+// printGPSCoordinates writes a latitude, longitude and optional altitude,
+// as decoded from an ISO 6709 location
+func printGPSCoordinates(value interface{}) (string, bool) {
+	coords, ok := value.([]float64)
+	if !ok || len(coords) < 2 {
+		return "", false
+	}
+	lat, _ := printCoordinate("N", "S")(coords[0])
+	lon, _ := printCoordinate("E", "W")(coords[1])
+	parts := []string{lat, lon}
+	if len(coords) > 2 {
+		alt, _ := printAltitude(coords[2])
+		parts = append(parts, alt)
+	}
+	return strings.Join(parts, ", "), true
+}
+
+// printMapped looks up the printed form of a value in names
+func printMapped(names map[string]string) func(interface{}) (string, bool) {
+	return func(value interface{}) (string, bool) {
+		name, ok := names[formatValue(value)]
+		return name, ok
+	}
+}
+
+// printJFIFVersion writes a JFIF major and minor version as "1.02"
+func printJFIFVersion(value interface{}) (string, bool) {
+	v, ok := value.([]int)
+	if !ok || len(v) != 2 {
+		return "", false
+	}
+	return fmt.Sprintf("%d.%.2d", v[0], v[1]), true
+}
+
+// printProfileVersion writes an ICC version, major byte then minor and
+// bug-fix nibbles, as "4.3.0"
+func printProfileVersion(value interface{}) (string, bool) {
+	v, ok := value.(int)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("%d.%d.%d", v>>8, v>>4&0x0F, v&0x0F), true
+}
+
+// outputValue chooses what the keyed JSON view shows for a field: the
+// ValueConv in numeric mode, otherwise the PrintConv. Numbers whose print
+// form adds nothing stay numbers, and binary data is always summarized.
+func (m *Metadata) outputValue(field Field) interface{} {
+	switch field.Value.(type) {
+	case []byte, EmbeddedImage:
+		return field.Print
+	case time.Time:
+		if !m.numeric {
+			return field.Print
+		}
+		return field.Value
+	}
+	if m.numeric || field.Print == formatValue(field.Value) {
+		return field.Value
+	}
+	return field.Print
+}
@@ -4,33 +4,60 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"math"
+	"strconv"
 	"strings"
 
 	"greg-hacke/go-metadata/tags"
 )
 
+// headerSize is how much of the file start is kept for structure detection
+const headerSize = 64
+
+// iptcScanSize bounds how much data is examined after a possible IPTC marker
+const iptcScanSize = 1 << 20
+
 // MetadataExtractor handles the actual extraction of metadata
 type MetadataExtractor struct {
-	data          []byte
+	r             io.ReaderAt // Random access to the file being parsed
+	size          int64       // Size of the file in bytes
+	header        []byte      // First bytes of the file, for structure detection
 	metadata      *Metadata
 	tagTables     []*tags.TagTable
 	loadedModules map[string]bool // Track which modules we've loaded
+	opts          *options        // Logger, trace callback and verbosity
+	fileType      *FileType       // Identified file type, nil to sniff the header
+	filter        *tagFilter      // Requested tags, nil for all
+	base          int64           // Offset of r in the file, for embedded documents
+	images        []EmbeddedImage // Embedded images to read as documents once the file has been walked
+}
+
+// NewMetadataExtractor creates a new extractor reading size bytes from r
+func NewMetadataExtractor(r io.ReaderAt, size int64, metadata *Metadata, tagTables []*tags.TagTable, opts ...Option) *MetadataExtractor {
+	return newMetadataExtractor(r, size, metadata, tagTables, newOptions(opts))
 }
 
-// NewMetadataExtractor creates a new extractor
-func NewMetadataExtractor(data []byte, metadata *Metadata, tagTables []*tags.TagTable) *MetadataExtractor {
+// newMetadataExtractor creates a new extractor using already resolved options
+func newMetadataExtractor(r io.ReaderAt, size int64, metadata *Metadata, tagTables []*tags.TagTable, o *options) *MetadataExtractor {
 	// Build map of already loaded modules
 	loadedModules := make(map[string]bool)
 	for _, table := range tagTables {
 		loadedModules[strings.ToUpper(table.ModuleName)] = true
 	}
 
+	// Keep the start of the file for structure detection
+	header := make([]byte, min(headerSize, max(size, 0)))
+	n, _ := r.ReadAt(header, 0)
+
 	return &MetadataExtractor{
-		data:          data,
+		r:             r,
+		size:          size,
+		header:        header[:n],
 		metadata:      metadata,
 		tagTables:     tagTables,
 		loadedModules: loadedModules,
+		opts:          o,
 	}
 }
 
@@ -41,13 +68,10 @@ func (e *MetadataExtractor) loadModuleIfNeeded(moduleName string) {
 		return
 	}
 
-	fmt.Printf("    Dynamically loading %s tables...\n", moduleName)
 	count := 0
 
-	// Create a list to show what we're loading
-	var loadedNames []string
-
-	for tableName, table := range tags.AllTags {
+	for _, tableName := range sortedTableNames() {
+		table := tags.AllTags[tableName]
 		// More inclusive matching
 		tableUpper := strings.ToUpper(tableName)
 		moduleNameUpper := strings.ToUpper(table.ModuleName)
@@ -57,23 +81,30 @@ func (e *MetadataExtractor) loadModuleIfNeeded(moduleName string) {
 			(moduleUpper == "EXIF" && strings.Contains(tableUpper, "::MAIN")) {
 			e.tagTables = append(e.tagTables, table)
 			count++
-			// Collect first few table descriptions for debug
-			if count <= 3 {
-				loadedNames = append(loadedNames, fmt.Sprintf("%s (module: %s, %d tags)", tableName, table.ModuleName, len(table.Tags)))
-			}
+			e.opts.emit(TraceEvent{
+				Kind:   TraceTable,
+				Level:  TraceLevelStructure,
+				Offset: -1,
+				Name:   tableName,
+				Detail: fmt.Sprintf("module %s, %d tags, loaded for %s", table.ModuleName, len(table.Tags), moduleName),
+			})
 		}
 	}
 
 	if count > 0 {
 		e.loadedModules[moduleUpper] = true
-		fmt.Printf("    Loaded %d %s tables\n", count, moduleName)
-		// Show what we loaded
-		for _, name := range loadedNames {
-			fmt.Printf("      - %s\n", name)
-		}
-		if count > 3 {
-			fmt.Printf("      ... and %d more\n", count-3)
-		}
+	}
+}
+
+// warnf records a non-fatal problem with the file. Like ExifTool, the
+// first warning is also reported as the ExifTool:Warning tag.
+func (e *MetadataExtractor) warnf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	e.opts.logger.Warn(msg)
+	e.metadata.Warnings = append(e.metadata.Warnings, msg)
+
+	if len(e.metadata.Warnings) == 1 && e.filter.wants(groupsFor("ExifTool", "", nil), "Warning") {
+		e.metadata.addField(Field{Namespace: "ExifTool", Key: "Warning", Raw: msg, Value: msg})
 	}
 }
 
@@ -88,26 +119,29 @@ func (e *MetadataExtractor) ExtractAll() (bool, bool) {
 func (e *MetadataExtractor) extractEmbeddedMetadata() bool {
 	found := false
 
-	// Pattern 1: TIFF/EXIF header
-	found = e.scanForTIFFHeaders() || found
-
-	// Pattern 2: JPEG segments
-	if e.isJPEG() {
-		fmt.Println("  Detected JPEG structure")
-		found = e.scanJPEGSegments() || found
+	// Files in a registered format are walked by their handler
+	if name, h := e.formatHandler(); h != nil {
+		sink := &formatSink{e: e}
+		if err := h.Parse(e.r, e.size, sink); err != nil {
+			e.warnf("%s structure could not be fully read: %v", name, err)
+		}
+		sink.flushXMP()
+		return e.extractDocuments() || sink.found
 	}
 
-	// Pattern 3: PNG chunks
-	if e.isPNG() {
-		fmt.Println("  Detected PNG structure")
-		found = e.scanPNGChunks() || found
+	// Containers are walked by extractContainerMetadata, never scanned byte by byte
+	if e.isContainer() {
+		return found
 	}
 
-	// Pattern 4: IPTC data
-	found = e.scanForIPTC() || found
-
-	// Pattern 5: XMP data
-	found = e.scanForXMP() || found
+	// Unstructured files: scan for IPTC and XMP blocks one window at a time,
+	// skipping scans that cannot produce a requested tag
+	if e.filter.wantsGroup(groupsFor("IPTC", "", nil)) {
+		found = e.scanForIPTC() || found
+	}
+	if e.filter.wantsGroup(tagGroups{"XMP", ""}) {
+		found = e.scanForXMP() || found
+	}
 
 	return found
 }
@@ -117,239 +151,128 @@ func (e *MetadataExtractor) extractContainerMetadata() bool {
 	found := false
 
 	// Pattern 1: ZIP-based files (PK signature)
-	if len(e.data) > 4 && e.data[0] == 'P' && e.data[1] == 'K' && e.data[2] == 0x03 && e.data[3] == 0x04 {
-		fmt.Println("  Detected ZIP container structure")
+	if e.isZIP() {
+		e.opts.emit(TraceEvent{Kind: TraceSegment, Level: TraceLevelStructure, Offset: 0, Name: "ZIP", Detail: "ZIP container structure"})
 		// For now, just note it's a ZIP
 		// Full implementation would parse ZIP directory
 		found = true
 	}
 
 	// Pattern 2: PDF files
-	if len(e.data) > 5 && bytes.Equal(e.data[0:4], []byte("%PDF")) {
-		fmt.Println("  Detected PDF structure")
+	if len(e.header) > 5 && bytes.Equal(e.header[0:4], []byte("%PDF")) {
+		e.opts.emit(TraceEvent{Kind: TraceSegment, Level: TraceLevelStructure, Offset: 0, Name: "PDF", Detail: "PDF structure"})
 		// For now, just note it's a PDF
 		// Full implementation would parse PDF objects
 		found = true
 	}
 
-	// Pattern 3: QuickTime/MP4 atom structure
-	if len(e.data) > 12 {
-		// Check for ftyp atom
-		size := binary.BigEndian.Uint32(e.data[0:4])
-		if size > 8 && size < uint32(len(e.data)) && bytes.Equal(e.data[4:8], []byte("ftyp")) {
-			fmt.Println("  Detected QuickTime/MP4 atom structure")
-			found = true
-		}
-	}
-
 	return found
 }
 
-// Helper methods to identify file types
-func (e *MetadataExtractor) isJPEG() bool {
-	return len(e.data) > 2 && e.data[0] == 0xFF && e.data[1] == 0xD8
-}
-
-func (e *MetadataExtractor) isPNG() bool {
-	return len(e.data) > 8 && bytes.Equal(e.data[0:8], []byte{0x89, 'P', 'N', 'G', 0x0D, 0x0A, 0x1A, 0x0A})
+// Helper methods to identify container files
+func (e *MetadataExtractor) isZIP() bool {
+	return len(e.header) > 4 && e.header[0] == 'P' && e.header[1] == 'K' && e.header[2] == 0x03 && e.header[3] == 0x04
 }
 
-// scanForTIFFHeaders scans for TIFF/EXIF headers in the data
-func (e *MetadataExtractor) scanForTIFFHeaders() bool {
-	found := false
-	for i := 0; i < len(e.data)-8; i++ {
-		if (e.data[i] == 'I' && e.data[i+1] == 'I' && e.data[i+2] == 42 && e.data[i+3] == 0) ||
-			(e.data[i] == 'M' && e.data[i+1] == 'M' && e.data[i+2] == 0 && e.data[i+3] == 42) {
-			fmt.Printf("  Found TIFF/EXIF header at offset %d\n", i)
-			if e.extractTIFFMetadata(e.data[i:], i) {
-				found = true
-			}
-		}
+func (e *MetadataExtractor) isQuickTime() bool {
+	if len(e.header) <= 12 {
+		return false
 	}
-	return found
+	// Check for ftyp atom
+	size := binary.BigEndian.Uint32(e.header[0:4])
+	return size > 8 && int64(size) < e.size && bytes.Equal(e.header[4:8], []byte("ftyp"))
 }
 
-// scanJPEGSegments scans for metadata in JPEG segments
-func (e *MetadataExtractor) scanJPEGSegments() bool {
-	found := false
-	offset := 2 // Skip SOI
-
-	for offset < len(e.data)-4 {
-		if e.data[offset] != 0xFF {
-			offset++
-			continue
-		}
-
-		marker := e.data[offset+1]
-		offset += 2
+func (e *MetadataExtractor) isContainer() bool {
+	return e.isZIP() || e.isQuickTime()
+}
 
-		// Skip stuffing bytes and standalone markers
-		if marker == 0xFF || marker == 0x00 || marker == 0x01 ||
-			(marker >= 0xD0 && marker <= 0xD8) {
-			continue
-		}
+// scanForIPTC scans for IPTC data
+func (e *MetadataExtractor) scanForIPTC() bool {
+	// Load IPTC tables if needed
+	e.loadModuleIfNeeded("IPTC")
 
-		if marker == 0xDA {
-			break // Start of scan - image data follows
+	found := false
+	head := make([]byte, 5)
+	err := scanReaderAt(e.r, 0, e.size, []byte{0x1C}, func(i int64) bool {
+		if i+5 >= e.size {
+			return false
 		}
-
-		// Read segment length
-		if offset+2 > len(e.data) {
-			break
+		if _, err := e.r.ReadAt(head, i); err != nil {
+			return false
 		}
-		segLen := int(binary.BigEndian.Uint16(e.data[offset : offset+2]))
-		offset += 2
-
-		if offset+segLen-2 > len(e.data) {
-			break
+		if head[1] > 0x0F {
+			return true
 		}
 
-		segData := e.data[offset : offset+segLen-2]
-
-		// Check for known metadata markers
-		if marker == 0xE1 && bytes.HasPrefix(segData, []byte("Exif\x00\x00")) {
-			fmt.Printf("    APP1/EXIF segment\n")
-			e.extractTIFFMetadata(segData[6:], offset)
-			found = true
-		} else if marker == 0xED && bytes.HasPrefix(segData, []byte("Photoshop 3.0\x00")) {
-			fmt.Printf("    APP13/Photoshop segment\n")
-			// Load Photoshop tables if needed
-			e.loadModuleIfNeeded("Photoshop")
-			// Scan for IPTC within Photoshop data
-			for i := 14; i < len(segData)-5; i++ {
-				if segData[i] == 0x1C {
-					e.extractIPTCData(segData[i:], offset+i)
-					found = true
-					break
-				}
-			}
-		} else if marker == 0xFE {
-			// Comment
-			if comment := strings.TrimSpace(string(segData)); comment != "" {
-				e.metadata.Fields["Comment"] = comment
-				found = true
-			}
+		// Verify it looks like IPTC
+		dataLen := int64(binary.BigEndian.Uint16(head[3:5]))
+		if i+5+dataLen > e.size {
+			return true
 		}
-
-		offset += segLen - 2
-	}
-
-	return found
-}
-
-// scanPNGChunks scans for metadata in PNG chunks
-func (e *MetadataExtractor) scanPNGChunks() bool {
-	found := false
-	offset := 8 // Skip PNG signature
-
-	for offset < len(e.data)-12 {
-		chunkLen := int(binary.BigEndian.Uint32(e.data[offset : offset+4]))
-		if offset+8+chunkLen+4 > len(e.data) {
-			break
+		data, err := readBlock(e.r, i, min(e.size-i, iptcScanSize))
+		if err != nil {
+			return false
 		}
-
-		chunkType := string(e.data[offset+4 : offset+8])
-		chunkData := e.data[offset+8 : offset+8+chunkLen]
-
-		// Text chunks
-		if chunkType == "tEXt" || chunkType == "zTXt" || chunkType == "iTXt" {
-			if nullPos := bytes.IndexByte(chunkData, 0); nullPos > 0 {
-				key := string(chunkData[:nullPos])
-				value := string(chunkData[nullPos+1:])
-				e.metadata.Fields["PNG:"+key] = value
-				found = true
-			}
-		} else if chunkType == "eXIf" {
-			// EXIF in PNG
-			fmt.Printf("    eXIf chunk\n")
-			e.extractTIFFMetadata(chunkData, offset+8)
+		e.opts.emit(TraceEvent{Kind: TraceSegment, Level: TraceLevelStructure, Offset: i, Name: "IPTC", Detail: "IPTC data"})
+		if e.extractIPTCData(data, int(i)) {
 			found = true
 		}
-
-		offset += 8 + chunkLen + 4 // length + type + data + CRC
+		return false // Only process first IPTC block
+	})
+	if err != nil {
+		e.opts.logger.Debug("IPTC scan stopped", "error", err)
 	}
-
 	return found
 }
 
-// scanForIPTC scans for IPTC data
-func (e *MetadataExtractor) scanForIPTC() bool {
-	// Load IPTC tables if needed
-	e.loadModuleIfNeeded("IPTC")
-
+// scanForXMP scans for XMP data
+func (e *MetadataExtractor) scanForXMP() bool {
 	found := false
-	for i := 0; i < len(e.data)-5; i++ {
-		if e.data[i] == 0x1C && e.data[i+1] <= 0x0F && e.data[i+2] <= 0xFF {
-			// Verify it looks like IPTC
-			if i+5 < len(e.data) {
-				dataLen := int(binary.BigEndian.Uint16(e.data[i+3 : i+5]))
-				if i+5+dataLen <= len(e.data) {
-					fmt.Printf("  Found IPTC data at offset %d\n", i)
-					if e.extractIPTCData(e.data[i:], i) {
-						found = true
-					}
-					break // Only process first IPTC block
-				}
-			}
-		}
+	err := scanReaderAt(e.r, 0, e.size, []byte("<?xpacket begin="), func(xmpStart int64) bool {
+		// Look for the packet trailer, holding at most one block in memory
+		xmpEnd := int64(-1)
+		scanReaderAt(e.r, xmpStart, min(e.size, xmpStart+maxBlockSize), []byte("<?xpacket end="), func(off int64) bool {
+			xmpEnd = off
+			return false
+		})
+		if xmpEnd <= xmpStart {
+			return false
+		}
+
+		e.opts.emit(TraceEvent{Kind: TraceSegment, Level: TraceLevelStructure, Offset: xmpStart, Name: "XMP", Detail: "XMP packet"})
+		xmpData, err := readBlock(e.r, xmpStart, xmpEnd-xmpStart)
+		if err != nil {
+			return false
+		}
+		e.extractXMPPacket(xmpData, nil)
+		found = true
+		return true
+	})
+	if err != nil {
+		e.opts.logger.Debug("XMP scan stopped", "error", err)
 	}
 	return found
 }
 
-// scanForXMP scans for XMP data
-func (e *MetadataExtractor) scanForXMP() bool {
-	if xmpStart := bytes.Index(e.data, []byte("<?xpacket begin=")); xmpStart >= 0 {
-		if xmpEnd := bytes.Index(e.data[xmpStart:], []byte("<?xpacket end=")); xmpEnd > 0 {
-			fmt.Printf("  Found XMP data at offset %d\n", xmpStart)
-			xmpData := e.data[xmpStart : xmpStart+xmpEnd]
-
-			// Basic XMP extraction - look for common tags
-			// This is simplified - full XMP parsing would parse the XML properly
-			e.extractBasicXMP(xmpData)
-
-			e.metadata.Fields["XMPPacket"] = fmt.Sprintf("[%d bytes]", xmpEnd)
-			return true
+// extractXMPPacket records an XMP packet and the properties found in it.
+// The extended XMP its HasExtendedXMP names is merged in and removed from
+// extended, which holds packets by GUID.
+func (e *MetadataExtractor) extractXMPPacket(xmpData []byte, extended map[string][]byte) {
+	packet := e.parseXMP(xmpData)
+	packets := []xmpPacket{packet}
+	if guid := packet.extendedGUID(); guid != "" {
+		if data, ok := extended[guid]; ok {
+			packets = append(packets, e.parseXMP(data))
+			delete(extended, guid)
 		}
 	}
-	return false
-}
+	e.extractXMP(packets...)
 
-// extractBasicXMP performs basic XMP extraction
-func (e *MetadataExtractor) extractBasicXMP(xmpData []byte) {
-	// Load XMP tables if needed
-	e.loadModuleIfNeeded("XMP")
-
-	// Very basic extraction - look for common patterns
-	// Full implementation would use XML parser
-	patterns := map[string]string{
-		"<dc:creator>":            "Creator",
-		"<dc:description>":        "Description",
-		"<dc:title>":              "Title",
-		"<dc:subject>":            "Subject",
-		"<photoshop:Credit>":      "Credit",
-		"<photoshop:DateCreated>": "DateCreated",
-	}
-
-	for pattern, fieldName := range patterns {
-		if idx := bytes.Index(xmpData, []byte(pattern)); idx >= 0 {
-			// Look for the closing tag
-			endPattern := strings.Replace(pattern, "<", "</", 1)
-			if endIdx := bytes.Index(xmpData[idx:], []byte(endPattern)); endIdx > 0 {
-				// Extract value between tags
-				valueStart := idx + len(pattern)
-				value := string(xmpData[valueStart : idx+endIdx])
-
-				// Clean up any XML artifacts
-				value = strings.TrimSpace(value)
-
-				// Skip if it's a nested structure
-				if !strings.Contains(value, "<") {
-					e.metadata.Fields["XMP:"+fieldName] = value
-					fmt.Printf("    Found XMP:%s = %.50s\n", fieldName, value)
-				}
-			}
-		}
+	if !e.filter.wants(groupsFor("XMP", "", nil), "XMPPacket") {
+		return
 	}
+	e.metadata.addField(Field{Namespace: "XMP", Key: "XMPPacket", Raw: xmpData, Value: xmpData})
 }
 
 // hasEXIFTables checks if we have EXIF tables loaded
@@ -362,9 +285,16 @@ func (e *MetadataExtractor) hasEXIFTables() bool {
 	return false
 }
 
+// wantsEXIF reports whether a requested tag could come from a TIFF/EXIF
+// structure, including the maker notes inside it
+func (e *MetadataExtractor) wantsEXIF() bool {
+	return e.filter.wantsTable(tagGroups{"EXIF", ""}, tags.AllTags["Exif::Main"]) ||
+		e.filter.wantsGroup(tagGroups{"MakerNotes", ""}) || e.wantsPhotoshop()
+}
+
 // findBasicEXIFTable searches for where basic EXIF tags should be
 func (e *MetadataExtractor) findBasicEXIFTable() {
-	fmt.Println("\n=== Looking for Basic EXIF Tags in ALL tables ===")
+	log := e.opts.logger
 
 	// Search for ImageDescription (0x010E) in ALL tables
 	hexKey := "0x010E"
@@ -373,247 +303,29 @@ func (e *MetadataExtractor) findBasicEXIFTable() {
 	found := false
 	for tableName, table := range tags.AllTags {
 		if tag, ok := table.Tags[hexKey]; ok {
-			fmt.Printf("  Found 0x010E in %s (module: %s): %s\n", tableName, table.ModuleName, tag.Name)
+			log.Debug("basic EXIF tag located", "id", hexKey, "table", tableName, "module", table.ModuleName, "name", tag.Name)
 			found = true
 		}
 		if tag, ok := table.Tags[decKey]; ok {
-			fmt.Printf("  Found 270 in %s (module: %s): %s\n", tableName, table.ModuleName, tag.Name)
+			log.Debug("basic EXIF tag located", "id", decKey, "table", tableName, "module", table.ModuleName, "name", tag.Name)
 			found = true
 		}
 	}
 
 	if !found {
-		fmt.Println("  WARNING: Basic EXIF tag 0x010E (ImageDescription) not found in ANY table!")
-		fmt.Println("  This suggests the tag tables were not generated correctly.")
+		log.Warn("basic EXIF tag 0x010E (ImageDescription) not found in any table; tag tables may not have been generated correctly")
 	}
 
 	// Check if there's a table that should contain basic EXIF tags
 	for tableName, table := range tags.AllTags {
 		if strings.Contains(tableName, "Exif") && strings.Contains(tableName, "Main") {
-			fmt.Printf("\n  Checking %s for basic tags:\n", tableName)
-			if _, ok := table.Tags["0x010E"]; ok {
-				fmt.Println("    Has ImageDescription")
-			}
-			if _, ok := table.Tags["0x0112"]; ok {
-				fmt.Println("    Has Orientation")
-			}
-			if _, ok := table.Tags["0x011A"]; ok {
-				fmt.Println("    Has XResolution")
-			}
-			// Show first few tags in this table
-			count := 0
-			for key, tag := range table.Tags {
-				if count < 5 {
-					fmt.Printf("    Sample tag: %s -> %s\n", key, tag.Name)
-					count++
-				}
-			}
-		}
-	}
-}
-
-// extractTIFFMetadata extracts metadata from TIFF/EXIF structures
-func (e *MetadataExtractor) extractTIFFMetadata(data []byte, baseOffset int) bool {
-	if len(data) < 8 {
-		return false
-	}
-
-	// Debug: Find where basic EXIF tags should be
-	e.findBasicEXIFTable()
-
-	// Load EXIF tables when we encounter TIFF data
-	e.loadModuleIfNeeded("EXIF")
-	e.loadModuleIfNeeded("Exif")
-
-	// Debug what's loaded
-	e.debugTagTables()
-
-	// Determine byte order
-	var byteOrder binary.ByteOrder
-	if data[0] == 'I' && data[1] == 'I' {
-		byteOrder = binary.LittleEndian
-		fmt.Println("    Little-endian byte order")
-	} else if data[0] == 'M' && data[1] == 'M' {
-		byteOrder = binary.BigEndian
-		fmt.Println("    Big-endian byte order")
-	} else {
-		return false
-	}
-
-	// Check magic
-	if byteOrder.Uint16(data[2:4]) != 42 {
-		return false
-	}
-
-	// Get IFD offset
-	ifdOffset := byteOrder.Uint32(data[4:8])
-	fmt.Printf("    First IFD at offset: %d\n", ifdOffset)
-
-	// Process IFDs
-	processedTags := 0
-	skippedTags := 0
-	for ifdNum := 0; ifdOffset > 0 && ifdOffset < uint32(len(data)) && ifdNum < 10; ifdNum++ {
-		numEntries := byteOrder.Uint16(data[ifdOffset : ifdOffset+2])
-		fmt.Printf("    IFD%d: %d entries at offset %d\n", ifdNum, numEntries, ifdOffset)
-		offset := ifdOffset + 2
-
-		for i := 0; i < int(numEntries) && offset+12 <= uint32(len(data)); i++ {
-			tagID := byteOrder.Uint16(data[offset : offset+2])
-			dataType := byteOrder.Uint16(data[offset+2 : offset+4])
-			count := byteOrder.Uint32(data[offset+4 : offset+8])
-			valueOffset := byteOrder.Uint32(data[offset+8 : offset+12])
-
-			// Debug: show tag info
-			fmt.Printf("      Tag 0x%04X: type=%d count=%d offset=%d", tagID, dataType, count, valueOffset)
-
-			// Try to decode tag across all tables
-			tagInfo := e.findTagInTables(tagID)
-			if tagInfo != nil {
-				fmt.Printf(" -> %s", tagInfo.Name)
-				value := e.extractTagValue(data, dataType, count, valueOffset, byteOrder)
-				if value != nil {
-					// Apply value mapping if available
-					if tagInfo.Values != nil && len(tagInfo.Values) > 0 {
-						value = e.applyValueMapping(value, tagInfo)
-					}
-
-					key := tagInfo.Name
-					if key == "" {
-						key = fmt.Sprintf("Tag_%04X", tagID)
-					}
-
-					// Ensure unique keys
-					if _, exists := e.metadata.Fields[key]; exists {
-						key = fmt.Sprintf("%s_%d", key, baseOffset+int(offset))
-					}
-
-					e.metadata.Fields[key] = value
-					processedTags++
-					fmt.Printf(" = %v", value)
-				}
-			} else {
-				fmt.Printf(" -> UNKNOWN")
-				skippedTags++
-			}
-			fmt.Println()
-
-			offset += 12
-		}
-
-		// Next IFD
-		if offset+4 <= uint32(len(data)) {
-			ifdOffset = byteOrder.Uint32(data[offset : offset+4])
-		} else {
-			break
-		}
-	}
-
-	fmt.Printf("    Processed %d tags, skipped %d unknown tags\n", processedTags, skippedTags)
-	return processedTags > 0
-}
-
-// extractIPTCData extracts IPTC metadata
-func (e *MetadataExtractor) extractIPTCData(data []byte, baseOffset int) bool {
-	found := false
-	offset := 0
-
-	fmt.Printf("    Extracting IPTC data from offset %d\n", baseOffset)
-
-	for offset < len(data)-5 {
-		if data[offset] != 0x1C {
-			break
-		}
-
-		record := data[offset+1]
-		dataset := data[offset+2]
-		offset += 3
-
-		// Get length
-		var dataLen int
-		if data[offset]&0x80 != 0 {
-			// Extended length
-			lenBytes := int(data[offset] & 0x7F)
-			offset++
-			for i := 0; i < lenBytes && offset < len(data); i++ {
-				dataLen = (dataLen << 8) | int(data[offset])
-				offset++
-			}
-		} else {
-			// Standard length
-			if offset+2 > len(data) {
-				break
-			}
-			dataLen = int(binary.BigEndian.Uint16(data[offset : offset+2]))
-			offset += 2
-		}
-
-		if offset+dataLen > len(data) {
-			break
-		}
-
-		// Find tag in tables
-		iptcKey := fmt.Sprintf("%d:%d", record, dataset)
-		tagInfo := e.findIPTCTagInTables(iptcKey)
-
-		// Debug what we found
-		fmt.Printf("      IPTC %s (record=%d, dataset=%d, len=%d)", iptcKey, record, dataset, dataLen)
-
-		if tagInfo != nil {
-			value := string(data[offset : offset+dataLen])
-			key := tagInfo.Name
-			if key == "" {
-				key = fmt.Sprintf("IPTC_%s", iptcKey)
-			}
-
-			// Special handling for Keywords (2:25) - accumulate them
-			if key == "Keywords" {
-				if existing, ok := e.metadata.Fields[key]; ok {
-					// Append to existing keywords
-					if existingStr, ok := existing.(string); ok && existingStr != "" {
-						value = existingStr + ", " + value
-					}
-				}
-			}
-
-			e.metadata.Fields[key] = value
-			found = true
-			fmt.Printf(" -> %s = %.50s\n", key, value)
-		} else {
-			fmt.Printf(" -> UNKNOWN\n")
-		}
-
-		offset += dataLen
-	}
-
-	return found
-}
-
-// findTagInTables searches for a tag across all loaded tables
-func (e *MetadataExtractor) findTagInTables(tagID uint16) *tags.TagDef {
-	hexKey := fmt.Sprintf("0x%04X", tagID)
-	decKey := fmt.Sprintf("%d", tagID)
-
-	for _, table := range e.tagTables {
-		if tag, ok := table.Tags[hexKey]; ok {
-			return &tag
-		}
-		if tag, ok := table.Tags[decKey]; ok {
-			return &tag
+			_, hasDescription := table.Tags["0x010E"]
+			_, hasOrientation := table.Tags["0x0112"]
+			_, hasXResolution := table.Tags["0x011A"]
+			log.Debug("EXIF main table check", "table", tableName,
+				"ImageDescription", hasDescription, "Orientation", hasOrientation, "XResolution", hasXResolution)
 		}
 	}
-	return nil
-}
-
-// findIPTCTagInTables searches for IPTC tags
-func (e *MetadataExtractor) findIPTCTagInTables(key string) *tags.TagDef {
-	for _, table := range e.tagTables {
-		if strings.Contains(strings.ToUpper(table.ModuleName), "IPTC") {
-			if tag, ok := table.Tags[key]; ok {
-				return &tag
-			}
-		}
-	}
-	return nil
 }
 
 // getTypeName returns a readable name for TIFF data types
@@ -643,11 +355,40 @@ func (e *MetadataExtractor) applyValueMapping(value interface{}, tagDef *tags.Ta
 	if mapped, ok := tagDef.Values[key]; ok {
 		return mapped
 	}
+	if _, ok := value.(string); ok {
+		return value
+	}
+
+	// Tables keyed in hex, such as 0x010001, and bit masks
+	n, _ := strconv.ParseInt(key, 10, 64)
+	bits := false
+	for k, mapped := range tagDef.Values {
+		if strings.HasPrefix(k, "0x") {
+			if h, err := strconv.ParseInt(k[2:], 16, 64); err == nil && h == n {
+				return mapped
+			}
+		}
+		bits = bits || strings.HasPrefix(k, "bit")
+	}
+	if bits && n > 0 {
+		var names []string
+		for bit := 0; bit < 64; bit++ {
+			if n&(1<<bit) == 0 {
+				continue
+			}
+			if name, ok := tagDef.Values[fmt.Sprintf("bit%d", bit)]; ok {
+				names = append(names, name)
+			} else {
+				names = append(names, fmt.Sprintf("[%d]", bit))
+			}
+		}
+		return strings.Join(names, ", ")
+	}
 	return value
 }
 
 // extractTagValue extracts value based on TIFF data type
-func (e *MetadataExtractor) extractTagValue(data []byte, dataType uint16, count uint32, offset uint32, byteOrder binary.ByteOrder) interface{} {
+func (e *MetadataExtractor) extractTagValue(t *tiffBlock, dataType uint16, count uint32, offset uint32) interface{} {
 	typeSizes := map[uint16]uint32{
 		1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8,
 		13: 4, // IFD
@@ -661,7 +402,13 @@ func (e *MetadataExtractor) extractTagValue(data []byte, dataType uint16, count
 		return nil
 	}
 
-	totalSize := size * count
+	// Compute in 64 bits so a corrupt count cannot wrap around
+	total := uint64(size) * uint64(count)
+	if total > maxBlockSize {
+		return nil
+	}
+	totalSize := uint32(total)
+	byteOrder := t.byteOrder
 	var valueData []byte
 
 	if totalSize <= 4 {
@@ -669,10 +416,10 @@ func (e *MetadataExtractor) extractTagValue(data []byte, dataType uint16, count
 		byteOrder.PutUint32(buf, offset)
 		valueData = buf[:totalSize]
 	} else {
-		if int(offset+totalSize) > len(data) {
+		var ok bool
+		if valueData, ok = t.read(offset, totalSize); !ok {
 			return nil
 		}
-		valueData = data[offset : offset+totalSize]
 	}
 
 	// Handle based on type
@@ -711,29 +458,12 @@ func (e *MetadataExtractor) extractTagValue(data []byte, dataType uint16, count
 		return vals
 
 	case 5: // RATIONAL
-		if count == 1 {
-			num := byteOrder.Uint32(valueData[0:4])
-			den := byteOrder.Uint32(valueData[4:8])
-			if den == 0 {
-				return "inf"
-			}
-			if num%den == 0 {
-				return num / den
-			}
-			return fmt.Sprintf("%d/%d", num, den)
-		}
-		// Return array of rationals as strings
-		vals := make([]string, count)
+		vals := make([]Rational, count)
 		for i := uint32(0); i < count; i++ {
-			num := byteOrder.Uint32(valueData[i*8 : i*8+4])
-			den := byteOrder.Uint32(valueData[i*8+4 : i*8+8])
-			if den == 0 {
-				vals[i] = "inf"
-			} else if num%den == 0 {
-				vals[i] = fmt.Sprintf("%d", num/den)
-			} else {
-				vals[i] = fmt.Sprintf("%d/%d", num, den)
-			}
+			vals[i] = Rational{byteOrder.Uint32(valueData[i*8:]), byteOrder.Uint32(valueData[i*8+4:])}
+		}
+		if count == 1 {
+			return vals[0]
 		}
 		return vals
 
@@ -748,7 +478,18 @@ func (e *MetadataExtractor) extractTagValue(data []byte, dataType uint16, count
 		return vals
 
 	case 7: // UNDEFINED
-		return valueData // Always return as []byte
+		// Versions and similar codes are text; short binary values are lists of bytes
+		if text := bytes.TrimRight(valueData, "\x00"); len(text) > 0 && isPrintable(text) {
+			return string(text)
+		}
+		if count <= 16 {
+			vals := make([]int, count)
+			for i, b := range valueData {
+				vals[i] = int(b)
+			}
+			return vals
+		}
+		return valueData
 
 	case 8: // SSHORT
 		if count == 1 {
@@ -771,28 +512,12 @@ func (e *MetadataExtractor) extractTagValue(data []byte, dataType uint16, count
 		return vals
 
 	case 10: // SRATIONAL
-		if count == 1 {
-			num := int32(byteOrder.Uint32(valueData[0:4]))
-			den := int32(byteOrder.Uint32(valueData[4:8]))
-			if den == 0 {
-				return "inf"
-			}
-			if num%den == 0 {
-				return num / den
-			}
-			return fmt.Sprintf("%d/%d", num, den)
-		}
-		vals := make([]string, count)
+		vals := make([]SRational, count)
 		for i := uint32(0); i < count; i++ {
-			num := int32(byteOrder.Uint32(valueData[i*8 : i*8+4]))
-			den := int32(byteOrder.Uint32(valueData[i*8+4 : i*8+8]))
-			if den == 0 {
-				vals[i] = "inf"
-			} else if num%den == 0 {
-				vals[i] = fmt.Sprintf("%d", num/den)
-			} else {
-				vals[i] = fmt.Sprintf("%d/%d", num, den)
-			}
+			vals[i] = SRational{int32(byteOrder.Uint32(valueData[i*8:])), int32(byteOrder.Uint32(valueData[i*8+4:]))}
+		}
+		if count == 1 {
+			return vals[0]
 		}
 		return vals
 
@@ -826,29 +551,36 @@ func (e *MetadataExtractor) extractTagValue(data []byte, dataType uint16, count
 	}
 }
 
+// isPrintable reports whether data is plain ASCII text
+func isPrintable(data []byte) bool {
+	for _, b := range data {
+		if b < 0x20 || b > 0x7E {
+			return false
+		}
+	}
+	return true
+}
+
 // debugTagTables helps debug which tables are loaded and where tags are found
 func (e *MetadataExtractor) debugTagTables() {
-	fmt.Println("\n=== Debug: Tag Tables Content ===")
+	log := e.opts.logger
 
 	// Look for specific tags we know should exist
 	testTags := []uint16{0x010E, 0x0112, 0x011A, 0x011B, 0x0128, 0x013B}
 	tagNames := []string{"ImageDescription", "Orientation", "XResolution", "YResolution", "ResolutionUnit", "Artist"}
 
 	for i, tagID := range testTags {
-		fmt.Printf("\nSearching for tag 0x%04X (%s):\n", tagID, tagNames[i])
 		hexKey := fmt.Sprintf("0x%04X", tagID)
 		decKey := fmt.Sprintf("%d", tagID)
 
 		found := false
 		for _, table := range e.tagTables {
 			if tag, ok := table.Tags[hexKey]; ok {
-				fmt.Printf("  FOUND in loaded table (module: %s) as hex key\n", table.ModuleName)
-				fmt.Printf("    Name: %s\n", tag.Name)
+				log.Debug("tag found in loaded table", "id", hexKey, "expected", tagNames[i], "module", table.ModuleName, "name", tag.Name)
 				found = true
 			}
 			if tag, ok := table.Tags[decKey]; ok {
-				fmt.Printf("  FOUND in loaded table (module: %s) as decimal key\n", table.ModuleName)
-				fmt.Printf("    Name: %s\n", tag.Name)
+				log.Debug("tag found in loaded table", "id", decKey, "expected", tagNames[i], "module", table.ModuleName, "name", tag.Name)
 				found = true
 			}
 		}
@@ -857,31 +589,19 @@ func (e *MetadataExtractor) debugTagTables() {
 			// Check ALL tables in tags.AllTags
 			for tableName, table := range tags.AllTags {
 				if tag, ok := table.Tags[hexKey]; ok {
-					fmt.Printf("  EXISTS in tags.AllTags[\"%s\"] (module: %s) but NOT LOADED\n", tableName, table.ModuleName)
-					fmt.Printf("    Tag Name: %s\n", tag.Name)
+					log.Debug("tag exists but table not loaded", "id", hexKey, "table", tableName, "module", table.ModuleName, "name", tag.Name)
 				}
 				if tag, ok := table.Tags[decKey]; ok {
-					fmt.Printf("  EXISTS in tags.AllTags[\"%s\"] (module: %s) as decimal but NOT LOADED\n", tableName, table.ModuleName)
-					fmt.Printf("    Tag Name: %s\n", tag.Name)
+					log.Debug("tag exists but table not loaded", "id", decKey, "table", tableName, "module", table.ModuleName, "name", tag.Name)
 				}
 			}
 		}
 	}
 
-	// Show what modules are loaded
-	fmt.Println("\n=== Loaded Modules ===")
+	// Show what modules and tables are loaded
+	modules := make([]string, 0, len(e.loadedModules))
 	for module := range e.loadedModules {
-		fmt.Printf("  %s\n", module)
-	}
-
-	// Show what tables are actually loaded
-	fmt.Printf("\n=== Loaded Tables (%d total) ===\n", len(e.tagTables))
-	for i, table := range e.tagTables {
-		if i < 10 || strings.Contains(strings.ToUpper(table.ModuleName), "EXIF") {
-			fmt.Printf("  Table[%d]: Module=%s, Tags=%d\n", i, table.ModuleName, len(table.Tags))
-		}
-	}
-	if len(e.tagTables) > 10 {
-		fmt.Printf("  ... and %d more tables\n", len(e.tagTables)-10)
+		modules = append(modules, module)
 	}
+	log.Debug("loaded modules", "modules", modules, "tables", len(e.tagTables))
 }
@@ -0,0 +1,99 @@
+package meta
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"greg-hacke/go-metadata/tags"
+)
+
+// Field represents a single extracted metadata value
+type Field struct {
+	Namespace string      // Family 0 group: general location (e.g. "EXIF", "IPTC", "XMP", "File")
+	Directory string      // Family 1 group: specific location (e.g. "IFD0", "ExifIFD", "GPS", "XMP-dc")
+	Category  string      // Family 2 group: kind of information (e.g. "Camera", "Image", "Time", "Location")
+	Key       string      // Tag name (e.g. "Make", "Keywords")
+	TagID     string      // Tag ID as keyed in its table (e.g. "0x010F", "2:25")
+	Raw       interface{} // Value as decoded from the file
+	Value     interface{} // ValueConv: typed numeric value (Rational, time.Time, float64 degrees, lists), e.g. 1
+	Print     string      // PrintConv: human-readable value, e.g. "Horizontal (normal)"
+	Table     string      // Source tag table (e.g. "Exif::Main"), empty for derived fields
+	Document  int         // Family 3 group: 0 for the file itself, n for embedded document "Doc<n>"
+
+	key string // Key of the field in Metadata.Fields
+}
+
+// ReadMetadata extracts all metadata fields from the file at filePath
+func ReadMetadata(filePath string, opts ...Option) ([]Field, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open file: %w", err)
+	}
+	defer file.Close()
+
+	return readMetadataWithPath(file, filePath, newOptions(opts))
+}
+
+// ReadMetadataFrom extracts all metadata fields from an already opened file
+func ReadMetadataFrom(file io.ReadSeeker, opts ...Option) ([]Field, error) {
+	return readMetadataWithPath(file, "", newOptions(opts))
+}
+
+// ReadMetadataAt extracts all metadata fields from size bytes of random-access data.
+// Only the parts of the file that hold metadata are read.
+func ReadMetadataAt(r io.ReaderAt, size int64, opts ...Option) ([]Field, error) {
+	o := newOptions(opts)
+	metadata, _, err := extractFromFile(r, size, "", o.requested, o)
+	if err != nil {
+		return nil, err
+	}
+	return metadata.List, nil
+}
+
+// readMetadataWithPath extracts fields with optional path for extension detection
+func readMetadataWithPath(file io.ReadSeeker, filePath string, o *options) ([]Field, error) {
+	r, size, err := readerAtFor(file)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, _, err := extractFromFile(r, size, filePath, o.requested, o)
+	if err != nil {
+		return nil, err
+	}
+	return metadata.List, nil
+}
+
+var (
+	tableNamesOnce sync.Once
+	tableNames     map[*tags.TagTable]string
+	sortedNames    []string
+)
+
+// initTableNames indexes AllTags once
+func initTableNames() {
+	tableNamesOnce.Do(func() {
+		tableNames = make(map[*tags.TagTable]string, len(tags.AllTags))
+		for name, t := range tags.AllTags {
+			tableNames[t] = name
+			sortedNames = append(sortedNames, name)
+		}
+		sort.Strings(sortedNames)
+	})
+}
+
+// tagTableName returns the AllTags key a loaded table was registered under
+func tagTableName(table *tags.TagTable) string {
+	initTableNames()
+	return tableNames[table]
+}
+
+// sortedTableNames returns the AllTags keys in sorted order, so that
+// searches over the tables give the same answer on every run
+func sortedTableNames() []string {
+	initTableNames()
+	return sortedNames
+}
@@ -0,0 +1,101 @@
+package meta
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// maxBlockSize caps how much of a single metadata block is held in memory
+const maxBlockSize = 16 << 20
+
+// scanWindowSize is the amount read at a time when scanning for byte patterns
+const scanWindowSize = 64 << 10
+
+// readBlock reads exactly n bytes at off, refusing blocks larger than maxBlockSize
+func readBlock(r io.ReaderAt, off int64, n int64) ([]byte, error) {
+	if n < 0 || n > maxBlockSize {
+		return nil, fmt.Errorf("block of %d bytes at offset %d exceeds limit", n, off)
+	}
+	buf := make([]byte, n)
+	read, err := r.ReadAt(buf, off)
+	if read == len(buf) {
+		// ReadAt may report io.EOF alongside a complete read at the end of the file
+		return buf, nil
+	}
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return nil, err
+}
+
+// scanReaderAt calls fn with the offset of each occurrence of pattern in
+// [start, end), reading one window at a time. fn returns false to stop.
+func scanReaderAt(r io.ReaderAt, start, end int64, pattern []byte, fn func(off int64) bool) error {
+	if len(pattern) == 0 {
+		return nil
+	}
+	buf := make([]byte, scanWindowSize+len(pattern)-1)
+	for pos := start; pos < end; pos += scanWindowSize {
+		n, err := r.ReadAt(buf[:min(int64(len(buf)), end-pos)], pos)
+		if n == 0 {
+			if err == nil || err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		window := buf[:n]
+
+		for i := 0; i < len(window); {
+			idx := bytes.Index(window[i:], pattern)
+			if idx < 0 || i+idx >= scanWindowSize {
+				// Matches starting in the overlap are found by the next window
+				break
+			}
+			if !fn(pos + int64(i+idx)) {
+				return nil
+			}
+			i += idx + 1
+		}
+	}
+	return nil
+}
+
+// seekReaderAt adapts an io.ReadSeeker to io.ReaderAt by seeking before each read
+type seekReaderAt struct {
+	mu sync.Mutex
+	rs io.ReadSeeker
+}
+
+// ReadAt implements io.ReaderAt
+func (s *seekReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.rs.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(s.rs, p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// readerAtFor returns random access to file along with its size
+func readerAtFor(file io.ReadSeeker) (io.ReaderAt, int64, error) {
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, 0, fmt.Errorf("cannot determine size: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, fmt.Errorf("cannot seek to start: %w", err)
+	}
+
+	if ra, ok := file.(io.ReaderAt); ok {
+		return ra, size, nil
+	}
+	return &seekReaderAt{rs: file}, size, nil
+}
@@ -0,0 +1,376 @@
+package meta
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"greg-hacke/go-metadata/tags"
+)
+
+// maxIFDDepth bounds how deeply directory pointers are followed
+const maxIFDDepth = 8
+
+// maxIFDChain bounds how many directories are read from one next-IFD chain
+const maxIFDChain = 64
+
+// tiffBlock gives bounded random access to a TIFF structure embedded in a file
+type tiffBlock struct {
+	r         io.ReaderAt
+	base      int64 // File offset of the TIFF header
+	size      int64 // Bytes available from base
+	byteOrder binary.ByteOrder
+	visited   map[uint32]bool // Directory offsets already read, for loop detection
+
+	cameraMake string // Make from IFD0, used to choose the maker note layout
+}
+
+// read returns length bytes at offset off from the TIFF header
+func (t *tiffBlock) read(off, length uint32) ([]byte, bool) {
+	if int64(off)+int64(length) > t.size {
+		return nil, false
+	}
+	data, err := readBlock(t.r, t.base+int64(off), int64(length))
+	return data, err == nil
+}
+
+// offsets decodes the directory offsets held by a pointer tag
+func (t *tiffBlock) offsets(dataType uint16, count uint32, valueOffset uint32) []uint32 {
+	if dataType != 4 && dataType != 13 {
+		return nil
+	}
+	if count == 1 {
+		return []uint32{valueOffset}
+	}
+	if count > 1<<16 {
+		return nil
+	}
+	data, ok := t.read(valueOffset, count*4)
+	if !ok {
+		return nil
+	}
+	offsets := make([]uint32, count)
+	for i := range offsets {
+		offsets[i] = t.byteOrder.Uint32(data[i*4:])
+	}
+	return offsets
+}
+
+// ifdPointers maps tags that point to another directory to the name of that directory
+var ifdPointers = map[uint16]string{
+	0x8769: "ExifIFD",
+	0x8825: "GPS",
+	0xA005: "InteropIFD",
+	0x014A: "SubIFD",
+}
+
+// ifdTables names the table used for each directory when the pointer tag doesn't say
+var ifdTables = map[string]string{
+	"IFD0":       "Exif::Main",
+	"ExifIFD":    "Exif::Main",
+	"GPS":        "GPS::Main",
+	"InteropIFD": "Exif::Main",
+	"SubIFD":     "Exif::Main",
+}
+
+// directoryTable returns the table holding tag definitions for directory dir,
+// preferring the table named by the pointer tag that led to it
+func directoryTable(dir string, pointer *tags.TagDef) *tags.TagTable {
+	if pointer != nil {
+		if table := subDirectoryTable(*pointer); table != nil {
+			return table
+		}
+	}
+	base := strings.TrimRight(dir, "0123456789")
+	if base == "IFD" {
+		base = "IFD0"
+	}
+	return tags.AllTags[ifdTables[base]]
+}
+
+// extractTIFFMetadata extracts metadata from the TIFF/EXIF structure at base
+func (e *MetadataExtractor) extractTIFFMetadata(r io.ReaderAt, base, size int64) bool {
+	if size < 8 {
+		return false
+	}
+
+	// Skip the whole structure when no requested tag can live in it
+	if !e.wantsEXIF() {
+		return false
+	}
+
+	// Debug: Find where basic EXIF tags should be
+	if e.opts.debugEnabled() {
+		e.findBasicEXIFTable()
+	}
+
+	// Load EXIF tables when we encounter TIFF data
+	e.loadModuleIfNeeded("EXIF")
+	e.loadModuleIfNeeded("Exif")
+	e.loadModuleIfNeeded("GPS")
+
+	// Debug what's loaded
+	if e.opts.debugEnabled() {
+		e.debugTagTables()
+	}
+
+	t, ifdOffset, ok := openTIFF(r, base, size)
+	if !ok {
+		return false
+	}
+	e.opts.logger.Debug("TIFF header", "offset", base, "byteOrder", t.byteOrder.String(), "firstIFD", ifdOffset)
+
+	// Walk the main chain: IFD0 holds the image, IFD1 the thumbnail, and
+	// multi-page files continue with IFD2 and on
+	firstField := len(e.metadata.List)
+	processedTags := 0
+	for ifdNum := 0; ifdOffset != 0 && ifdNum < maxIFDChain; ifdNum++ {
+		dir := fmt.Sprintf("IFD%d", ifdNum)
+		var tagCount int
+		ifdOffset, tagCount = e.processIFD(t, ifdOffset, tagGroups{"EXIF", dir}, directoryTable(dir, nil), 0)
+		processedTags += tagCount
+	}
+
+	e.metadata.mergeEXIFValues(firstField)
+
+	e.opts.logger.Debug("TIFF directories processed", "offset", base, "tags", processedTags, "directories", len(t.visited))
+	return processedTags > 0
+}
+
+// openTIFF reads the TIFF header at base, returning the structure and the
+// offset of its first directory
+func openTIFF(r io.ReaderAt, base, size int64) (*tiffBlock, uint32, bool) {
+	header := make([]byte, 8)
+	if _, err := r.ReadAt(header, base); err != nil {
+		return nil, 0, false
+	}
+
+	// Determine byte order
+	t := &tiffBlock{r: r, base: base, size: size, visited: make(map[uint32]bool)}
+	if header[0] == 'I' && header[1] == 'I' {
+		t.byteOrder = binary.LittleEndian
+	} else if header[0] == 'M' && header[1] == 'M' {
+		t.byteOrder = binary.BigEndian
+	} else {
+		return nil, 0, false
+	}
+
+	// Check magic
+	if t.byteOrder.Uint16(header[2:4]) != 42 {
+		return nil, 0, false
+	}
+	return t, t.byteOrder.Uint32(header[4:8]), true
+}
+
+// processIFD reads the directory at ifdOffset, resolving every tag against
+// table only, and follows pointers to sub-directories. It returns the offset
+// of the next directory in the chain and the number of tags recorded.
+func (e *MetadataExtractor) processIFD(t *tiffBlock, ifdOffset uint32, groups tagGroups, table *tags.TagTable, depth int) (uint32, int) {
+	dir := groups[1]
+	if t.visited[ifdOffset] {
+		e.warnf("%s at offset %d was already read, ignoring directory loop", dir, t.base+int64(ifdOffset))
+		return 0, 0
+	}
+	if depth > maxIFDDepth {
+		e.warnf("%s at offset %d is nested too deeply", dir, t.base+int64(ifdOffset))
+		return 0, 0
+	}
+	t.visited[ifdOffset] = true
+
+	byteOrder := t.byteOrder
+	countData, ok := t.read(ifdOffset, 2)
+	if !ok {
+		e.warnf("%s offset %d is outside the TIFF data", dir, ifdOffset)
+		return 0, 0
+	}
+	numEntries := byteOrder.Uint16(countData)
+	entries, ok := t.read(ifdOffset+2, uint32(numEntries)*12+4)
+	if !ok {
+		// Tolerate a truncated directory by reading only whole entries
+		e.warnf("%s at offset %d is truncated", dir, t.base+int64(ifdOffset))
+		avail := min(t.size-int64(ifdOffset)-2, int64(numEntries)*12)
+		numEntries = uint16(max(avail, 0) / 12)
+		if entries, ok = t.read(ifdOffset+2, uint32(numEntries)*12); !ok {
+			return 0, 0
+		}
+	}
+
+	e.opts.emit(TraceEvent{
+		Kind:   TraceIFD,
+		Level:  TraceLevelStructure,
+		Offset: t.base + int64(ifdOffset),
+		Name:   dir,
+		Detail: fmt.Sprintf("%d entries", numEntries),
+	})
+
+	if table == nil {
+		e.opts.logger.Debug("no tag table for directory", "directory", dir)
+	}
+
+	// Directories that can't hold a requested tag are still walked for pointers
+	wantDir := e.filter.wantsTable(groups, table)
+
+	processedTags := 0
+	skippedTags := 0
+	subIFDs := 0
+	for i := 0; i < int(numEntries); i++ {
+		entry := entries[i*12 : i*12+12]
+		entryOffset := t.base + int64(ifdOffset) + 2 + int64(i)*12
+		tagID := byteOrder.Uint16(entry[0:2])
+		dataType := byteOrder.Uint16(entry[2:4])
+		count := byteOrder.Uint32(entry[4:8])
+		valueOffset := byteOrder.Uint32(entry[8:12])
+
+		// Tag IDs are only meaningful within the directory's own table
+		tagInfo, tagTable := e.findTagInTable(table, tagID)
+
+		// The Make decides how maker notes are read, so keep it even when not requested
+		if tagID == 0x010F && dir == "IFD0" && dataType == 2 {
+			if cameraMake, ok := e.extractTagValue(t, dataType, count, valueOffset).(string); ok {
+				t.cameraMake = strings.TrimSpace(cameraMake)
+			}
+		}
+
+		if tagInfo == nil {
+			skippedTags++
+			e.opts.emit(TraceEvent{
+				Kind:   TraceTag,
+				Level:  TraceLevelDetail,
+				Offset: entryOffset,
+				Name:   fmt.Sprintf("0x%04X", tagID),
+				Detail: fmt.Sprintf("unknown %s[%d] in %s at %d", e.getTypeName(dataType), count, dir, valueOffset),
+			})
+		} else if tiffEmbeddedBlocks[tagID] && groups[0] == "EXIF" || tagID == mpfImageList && groups[0] == "MPF" {
+			// Decoded below rather than kept whole
+		} else if !wantDir || !e.filter.wants(groupsFor(groups[0], dir, tagInfo), tagInfo.Name) {
+			// Not requested, so leave the value undecoded
+			skippedTags++
+		} else if e.recordTIFFTag(t, groups, tagInfo, tagTable, tagID, dataType, count, valueOffset, entryOffset) {
+			processedTags++
+		}
+
+		// Follow pointers to sub-directories whether or not the pointer itself is wanted
+		if tagID == 0x927C && dir == "ExifIFD" {
+			processedTags += e.processMakerNote(t, count, valueOffset, depth)
+		} else if tiffEmbeddedBlocks[tagID] && groups[0] == "EXIF" {
+			processedTags += e.processEmbeddedBlock(t, tagID, count, valueOffset)
+		} else if tagID == mpfImageList && groups[0] == "MPF" {
+			processedTags += e.processMPImages(t, tagInfo, count, valueOffset)
+		} else if subDir, ok := ifdPointers[tagID]; ok && groups[0] == "EXIF" {
+			for n, subOffset := range t.offsets(dataType, count, valueOffset) {
+				name := subDir
+				if tagID == 0x014A {
+					// SubIFD, SubIFD1, SubIFD2, ...
+					if subIFDs > 0 {
+						name = fmt.Sprintf("SubIFD%d", subIFDs)
+					}
+					subIFDs++
+				} else if n > 0 {
+					break
+				}
+				if subOffset == 0 {
+					continue
+				}
+				e.opts.emit(TraceEvent{Kind: TraceIFD, Level: TraceLevelStructure, Offset: entryOffset, Name: name, Detail: fmt.Sprintf("pointer from %s to %d", dir, subOffset)})
+				_, subCount := e.processIFD(t, subOffset, tagGroups{"EXIF", name}, directoryTable(name, tagInfo), depth+1)
+				processedTags += subCount
+			}
+		}
+	}
+
+	e.opts.logger.Debug("TIFF directory processed", "directory", dir, "tags", processedTags, "unknown", skippedTags)
+
+	// Next IFD
+	if len(entries) < int(numEntries)*12+4 {
+		return 0, processedTags
+	}
+	return byteOrder.Uint32(entries[int(numEntries)*12:]), processedTags
+}
+
+// recordTIFFTag decodes one directory entry and adds it to the metadata
+func (e *MetadataExtractor) recordTIFFTag(t *tiffBlock, groups tagGroups, tagInfo *tags.TagDef, table *tags.TagTable, tagID, dataType uint16, count, valueOffset uint32, entryOffset int64) bool {
+	raw := e.extractTagValue(t, dataType, count, valueOffset)
+	if raw == nil {
+		return false
+	}
+
+	// Dates become time.Time; zones and sub-seconds are merged in once the directory is read
+	value := raw
+	if s, ok := value.(string); ok {
+		if t, ok := parseExifTime(s); ok {
+			value = t
+		}
+	}
+
+	key := tagInfo.Name
+	if key == "" {
+		key = fmt.Sprintf("Tag_%04X", tagID)
+	}
+
+	// Repeated names are told apart by their group, e.g. "IFD1:XResolution"
+	g := groupsFor(groups[0], groups[1], tagInfo)
+	e.metadata.addField(Field{
+		Namespace: g[0],
+		Directory: g[1],
+		Category:  g[2],
+		Key:       key,
+		TagID:     fmt.Sprintf("0x%04X", tagID),
+		Raw:       raw,
+		Value:     value,
+		Print:     e.printConv(tagInfo, value),
+		Table:     tagTableName(table),
+	})
+	e.opts.emit(TraceEvent{
+		Kind:   TraceTag,
+		Level:  TraceLevelTags,
+		Offset: entryOffset,
+		Name:   tagInfo.Name,
+		Detail: fmt.Sprintf("%s 0x%04X %s[%d] = %s", groups[1], tagID, e.getTypeName(dataType), count, traceValue(e.printConv(tagInfo, value))),
+	})
+	return true
+}
+
+// findTagInTable looks up a numeric tag ID in a single table
+func (e *MetadataExtractor) findTagInTable(table *tags.TagTable, tagID uint16) (*tags.TagDef, *tags.TagTable) {
+	if table == nil {
+		return nil, nil
+	}
+	if tag, ok := table.Tags[fmt.Sprintf("0x%04X", tagID)]; ok {
+		return &tag, table
+	}
+	if tag, ok := table.Tags[fmt.Sprintf("%d", tagID)]; ok {
+		return &tag, table
+	}
+	return nil, nil
+}
+
+// tiffEmbeddedBlocks are the tags whose data is another kind of metadata
+// block: Photoshop image resources (0x8649) and an ICC profile (0x8773)
+var tiffEmbeddedBlocks = map[uint16]bool{
+	0x8649: true,
+	0x8773: true,
+}
+
+// processEmbeddedBlock decodes the metadata block held by one of the
+// tiffEmbeddedBlocks, returning the number of tags recorded
+func (e *MetadataExtractor) processEmbeddedBlock(t *tiffBlock, tagID uint16, count, valueOffset uint32) int {
+	var extract func(data []byte, offset int64) bool
+	switch {
+	case tagID == 0x8649 && e.wantsPhotoshop():
+		extract = e.extractPhotoshop
+	case tagID == 0x8773 && e.filter.wantsGroup(tagGroups{"ICC_Profile", ""}):
+		extract = e.extractICCProfile
+	}
+	if extract == nil || count <= 4 {
+		return 0
+	}
+	data, ok := t.read(valueOffset, count)
+	if !ok {
+		e.warnf("Tag 0x%04X data at offset %d is outside the TIFF data", tagID, t.base+int64(valueOffset))
+		return 0
+	}
+	before := len(e.metadata.List)
+	extract(data, t.base+int64(valueOffset))
+	return len(e.metadata.List) - before
+}
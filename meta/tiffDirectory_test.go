@@ -0,0 +1,166 @@
+package meta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"greg-hacke/go-metadata/tags"
+)
+
+// tiffEntry encodes a big-endian directory entry
+func tiffEntry(tagID, dataType uint16, count, value uint32) []byte {
+	b := binary.BigEndian.AppendUint16(nil, tagID)
+	b = binary.BigEndian.AppendUint16(b, dataType)
+	b = binary.BigEndian.AppendUint32(b, count)
+	return binary.BigEndian.AppendUint32(b, value)
+}
+
+// tiffText encodes up to four bytes of ASCII text held in an entry
+func tiffText(tagID uint16, s string) []byte {
+	value := make([]byte, 4)
+	copy(value, s+"\x00")
+	return tiffEntry(tagID, 2, uint32(len(s)+1), binary.BigEndian.Uint32(value))
+}
+
+// tiffIFD encodes a directory of entries followed by the offset of the next
+func tiffIFD(next uint32, entries ...[]byte) []byte {
+	b := binary.BigEndian.AppendUint16(nil, uint16(len(entries)))
+	b = append(b, bytes.Join(entries, nil)...)
+	return binary.BigEndian.AppendUint32(b, next)
+}
+
+// tiffFile encodes a big-endian TIFF whose directories follow the header
+// one after the other, the first at offset 8
+func tiffFile(ifds ...[]byte) []byte {
+	return append([]byte("MM\x00\x2A\x00\x00\x00\x08"), bytes.Join(ifds, nil)...)
+}
+
+// withExifTables installs the EXIF tables the TIFF tests use
+func withExifTables(t *testing.T) {
+	withTables(t, map[string]*tags.TagTable{
+		"Exif::Main": {ModuleName: "Exif", Tags: map[string]tags.TagDef{
+			"0x010E": {ID: "0x010E", Name: "ImageDescription"},
+			"0x010F": {ID: "0x010F", Name: "Make"},
+			"0x014A": {ID: "0x014A", Name: "SubIFD", SubIFD: "Image::ExifTool::Exif::Main"},
+			"0x8769": {ID: "0x8769", Name: "ExifOffset", SubIFD: "Image::ExifTool::Exif::Main"},
+		}},
+	})
+}
+
+// extractTestTIFF decodes data as a TIFF file
+func extractTestTIFF(t *testing.T, data []byte) *Metadata {
+	t.Helper()
+	e := testExtractor(nil)
+	e.extractTIFFMetadata(bytes.NewReader(data), 0, int64(len(data)))
+	return e.metadata
+}
+
+// count returns the number of fields named key
+func (m *Metadata) count(key string) int {
+	n := 0
+	for _, f := range m.List {
+		if f.Key == key {
+			n++
+		}
+	}
+	return n
+}
+
+func TestTIFFDirectoryLoops(t *testing.T) {
+	withExifTables(t)
+
+	// A chain of directories, each holding one description and leading
+	// through a SubIFD pointer to the next
+	nested := func(n int) []byte {
+		var ifds [][]byte
+		for i := range n {
+			entries := [][]byte{tiffText(0x010E, "abc")}
+			if i < n-1 {
+				entries = append(entries, tiffEntry(0x014A, 4, 1, uint32(8+30*(i+1))))
+			} else {
+				entries = append(entries, tiffText(0x010F, "end"))
+			}
+			ifds = append(ifds, tiffIFD(0, entries...))
+		}
+		return tiffFile(ifds...)
+	}
+	// A next-IFD chain of n directories
+	chain := func(n int) []byte {
+		var ifds [][]byte
+		for i := range n {
+			next := uint32(8 + 18*(i+1))
+			if i == n-1 {
+				next = 0
+			}
+			ifds = append(ifds, tiffIFD(next, tiffText(0x010E, "abc")))
+		}
+		return tiffFile(ifds...)
+	}
+
+	tests := []struct {
+		name         string
+		file         []byte
+		descriptions int
+		warning      string
+	}{
+		{
+			"next IFD points back",
+			tiffFile(tiffIFD(8, tiffText(0x010E, "abc"))),
+			1, "IFD1 at offset 8 was already read, ignoring directory loop",
+		},
+		{
+			"next IFD points to IFD0 from IFD1",
+			tiffFile(tiffIFD(26, tiffText(0x010E, "abc")), tiffIFD(8, tiffText(0x010E, "def"))),
+			2, "IFD2 at offset 8 was already read, ignoring directory loop",
+		},
+		{
+			"self-referencing ExifIFD",
+			tiffFile(tiffIFD(0, tiffEntry(0x8769, 4, 1, 26)), tiffIFD(0, tiffText(0x010E, "abc"), tiffEntry(0x8769, 4, 1, 26))),
+			1, "ExifIFD at offset 26 was already read, ignoring directory loop",
+		},
+		{"deepest SubIFD", nested(maxIFDDepth + 1), maxIFDDepth + 1, ""},
+		{
+			"SubIFDs nested too deeply",
+			nested(maxIFDDepth + 2), maxIFDDepth + 1,
+			fmt.Sprintf("SubIFD at offset %d is nested too deeply", 8+30*(maxIFDDepth+1)),
+		},
+		{"longest chain", chain(maxIFDChain), maxIFDChain, ""},
+		{"chain too long", chain(maxIFDChain + 5), maxIFDChain, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := extractTestTIFF(t, tt.file)
+			if got := m.count("ImageDescription"); got != tt.descriptions {
+				t.Errorf("%d descriptions read, want %d", got, tt.descriptions)
+			}
+			switch {
+			case tt.warning == "" && len(m.Warnings) > 0:
+				t.Errorf("warnings: %q", m.Warnings)
+			case tt.warning != "" && (len(m.Warnings) != 1 || m.Warnings[0] != tt.warning):
+				t.Errorf("warnings = %q, want %q", m.Warnings, tt.warning)
+			}
+		})
+	}
+}
+
+func TestTIFFBadDirectories(t *testing.T) {
+	withExifTables(t)
+	tests := []struct {
+		name    string
+		file    []byte
+		warning string
+	}{
+		{"IFD0 outside the file", append([]byte("MM\x00\x2A\x00\x00\x01\x00"), make([]byte, 8)...), "IFD0 offset 256 is outside the TIFF data"},
+		{"truncated", tiffFile(tiffIFD(0, tiffText(0x010E, "abc"), tiffText(0x010F, "xyz")))[:8+2+12+6], "IFD0 at offset 8 is truncated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := extractTestTIFF(t, tt.file)
+			if len(m.Warnings) != 1 || m.Warnings[0] != tt.warning {
+				t.Errorf("warnings = %q, want %q", m.Warnings, tt.warning)
+			}
+		})
+	}
+}
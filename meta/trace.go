@@ -0,0 +1,85 @@
+package meta
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// TraceKind identifies what a TraceEvent describes
+type TraceKind int
+
+const (
+	TraceSegment TraceKind = iota // A segment, chunk or block was found in the file
+	TraceIFD                      // A TIFF image file directory was entered
+	TraceTag                      // A tag was decoded
+	TraceTable                    // A tag table was loaded
+)
+
+// Verbosity levels at which each kind of event is emitted
+const (
+	TraceLevelStructure = 1 // Segments, directories and tables
+	TraceLevelTags      = 2 // Individual decoded tags
+	TraceLevelDetail    = 3 // Unknown tags and other parser internals
+)
+
+// String returns the name of the event kind
+func (k TraceKind) String() string {
+	switch k {
+	case TraceSegment:
+		return "segment"
+	case TraceIFD:
+		return "ifd"
+	case TraceTag:
+		return "tag"
+	case TraceTable:
+		return "table"
+	}
+	return "unknown"
+}
+
+// TraceEvent describes a single step taken while parsing a file
+type TraceEvent struct {
+	Kind   TraceKind
+	Level  int    // Verbosity level of the event (see TraceLevel constants)
+	Offset int64  // Byte offset in the file, or -1 if not applicable
+	Name   string // Segment, directory, tag or table name
+	Detail string // Additional human-readable information
+}
+
+// TraceFunc receives trace events as the file is parsed
+type TraceFunc func(TraceEvent)
+
+// emit delivers an event to the trace callback and the debug logger
+func (o *options) emit(ev TraceEvent) {
+	if ev.Level > o.verbosity {
+		return
+	}
+	if o.trace != nil {
+		o.trace(ev)
+	}
+	if o.logger.Enabled(context.Background(), slog.LevelDebug) {
+		attrs := []any{"name", ev.Name}
+		if ev.Offset >= 0 {
+			attrs = append(attrs, "offset", ev.Offset)
+		}
+		if ev.Detail != "" {
+			attrs = append(attrs, "detail", ev.Detail)
+		}
+		o.logger.Debug(ev.Kind.String(), attrs...)
+	}
+}
+
+// debugEnabled reports whether debug logging is active
+func (o *options) debugEnabled() bool {
+	return o.logger.Enabled(context.Background(), slog.LevelDebug)
+}
+
+// traceValue formats a value for an event detail, truncated to a readable length
+func traceValue(value interface{}) string {
+	text := fmt.Sprint(value)
+	if len(text) > 50 {
+		text = text[:50] + "..."
+	}
+	return text
+}
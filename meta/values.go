@@ -0,0 +1,358 @@
+package meta
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rational is an unsigned TIFF RATIONAL value
+type Rational struct {
+	Num uint32
+	Den uint32
+}
+
+// Float64 returns the value as a float, +Inf or NaN when the denominator is zero
+func (r Rational) Float64() float64 {
+	if r.Den == 0 {
+		if r.Num == 0 {
+			return math.NaN()
+		}
+		return math.Inf(1)
+	}
+	return float64(r.Num) / float64(r.Den)
+}
+
+// String formats the value as ExifTool does without print conversion
+func (r Rational) String() string {
+	return formatRational(int64(r.Num), int64(r.Den))
+}
+
+// MarshalJSON writes the value as a JSON number where possible
+func (r Rational) MarshalJSON() ([]byte, error) {
+	return marshalRational(int64(r.Num), int64(r.Den))
+}
+
+// SRational is a signed TIFF SRATIONAL value
+type SRational struct {
+	Num int32
+	Den int32
+}
+
+// Float64 returns the value as a float, ±Inf or NaN when the denominator is zero
+func (r SRational) Float64() float64 {
+	if r.Den == 0 {
+		if r.Num == 0 {
+			return math.NaN()
+		}
+		return math.Inf(int(r.Num))
+	}
+	return float64(r.Num) / float64(r.Den)
+}
+
+// String formats the value as ExifTool does without print conversion
+func (r SRational) String() string {
+	return formatRational(int64(r.Num), int64(r.Den))
+}
+
+// MarshalJSON writes the value as a JSON number where possible
+func (r SRational) MarshalJSON() ([]byte, error) {
+	return marshalRational(int64(r.Num), int64(r.Den))
+}
+
+// formatRational writes whole numbers plainly and others as a decimal
+func formatRational(num, den int64) string {
+	switch {
+	case den == 0 && num == 0:
+		return "undef"
+	case den == 0:
+		return "inf"
+	case num%den == 0:
+		return strconv.FormatInt(num/den, 10)
+	}
+	return strconv.FormatFloat(float64(num)/float64(den), 'g', 10, 64)
+}
+
+// marshalRational writes a rational as a JSON number, or a string for inf/undef
+func marshalRational(num, den int64) ([]byte, error) {
+	if den == 0 {
+		return []byte(strconv.Quote(formatRational(num, den))), nil
+	}
+	return []byte(formatRational(num, den)), nil
+}
+
+// exifTimeLayout is the date format used by EXIF and IPTC-derived tags
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// parseExifTime parses "YYYY:MM:DD HH:MM:SS" or a bare "YYYY:MM:DD". The
+// result is in UTC until a time zone offset is known.
+func parseExifTime(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	switch len(s) {
+	case len(exifTimeLayout):
+		t, err := time.Parse(exifTimeLayout, s)
+		return t, err == nil
+	case len("2006:01:02"):
+		t, err := time.Parse("2006:01:02", s)
+		return t, err == nil
+	}
+	return time.Time{}, false
+}
+
+// withOffset moves a wall-clock time into the zone given as "+HH:MM"
+func withOffset(t time.Time, offset string) (time.Time, bool) {
+	zone, err := time.Parse("-07:00", strings.TrimSpace(offset))
+	if err != nil {
+		return t, false
+	}
+	_, secs := zone.Zone()
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.FixedZone("", secs)), true
+}
+
+// withSubSec adds fractional seconds given as the digits after the decimal point
+func withSubSec(t time.Time, subSec string) (time.Time, bool) {
+	digits := strings.TrimSpace(subSec)
+	if digits == "" || len(digits) > 9 {
+		return t, false
+	}
+	n, err := strconv.Atoi(digits)
+	if err != nil || n < 0 {
+		return t, false
+	}
+	for i := len(digits); i < 9; i++ {
+		n *= 10
+	}
+	return t.Add(time.Duration(n)), true
+}
+
+// formatTime writes a time as ExifTool does, including sub-seconds and zone when known
+func formatTime(t time.Time) string {
+	s := t.Format(exifTimeLayout)
+	if t.Nanosecond() != 0 {
+		s += strings.TrimRight(fmt.Sprintf(".%09d", t.Nanosecond()), "0")
+	}
+	if t.Location() != time.UTC {
+		s += t.Format("-07:00")
+	}
+	return s
+}
+
+// degrees converts a degrees, minutes, seconds triple to decimal degrees
+func degrees(dms []Rational) (float64, bool) {
+	if len(dms) == 0 || len(dms) > 3 {
+		return 0, false
+	}
+	value := 0.0
+	scale := 1.0
+	for _, part := range dms {
+		f := part.Float64()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return 0, false
+		}
+		value += f / scale
+		scale *= 60
+	}
+	return value, true
+}
+
+// exifTimeTags maps each EXIF date tag to its OffsetTime and SubSecTime companions
+var exifTimeTags = map[string][2]string{
+	"ModifyDate":       {"OffsetTime", "SubSecTime"},
+	"DateTimeOriginal": {"OffsetTimeOriginal", "SubSecTimeOriginal"},
+	"CreateDate":       {"OffsetTimeDigitized", "SubSecTimeDigitized"},
+}
+
+// mergeEXIFValues combines related EXIF fields recorded from index start on:
+// dates gain their time zone and sub-seconds, GPS coordinates become signed
+// decimal degrees, and GPSDateStamp gains the GPSTimeStamp time of day
+func (m *Metadata) mergeEXIFValues(start int) {
+	// Index the raw values of this TIFF structure by tag name
+	raw := make(map[string]interface{})
+	for _, f := range m.List[start:] {
+		if f.Namespace == "EXIF" {
+			raw[f.Key] = f.Raw
+		}
+	}
+
+	for i := start; i < len(m.List); i++ {
+		f := &m.List[i]
+		if f.Namespace != "EXIF" {
+			continue
+		}
+
+		if companions, ok := exifTimeTags[f.Key]; ok {
+			t, ok := f.Value.(time.Time)
+			if !ok {
+				continue
+			}
+			if s, ok := raw[companions[1]].(string); ok {
+				t, _ = withSubSec(t, s)
+			}
+			if s, ok := raw[companions[0]].(string); ok {
+				t, _ = withOffset(t, s)
+			}
+			m.setValue(i, t)
+			continue
+		}
+
+		switch f.Key {
+		case "GPSLatitude", "GPSLongitude", "GPSDestLatitude", "GPSDestLongitude":
+			dms, ok := f.Raw.([]Rational)
+			if !ok {
+				continue
+			}
+			value, ok := degrees(dms)
+			if !ok {
+				continue
+			}
+			if ref, ok := raw[f.Key+"Ref"].(string); ok && (strings.HasPrefix(ref, "S") || strings.HasPrefix(ref, "W")) {
+				value = -value
+			}
+			m.setValue(i, value)
+
+		case "GPSAltitude":
+			r, ok := f.Raw.(Rational)
+			if !ok {
+				continue
+			}
+			value := r.Float64()
+			if ref, ok := raw["GPSAltitudeRef"].(int); ok && ref == 1 {
+				value = -value // Below sea level
+			}
+			m.setValue(i, value)
+
+		case "GPSDateStamp":
+			date, ok := f.Value.(time.Time)
+			if !ok {
+				continue
+			}
+			if hms, ok := raw["GPSTimeStamp"].([]Rational); ok && len(hms) == 3 {
+				secs, _ := degrees(hms)
+				date = date.Add(time.Duration(math.Round(secs * 3600 * float64(time.Second))))
+			}
+			m.setValue(i, date)
+		}
+	}
+}
+
+// setValue replaces the value of the field at index i in both views
+func (m *Metadata) setValue(i int, value interface{}) {
+	field := &m.List[i]
+	field.Value = value
+	field.Print = printValue(field.Key, value)
+	if field.key != "" {
+		m.Fields[field.key] = m.outputValue(*field)
+	}
+}
+
+// Int returns the value as an integer when it is a whole number
+func (f Field) Int() (int64, bool) {
+	switch v := f.Value.(type) {
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint32:
+		return int64(v), true
+	case Rational, SRational, float32, float64:
+		if x, ok := f.Float(); ok && x == math.Trunc(x) && !math.IsInf(x, 0) {
+			return int64(x), true
+		}
+	}
+	return 0, false
+}
+
+// Float returns numeric values, including rationals, as a float
+func (f Field) Float() (float64, bool) {
+	switch v := f.Value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case Rational:
+		return v.Float64(), v.Den != 0
+	case SRational:
+		return v.Float64(), v.Den != 0
+	}
+	return 0, false
+}
+
+// Time returns date values, with any time zone and sub-seconds merged in
+func (f Field) Time() (time.Time, bool) {
+	t, ok := f.Value.(time.Time)
+	return t, ok
+}
+
+// Rat returns an unsigned rational value
+func (f Field) Rat() (Rational, bool) {
+	r, ok := f.Value.(Rational)
+	return r, ok
+}
+
+// List returns the elements of a list value in order, or the value itself
+// as a single element. Binary data is not split into bytes.
+func (f Field) List() []interface{} {
+	if f.Value == nil {
+		return nil
+	}
+	if _, ok := f.Value.([]byte); ok {
+		return []interface{}{f.Value}
+	}
+	v := reflect.ValueOf(f.Value)
+	if v.Kind() != reflect.Slice {
+		return []interface{}{f.Value}
+	}
+	list := make([]interface{}, v.Len())
+	for i := range list {
+		list[i] = v.Index(i).Interface()
+	}
+	return list
+}
+
+// String returns the human-readable (PrintConv) form of the value
+func (f Field) String() string {
+	if f.Print != "" {
+		return f.Print
+	}
+	return formatValue(f.Value)
+}
+
+// formatValue writes a value the way ExifTool lists it
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		return formatTime(v)
+	case []byte:
+		return fmt.Sprintf("(Binary data %d bytes)", len(v))
+	case fmt.Stringer:
+		return v.String()
+	}
+
+	// Lists are space separated, as ExifTool prints numeric arrays
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Slice {
+		parts := make([]string, rv.Len())
+		for i := range parts {
+			parts[i] = formatValue(rv.Index(i).Interface())
+		}
+		sep := " "
+		if elem := rv.Type().Elem(); elem.Kind() == reflect.String || elem == reflect.TypeOf(time.Time{}) {
+			sep = ", "
+		}
+		return strings.Join(parts, sep)
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,482 @@
+package meta
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"greg-hacke/go-metadata/tags"
+)
+
+const (
+	rdfNS = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	xmlNS = "http://www.w3.org/XML/1998/namespace"
+)
+
+// xmpPrefixes maps namespace URIs to the prefixes ExifTool names their tag
+// tables and groups by, whatever prefix a packet declares for them.
+// Namespaces not listed keep the packet's prefix.
+var xmpPrefixes = map[string]string{
+	"http://purl.org/dc/elements/1.1/":                         "dc",
+	"http://ns.adobe.com/xap/1.0/":                             "xmp",
+	"http://ns.adobe.com/xap/1.0/rights/":                      "xmpRights",
+	"http://ns.adobe.com/xap/1.0/mm/":                          "xmpMM",
+	"http://ns.adobe.com/xap/1.0/bj/":                          "xmpBJ",
+	"http://ns.adobe.com/xap/1.0/t/pg/":                        "xmpTPg",
+	"http://ns.adobe.com/xmp/1.0/DynamicMedia/":                "xmpDM",
+	"http://ns.adobe.com/xmp/note/":                            "xmpNote",
+	"http://ns.adobe.com/pdf/1.3/":                             "pdf",
+	"http://ns.adobe.com/photoshop/1.0/":                       "photoshop",
+	"http://ns.adobe.com/camera-raw-settings/1.0/":             "crs",
+	"http://ns.adobe.com/tiff/1.0/":                            "tiff",
+	"http://ns.adobe.com/exif/1.0/":                            "exif",
+	"http://cipa.jp/exif/1.0/":                                 "exifEX",
+	"http://ns.adobe.com/exif/1.0/aux/":                        "aux",
+	"http://ns.adobe.com/lightroom/1.0/":                       "lr",
+	"http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/":              "iptcCore",
+	"http://iptc.org/std/Iptc4xmpExt/2008-02-29/":              "iptcExt",
+	"http://ns.useplus.org/ldf/xmp/1.0/":                       "plus",
+	"http://www.metadataworkinggroup.com/schemas/regions/":     "mwg-rs",
+	"http://www.metadataworkinggroup.com/schemas/keywords/":    "mwg-kw",
+	"http://www.metadataworkinggroup.com/schemas/collections/": "mwg-coll",
+	"http://ns.google.com/photos/1.0/panorama/":                "GPano",
+	"http://ns.google.com/photos/1.0/camera/":                  "GCamera",
+	"http://ns.microsoft.com/photo/1.0/":                       "microsoft",
+	"adobe:ns:meta/":                                           "x",
+}
+
+// xmlElement is an element of a parsed XMP packet
+type xmlElement struct {
+	name     xml.Name
+	attrs    []xml.Attr
+	children []*xmlElement
+	text     string
+}
+
+// attr returns the value of the attribute in namespace ns
+func (el *xmlElement) attr(ns, local string) (string, bool) {
+	for _, a := range el.attrs {
+		if a.Name.Space == ns && a.Name.Local == local {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// is reports whether the element is rdf:local
+func (el *xmlElement) is(local string) bool {
+	return el.name.Space == rdfNS && el.name.Local == local
+}
+
+// parseXMLTree parses an XMP packet into a tree of elements, returning the
+// prefix first declared for each namespace URI. On malformed XML the tree
+// holds everything before the error.
+func parseXMLTree(data []byte) (*xmlElement, map[string]string, error) {
+	root := &xmlElement{}
+	prefixes := make(map[string]string)
+	stack := []*xmlElement{root}
+	var text strings.Builder
+
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return root, prefixes, nil
+		}
+		if err != nil {
+			return root, prefixes, err
+		}
+		top := stack[len(stack)-1]
+		switch t := tok.(type) {
+		case xml.StartElement:
+			el := &xmlElement{name: t.Name}
+			for _, a := range t.Attr {
+				switch {
+				case a.Name.Space == "xmlns":
+					if _, ok := prefixes[a.Value]; !ok {
+						prefixes[a.Value] = a.Name.Local
+					}
+				case a.Name.Space == "" && a.Name.Local == "xmlns":
+				default:
+					el.attrs = append(el.attrs, a)
+				}
+			}
+			top.children = append(top.children, el)
+			stack = append(stack, el)
+			text.Reset()
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if len(top.children) == 0 {
+				top.text = text.String()
+			}
+			text.Reset()
+			stack = stack[:len(stack)-1]
+		}
+	}
+}
+
+// xmpNode is the value of an XMP property: text, an array or a structure
+type xmpNode struct {
+	ns, name  string // Namespace URI and local name of the property
+	lang      string // xml:lang qualifier
+	text      string
+	array     string     // "Bag", "Seq" or "Alt" for arrays
+	items     []*xmpNode // Array items
+	fields    []*xmpNode // Structure fields
+	structure bool
+}
+
+// rdfDescriptions returns the node elements of every rdf:RDF in the tree,
+// together with the attributes of an enclosing x:xmpmeta
+func rdfDescriptions(el *xmlElement, found []*xmlElement) []*xmlElement {
+	for _, child := range el.children {
+		switch {
+		case child.is("RDF"):
+			found = append(found, child.children...)
+		case child.name.Space == "adobe:ns:meta/" && len(child.attrs) > 0:
+			found = append(found, &xmlElement{name: child.name, attrs: child.attrs})
+			found = rdfDescriptions(child, found)
+		default:
+			found = rdfDescriptions(child, found)
+		}
+	}
+	return found
+}
+
+// nodeProperties returns the properties of a node element: its attributes
+// (the shorthand form) and its property elements
+func nodeProperties(el *xmlElement) []*xmpNode {
+	var props []*xmpNode
+	for _, a := range el.attrs {
+		if a.Name.Space == rdfNS || a.Name.Space == xmlNS || a.Name.Space == "" {
+			continue
+		}
+		props = append(props, &xmpNode{ns: a.Name.Space, name: a.Name.Local, text: a.Value})
+	}
+	for _, child := range el.children {
+		props = append(props, parseProperty(child))
+	}
+	return props
+}
+
+// parseProperty decodes a property element and its value
+func parseProperty(el *xmlElement) *xmpNode {
+	p := &xmpNode{ns: el.name.Space, name: el.name.Local}
+	p.lang, _ = el.attr(xmlNS, "lang")
+
+	if parseType, _ := el.attr(rdfNS, "parseType"); parseType == "Resource" {
+		p.structure = true
+		p.fields = nodeProperties(el)
+		return p.withValue()
+	}
+	if resource, ok := el.attr(rdfNS, "resource"); ok {
+		p.text = resource
+		return p
+	}
+	if len(el.children) == 0 {
+		// Qualifier attributes make an empty element a structure
+		if fields := nodeProperties(el); len(fields) > 0 && strings.TrimSpace(el.text) == "" {
+			p.structure = true
+			p.fields = fields
+			return p.withValue()
+		}
+		p.text = el.text
+		return p
+	}
+
+	child := el.children[0]
+	switch {
+	case child.is("Bag"), child.is("Seq"), child.is("Alt"):
+		p.array = child.name.Local
+		for _, li := range child.children {
+			if li.is("li") {
+				p.items = append(p.items, parseProperty(li))
+			}
+		}
+	case child.is("Description"):
+		// A structure, or a value with qualifiers
+		p.structure = true
+		p.fields = nodeProperties(child)
+	default:
+		// Fields written directly inside the property
+		p.structure = true
+		for _, c := range el.children {
+			p.fields = append(p.fields, parseProperty(c))
+		}
+	}
+	return p.withValue()
+}
+
+// withValue replaces a structure holding rdf:value with that value, dropping
+// its qualifiers
+func (p *xmpNode) withValue() *xmpNode {
+	for _, f := range p.fields {
+		if f.ns == rdfNS && f.name == "value" {
+			f.ns, f.name = p.ns, p.name
+			if f.lang == "" {
+				f.lang = p.lang
+			}
+			return f
+		}
+	}
+	return p
+}
+
+// xmpTag is a flattened XMP property ready to become a field
+type xmpTag struct {
+	prefix string // ExifTool namespace prefix, e.g. "dc"
+	id     string // Flattened tag ID, e.g. "CreatorContactInfoCiAdrCity"
+	name   string
+	def    *tags.TagDef
+	table  *tags.TagTable
+	values []string
+	list   bool
+}
+
+// xmpFlattener turns property trees into tags the way ExifTool flattens
+// structures: each field becomes a tag named after the structure and the
+// field, and fields of structures in arrays become lists
+type xmpFlattener struct {
+	prefixes map[string]string // Declared prefixes by namespace URI
+	tables   map[string]*tags.TagTable
+	tags     []*xmpTag
+	index    map[string]*xmpTag // Tags by prefix, ID and language
+}
+
+// prefix returns ExifTool's prefix for a namespace URI
+func (f *xmpFlattener) prefix(ns string) string {
+	if p, ok := xmpPrefixes[ns]; ok {
+		return p
+	}
+	if p, ok := f.prefixes[ns]; ok && p != "" {
+		return p
+	}
+	return ns
+}
+
+// table returns the tag table for a namespace prefix, or nil if there is none
+func (f *xmpFlattener) table(prefix string) *tags.TagTable {
+	if t, ok := f.tables[prefix]; ok {
+		return t
+	}
+	t := tags.AllTags["XMP::"+prefix]
+	if t == nil {
+		for _, name := range sortedTableNames() {
+			if table := tags.AllTags[name]; strings.EqualFold(table.ModuleName, "XMP") && strings.EqualFold(name[strings.LastIndex(name, ":")+1:], prefix) {
+				t = table
+				break
+			}
+		}
+	}
+	f.tables[prefix] = t
+	return t
+}
+
+// property flattens a top-level property
+func (f *xmpFlattener) property(p *xmpNode) {
+	prefix := f.prefix(p.ns)
+	table := f.table(prefix)
+	def := lookupTag(table, p.name)
+	name := upperFirst(p.name)
+	if def != nil && def.Name != "" {
+		name = def.Name
+	}
+	f.flatten(prefix, table, p.name, name, p, false)
+}
+
+// flatten adds the tags for node, a property with tag ID id and name name
+func (f *xmpFlattener) flatten(prefix string, table *tags.TagTable, id, name string, node *xmpNode, inList bool) {
+	switch {
+	case node.array == "Alt" && hasLanguages(node.items):
+		// Language alternatives: x-default keeps the plain name
+		for _, item := range node.items {
+			if item.lang == "" || strings.EqualFold(item.lang, "x-default") {
+				f.flatten(prefix, table, id, name, item, inList)
+			} else {
+				f.flatten(prefix, table, id+"-"+item.lang, name+"-"+item.lang, item, inList)
+			}
+		}
+	case node.array != "":
+		for _, item := range node.items {
+			f.flatten(prefix, table, id, name, item, true)
+		}
+	case node.structure:
+		for _, field := range node.fields {
+			fieldID := id + upperFirst(field.name)
+			fieldName := name + upperFirst(field.name)
+			if def := lookupTag(table, fieldID); def != nil && def.Name != "" {
+				fieldName = def.Name
+			}
+			f.flatten(prefix, table, fieldID, fieldName, field, inList)
+		}
+	default:
+		f.add(prefix, table, id, name, node.text, inList)
+	}
+}
+
+// add records one value of a flattened tag
+func (f *xmpFlattener) add(prefix string, table *tags.TagTable, id, name, value string, list bool) {
+	key := prefix + "\x00" + id
+	t := f.index[key]
+	if t == nil {
+		base, _, _ := strings.Cut(id, "-")
+		t = &xmpTag{prefix: prefix, id: id, name: name, table: table, def: lookupTag(table, base)}
+		f.index[key] = t
+		f.tags = append(f.tags, t)
+	}
+	t.values = append(t.values, value)
+	t.list = t.list || list || len(t.values) > 1
+}
+
+// hasLanguages reports whether any array item has an xml:lang qualifier
+func hasLanguages(items []*xmpNode) bool {
+	for _, item := range items {
+		if item.lang != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupTag returns the definition of a tag in table, or nil
+func lookupTag(table *tags.TagTable, id string) *tags.TagDef {
+	if table == nil {
+		return nil
+	}
+	if def, ok := table.Tags[id]; ok {
+		return &def
+	}
+	return nil
+}
+
+// upperFirst returns s with its first letter in upper case, as ExifTool
+// names tags after XMP properties
+func upperFirst(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	if r == utf8.RuneError {
+		return s
+	}
+	return string(unicode.ToUpper(r)) + s[size:]
+}
+
+// parseXMPDate converts an XMP date to a time, or to ExifTool's
+// "YYYY:MM:DD" form when it is only a partial date
+func parseXMPDate(s string) (interface{}, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04Z07:00"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			if t.Location() == time.UTC {
+				// An explicit zone, unlike the unknown zone of bare times
+				t = t.In(time.FixedZone("", 0))
+			}
+			return t, true
+		}
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05.999999999", "2006-01-02T15:04"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01", "2006"} {
+		if _, err := time.Parse(layout, s); err == nil {
+			return strings.ReplaceAll(s, "-", ":"), true
+		}
+	}
+	return nil, false
+}
+
+// xmpNoteNS holds HasExtendedXMP, which names the extended packet that
+// continues a standard one
+const xmpNoteNS = "http://ns.adobe.com/xmp/note/"
+
+// xmpPacket is the top-level properties of a parsed XMP packet
+type xmpPacket struct {
+	props    []*xmpNode
+	prefixes map[string]string // Declared prefixes by namespace URI
+}
+
+// parseXMP parses an XMP packet, warning if it is not well-formed XML
+func (e *MetadataExtractor) parseXMP(xmpData []byte) xmpPacket {
+	root, prefixes, err := parseXMLTree(xmpData)
+	if err != nil {
+		var syntax *xml.SyntaxError
+		if errors.As(err, &syntax) {
+			e.warnf("XMP format error (line %d: %s)", syntax.Line, syntax.Msg)
+		} else {
+			e.warnf("XMP format error: %v", err)
+		}
+	}
+	packet := xmpPacket{prefixes: prefixes}
+	for _, desc := range rdfDescriptions(root, nil) {
+		for _, p := range nodeProperties(desc) {
+			if p.ns != rdfNS {
+				packet.props = append(packet.props, p)
+			}
+		}
+	}
+	return packet
+}
+
+// extendedGUID returns the GUID of the packet's extended XMP, or "" if it has none
+func (p xmpPacket) extendedGUID() string {
+	for _, prop := range p.props {
+		if prop.ns == xmpNoteNS && prop.name == "HasExtendedXMP" {
+			return strings.ToUpper(strings.TrimSpace(prop.text))
+		}
+	}
+	return ""
+}
+
+// extractXMP records the properties of XMP packets as fields. The packets
+// are flattened together, so a standard packet and its extended XMP give
+// one set of tags.
+func (e *MetadataExtractor) extractXMP(packets ...xmpPacket) {
+	f := &xmpFlattener{prefixes: make(map[string]string), tables: make(map[string]*tags.TagTable), index: make(map[string]*xmpTag)}
+	for _, packet := range packets {
+		for ns, prefix := range packet.prefixes {
+			if _, ok := f.prefixes[ns]; !ok {
+				f.prefixes[ns] = prefix
+			}
+		}
+		for _, p := range packet.props {
+			f.property(p)
+		}
+	}
+
+	for _, t := range f.tags {
+		g := groupsFor("XMP", "XMP-"+t.prefix, t.def)
+		if !e.filter.wants(g, t.name) {
+			continue
+		}
+		var value interface{} = t.values[0]
+		if t.list {
+			value = t.values
+		} else if t.def != nil && t.def.Format == "date" {
+			if date, ok := parseXMPDate(t.values[0]); ok {
+				value = date
+			}
+		}
+		field := Field{
+			Namespace: g[0],
+			Directory: g[1],
+			Category:  g[2],
+			Key:       t.name,
+			TagID:     t.id,
+			Raw:       t.values[0],
+			Value:     value,
+			Print:     e.printConv(t.def, value),
+			Table:     tagTableName(t.table),
+		}
+		if t.list {
+			field.Raw = t.values
+		}
+		e.metadata.addField(field)
+		e.opts.emit(TraceEvent{Kind: TraceTag, Level: TraceLevelTags, Offset: -1, Name: g[1] + ":" + t.name, Detail: traceValue(field.Print)})
+	}
+}
@@ -0,0 +1,172 @@
+package meta
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"greg-hacke/go-metadata/formats"
+	"greg-hacke/go-metadata/tags"
+)
+
+// fieldCheck is a value expected of the field with a family 1 group and name
+type fieldCheck struct {
+	directory, key string
+	want           interface{}
+}
+
+// checkFields reports the fields of m that are missing or have other values
+func checkFields(t *testing.T, m *Metadata, checks []fieldCheck) {
+	t.Helper()
+	for _, c := range checks {
+		var found *Field
+		for i := range m.List {
+			if m.List[i].Directory == c.directory && m.List[i].Key == c.key {
+				found = &m.List[i]
+				break
+			}
+		}
+		switch {
+		case found == nil:
+			t.Errorf("no %s:%s field", c.directory, c.key)
+		case !reflect.DeepEqual(found.Value, c.want):
+			t.Errorf("%s:%s = %#v, want %#v", c.directory, c.key, found.Value, c.want)
+		}
+	}
+}
+
+// testXMPPacket has language alternatives, a Bag, a Seq, shorthand
+// properties, structures, a structure in an array and a value with
+// qualifiers
+const testXMPPacket = `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:xmpMM="http://ns.adobe.com/xap/1.0/mm/"
+    xmlns:stEvt="http://ns.adobe.com/xap/1.0/sType/ResourceEvent#"
+    xmlns:Iptc4xmpCore="http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/"
+    xmlns:my="http://example.com/ns/my/"
+    xmp:CreatorTool="Tool 1.0"
+    xmp:CreateDate="2024-05-06T07:08:09+02:00">
+   <dc:title>
+    <rdf:Alt>
+     <rdf:li xml:lang="x-default">Sunset</rdf:li>
+     <rdf:li xml:lang="fr">Coucher</rdf:li>
+    </rdf:Alt>
+   </dc:title>
+   <dc:subject><rdf:Bag><rdf:li>sky</rdf:li><rdf:li>sea</rdf:li></rdf:Bag></dc:subject>
+   <dc:creator><rdf:Seq><rdf:li>Ann</rdf:li><rdf:li>Bo</rdf:li></rdf:Seq></dc:creator>
+   <Iptc4xmpCore:CreatorContactInfo rdf:parseType="Resource">
+    <Iptc4xmpCore:CiAdrCity>Paris</Iptc4xmpCore:CiAdrCity>
+    <Iptc4xmpCore:CiAdrCtry>France</Iptc4xmpCore:CiAdrCtry>
+   </Iptc4xmpCore:CreatorContactInfo>
+   <xmpMM:History>
+    <rdf:Seq>
+     <rdf:li rdf:parseType="Resource"><stEvt:action>created</stEvt:action></rdf:li>
+     <rdf:li><rdf:Description stEvt:action="saved"/></rdf:li>
+    </rdf:Seq>
+   </xmpMM:History>
+   <xmp:Rating rdf:parseType="Resource"><rdf:value>5</rdf:value><my:note>qualifier</my:note></xmp:Rating>
+   <my:label>blue</my:label>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+// withXMPTables installs the XMP tables the tests use
+func withXMPTables(t *testing.T) {
+	withTables(t, map[string]*tags.TagTable{
+		"XMP::dc": {ModuleName: "XMP", Tags: map[string]tags.TagDef{
+			"title":   {ID: "title", Name: "Title"},
+			"subject": {ID: "subject", Name: "Subject"},
+			"creator": {ID: "creator", Name: "Creator"},
+		}},
+		"XMP::xmp": {ModuleName: "XMP", Tags: map[string]tags.TagDef{
+			"CreateDate":  {ID: "CreateDate", Name: "CreateDate", Format: "date"},
+			"CreatorTool": {ID: "CreatorTool", Name: "CreatorTool"},
+			"Rating":      {ID: "Rating", Name: "Rating"},
+		}},
+	})
+}
+
+func TestXMP(t *testing.T) {
+	withXMPTables(t)
+	e := testExtractor(nil)
+	e.extractXMPPacket([]byte(testXMPPacket), nil)
+	if len(e.metadata.Warnings) > 0 {
+		t.Errorf("warnings: %q", e.metadata.Warnings)
+	}
+	checkFields(t, e.metadata, []fieldCheck{
+		{"XMP-dc", "Title", "Sunset"},
+		{"XMP-dc", "Title-fr", "Coucher"},
+		{"XMP-dc", "Subject", []string{"sky", "sea"}},
+		{"XMP-dc", "Creator", []string{"Ann", "Bo"}},
+		{"XMP-xmp", "CreatorTool", "Tool 1.0"},
+		{"XMP-xmp", "CreateDate", time.Date(2024, 5, 6, 7, 8, 9, 0, time.FixedZone("", 2*60*60))},
+		{"XMP-xmp", "Rating", "5"},
+		{"XMP-iptcCore", "CreatorContactInfoCiAdrCity", "Paris"},
+		{"XMP-iptcCore", "CreatorContactInfoCiAdrCtry", "France"},
+		{"XMP-xmpMM", "HistoryAction", []string{"created", "saved"}},
+		{"XMP-my", "Label", "blue"},
+	})
+	for _, f := range e.metadata.List {
+		if f.Key == "RatingNote" || f.Key == "Note" {
+			t.Errorf("qualifier kept as %s", f.Key)
+		}
+	}
+}
+
+func TestXMPFormatError(t *testing.T) {
+	e := testExtractor(nil)
+	e.extractXMPPacket([]byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF>`), nil)
+	if len(e.metadata.Warnings) != 1 || !strings.HasPrefix(e.metadata.Warnings[0], "XMP format error") {
+		t.Errorf("warnings = %q, want an XMP format error", e.metadata.Warnings)
+	}
+}
+
+func TestExtendedXMP(t *testing.T) {
+	withXMPTables(t)
+	packet := func(props string) []byte {
+		return []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+			`<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:xmpNote="http://ns.adobe.com/xmp/note/">` +
+			props + `</rdf:Description></rdf:RDF></x:xmpmeta>`)
+	}
+	extended := packet(`<dc:subject><rdf:Bag><rdf:li>sea</rdf:li></rdf:Bag></dc:subject><dc:creator><rdf:Seq><rdf:li>Ann</rdf:li></rdf:Seq></dc:creator>`)
+	orphan := packet(`<dc:creator><rdf:Seq><rdf:li>Bo</rdf:li></rdf:Seq></dc:creator>`)
+	sum := md5.Sum(extended)
+	guid := strings.ToUpper(hex.EncodeToString(sum[:]))
+	sum = md5.Sum(orphan)
+	orphanGUID := strings.ToUpper(hex.EncodeToString(sum[:]))
+	standard := packet(`<xmpNote:HasExtendedXMP>` + strings.ToLower(guid) + `</xmpNote:HasExtendedXMP><dc:subject><rdf:Bag><rdf:li>sky</rdf:li></rdf:Bag></dc:subject>`)
+
+	e := testExtractor(nil)
+	s := &formatSink{e: e}
+	// Extended packets are collected by their MD5 before the standard packet is decoded
+	for _, block := range []struct {
+		kind formats.BlockKind
+		data []byte
+	}{
+		{formats.BlockExtendedXMP, orphan},
+		{formats.BlockXMP, standard},
+		{formats.BlockExtendedXMP, extended},
+	} {
+		s.Block(block.kind, bytes.NewReader(block.data), 0, int64(len(block.data)))
+	}
+	s.flushXMP()
+
+	checkFields(t, e.metadata, []fieldCheck{
+		{"XMP-dc", "Subject", []string{"sky", "sea"}},
+		{"XMP-dc", "Creator", []string{"Ann"}},
+		{"XMP-xmpNote", "HasExtendedXMP", strings.ToLower(guid)},
+	})
+	want := []string{"Ignored extended XMP with GUID " + orphanGUID + " not named by HasExtendedXMP"}
+	if !reflect.DeepEqual(e.metadata.Warnings, want) {
+		t.Errorf("warnings = %q, want %q", e.metadata.Warnings, want)
+	}
+}
@@ -100,6 +100,7 @@ func parsePMFile(path string, data *ParsedData) error {
 
 	scanner := bufio.NewScanner(file)
 	var currentTable *TagTable
+	var currentTableName string // Last part of the current table's name, e.g. "EnvelopeRecord"
 	var currentTag *TagDef
 	var currentKey string
 	var inTagTable bool
@@ -114,8 +115,8 @@ func parsePMFile(path string, data *ParsedData) error {
 	packageRe := regexp.MustCompile(`^\s*package\s+(.+?)\s*;`)
 	tagTableRe := regexp.MustCompile(`^\s*%([A-Za-z0-9_:]+)\s*=\s*\(`)
 	// Updated patterns to be more flexible with whitespace and brackets
-	tagDefStartRe := regexp.MustCompile(`^\s*(?:'([^']+)'|"([^"]+)"|0x([0-9a-fA-F]+)|(\d+)|(\w+))\s*=>\s*\{`)
-	tagDefInlineRe := regexp.MustCompile(`^\s*(?:'([^']+)'|"([^"]+)"|0x([0-9a-fA-F]+)|(\d+)|(\w+))\s*=>\s*(.+?)(?:,\s*)?$`)
+	tagDefStartRe := regexp.MustCompile(`^\s*(?:'([^']+)'|"([^"]+)"|0x([0-9a-fA-F]+)|(\d+(?:\.\d+)?)|(\w+))\s*=>\s*\{`)
+	tagDefInlineRe := regexp.MustCompile(`^\s*(?:'([^']+)'|"([^"]+)"|0x([0-9a-fA-F]+)|(\d+(?:\.\d+)?)|(\w+))\s*=>\s*(.+?)(?:,\s*)?$`)
 	nameRe := regexp.MustCompile(`Name\s*=>\s*'([^']+)'`)
 	descRe := regexp.MustCompile(`Description\s*=>\s*'([^']+)'`)
 	notesRe := regexp.MustCompile(`Notes\s*=>\s*(?:'([^']+)'|q\{([^}]+)\})`)
@@ -154,6 +155,7 @@ func parsePMFile(path string, data *ParsedData) error {
 			// e.g., Image::ExifTool::JPEG::Main -> Main
 			parts := strings.Split(tableName, "::")
 			shortName := parts[len(parts)-1]
+			currentTableName = shortName
 
 			currentTable = &TagTable{
 				ModuleName:  moduleName,
@@ -253,17 +255,7 @@ func parsePMFile(path string, data *ParsedData) error {
 
 				// Special handling for IPTC tags
 				if currentTable != nil && strings.Contains(currentTable.ModuleName, "IPTC") && isNumeric(currentKey) {
-					// Convert single number to IPTC format (record:dataset)
-					num, _ := strconv.Atoi(currentKey)
-					if num < 256 {
-						// Single byte - assume record 2
-						currentKey = fmt.Sprintf("2:%d", num)
-					} else {
-						// Two bytes encoded
-						record := num >> 8
-						dataset := num & 0xFF
-						currentKey = fmt.Sprintf("%d:%d", record, dataset)
-					}
+					currentKey = iptcTagKey(currentTableName, currentKey)
 					fmt.Fprintf(os.Stderr, "DEBUG: IPTC tag converted to: %s\n", currentKey)
 				}
 
@@ -294,17 +286,7 @@ func parsePMFile(path string, data *ParsedData) error {
 
 				// Special handling for IPTC tags
 				if currentTable != nil && strings.Contains(currentTable.ModuleName, "IPTC") && isNumeric(currentKey) {
-					// Convert single number to IPTC format (record:dataset)
-					num, _ := strconv.Atoi(currentKey)
-					if num < 256 {
-						// Single byte - assume record 2
-						currentKey = fmt.Sprintf("2:%d", num)
-					} else {
-						// Two bytes encoded
-						record := num >> 8
-						dataset := num & 0xFF
-						currentKey = fmt.Sprintf("%d:%d", record, dataset)
-					}
+					currentKey = iptcTagKey(currentTableName, currentKey)
 					fmt.Fprintf(os.Stderr, "DEBUG: IPTC tag converted to: %s\n", currentKey)
 				}
 
@@ -722,8 +704,8 @@ func parseValueMappings(content string, tag *TagDef) {
 		return
 	}
 
-	// Numeric values: 0 => 'None', 1 => 'Standard'
-	numRe := regexp.MustCompile(`(\d+)\s*=>\s*'([^']+)'`)
+	// Numeric values: 0 => 'None', 1 => 'Standard', but not the digits of a hex key
+	numRe := regexp.MustCompile(`\b(\d+)\s*=>\s*'([^']+)'`)
 	for _, match := range numRe.FindAllStringSubmatch(content, -1) {
 		if len(match) >= 3 {
 			tag.Values[match[1]] = match[2]
@@ -923,3 +905,29 @@ func generateVarName(tableName string) string {
 	// Join with underscores and add Tags suffix
 	return strings.Join(result, "_") + "_Tags"
 }
+
+// iptcRecords maps the IPTC tag tables to the IIM record they describe
+var iptcRecords = map[string]int{
+	"EnvelopeRecord":    1,
+	"ApplicationRecord": 2,
+	"NewsPhoto":         3,
+	"PreObjectData":     7,
+	"ObjectData":        8,
+	"PostObjectData":    9,
+}
+
+// iptcTagKey converts an IPTC dataset number to the "record:dataset" form,
+// taking the record from the name of the table the tag belongs to, such as
+// "EnvelopeRecord"
+func iptcTagKey(tableName, key string) string {
+	num, _ := strconv.Atoi(key)
+	if num >= 256 {
+		// Two bytes encoded
+		return fmt.Sprintf("%d:%d", num>>8, num&0xFF)
+	}
+	record, ok := iptcRecords[tableName]
+	if !ok {
+		record = 2
+	}
+	return fmt.Sprintf("%d:%d", record, num)
+}